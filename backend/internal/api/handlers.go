@@ -1,11 +1,21 @@
 package api
 
 import (
-	"math/big"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"web3-portfolio-dashboard/backend/internal/models"
+	"web3-portfolio-dashboard/backend/internal/services"
 )
 
 // Auth handlers
@@ -96,7 +106,13 @@ func (s *Server) getUserProfileHandler(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"user": user})
+	unread, err := s.notificationService.UnreadCount(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"user": user, "unread_notifications": unread})
 }
 
 func (s *Server) updateUserProfileHandler(c *gin.Context) {
@@ -321,13 +337,13 @@ func (s *Server) refreshPortfolioBalancesHandler(c *gin.Context) {
 	userID := c.GetString("user_id")
 	portfolioID := c.Param("id")
 
-	balances, err := s.portfolioService.RefreshPortfolioBalances(userID, portfolioID)
+	refresh, err := s.portfolioService.RefreshPortfolioBalances(c.Request.Context(), userID, portfolioID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"balances": balances})
+	c.JSON(http.StatusOK, gin.H{"balances": refresh.Balances, "errors": refresh.Errors})
 }
 
 // Portfolio transaction handlers
@@ -358,7 +374,7 @@ func (s *Server) refreshPortfolioTransactionsHandler(c *gin.Context) {
 	userID := c.GetString("user_id")
 	portfolioID := c.Param("id")
 
-	transactions, err := s.portfolioService.RefreshPortfolioTransactions(userID, portfolioID)
+	transactions, err := s.portfolioService.RefreshPortfolioTransactions(c.Request.Context(), userID, portfolioID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -385,6 +401,10 @@ func (s *Server) getPortfolioPerformanceHandler(c *gin.Context) {
 	userID := c.GetString("user_id")
 	portfolioID := c.Param("id")
 	period := c.DefaultQuery("period", "30d")
+	if _, err := services.PeriodStart(period, time.Now()); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	performance, err := s.portfolioService.GetPortfolioPerformance(userID, portfolioID, period)
 	if err != nil {
@@ -412,6 +432,10 @@ func (s *Server) getPortfolioHistoryHandler(c *gin.Context) {
 	userID := c.GetString("user_id")
 	portfolioID := c.Param("id")
 	period := c.DefaultQuery("period", "30d")
+	if _, err := services.PeriodStart(period, time.Now()); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	history, err := s.portfolioService.GetPortfolioHistory(userID, portfolioID, period)
 	if err != nil {
@@ -422,6 +446,63 @@ func (s *Server) getPortfolioHistoryHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"history": history})
 }
 
+func (s *Server) getPortfolioPnLHandler(c *gin.Context) {
+	userID := c.GetString("user_id")
+	portfolioID := c.Param("id")
+
+	method, err := services.ParseCostBasisMethod(c.Query("method"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	opts := services.PnLOptions{Method: method}
+
+	if value := c.Query("from"); value != "" {
+		if opts.From, err = parseTime(value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from: " + err.Error()})
+			return
+		}
+	}
+	if value := c.Query("to"); value != "" {
+		if opts.To, err = parseTime(value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to: " + err.Error()})
+			return
+		}
+	}
+	if !opts.From.IsZero() && !opts.To.IsZero() && !opts.From.Before(opts.To) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must be before to"})
+		return
+	}
+
+	pnl, err := s.portfolioService.GetPortfolioPnL(userID, portfolioID, opts)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Portfolio not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"pnl": pnl})
+}
+
+func (s *Server) exportPortfolioHandler(c *gin.Context) {
+	userID := c.GetString("user_id")
+	portfolioID := c.Param("id")
+
+	opts, err := services.ParseExportOptions(c.Query("report"), c.Query("format"), c.Query("year"), c.Query("method"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := s.portfolioService.ExportPortfolio(userID, portfolioID, opts, &buf); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Portfolio not found"})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", opts.Filename(portfolioID)))
+	c.Data(http.StatusOK, "text/csv; charset=utf-8", buf.Bytes())
+}
+
 // Alert handlers
 func (s *Server) getAlertsHandler(c *gin.Context) {
 	userID := c.GetString("user_id")
@@ -447,9 +528,10 @@ func (s *Server) createAlertHandler(c *gin.Context) {
 	}
 
 	var req struct {
-		Type       string                 `json:"type" binding:"required"`
-		Name       string                 `json:"name" binding:"required"`
-		Conditions map[string]interface{} `json:"conditions" binding:"required"`
+		Type       string                  `json:"type" binding:"required"`
+		Name       string                  `json:"name" binding:"required"`
+		Conditions *models.AlertConditions `json:"conditions" binding:"required"`
+		services.AlertSettings
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -457,7 +539,7 @@ func (s *Server) createAlertHandler(c *gin.Context) {
 		return
 	}
 
-	alert, err := s.alertService.CreateAlert(userID, req.Type, req.Name, req.Conditions)
+	alert, err := s.alertService.CreateAlert(userID, req.Type, req.Name, *req.Conditions, req.AlertSettings)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -484,9 +566,10 @@ func (s *Server) updateAlertHandler(c *gin.Context) {
 	alertID := c.Param("id")
 
 	var req struct {
-		Type       string                 `json:"type"`
-		Name       string                 `json:"name"`
-		Conditions map[string]interface{} `json:"conditions"`
+		Type       string                  `json:"type"`
+		Name       string                  `json:"name"`
+		Conditions *models.AlertConditions `json:"conditions"`
+		services.AlertSettings
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -494,7 +577,7 @@ func (s *Server) updateAlertHandler(c *gin.Context) {
 		return
 	}
 
-	alert, err := s.alertService.UpdateAlert(userID, alertID, req.Type, req.Name, req.Conditions)
+	alert, err := s.alertService.UpdateAlert(userID, alertID, req.Type, req.Name, req.Conditions, req.AlertSettings)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -528,51 +611,397 @@ func (s *Server) toggleAlertHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"alert": alert})
 }
 
+// Notification handlers
+func (s *Server) getNotificationsHandler(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 200 {
+		limit = 50
+	}
+	unreadOnly := c.Query("unread") == "true"
+
+	notifications, total, err := s.notificationService.GetNotifications(userID, page, limit, unreadOnly)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	unread, err := s.notificationService.UnreadCount(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"notifications": notifications,
+		"unread":        unread,
+		"pagination": gin.H{
+			"page":  page,
+			"limit": limit,
+			"total": total,
+		},
+	})
+}
+
+func (s *Server) markNotificationsReadHandler(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	// Without ids every notification is marked read
+	var req struct {
+		IDs []string `json:"ids"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	updated, err := s.notificationService.MarkRead(userID, req.IDs)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"updated": updated})
+}
+
+func (s *Server) dismissNotificationsHandler(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var req struct {
+		IDs []string `json:"ids" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	dismissed, err := s.notificationService.DismissNotifications(userID, req.IDs)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"dismissed": dismissed})
+}
+
+// Event stream handler: Server-Sent Events for the user's alert notifications,
+// balance refreshes of the listed portfolios (all by default) and price ticks
+// of the listed tokens
+func (s *Server) eventsHandler(c *gin.Context) {
+	userID, err := uuid.Parse(c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user"})
+		return
+	}
+
+	portfolios := splitList(c.Query("portfolios"))
+	for _, portfolioID := range portfolios {
+		if _, err := s.portfolioService.GetPortfolio(userID.String(), portfolioID); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Portfolio not found"})
+			return
+		}
+	}
+
+	sub := s.events.Subscribe(userID, portfolios, splitList(c.Query("tokens")))
+	defer s.events.Unsubscribe(sub)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // keep nginx from buffering the stream
+	c.Status(http.StatusOK)
+	fmt.Fprint(c.Writer, ": connected\n\n")
+	c.Writer.Flush()
+
+	interval := s.config.EventsHeartbeatInterval
+	if interval <= 0 {
+		interval = 15 * time.Second
+	}
+	heartbeat := time.NewTicker(interval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case event, ok := <-sub.Events():
+			// The hub closes lagging subscriptions and all of them on shutdown
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				s.logger.WithError(err).Error("Failed to encode event")
+				continue
+			}
+			fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+		case <-heartbeat.C:
+			fmt.Fprint(c.Writer, ": heartbeat\n\n")
+		}
+		c.Writer.Flush()
+	}
+}
+
+// splitList splits a comma-separated query value, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 // Web3 handlers
 func (s *Server) getNetworksHandler(c *gin.Context) {
-	networks := s.web3Service.GetNetworkStatus()
+	networks := s.web3Service.Networks()
 	c.JSON(http.StatusOK, gin.H{"networks": networks})
 }
 
 func (s *Server) getNetworkStatusHandler(c *gin.Context) {
 	network := c.Param("network")
-	status := s.web3Service.GetNetworkStatus()
-
-	if networkStatus, exists := status[network]; exists {
-		c.JSON(http.StatusOK, gin.H{"network": network, "status": networkStatus})
-	} else {
+	networkConfig, exists := s.web3Service.Network(network)
+	if !exists {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Network not found"})
+		return
 	}
+
+	status := s.web3Service.GetNetworkStatus()
+	c.JSON(http.StatusOK, gin.H{"network": networkConfig.Name, "status": status[networkConfig.Name]})
 }
 
 func (s *Server) getGasPriceHandler(c *gin.Context) {
 	network := c.Param("network")
 
-	gasPrice, err := s.web3Service.GetGasPrice(network)
+	fees, err := s.gasOracle.Fees(c.Request.Context(), network)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	estimate := func(estimate services.GasEstimate) gin.H {
+		return gin.H{
+			"max_priority_fee":      estimate.MaxPriorityFee.String(),
+			"max_priority_fee_gwei": services.FormatGwei(estimate.MaxPriorityFee),
+			"max_fee":               estimate.MaxFee.String(),
+			"max_fee_gwei":          services.FormatGwei(estimate.MaxFee),
+		}
+	}
+	percentiles := gin.H{}
+	for i, percentile := range services.GasPercentiles() {
+		percentiles[strconv.FormatFloat(percentile, 'f', -1, 64)] = services.FormatGwei(fees.PriorityFees[i])
+	}
+	gasPrice, _ := fees.Price(services.GasFeeStandard)
+
 	c.JSON(http.StatusOK, gin.H{
-		"network":        network,
-		"gas_price":      gasPrice.String(),
-		"gas_price_gwei": new(big.Int).Div(gasPrice, big.NewInt(1000000000)).String(),
+		"network":                       network,
+		"eip1559":                       fees.EIP1559,
+		"block":                         fees.Block,
+		"base_fee":                      fees.BaseFee.String(),
+		"base_fee_gwei":                 services.FormatGwei(fees.BaseFee),
+		"priority_fee_percentiles_gwei": percentiles,
+		"slow":                          estimate(fees.Slow),
+		"standard":                      estimate(fees.Standard),
+		"fast":                          estimate(fees.Fast),
+		"gas_price":                     gasPrice.String(),
+		"gas_price_gwei":                services.FormatGwei(gasPrice),
+		"updated_at":                    fees.UpdatedAt,
 	})
 }
 
 func (s *Server) getTokenPriceHandler(c *gin.Context) {
 	symbol := c.Param("symbol")
 
-	price, err := s.web3Service.GetTokenPrice(symbol)
+	price, err := s.priceService.GetPrice(c.Request.Context(), symbol)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"symbol": symbol,
-		"price":  price,
+		"symbol":     symbol,
+		"price":      strconv.FormatFloat(price.Price, 'f', -1, 64),
+		"source":     price.Source,
+		"updated_at": price.UpdatedAt,
+		"stale":      price.Stale,
+	})
+}
+
+func (s *Server) getNetworkTokensHandler(c *gin.Context) {
+	network := c.Param("network")
+	if _, exists := s.web3Service.Network(network); !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Network not found"})
+		return
+	}
+
+	tokens, err := s.tokenCatalog.ListTokens(network)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"network": network, "tokens": tokens})
+}
+
+func (s *Server) getNetworkTokenHandler(c *gin.Context) {
+	if !s.validTokenParams(c) {
+		return
+	}
+
+	token, err := s.tokenCatalog.GetToken(c.Param("network"), c.Param("address"))
+	if errors.Is(err, services.ErrTokenNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Token not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"token": token})
+}
+
+func (s *Server) discoverNetworkTokenHandler(c *gin.Context) {
+	if !s.validTokenParams(c) {
+		return
+	}
+
+	tokens, err := s.tokenCatalog.Discover(c.Param("network"), []string{c.Param("address")})
+	if errors.Is(err, services.ErrNotERC20) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"token": tokens[0]})
+}
+
+// validTokenParams checks the network and address of a token route, answering the request when they're invalid
+func (s *Server) validTokenParams(c *gin.Context) bool {
+	if _, exists := s.web3Service.Network(c.Param("network")); !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Network not found"})
+		return false
+	}
+	if !common.IsHexAddress(c.Param("address")) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid token address"})
+		return false
+	}
+	return true
+}
+
+// Admin handlers
+func (s *Server) setTokenOverrideHandler(c *gin.Context) {
+	var req struct {
+		Symbol   string `json:"symbol" binding:"required"`
+		Name     string `json:"name"`
+		Decimals *uint8 `json:"decimals" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	token, err := s.tokenCatalog.SetOverride(c.Param("network"), c.Param("address"), req.Symbol, req.Name, *req.Decimals)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"token": token})
+}
+
+func (s *Server) clearTokenOverrideHandler(c *gin.Context) {
+	token, err := s.tokenCatalog.ClearOverride(c.Param("network"), c.Param("address"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"token": token})
+}
+
+func (s *Server) getJobsHandler(c *gin.Context) {
+	jobs, err := s.scheduler.Jobs()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"instance": s.scheduler.Instance(),
+		"leader":   s.scheduler.IsLeader(),
+		"jobs":     jobs,
+	})
+}
+
+func (s *Server) getJobRunsHandler(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if limit < 1 || limit > 500 {
+		limit = 50
+	}
+
+	runs, err := s.scheduler.JobRuns(c.Query("job"), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"runs": runs})
+}
+
+func (s *Server) getTokenPriceHistoryHandler(c *gin.Context) {
+	symbol := c.Param("symbol")
+
+	to := time.Now()
+	if value := c.Query("to"); value != "" {
+		t, err := parseTime(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to: " + err.Error()})
+			return
+		}
+		to = t
+	}
+
+	from := to.AddDate(0, 0, -7)
+	if value := c.Query("from"); value != "" {
+		t, err := parseTime(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from: " + err.Error()})
+			return
+		}
+		from = t
+	}
+
+	interval, err := services.ParseInterval(c.DefaultQuery("interval", "1h"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	candles, err := s.priceHistory.GetCandles(symbol, from, to, interval)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"symbol":   strings.ToUpper(symbol),
+		"from":     from,
+		"to":       to,
+		"interval": interval.String(),
+		"candles":  candles,
 	})
 }
 
@@ -580,7 +1009,7 @@ func (s *Server) getAddressBalanceHandler(c *gin.Context) {
 	address := c.Param("address")
 	network := c.DefaultQuery("network", "ethereum")
 
-	balance, err := s.web3Service.GetBalance(address, network)
+	balance, err := s.web3Service.GetBalance(c.Request.Context(), address, network)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -597,7 +1026,7 @@ func (s *Server) getAddressTokensHandler(c *gin.Context) {
 	address := c.Param("address")
 	network := c.DefaultQuery("network", "ethereum")
 
-	tokens, err := s.web3Service.GetTokenBalances(address, network)
+	tokens, err := s.web3Service.GetTokenBalances(c.Request.Context(), address, network)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -722,3 +1151,11 @@ func (s *Server) deleteQuestionHandler(c *gin.Context) {
 	// TODO: Call service to delete question
 	c.JSON(http.StatusOK, gin.H{"message": "Question deleted (stub)"})
 }
+
+// parseTime accepts RFC 3339 timestamps or Unix seconds
+func parseTime(value string) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
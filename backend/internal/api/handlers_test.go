@@ -1,14 +1,19 @@
 package api
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
 
@@ -63,11 +68,19 @@ func setupTestServer(t *testing.T) *Server {
 	}
 	logger := logrus.New()
 	web3Service := services.NewWeb3Service(cfg)
-	portfolioService := services.NewPortfolioService(db, web3Service)
+	priceService := services.NewPriceService(cfg, services.DefaultPriceProviders(cfg, web3Service))
+	priceHistory := services.NewPriceHistoryService(db, cfg, priceService, web3Service)
+	transactionIndexer := services.NewTransactionIndexer(db, cfg, web3Service, priceHistory)
+	balanceRefresher := services.NewBalanceRefresher(cfg, web3Service)
+	portfolioService := services.NewPortfolioService(db, web3Service, priceHistory, transactionIndexer, balanceRefresher)
 	authService := services.NewAuthService(db, cfg.JWTSecret)
-	alertService := services.NewAlertService(db)
+	notificationService := services.NewNotificationService(db, cfg, services.DefaultNotifiers(cfg))
+	gasOracle := services.NewGasOracle(cfg, web3Service)
+	alertService := services.NewAlertService(db, web3Service, priceHistory, gasOracle, notificationService)
+	tokenCatalog := services.NewTokenCatalogService(db, web3Service)
+	scheduler := services.NewScheduler(db, cfg)
 
-	return NewServer(cfg, logger, db, portfolioService, authService, alertService, web3Service)
+	return NewServer(cfg, logger, db, portfolioService, authService, alertService, notificationService, web3Service, tokenCatalog, priceService, priceHistory, gasOracle, scheduler, services.NewEventHub(cfg.EventsBufferSize))
 }
 
 func TestHealthHandler(t *testing.T) {
@@ -102,6 +115,80 @@ func TestVersionHandler(t *testing.T) {
 	require.Equal(t, "1.0.0", body["version"])
 }
 
+func TestEventStreamTokenIsNotLogged(t *testing.T) {
+	var ginLog, appLog bytes.Buffer
+	defaultWriter := gin.DefaultWriter
+	gin.DefaultWriter = &ginLog
+	defer func() { gin.DefaultWriter = defaultWriter }()
+
+	cfg := &config.Config{JWTSecret: "test-secret", Environment: "test"}
+	logger := logrus.New()
+	logger.SetOutput(&appLog)
+	server := NewServer(cfg, logger, nil, nil, services.NewAuthService(nil, cfg.JWTSecret), nil, nil, nil, nil, nil, nil, nil, nil, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/events?token=not-a-real-jwt", nil)
+	rec := httptest.NewRecorder()
+	server.engine.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	require.Contains(t, appLog.String(), "token=REDACTED")
+	require.NotContains(t, appLog.String()+ginLog.String(), "not-a-real-jwt")
+}
+
+func TestEventStream(t *testing.T) {
+	server := newMinimalTestServer()
+	server.config.EventsHeartbeatInterval = 20 * time.Millisecond
+	server.events = services.NewEventHub(8)
+	userID, otherID := uuid.New(), uuid.New()
+	server.engine.GET("/api/v1/events", func(c *gin.Context) {
+		c.Set("user_id", userID.String())
+	}, server.eventsHandler)
+
+	httpServer := httptest.NewServer(server.engine)
+	defer httpServer.Close()
+	resp, err := http.Get(httpServer.URL + "/api/v1/events?tokens=eth")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	lines := bufio.NewReader(resp.Body)
+	next := func() string {
+		line, err := lines.ReadString('\n')
+		require.NoError(t, err)
+		return strings.TrimSuffix(line, "\n")
+	}
+	require.Equal(t, ": connected", next())
+	require.Equal(t, "", next())
+	require.Equal(t, ": heartbeat", next())
+	require.Equal(t, "", next())
+
+	require.Eventually(t, func() bool { return server.events.Subscribers() == 1 }, time.Second, 5*time.Millisecond)
+	server.events.PublishToUser(otherID, services.Event{Type: services.EventNotification, Data: "not yours"})
+	server.events.Publish(services.Event{Type: services.EventPrice, Token: "BTC", Data: "not subscribed"})
+	server.events.Publish(services.Event{Type: services.EventPrice, Token: "ETH", Data: gin.H{"price": "3000"}})
+
+	line := next()
+	for strings.HasPrefix(line, ":") || line == "" {
+		line = next()
+	}
+	require.Equal(t, "id: 3", line)
+	require.Equal(t, "event: price", next())
+	var event services.Event
+	require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(next(), "data: ")), &event))
+	require.Equal(t, "ETH", event.Token)
+	require.Equal(t, map[string]interface{}{"price": "3000"}, event.Data)
+
+	// Shutting the hub down ends the stream
+	server.events.Close()
+	_, err = io.ReadAll(lines)
+	require.NoError(t, err)
+}
+
+func TestRedactToken(t *testing.T) {
+	require.Equal(t, "/api/v1/events?token=REDACTED&tokens=ETH", redactToken("/api/v1/events?token=secret&tokens=ETH"))
+	require.Equal(t, "/api/v1/portfolios?page=2", redactToken("/api/v1/portfolios?page=2"))
+}
+
 func TestAuthRegisterAndProfile(t *testing.T) {
 	server := setupTestServer(t)
 
@@ -2,6 +2,7 @@ package api
 
 import (
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
@@ -51,7 +52,7 @@ func loggerMiddleware(logger *logrus.Logger) gin.HandlerFunc {
 			"client_ip":   param.ClientIP,
 			"timestamp":   param.TimeStamp.Format(time.RFC3339),
 			"method":      param.Method,
-			"path":        param.Path,
+			"path":        redactToken(param.Path),
 			"protocol":    param.Request.Proto,
 			"status_code": param.StatusCode,
 			"latency":     param.Latency,
@@ -129,6 +130,18 @@ func authMiddleware(authService *services.AuthService) gin.HandlerFunc {
 	})
 }
 
+// queryTokenAuthMiddleware authenticates like authMiddleware, also accepting
+// the access token as a "token" query parameter
+func queryTokenAuthMiddleware(authService *services.AuthService) gin.HandlerFunc {
+	auth := authMiddleware(authService)
+	return gin.HandlerFunc(func(c *gin.Context) {
+		if token := c.Query("token"); token != "" && c.GetHeader("Authorization") == "" {
+			c.Request.Header.Set("Authorization", "Bearer "+token)
+		}
+		auth(c)
+	})
+}
+
 // Request ID middleware
 func requestIDMiddleware() gin.HandlerFunc {
 	return gin.HandlerFunc(func(c *gin.Context) {
@@ -201,6 +214,18 @@ func generateRequestID() string {
 	return time.Now().Format("20060102150405") + "-" + randomString(8)
 }
 
+// redactToken hides an access token passed in a logged path's query
+func redactToken(path string) string {
+	parsed, err := url.Parse(path)
+	if err != nil || !parsed.Query().Has("token") {
+		return path
+	}
+	query := parsed.Query()
+	query.Set("token", "REDACTED")
+	parsed.RawQuery = query.Encode()
+	return parsed.String()
+}
+
 // Helper function to generate random string
 func randomString(length int) string {
 	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
@@ -237,3 +262,24 @@ func planMiddleware(db *gorm.DB) gin.HandlerFunc {
 		c.Next()
 	})
 }
+
+// Admin-only access middleware
+func adminMiddleware(db *gorm.DB) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		userID, exists := c.Get("user_id")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+			c.Abort()
+			return
+		}
+
+		var role models.Role
+		if err := db.First(&role, "user_id = ?", userID).Error; err != nil || role.Role != "admin" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	})
+}
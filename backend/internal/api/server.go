@@ -1,6 +1,8 @@
 package api
 
 import (
+	"context"
+	"log"
 	"net/http"
 	"os"
 	"time"
@@ -14,14 +16,21 @@ import (
 )
 
 type Server struct {
-	engine           *gin.Engine
-	config           *config.Config
-	logger           *logrus.Logger
-	db               *gorm.DB
-	portfolioService *services.PortfolioService
-	authService      *services.AuthService
-	alertService     *services.AlertService
-	web3Service      *services.Web3Service
+	engine              *gin.Engine
+	config              *config.Config
+	logger              *logrus.Logger
+	db                  *gorm.DB
+	portfolioService    *services.PortfolioService
+	authService         *services.AuthService
+	alertService        *services.AlertService
+	notificationService *services.NotificationService
+	web3Service         *services.Web3Service
+	tokenCatalog        *services.TokenCatalogService
+	priceService        *services.PriceService
+	priceHistory        *services.PriceHistoryService
+	gasOracle           *services.GasOracle
+	scheduler           *services.Scheduler
+	events              *services.EventHub
 }
 
 func NewServer(
@@ -31,23 +40,39 @@ func NewServer(
 	portfolioService *services.PortfolioService,
 	authService *services.AuthService,
 	alertService *services.AlertService,
+	notificationService *services.NotificationService,
 	web3Service *services.Web3Service,
+	tokenCatalog *services.TokenCatalogService,
+	priceService *services.PriceService,
+	priceHistory *services.PriceHistoryService,
+	gasOracle *services.GasOracle,
+	scheduler *services.Scheduler,
+	events *services.EventHub,
 ) *Server {
 	if cfg.Environment == "production" {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
-	r := gin.Default()
+	// Not gin.Default: its logger would print the event stream's ?token=
+	// unredacted. registerRoutes adds our own logger and recovery.
+	r := gin.New()
 
 	s := &Server{
-		engine:           r,
-		config:           cfg,
-		logger:           logger,
-		db:               db,
-		portfolioService: portfolioService,
-		authService:      authService,
-		alertService:     alertService,
-		web3Service:      web3Service,
+		engine:              r,
+		config:              cfg,
+		logger:              logger,
+		db:                  db,
+		portfolioService:    portfolioService,
+		authService:         authService,
+		alertService:        alertService,
+		notificationService: notificationService,
+		web3Service:         web3Service,
+		tokenCatalog:        tokenCatalog,
+		priceService:        priceService,
+		priceHistory:        priceHistory,
+		gasOracle:           gasOracle,
+		scheduler:           scheduler,
+		events:              events,
 	}
 
 	s.registerRoutes()
@@ -56,13 +81,13 @@ func NewServer(
 
 func (s *Server) registerRoutes() {
 	// Add middleware
+	s.engine.Use(recoveryMiddleware(s.logger))
 	s.engine.Use(corsMiddleware(s.config.CorsAllowedOrigins))
 	s.engine.Use(loggerMiddleware(s.logger))
 	s.engine.Use(rateLimitMiddleware())
 	s.engine.Use(requestIDMiddleware())
 	s.engine.Use(securityHeadersMiddleware())
 	s.engine.Use(errorHandlingMiddleware())
-	s.engine.Use(recoveryMiddleware(s.logger))
 
 	// Public routes
 	s.engine.GET("/health", s.healthHandler)
@@ -78,6 +103,9 @@ func (s *Server) registerRoutes() {
 		auth.POST("/logout", s.logoutHandler)
 	}
 
+	// Event stream; EventSource can't set headers, so it may pass ?token=
+	s.engine.GET("/api/v1/events", queryTokenAuthMiddleware(s.authService), s.eventsHandler)
+
 	// Protected routes
 	protected := s.engine.Group("/api/v1")
 	protected.Use(authMiddleware(s.authService))
@@ -122,6 +150,8 @@ func (s *Server) registerRoutes() {
 			analytics.GET("/portfolio/:id/performance", s.getPortfolioPerformanceHandler)
 			analytics.GET("/portfolio/:id/allocation", s.getPortfolioAllocationHandler)
 			analytics.GET("/portfolio/:id/history", s.getPortfolioHistoryHandler)
+			analytics.GET("/portfolio/:id/pnl", s.getPortfolioPnLHandler)
+			analytics.GET("/portfolio/:id/export", s.exportPortfolioHandler)
 		}
 
 		// Alerts
@@ -136,17 +166,39 @@ func (s *Server) registerRoutes() {
 			alerts.POST("/:id/toggle", s.toggleAlertHandler)
 		}
 
+		// Notifications
+		notifications := protected.Group("/notifications")
+		{
+			notifications.GET("", s.getNotificationsHandler)
+			notifications.POST("/read", s.markNotificationsReadHandler)
+			notifications.POST("/dismiss", s.dismissNotificationsHandler)
+		}
+
 		// Web3 data
 		web3 := protected.Group("/web3")
 		{
 			web3.GET("/networks", s.getNetworksHandler)
 			web3.GET("/networks/:network/status", s.getNetworkStatusHandler)
 			web3.GET("/networks/:network/gas", s.getGasPriceHandler)
+			web3.GET("/networks/:network/tokens", s.getNetworkTokensHandler)
+			web3.GET("/networks/:network/tokens/:address", s.getNetworkTokenHandler)
+			web3.POST("/networks/:network/tokens/:address/discover", s.discoverNetworkTokenHandler)
 			web3.GET("/tokens/:symbol/price", s.getTokenPriceHandler)
+			web3.GET("/tokens/:symbol/history", s.getTokenPriceHistoryHandler)
 			web3.GET("/addresses/:address/balance", s.getAddressBalanceHandler)
 			web3.GET("/addresses/:address/tokens", s.getAddressTokensHandler)
 		}
 
+		// Admin
+		admin := protected.Group("/admin")
+		admin.Use(adminMiddleware(s.db))
+		{
+			admin.PUT("/tokens/:network/:address", s.setTokenOverrideHandler)
+			admin.DELETE("/tokens/:network/:address/override", s.clearTokenOverrideHandler)
+			admin.GET("/jobs", s.getJobsHandler)
+			admin.GET("/jobs/runs", s.getJobRunsHandler)
+		}
+
 		// Forum routes
 		forum := protected.Group("/forum")
 		{
@@ -162,8 +214,26 @@ func (s *Server) registerRoutes() {
 	}
 }
 
-func (s *Server) Start(addr string) error {
-	return s.engine.Run(addr)
+// Start serves HTTP until ctx is cancelled, then shuts down gracefully
+func (s *Server) Start(ctx context.Context, addr string) error {
+	srv := &http.Server{Addr: addr, Handler: s.engine}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Println("Shutting down server...")
+	// End event streams so they don't hold up the shutdown
+	s.events.Close()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.config.ShutdownTimeout)
+	defer cancel()
+	return srv.Shutdown(shutdownCtx)
 }
 
 // Health and version handlers
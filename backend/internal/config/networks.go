@@ -0,0 +1,377 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// NetworkConfig describes an EVM network the backend can connect to
+type NetworkConfig struct {
+	Name         string        `json:"name"`
+	DisplayName  string        `json:"display_name"`
+	ChainID      uint64        `json:"chain_id"`
+	NativeSymbol string        `json:"native_symbol"`
+	NativeName   string        `json:"native_name"`
+	Decimals     uint8         `json:"decimals"`
+	ExplorerURL  string        `json:"explorer_url"`
+	RPCURLs      []string      `json:"rpc_urls"`
+	WSURL        string        `json:"ws_url"`     // WebSocket RPC for new-block subscriptions; empty disables them
+	Multicall3   string        `json:"multicall3"` // empty falls back to JSON-RPC batches
+	Tokens       []TokenConfig `json:"tokens"`
+	// Chainlink USD aggregators keyed by symbol, used as an on-chain price source
+	PriceFeeds map[string]string `json:"price_feeds"`
+	// Etherscan-compatible API for native transfers; empty uses the Etherscan v2 multichain API
+	ExplorerAPIURL string `json:"explorer_api_url"`
+	// Blocks after which a block is treated as final and can no longer be reorged out
+	Finality uint64 `json:"finality"`
+}
+
+// TokenConfig describes an ERC-20 token tracked on a network
+type TokenConfig struct {
+	Address  string `json:"address"`
+	Symbol   string `json:"symbol"`
+	Name     string `json:"name"`
+	Decimals uint8  `json:"decimals"`
+}
+
+// networkEntry is an entry of the networks file. Fields it leaves out keep
+// the values of the network it overrides.
+type networkEntry struct {
+	NetworkConfig
+	Decimals *uint8       `json:"decimals"`
+	Tokens   []tokenEntry `json:"tokens"`
+}
+
+// tokenEntry is a token of a networks file entry. Decimals are required,
+// since 0 is a valid value.
+type tokenEntry struct {
+	TokenConfig
+	Decimals *uint8 `json:"decimals"`
+}
+
+// DefaultFinality is the finality of networks that don't set one
+const DefaultFinality = 64
+
+// Multicall3Address is the canonical Multicall3 deployment, identical on most EVM chains
+const Multicall3Address = "0xcA11bde05977b3631167028862bE2a173976CA11"
+
+// NetworkRegistry holds the configured networks, keyed by name
+type NetworkRegistry struct {
+	networks map[string]*NetworkConfig
+	order    []string
+}
+
+// NewNetworkRegistry creates a registry from a list of network configs.
+// Later entries replace earlier ones with the same name.
+func NewNetworkRegistry(networks []NetworkConfig) *NetworkRegistry {
+	r := &NetworkRegistry{networks: make(map[string]*NetworkConfig)}
+	for _, n := range networks {
+		r.Add(n)
+	}
+	return r
+}
+
+// Add registers a network, replacing any existing entry with the same name
+func (r *NetworkRegistry) Add(network NetworkConfig) {
+	network.Name = strings.ToLower(strings.TrimSpace(network.Name))
+	if network.Name == "" {
+		return
+	}
+	if network.Decimals == 0 {
+		network.Decimals = 18
+	}
+	if network.Finality == 0 {
+		network.Finality = DefaultFinality
+	}
+	if _, exists := r.networks[network.Name]; !exists {
+		r.order = append(r.order, network.Name)
+	}
+	r.networks[network.Name] = &network
+}
+
+// merge applies a networks file entry on top of the registered network with
+// the same name, or registers it as a new network
+func (r *NetworkRegistry) merge(entry networkEntry) error {
+	override := entry.NetworkConfig
+	override.Name = strings.ToLower(strings.TrimSpace(override.Name))
+	if override.Name == "" {
+		return fmt.Errorf("network has no name")
+	}
+	if entry.Decimals != nil && *entry.Decimals == 0 {
+		return fmt.Errorf("network %s: decimals must be greater than 0", override.Name)
+	}
+
+	var tokens []TokenConfig
+	if entry.Tokens != nil {
+		tokens = make([]TokenConfig, 0, len(entry.Tokens))
+		for _, token := range entry.Tokens {
+			if token.Decimals == nil {
+				return fmt.Errorf("network %s: token %s has no decimals", override.Name, token.Address)
+			}
+			token.TokenConfig.Decimals = *token.Decimals
+			tokens = append(tokens, token.TokenConfig)
+		}
+	}
+
+	network := NetworkConfig{Name: override.Name}
+	if existing, exists := r.networks[override.Name]; exists {
+		network = *existing
+	}
+	setString(&network.DisplayName, override.DisplayName)
+	setString(&network.NativeSymbol, override.NativeSymbol)
+	setString(&network.NativeName, override.NativeName)
+	setString(&network.ExplorerURL, override.ExplorerURL)
+	setString(&network.WSURL, override.WSURL)
+	setString(&network.Multicall3, override.Multicall3)
+	setString(&network.ExplorerAPIURL, override.ExplorerAPIURL)
+	if override.ChainID != 0 {
+		network.ChainID = override.ChainID
+	}
+	if entry.Decimals != nil {
+		network.Decimals = *entry.Decimals
+	}
+	if override.RPCURLs != nil {
+		network.RPCURLs = override.RPCURLs
+	}
+	if entry.Tokens != nil {
+		network.Tokens = tokens
+	}
+	if override.PriceFeeds != nil {
+		network.PriceFeeds = override.PriceFeeds
+	}
+	if override.Finality != 0 {
+		network.Finality = override.Finality
+	}
+
+	r.Add(network)
+	return nil
+}
+
+// Get returns the config for a network
+func (r *NetworkRegistry) Get(name string) (*NetworkConfig, bool) {
+	if r == nil {
+		return nil, false
+	}
+	network, exists := r.networks[strings.ToLower(name)]
+	return network, exists
+}
+
+// All returns every registered network in registration order
+func (r *NetworkRegistry) All() []*NetworkConfig {
+	if r == nil {
+		return nil
+	}
+	networks := make([]*NetworkConfig, 0, len(r.order))
+	for _, name := range r.order {
+		networks = append(networks, r.networks[name])
+	}
+	return networks
+}
+
+// loadNetworks builds the network registry from the built-in defaults,
+// an optional JSON file (NETWORKS_FILE) and per-network RPC env vars.
+// File entries add networks or override the fields they set.
+func loadNetworks() *NetworkRegistry {
+	registry := NewNetworkRegistry(defaultNetworks())
+
+	if path := getEnv("NETWORKS_FILE", ""); path != "" {
+		entries, err := readNetworksFile(path)
+		if err != nil {
+			log.Printf("Warning: failed to load networks file %s: %v", path, err)
+		}
+		for _, entry := range entries {
+			if err := registry.merge(entry); err != nil {
+				log.Printf("Warning: skipping entry of networks file %s: %v", path, err)
+			}
+		}
+	}
+
+	// <NAME>_RPC_URL takes precedence over the file and accepts a comma-separated list
+	for _, network := range registry.All() {
+		key := strings.ToUpper(network.Name) + "_RPC_URL"
+		if urls := splitList(os.Getenv(key)); len(urls) > 0 {
+			network.RPCURLs = urls
+		}
+		if wsURL := strings.TrimSpace(os.Getenv(strings.ToUpper(network.Name) + "_WS_URL")); wsURL != "" {
+			network.WSURL = wsURL
+		}
+		if finality, err := strconv.ParseUint(os.Getenv(strings.ToUpper(network.Name)+"_FINALITY"), 10, 64); err == nil && finality > 0 {
+			network.Finality = finality
+		}
+	}
+
+	return registry
+}
+
+// setString overrides a field when the override is set
+func setString(field *string, override string) {
+	if override != "" {
+		*field = override
+	}
+}
+
+func readNetworksFile(path string) ([]networkEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []networkEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("invalid networks file: %w", err)
+	}
+	return entries, nil
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func defaultNetworks() []NetworkConfig {
+	return []NetworkConfig{
+		{
+			Name:         "ethereum",
+			DisplayName:  "Ethereum",
+			ChainID:      1,
+			NativeSymbol: "ETH",
+			NativeName:   "Ethereum",
+			ExplorerURL:  "https://etherscan.io",
+			Multicall3:   Multicall3Address,
+			Finality:     64,
+			RPCURLs:      []string{"https://mainnet.infura.io/v3/your-project-id"},
+			Tokens: []TokenConfig{
+				{"0xdAC17F958D2ee523a2206206994597C13D831ec7", "USDT", "Tether USD", 6},
+				{"0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48", "USDC", "USD Coin", 6},
+				{"0x6B175474E89094C44Da98b954EedeAC495271d0F", "DAI", "Dai Stablecoin", 18},
+				{"0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2", "WETH", "Wrapped Ether", 18},
+			},
+			PriceFeeds: map[string]string{
+				"ETH":  "0x5f4eC3Df9cbd43714FE2740f5E3616155c5b8419",
+				"BTC":  "0xF4030086522a5bEEa4988F8cA5B36dbC97BeE88c",
+				"USDC": "0x8fFfFfd4AfB6115b954Bd326cbe7B4BA576818f6",
+				"USDT": "0x3E7d1eAB13ad0104d2750B8863b489D65364e32D",
+				"DAI":  "0xAed0c38402a5d19df6E4c03F4E2DceD6e29c1ee9",
+				"LINK": "0x2c1d072e956AFFC0D435Cb7AC38EF18d24d9127c",
+			},
+		},
+		{
+			Name:         "polygon",
+			DisplayName:  "Polygon",
+			ChainID:      137,
+			NativeSymbol: "MATIC",
+			NativeName:   "Polygon",
+			ExplorerURL:  "https://polygonscan.com",
+			Multicall3:   Multicall3Address,
+			Finality:     256,
+			Tokens: []TokenConfig{
+				{"0xc2132D05D31c914a87C6611C10748AEb04B58e8F", "USDT", "Tether USD", 6},
+				{"0x2791Bca1f2de4661ED88A30C99A7a9449Aa84174", "USDC", "USD Coin", 6},
+				{"0x8f3Cf7ad23Cd3CaDbD9735AFf958023239c6A063", "DAI", "Dai Stablecoin", 18},
+				{"0x0d500B1d8E8eF31E21C99d1Db9A6444d3ADf1270", "WMATIC", "Wrapped MATIC", 18},
+			},
+		},
+		{
+			Name:         "bsc",
+			DisplayName:  "BNB Smart Chain",
+			ChainID:      56,
+			NativeSymbol: "BNB",
+			NativeName:   "Binance Smart Chain",
+			ExplorerURL:  "https://bscscan.com",
+			Multicall3:   Multicall3Address,
+			Finality:     15,
+			Tokens: []TokenConfig{
+				{"0x55d398326f99059fF775485246999027B3197955", "USDT", "Tether USD", 18},
+				{"0x8AC76a51cc950d9822D68b83fE1Ad97B32Cd580d", "USDC", "USD Coin", 18},
+				{"0x1AF3F329e8BE154074D8769D1FFa4eE058B1DBc3", "DAI", "Dai Stablecoin", 18},
+				{"0x2170Ed0880ac9A755fd29B2688956BD959F933F8", "WETH", "Wrapped Ether", 18},
+			},
+		},
+		{
+			Name:         "arbitrum",
+			DisplayName:  "Arbitrum One",
+			ChainID:      42161,
+			NativeSymbol: "ETH",
+			NativeName:   "Arbitrum",
+			ExplorerURL:  "https://arbiscan.io",
+			Multicall3:   Multicall3Address,
+			Finality:     20,
+			Tokens: []TokenConfig{
+				{"0xFd086bC7CD5C481DCC9C85ebE478A1C0b69FCbb9", "USDT", "Tether USD", 6},
+				{"0xaf88d065e77c8cC2239327C5EDb3A432268e5831", "USDC", "USD Coin", 6},
+				{"0xDA10009cBd5D07dd0CeCc66161FC93D7c9000da1", "DAI", "Dai Stablecoin", 18},
+				{"0x82aF49447D8a07e3bd95BD0d56f35241523fBab1", "WETH", "Wrapped Ether", 18},
+			},
+		},
+		{
+			Name:         "optimism",
+			DisplayName:  "OP Mainnet",
+			ChainID:      10,
+			NativeSymbol: "ETH",
+			NativeName:   "Ether",
+			ExplorerURL:  "https://optimistic.etherscan.io",
+			Multicall3:   Multicall3Address,
+			Finality:     10,
+			Tokens: []TokenConfig{
+				{"0x94b008aA00579c1307B0EF2c499aD98a8ce58e58", "USDT", "Tether USD", 6},
+				{"0x0b2C639c533813f4Aa9D7837CAf62653d097Ff85", "USDC", "USD Coin", 6},
+				{"0xDA10009cBd5D07dd0CeCc66161FC93D7c9000da1", "DAI", "Dai Stablecoin", 18},
+				{"0x4200000000000000000000000000000000000006", "WETH", "Wrapped Ether", 18},
+			},
+		},
+		{
+			Name:         "base",
+			DisplayName:  "Base",
+			ChainID:      8453,
+			NativeSymbol: "ETH",
+			NativeName:   "Ether",
+			ExplorerURL:  "https://basescan.org",
+			Multicall3:   Multicall3Address,
+			Finality:     10,
+			Tokens: []TokenConfig{
+				{"0x833589fCD6eDb6E08f4c7C32D4f71b54bdA02913", "USDC", "USD Coin", 6},
+				{"0x50c5725949A6F0c72E6C4a641F24049A917DB0Cb", "DAI", "Dai Stablecoin", 18},
+				{"0x4200000000000000000000000000000000000006", "WETH", "Wrapped Ether", 18},
+			},
+		},
+		{
+			Name:         "avalanche",
+			DisplayName:  "Avalanche C-Chain",
+			ChainID:      43114,
+			NativeSymbol: "AVAX",
+			NativeName:   "Avalanche",
+			ExplorerURL:  "https://snowtrace.io",
+			Multicall3:   Multicall3Address,
+			Finality:     1,
+			Tokens: []TokenConfig{
+				{"0x9702230A8Ea53601f5cD2dc00fDBc13d4dF4A8c7", "USDT", "Tether USD", 6},
+				{"0xB97EF9Ef8734C71904D8002F8b6Bc66Dd9c48a6E", "USDC", "USD Coin", 6},
+				{"0xB31f66AA3C1e785363F0875A1B74E27b85FD66c7", "WAVAX", "Wrapped AVAX", 18},
+			},
+		},
+		{
+			Name:         "linea",
+			DisplayName:  "Linea",
+			ChainID:      59144,
+			NativeSymbol: "ETH",
+			NativeName:   "Ether",
+			ExplorerURL:  "https://lineascan.build",
+			Multicall3:   Multicall3Address,
+			Finality:     10,
+			Tokens: []TokenConfig{
+				{"0x176211869cA2b568f2A7D4EE941E073a821EE1ff", "USDC", "USD Coin", 6},
+				{"0xe5D7C2a44FfDDf6b295A15c148167daaAf5Cf34f", "WETH", "Wrapped Ether", 18},
+			},
+		},
+	}
+}
@@ -0,0 +1,134 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func loadNetworksFrom(t *testing.T, file string) *NetworkRegistry {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "networks.json")
+	require.NoError(t, os.WriteFile(path, []byte(file), 0o600))
+	t.Setenv("NETWORKS_FILE", path)
+	t.Setenv("ETHEREUM_RPC_URL", "")
+	return loadNetworks()
+}
+
+func TestNetworksFileMergesFields(t *testing.T) {
+	defaults := NewNetworkRegistry(defaultNetworks())
+	ethereum, _ := defaults.Get("ethereum")
+
+	for _, tc := range []struct {
+		name  string
+		file  string
+		check func(t *testing.T, network *NetworkConfig)
+	}{
+		{
+			name: "fields left out keep the defaults",
+			file: `[{"name": "Ethereum", "explorer_url": "https://eth.blockscout.com"}]`,
+			check: func(t *testing.T, network *NetworkConfig) {
+				require.Equal(t, "https://eth.blockscout.com", network.ExplorerURL)
+				require.Equal(t, ethereum.RPCURLs, network.RPCURLs)
+				require.Equal(t, ethereum.Tokens, network.Tokens)
+				require.Equal(t, ethereum.PriceFeeds, network.PriceFeeds)
+				require.Equal(t, uint8(18), network.Decimals)
+				require.Equal(t, uint64(64), network.Finality)
+			},
+		},
+		{
+			name: "fields set replace the defaults",
+			file: `[{"name": "ethereum", "rpc_urls": ["https://eth.example"], "finality": 32,
+				"tokens": [{"address": "0x6B175474E89094C44Da98b954EedeAC495271d0F", "symbol": "DAI", "decimals": 18}]}]`,
+			check: func(t *testing.T, network *NetworkConfig) {
+				require.Equal(t, []string{"https://eth.example"}, network.RPCURLs)
+				require.Equal(t, []TokenConfig{{Address: "0x6B175474E89094C44Da98b954EedeAC495271d0F", Symbol: "DAI", Decimals: 18}}, network.Tokens)
+				require.Equal(t, uint64(32), network.Finality)
+				require.Equal(t, ethereum.ChainID, network.ChainID)
+			},
+		},
+		{
+			name: "an empty token list clears the tokens",
+			file: `[{"name": "ethereum", "tokens": []}]`,
+			check: func(t *testing.T, network *NetworkConfig) {
+				require.Empty(t, network.Tokens)
+				require.Equal(t, ethereum.RPCURLs, network.RPCURLs)
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			network, ok := loadNetworksFrom(t, tc.file).Get("ethereum")
+			require.True(t, ok)
+			tc.check(t, network)
+		})
+	}
+}
+
+func TestNetworksFileDecimals(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		entry    string
+		added    bool
+		decimals uint8 // of the network
+		tokens   []TokenConfig
+	}{
+		{
+			name:     "a new network without decimals uses 18",
+			entry:    `{"name": "zksync", "chain_id": 324}`,
+			added:    true,
+			decimals: 18,
+		},
+		{
+			name:     "network decimals are kept",
+			entry:    `{"name": "zksync", "decimals": 9}`,
+			added:    true,
+			decimals: 9,
+		},
+		{
+			name:  "network decimals of 0 reject the entry",
+			entry: `{"name": "zksync", "decimals": 0}`,
+		},
+		{
+			name:     "a token with 0 decimals keeps them",
+			entry:    `{"name": "zksync", "tokens": [{"address": "0x01", "symbol": "PTS", "decimals": 0}]}`,
+			added:    true,
+			decimals: 18,
+			tokens:   []TokenConfig{{Address: "0x01", Symbol: "PTS", Decimals: 0}},
+		},
+		{
+			name:  "a token without decimals rejects the entry",
+			entry: `{"name": "zksync", "tokens": [{"address": "0x01", "symbol": "PTS"}]}`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			network, ok := loadNetworksFrom(t, "["+tc.entry+"]").Get("zksync")
+			require.Equal(t, tc.added, ok)
+			if !tc.added {
+				return
+			}
+			require.Equal(t, tc.decimals, network.Decimals)
+			require.Equal(t, tc.tokens, network.Tokens)
+		})
+	}
+}
+
+func TestNetworkEnvOverridesFile(t *testing.T) {
+	t.Setenv("POLYGON_RPC_URL", "https://a.example, https://b.example")
+	t.Setenv("POLYGON_FINALITY", "128")
+	registry := loadNetworksFrom(t, `[{"name": "polygon", "rpc_urls": ["https://file.example"], "ws_url": "wss://file.example", "finality": 512}]`)
+
+	polygon, ok := registry.Get("polygon")
+	require.True(t, ok)
+	require.Equal(t, []string{"https://a.example", "https://b.example"}, polygon.RPCURLs)
+	require.Equal(t, "wss://file.example", polygon.WSURL)
+	require.Equal(t, uint64(128), polygon.Finality)
+
+	// Overrides keep the built-in order
+	names := make([]string, 0, len(registry.All()))
+	for _, network := range registry.All() {
+		names = append(names, network.Name)
+	}
+	require.Equal(t, []string{"ethereum", "polygon", "bsc", "arbitrum", "optimism", "base", "avalanche", "linea"}, names)
+}
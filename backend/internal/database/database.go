@@ -11,6 +11,7 @@ import (
 	"gorm.io/gorm/logger"
 
 	"web3-portfolio-dashboard/backend/internal/models"
+	"web3-portfolio-dashboard/backend/internal/services"
 )
 
 // New creates a new database connection
@@ -68,6 +69,18 @@ func Migrate(db *gorm.DB) error {
 		}
 	}
 
+	// Balances used to be inserted on every refresh; keep only the newest row
+	// per holding so the unique index can be created
+	if db.Migrator().HasTable(&models.Balance{}) && !db.Migrator().HasColumn(&models.Balance{}, "Network") {
+		err := db.Exec(`DELETE FROM balances WHERE EXISTS (
+			SELECT 1 FROM balances newer
+			WHERE newer.address_id = balances.address_id AND newer.token_address = balances.token_address
+			AND (newer.updated_at > balances.updated_at OR (newer.updated_at = balances.updated_at AND newer.id > balances.id)))`).Error
+		if err != nil {
+			return fmt.Errorf("failed to remove duplicate balances: %w", err)
+		}
+	}
+
 	// Migrate all models at once
 	if err := db.AutoMigrate(
 		&models.User{},
@@ -75,7 +88,17 @@ func Migrate(db *gorm.DB) error {
 		&models.Address{},
 		&models.Transaction{},
 		&models.Alert{},
+		&models.NotifiedTransaction{},
+		&models.Notification{},
+		&models.NotificationDeadLetter{},
 		&models.Balance{},
+		&models.Token{},
+		&models.PriceSnapshot{},
+		&models.PortfolioSnapshot{},
+		&models.SyncCursor{},
+		&models.IndexedBlock{},
+		&models.JobRun{},
+		&models.SchedulerLock{},
 		// Forum models
 		&models.Question{},
 		&models.Answer{},
@@ -88,11 +111,70 @@ func Migrate(db *gorm.DB) error {
 		return fmt.Errorf("failed to run migrations: %w", err)
 	}
 
+	// Rows left by the old mock transaction refresh have made-up hashes and no log index
+	if err := db.Exec("DELETE FROM transactions WHERE log_index IS NULL").Error; err != nil {
+		return fmt.Errorf("failed to remove placeholder transactions: %w", err)
+	}
+
+	// Balances stored before they had a network take it from their address
+	if err := db.Exec("UPDATE balances SET network = (SELECT network FROM addresses WHERE addresses.id = balances.address_id) WHERE network = ''").Error; err != nil {
+		return fmt.Errorf("failed to set balance networks: %w", err)
+	}
+
+	if err := migrateAlertConditions(db); err != nil {
+		return err
+	}
+
 	log.Println("✅ All tables migrated successfully")
 	log.Println("Database migrations completed successfully")
 	return nil
 }
 
+// migrateAlertConditions rewrites alert conditions stored before they were
+// versioned, and deactivates alerts whose conditions can no longer be read or
+// don't pass the checks new alerts get, since they would fail every check
+func migrateAlertConditions(db *gorm.DB) error {
+	var rows []struct {
+		ID         string
+		Type       string
+		Conditions string
+		IsActive   bool
+	}
+	if err := db.Table("alerts").Select("id, type, conditions, is_active").Scan(&rows).Error; err != nil {
+		return fmt.Errorf("failed to load alert conditions: %w", err)
+	}
+
+	deactivate := func(id, reason string, err error) error {
+		log.Printf("❌ Deactivating alert %s with %s conditions: %v", id, reason, err)
+		if err := db.Table("alerts").Where("id = ?", id).Update("is_active", false).Error; err != nil {
+			return fmt.Errorf("failed to deactivate alert %s: %w", id, err)
+		}
+		return nil
+	}
+	for _, row := range rows {
+		conditions, err := models.DecodeAlertConditions([]byte(row.Conditions), false)
+		if err != nil {
+			if err := deactivate(row.ID, "unreadable", err); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := services.ValidateAlertConditions(row.Type, conditions); err != nil && row.IsActive {
+			if err := deactivate(row.ID, "invalid", err); err != nil {
+				return err
+			}
+		}
+		if conditions.Version == models.AlertConditionsVersion {
+			continue
+		}
+		err = db.Model(&models.Alert{}).Where("id = ?", row.ID).Select("Conditions").Updates(&models.Alert{Conditions: *conditions}).Error
+		if err != nil {
+			return fmt.Errorf("failed to migrate conditions of alert %s: %w", row.ID, err)
+		}
+	}
+	return nil
+}
+
 // CreateIndexes creates additional database indexes
 func CreateIndexes(db *gorm.DB) error {
 	log.Println("Creating database indexes...")
@@ -0,0 +1,46 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"web3-portfolio-dashboard/backend/internal/models"
+)
+
+func TestMigrateAlertConditionsDeactivatesInvalidAlerts(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "alerts.db")), &gorm.Config{})
+	require.NoError(t, err)
+	for _, statement := range []string{
+		`CREATE TABLE alerts (id TEXT PRIMARY KEY, type TEXT, conditions TEXT, is_active NUMERIC, updated_at DATETIME)`,
+		`INSERT INTO alerts VALUES
+			('legacy', 'price', '{"type": "price", "token": "ETH", "operator": ">", "value": 3000}', true, NULL),
+			('mismatched', 'balance', '{"type": "price", "token": "ETH", "operator": ">", "value": 3000}', true, NULL),
+			('incomplete', 'balance', '{"type": "balance", "operator": "<"}', true, NULL),
+			('unreadable', 'price', 'not json', true, NULL)`,
+	} {
+		require.NoError(t, db.Exec(statement).Error)
+	}
+
+	require.NoError(t, migrateAlertConditions(db))
+
+	var rows []struct {
+		ID         string
+		Conditions string
+		IsActive   bool
+	}
+	require.NoError(t, db.Table("alerts").Order("id").Find(&rows).Error)
+	active := make(map[string]bool, len(rows))
+	for _, row := range rows {
+		active[row.ID] = row.IsActive
+		if row.ID != "unreadable" {
+			conditions, err := models.DecodeAlertConditions([]byte(row.Conditions), true)
+			require.NoError(t, err, row.ID)
+			require.Equal(t, models.AlertConditionsVersion, conditions.Version, row.ID)
+		}
+	}
+	require.Equal(t, map[string]bool{"legacy": true, "mismatched": false, "incomplete": false, "unreadable": false}, active)
+}
@@ -0,0 +1,314 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// AlertConditionsVersion is the current shape of stored alert conditions.
+// Version 0 is the untyped JSON stored before conditions were versioned.
+const AlertConditionsVersion = 1
+
+func init() {
+	schema.RegisterSerializer("alert_conditions", AlertConditionsSerializer{})
+}
+
+// AlertConditions is an alert's condition tree and the version of its shape
+type AlertConditions struct {
+	Version int `json:"version"`
+	AlertCondition
+}
+
+// AlertCondition is a group of conditions that must all (And) or any (Or)
+// hold, or a single comparison of a live value with Value
+type AlertCondition struct {
+	And []AlertCondition `json:"and,omitempty"`
+	Or  []AlertCondition `json:"or,omitempty"`
+
+	Type        string      `json:"type,omitempty"` // price, balance, transaction, portfolio_value, gas
+	Token       string      `json:"token,omitempty"`
+	Address     string      `json:"address,omitempty"`
+	Network     string      `json:"network,omitempty"`
+	PortfolioID string      `json:"portfolio_id,omitempty"`
+	Operator    string      `json:"operator,omitempty"`
+	Value       json.Number `json:"value,omitempty"` // a JSON number or decimal string, kept exact
+	Window      string      `json:"window,omitempty"`
+	Fee         string      `json:"fee,omitempty"` // for gas conditions: base_fee, slow, standard or fast
+
+	// Filters on the transactions that set off a transaction condition
+	Direction             string      `json:"direction,omitempty"` // in or out, relative to Address
+	MinAmount             json.Number `json:"min_amount,omitempty"`
+	Counterparties        []string    `json:"counterparties,omitempty"`
+	ExcludeCounterparties []string    `json:"exclude_counterparties,omitempty"`
+	Methods               []string    `json:"methods,omitempty"` // method names or 4-byte selectors
+}
+
+// conditionFieldKinds maps each condition field to the JSON kind it holds
+var conditionFieldKinds = map[string]string{
+	"and":          "list",
+	"or":           "list",
+	"type":         "string",
+	"token":        "string",
+	"address":      "string",
+	"network":      "string",
+	"portfolio_id": "string",
+	"operator":     "string",
+	"value":        "number",
+	"window":       "string",
+	"fee":          "string",
+
+	"direction":              "string",
+	"min_amount":             "number",
+	"counterparties":         "strings",
+	"exclude_counterparties": "strings",
+	"methods":                "strings",
+}
+
+// UnmarshalJSON decodes conditions sent by a client, rejecting unknown fields
+// and values of the wrong type with the path to the offending field
+func (c *AlertConditions) UnmarshalJSON(data []byte) error {
+	conditions, err := DecodeAlertConditions(data, true)
+	if err != nil {
+		return err
+	}
+	*c = *conditions
+	return nil
+}
+
+// DecodeAlertConditions decodes conditions JSON. Strict decoding fails on
+// unknown fields and mistyped values; lenient decoding, used for stored rows,
+// drops them so older alerts still load.
+func DecodeAlertConditions(data []byte, strict bool) (*AlertConditions, error) {
+	var raw interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("conditions: %w", err)
+	}
+	root, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("conditions: must be an object")
+	}
+
+	conditions := &AlertConditions{}
+	if version, ok := root["version"]; ok {
+		number, ok := version.(json.Number)
+		v, err := number.Int64()
+		if !ok || err != nil || v < 0 || v > AlertConditionsVersion {
+			return nil, fmt.Errorf("conditions.version: unsupported version %v", version)
+		}
+		conditions.Version = int(v)
+		delete(root, "version")
+	}
+
+	condition, err := decodeCondition(root, "conditions", strict)
+	if err != nil {
+		return nil, err
+	}
+	conditions.AlertCondition = *condition
+	return conditions, nil
+}
+
+func decodeCondition(node map[string]interface{}, path string, strict bool) (*AlertCondition, error) {
+	fields := make([]string, 0, len(node))
+	for field := range node {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	condition := &AlertCondition{}
+	for _, field := range fields {
+		value := node[field]
+		fieldPath := path + "." + field
+
+		switch conditionFieldKinds[field] {
+		case "list":
+			items, ok := value.([]interface{})
+			if !ok {
+				if strict {
+					return nil, fmt.Errorf("%s: must be a list", fieldPath)
+				}
+				continue
+			}
+			group := make([]AlertCondition, 0, len(items))
+			for i, item := range items {
+				itemPath := fmt.Sprintf("%s[%d]", fieldPath, i)
+				child, ok := item.(map[string]interface{})
+				if !ok {
+					if strict {
+						return nil, fmt.Errorf("%s: must be an object", itemPath)
+					}
+					continue
+				}
+				decoded, err := decodeCondition(child, itemPath, strict)
+				if err != nil {
+					return nil, err
+				}
+				group = append(group, *decoded)
+			}
+			if field == "and" {
+				condition.And = group
+			} else {
+				condition.Or = group
+			}
+		case "string":
+			text, ok := value.(string)
+			if !ok {
+				if strict {
+					return nil, fmt.Errorf("%s: must be a string", fieldPath)
+				}
+				continue
+			}
+			condition.setString(field, text)
+		case "number":
+			number, ok := conditionNumber(value)
+			if !ok {
+				if strict {
+					return nil, fmt.Errorf("%s: must be a number or decimal string", fieldPath)
+				}
+				continue
+			}
+			if field == "value" {
+				condition.Value = number
+			} else {
+				condition.MinAmount = number
+			}
+		case "strings":
+			list, ok := conditionStrings(value)
+			if !ok {
+				if strict {
+					return nil, fmt.Errorf("%s: must be a list of strings", fieldPath)
+				}
+				continue
+			}
+			condition.setStrings(field, list)
+		default:
+			if strict {
+				return nil, fmt.Errorf("%s: unknown field", fieldPath)
+			}
+		}
+	}
+	return condition, nil
+}
+
+func (c *AlertCondition) setString(field, value string) {
+	switch field {
+	case "type":
+		c.Type = value
+	case "token":
+		c.Token = value
+	case "address":
+		c.Address = value
+	case "network":
+		c.Network = value
+	case "portfolio_id":
+		c.PortfolioID = value
+	case "operator":
+		c.Operator = value
+	case "window":
+		c.Window = value
+	case "fee":
+		c.Fee = value
+	case "direction":
+		c.Direction = value
+	}
+}
+
+func (c *AlertCondition) setStrings(field string, values []string) {
+	switch field {
+	case "counterparties":
+		c.Counterparties = values
+	case "exclude_counterparties":
+		c.ExcludeCounterparties = values
+	case "methods":
+		c.Methods = values
+	}
+}
+
+// conditionStrings accepts a JSON list of strings
+func conditionStrings(value interface{}) ([]string, bool) {
+	items, ok := value.([]interface{})
+	if !ok {
+		return nil, false
+	}
+	list := make([]string, 0, len(items))
+	for _, item := range items {
+		text, ok := item.(string)
+		if !ok {
+			return nil, false
+		}
+		list = append(list, text)
+	}
+	return list, true
+}
+
+// conditionNumber accepts a JSON number or a string holding one
+func conditionNumber(value interface{}) (json.Number, bool) {
+	switch v := value.(type) {
+	case json.Number:
+		return v, true
+	case string:
+		var number json.Number
+		text := strings.TrimSpace(v)
+		if err := json.Unmarshal([]byte(text), &number); err != nil || text == "" || text[0] == '"' {
+			return "", false
+		}
+		return number, true
+	}
+	return "", false
+}
+
+// GormDBDataType stores conditions as jsonb on Postgres and text elsewhere
+func (AlertConditions) GormDBDataType(db *gorm.DB, field *schema.Field) string {
+	if db.Dialector.Name() == "postgres" {
+		return "jsonb"
+	}
+	return "text"
+}
+
+// AlertConditionsSerializer stores AlertConditions as JSON at the current
+// version and loads older versions leniently
+type AlertConditionsSerializer struct{}
+
+func (AlertConditionsSerializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	var data []byte
+	switch v := dbValue.(type) {
+	case nil:
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("failed to scan alert conditions: unsupported type %T", dbValue)
+	}
+
+	conditions, err := DecodeAlertConditions(data, false)
+	if err != nil {
+		return err
+	}
+	field.ReflectValueOf(ctx, dst).Set(reflect.ValueOf(*conditions))
+	return nil
+}
+
+func (AlertConditionsSerializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	conditions, ok := fieldValue.(AlertConditions)
+	if !ok {
+		return nil, fmt.Errorf("failed to store alert conditions: unsupported type %T", fieldValue)
+	}
+	conditions.Version = AlertConditionsVersion
+	data, err := json.Marshal(conditions)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// JSON is a JSON document kept as a string, stored as jsonb on Postgres and
+// text elsewhere
+type JSON string
+
+func (JSON) GormDBDataType(db *gorm.DB, field *schema.Field) string {
+	return jsonDataType(db)
+}
+
+func jsonDataType(db *gorm.DB) string {
+	if db.Dialector.Name() == "postgres" {
+		return "jsonb"
+	}
+	return "text"
+}
+
+// StringList is a list of strings stored as a JSON array, as jsonb on
+// Postgres and text elsewhere
+type StringList []string
+
+func (StringList) GormDBDataType(db *gorm.DB, field *schema.Field) string {
+	return jsonDataType(db)
+}
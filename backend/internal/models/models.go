@@ -47,41 +47,150 @@ type Address struct {
 	Label       string    `json:"label"`
 	IsActive    bool      `json:"is_active" gorm:"default:true"`
 	Balances    []Balance `json:"balances" gorm:"foreignKey:AddressID"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	// When balances were last read in full; nil until the first refresh
+	LastRefreshedAt *time.Time `json:"last_refreshed_at"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
 }
 
 // Transaction represents a blockchain transaction
 type Transaction struct {
-	ID           uuid.UUID `json:"id" gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
-	PortfolioID  uuid.UUID `json:"portfolio_id" gorm:"type:uuid;not null"`
-	TxHash       string    `json:"tx_hash" gorm:"not null"`
-	Network      string    `json:"network" gorm:"not null"`
-	TokenAddress string    `json:"token_address"`
-	Amount       string    `json:"amount" gorm:"type:decimal(65,18)"`
-	BlockNumber  uint64    `json:"block_number"`
-	Timestamp    time.Time `json:"timestamp"`
-	CreatedAt    time.Time `json:"created_at"`
+	ID            uuid.UUID `json:"id" gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+	PortfolioID   uuid.UUID `json:"portfolio_id" gorm:"type:uuid;not null;uniqueIndex:idx_transactions_identity"`
+	AddressID     uuid.UUID `json:"address_id" gorm:"type:uuid;index"`
+	TxHash        string    `json:"tx_hash" gorm:"not null;uniqueIndex:idx_transactions_identity"`
+	Network       string    `json:"network" gorm:"not null;uniqueIndex:idx_transactions_identity"`
+	LogIndex      int       `json:"log_index" gorm:"uniqueIndex:idx_transactions_identity"` // -1 for the native value transfer
+	FromAddress   string    `json:"from" gorm:"default:''"`
+	ToAddress     string    `json:"to" gorm:"default:''"`
+	Direction     string    `json:"direction" gorm:"default:''"` // in, out or self, relative to the portfolio
+	Status        string    `json:"status" gorm:"default:'success'"`
+	TokenAddress  string    `json:"token_address"`
+	TokenSymbol   string    `json:"token_symbol" gorm:"default:''"`
+	TokenDecimals uint8     `json:"token_decimals" gorm:"default:0"`
+	Amount        string    `json:"amount" gorm:"type:decimal(65,18)"`
+	MethodID      string    `json:"method_id" gorm:"default:''"` // 4-byte selector of the call
+	Method        string    `json:"method" gorm:"default:''"`    // swap, approve, transfer, ...
+	GasUsed       uint64    `json:"gas_used" gorm:"default:0"`
+	Fee           string    `json:"fee" gorm:"type:decimal(65,18);default:0"` // gas paid by the portfolio, in the native token
+	FeeValue      *string   `json:"fee_value" gorm:"type:decimal(20,8)"`      // fee in USD at the time; nil when unpriced
+	BlockNumber   uint64    `json:"block_number"`
+	BlockHash     string    `json:"block_hash" gorm:"default:''"`
+	Confirmations uint64    `json:"confirmations" gorm:"default:0"` // blocks from this one to the head, counted up to the network's finality
+	Finalized     bool      `json:"finalized" gorm:"default:false;index"`
+	Timestamp     time.Time `json:"timestamp"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// SyncCursor records the last block indexed for an address
+type SyncCursor struct {
+	ID        uuid.UUID `json:"id" gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+	AddressID uuid.UUID `json:"address_id" gorm:"type:uuid;not null;uniqueIndex"`
+	LastBlock uint64    `json:"last_block"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// IndexedBlock is a recent block of a network as the indexer last read it;
+// a stored block that is no longer on the chain was reorged out
+type IndexedBlock struct {
+	Network    string    `json:"network" gorm:"primaryKey"`
+	Number     uint64    `json:"number" gorm:"primaryKey;autoIncrement:false"`
+	Hash       string    `json:"hash" gorm:"not null"`
+	ParentHash string    `json:"parent_hash" gorm:"not null"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// Notification is a triggered alert stored for its user
+type Notification struct {
+	ID        uuid.UUID  `json:"id" gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+	UserID    uuid.UUID  `json:"user_id" gorm:"type:uuid;not null;index:idx_notifications_user_created"`
+	AlertID   uuid.UUID  `json:"alert_id" gorm:"type:uuid;index"`
+	Type      string     `json:"type" gorm:"not null"`
+	Message   string     `json:"message"`
+	Data      JSON       `json:"data" gorm:"not null"` // trigger payload
+	ReadAt    *time.Time `json:"read_at"`
+	CreatedAt time.Time  `json:"created_at" gorm:"index:idx_notifications_user_created"`
+}
+
+// NotificationDeadLetter records a notification that could not be delivered on a channel
+type NotificationDeadLetter struct {
+	ID             uuid.UUID `json:"id" gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+	NotificationID uuid.UUID `json:"notification_id" gorm:"type:uuid;not null;index"`
+	UserID         uuid.UUID `json:"user_id" gorm:"type:uuid;not null;index"`
+	Channel        string    `json:"channel" gorm:"not null"`
+	Attempts       int       `json:"attempts"`
+	Error          string    `json:"error"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// JobRun records one run of a scheduled background job
+type JobRun struct {
+	ID         uuid.UUID  `json:"id" gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+	Job        string     `json:"job" gorm:"not null;index:idx_job_runs_job_started"`
+	Instance   string     `json:"instance"`               // replica that ran the job
+	Status     string     `json:"status" gorm:"not null"` // running, success, failed
+	Processed  int        `json:"processed"`
+	Error      string     `json:"error"`
+	StartedAt  time.Time  `json:"started_at" gorm:"not null;index:idx_job_runs_job_started"`
+	FinishedAt *time.Time `json:"finished_at"`
+}
+
+// SchedulerLock is a lease on a named lock; the scheduler holds one so that a
+// single replica runs background jobs
+type SchedulerLock struct {
+	Name      string    `json:"name" gorm:"primaryKey"`
+	Holder    string    `json:"holder" gorm:"not null"`
+	ExpiresAt time.Time `json:"expires_at" gorm:"not null"`
 }
 
 // Alert represents a user's alert
 type Alert struct {
-	ID         uuid.UUID `json:"id" gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
-	UserID     uuid.UUID `json:"user_id" gorm:"type:uuid;not null"`
-	Type       string    `json:"type" gorm:"not null"`
-	Name       string    `json:"name" gorm:"not null"`
-	Conditions string    `json:"conditions" gorm:"type:jsonb;not null"`
-	IsActive   bool      `json:"is_active" gorm:"default:true"`
-	CreatedAt  time.Time `json:"created_at"`
-	UpdatedAt  time.Time `json:"updated_at"`
+	ID         uuid.UUID       `json:"id" gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+	UserID     uuid.UUID       `json:"user_id" gorm:"type:uuid;not null"`
+	Type       string          `json:"type" gorm:"not null"`
+	Name       string          `json:"name" gorm:"not null"`
+	Conditions AlertConditions `json:"conditions" gorm:"serializer:alert_conditions;not null"`
+	Channels   StringList      `json:"channels" gorm:"serializer:json"` // delivery besides in-app: webhook, discord, email
+	WebhookURL string          `json:"webhook_url"`
+	// WebhookSecret signs the alert's webhooks. It is only shown to the owner
+	// once, as NewWebhookSecret in the response that issued it.
+	WebhookSecret    string `json:"-"`
+	NewWebhookSecret string `json:"webhook_secret,omitempty" gorm:"-"`
+	IsActive   bool            `json:"is_active" gorm:"default:true"`
+
+	// Re-triggering: after firing, an alert is disarmed until its condition
+	// clears by RearmPercent of the target, and never fires twice within
+	// CooldownSeconds. FireOnce deactivates it after the first trigger.
+	CooldownSeconds int        `json:"cooldown_seconds" gorm:"not null;default:0"`
+	RearmPercent    float64    `json:"rearm_percent" gorm:"not null;default:0"`
+	FireOnce        bool       `json:"fire_once" gorm:"not null;default:false"`
+	Armed           bool       `json:"armed" gorm:"not null;default:true"`
+	LastTriggeredAt *time.Time `json:"last_triggered_at"`
+	TriggerCount    int        `json:"trigger_count" gorm:"not null;default:0"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// NotifiedTransaction is a transfer a transaction alert has fired for. A
+// transfer indexed again after a reorg is the same transfer and doesn't
+// fire the alert twice.
+type NotifiedTransaction struct {
+	AlertID     uuid.UUID `json:"alert_id" gorm:"type:uuid;primaryKey"`
+	Network     string    `json:"network" gorm:"primaryKey"`
+	TxHash      string    `json:"tx_hash" gorm:"primaryKey"`
+	LogIndex    int       `json:"log_index" gorm:"primaryKey;autoIncrement:false"`
+	BlockNumber uint64    `json:"block_number"`
+	CreatedAt   time.Time `json:"created_at"`
 }
 
 // Balance represents a token balance
 type Balance struct {
 	ID           uuid.UUID `json:"id" gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
-	AddressID    uuid.UUID `json:"address_id" gorm:"type:uuid;not null"`
+	AddressID    uuid.UUID `json:"address_id" gorm:"type:uuid;not null;uniqueIndex:idx_balances_holding"`
 	Address      Address   `json:"address" gorm:"foreignKey:AddressID"`
-	TokenAddress string    `json:"token_address"`
+	Network      string    `json:"network" gorm:"not null;default:'';uniqueIndex:idx_balances_holding"`
+	TokenAddress string    `json:"token_address" gorm:"uniqueIndex:idx_balances_holding"` // empty for the native token
 	Symbol       string    `json:"symbol"`
 	Name         string    `json:"name"`
 	Amount       string    `json:"amount" gorm:"type:decimal(65,18)"`
@@ -91,6 +200,41 @@ type Balance struct {
 	UpdatedAt    time.Time `json:"updated_at"`
 }
 
+// Token is a catalog entry for an ERC-20 token on a network
+type Token struct {
+	ID         uuid.UUID `json:"id" gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+	Network    string    `json:"network" gorm:"not null;uniqueIndex:idx_tokens_network_address"`
+	Address    string    `json:"address" gorm:"not null;uniqueIndex:idx_tokens_network_address"` // checksummed
+	Symbol     string    `json:"symbol"`
+	Name       string    `json:"name"`
+	Decimals   uint8     `json:"decimals"`
+	LogoURI    string    `json:"logo_uri"`
+	Source     string    `json:"source" gorm:"not null;default:'onchain'"` // onchain, tokenlist, admin
+	IsOverride bool      `json:"is_override" gorm:"default:false"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// PriceSnapshot is a USD price of a token symbol at a point in time
+type PriceSnapshot struct {
+	ID        uuid.UUID `json:"id" gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+	Symbol    string    `json:"symbol" gorm:"not null;uniqueIndex:idx_price_snapshots_symbol_timestamp"`
+	Price     float64   `json:"price" gorm:"not null"`
+	Source    string    `json:"source"`
+	Timestamp time.Time `json:"timestamp" gorm:"not null;uniqueIndex:idx_price_snapshots_symbol_timestamp"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PortfolioSnapshot is the value of a portfolio at a point in time
+type PortfolioSnapshot struct {
+	ID          uuid.UUID `json:"id" gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+	PortfolioID uuid.UUID `json:"portfolio_id" gorm:"type:uuid;not null;index:idx_portfolio_snapshots_portfolio_timestamp"`
+	TotalValue  string    `json:"total_value" gorm:"type:decimal(20,8)"`
+	Assets      JSON      `json:"assets" gorm:"not null"` // per-asset values
+	Timestamp   time.Time `json:"timestamp" gorm:"not null;index:idx_portfolio_snapshots_portfolio_timestamp"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
 // Forum models
 
 type Question struct {
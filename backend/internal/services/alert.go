@@ -1,27 +1,33 @@
 package services
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"log"
+	"math/big"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
 	"web3-portfolio-dashboard/backend/internal/models"
 )
 
 type AlertService struct {
-	db *gorm.DB
-}
-
-type AlertCondition struct {
-	Type      string      `json:"type"`      // price, balance, transaction
-	Operator  string      `json:"operator"`  // >, <, >=, <=, ==, !=
-	Value     interface{} `json:"value"`
-	Token     string      `json:"token,omitempty"`
-	Network   string      `json:"network,omitempty"`
-	Address   string      `json:"address,omitempty"`
+	db            *gorm.DB
+	web3Service   *Web3Service
+	priceHistory  *PriceHistoryService
+	gasOracle     *GasOracle
+	notifications *NotificationService
+	events        *EventHub
 }
 
 type AlertNotification struct {
@@ -32,8 +38,23 @@ type AlertNotification struct {
 	Timestamp time.Time              `json:"timestamp"`
 }
 
-func NewAlertService(db *gorm.DB) *AlertService {
-	return &AlertService{db: db}
+// AlertSettings are an alert's delivery and re-trigger settings. On update,
+// nil fields keep their current value.
+type AlertSettings struct {
+	Channels        []string `json:"channels"`
+	WebhookURL      string   `json:"webhook_url"`
+	CooldownSeconds *int     `json:"cooldown_seconds"`
+	RearmPercent    *float64 `json:"rearm_percent"`
+	FireOnce        *bool    `json:"fire_once"`
+}
+
+func NewAlertService(db *gorm.DB, web3 *Web3Service, priceHistory *PriceHistoryService, gasOracle *GasOracle, notifications *NotificationService) *AlertService {
+	return &AlertService{db: db, web3Service: web3, priceHistory: priceHistory, gasOracle: gasOracle, notifications: notifications}
+}
+
+// SetEventHub makes triggered alerts push to the user's clients
+func (s *AlertService) SetEventHub(events *EventHub) {
+	s.events = events
 }
 
 // GetAlerts retrieves all alerts for a user
@@ -53,7 +74,7 @@ func (s *AlertService) GetAlerts(userID string) ([]models.Alert, error) {
 }
 
 // CreateAlert creates a new alert
-func (s *AlertService) CreateAlert(userID, alertType, name string, conditions map[string]interface{}) (*models.Alert, error) {
+func (s *AlertService) CreateAlert(userID, alertType, name string, conditions models.AlertConditions, settings AlertSettings) (*models.Alert, error) {
 	userUUID, err := uuid.Parse(userID)
 	if err != nil {
 		return nil, fmt.Errorf("invalid user ID: %w", err)
@@ -65,22 +86,20 @@ func (s *AlertService) CreateAlert(userID, alertType, name string, conditions ma
 	}
 
 	// Validate conditions
-	if err := s.validateConditions(conditions); err != nil {
+	if err := ValidateAlertConditions(alertType, &conditions); err != nil {
 		return nil, fmt.Errorf("invalid conditions: %w", err)
 	}
 
-	// Serialize conditions to JSON
-	conditionsJSON, err := json.Marshal(conditions)
-	if err != nil {
-		return nil, fmt.Errorf("failed to serialize conditions: %w", err)
-	}
-
 	alert := &models.Alert{
-		UserID:    userUUID,
-		Type:      alertType,
-		Name:      name,
-		Conditions: string(conditionsJSON),
-		IsActive:  true,
+		UserID:     userUUID,
+		Type:       alertType,
+		Name:       name,
+		Conditions: conditions,
+		IsActive:   true,
+		Armed:      true,
+	}
+	if err := applySettings(alert, settings); err != nil {
+		return nil, err
 	}
 
 	err = s.db.Create(alert).Error
@@ -112,8 +131,8 @@ func (s *AlertService) GetAlert(userID, alertID string) (*models.Alert, error) {
 	return &alert, nil
 }
 
-// UpdateAlert updates an alert
-func (s *AlertService) UpdateAlert(userID, alertID, alertType, name string, conditions map[string]interface{}) (*models.Alert, error) {
+// UpdateAlert updates an alert. Changing its conditions re-arms it.
+func (s *AlertService) UpdateAlert(userID, alertID, alertType, name string, conditions *models.AlertConditions, settings AlertSettings) (*models.Alert, error) {
 	alert, err := s.GetAlert(userID, alertID)
 	if err != nil {
 		return nil, err
@@ -131,15 +150,18 @@ func (s *AlertService) UpdateAlert(userID, alertID, alertType, name string, cond
 	}
 
 	if conditions != nil {
-		if err := s.validateConditions(conditions); err != nil {
+		alert.Conditions = *conditions
+		alert.Armed = true
+	}
+	// A new type must still match the stored conditions
+	if conditions != nil || alertType != "" {
+		if err := ValidateAlertConditions(alert.Type, &alert.Conditions); err != nil {
 			return nil, fmt.Errorf("invalid conditions: %w", err)
 		}
+	}
 
-		conditionsJSON, err := json.Marshal(conditions)
-		if err != nil {
-			return nil, fmt.Errorf("failed to serialize conditions: %w", err)
-		}
-		alert.Conditions = string(conditionsJSON)
+	if err := applySettings(alert, settings); err != nil {
+		return nil, err
 	}
 
 	err = s.db.Save(alert).Error
@@ -157,7 +179,12 @@ func (s *AlertService) DeleteAlert(userID, alertID string) error {
 		return err
 	}
 
-	err = s.db.Delete(alert).Error
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("alert_id = ?", alert.ID).Delete(&models.NotifiedTransaction{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(alert).Error
+	})
 	if err != nil {
 		return fmt.Errorf("failed to delete alert: %w", err)
 	}
@@ -173,6 +200,9 @@ func (s *AlertService) ToggleAlert(userID, alertID string) (*models.Alert, error
 	}
 
 	alert.IsActive = !alert.IsActive
+	if alert.IsActive {
+		alert.Armed = true
+	}
 	err = s.db.Save(alert).Error
 	if err != nil {
 		return nil, fmt.Errorf("failed to toggle alert: %w", err)
@@ -181,279 +211,573 @@ func (s *AlertService) ToggleAlert(userID, alertID string) (*models.Alert, error
 	return alert, nil
 }
 
-// CheckAlerts checks all active alerts and triggers notifications if conditions are met
-func (s *AlertService) CheckAlerts() error {
+// CheckAlerts checks all active alerts and triggers notifications if conditions
+// are met. It returns how many alerts were checked.
+func (s *AlertService) CheckAlerts(ctx context.Context) (int, error) {
 	var alerts []models.Alert
 	err := s.db.Where("is_active = ?", true).Find(&alerts).Error
 	if err != nil {
-		return fmt.Errorf("failed to get active alerts: %w", err)
+		return 0, fmt.Errorf("failed to get active alerts: %w", err)
 	}
 
-	for _, alert := range alerts {
-		if err := s.checkAlert(&alert); err != nil {
+	for i, alert := range alerts {
+		if err := ctx.Err(); err != nil {
+			return i, err
+		}
+		if err := s.checkAlert(ctx, &alert); err != nil {
 			// Log error but continue with other alerts
 			fmt.Printf("Error checking alert %s: %v\n", alert.ID, err)
 		}
 	}
 
-	return nil
+	return len(alerts), nil
 }
 
 // checkAlert checks if a specific alert should be triggered
-func (s *AlertService) checkAlert(alert *models.Alert) error {
-	var conditions map[string]interface{}
-	err := json.Unmarshal([]byte(alert.Conditions), &conditions)
+func (s *AlertService) checkAlert(ctx context.Context, alert *models.Alert) error {
+	now := time.Now()
+	evaluation, err := s.evaluateAlert(ctx, alert, now)
 	if err != nil {
-		return fmt.Errorf("failed to parse conditions: %w", err)
+		return err
 	}
 
-	// Check conditions based on alert type
-	switch alert.Type {
-	case "price":
-		return s.checkPriceAlert(alert, conditions)
-	case "balance":
-		return s.checkBalanceAlert(alert, conditions)
-	case "transaction":
-		return s.checkTransactionAlert(alert, conditions)
-	default:
-		return fmt.Errorf("unknown alert type: %s", alert.Type)
+	switch decideTrigger(alert, evaluation, now) {
+	case triggerFire:
+		return s.triggerAlert(ctx, alert, evaluation, now)
+	case triggerRearm:
+		if err := s.db.Model(alert).UpdateColumn("armed", true).Error; err != nil {
+			return fmt.Errorf("failed to re-arm alert: %w", err)
+		}
 	}
+	return nil
 }
 
-// checkPriceAlert checks price-based alerts
-func (s *AlertService) checkPriceAlert(alert *models.Alert, conditions map[string]interface{}) error {
-	// This is a simplified implementation
-	// In production, you'd fetch real-time price data from APIs
-	
-	token, ok := conditions["token"].(string)
-	if !ok {
-		return fmt.Errorf("token not specified in conditions")
+// evaluateAlert checks an alert's condition against live data
+func (s *AlertService) evaluateAlert(ctx context.Context, alert *models.Alert, now time.Time) (*alertEvaluation, error) {
+	expr, err := parseConditions(&alert.Conditions)
+	if err != nil {
+		return nil, err
 	}
+	return s.evaluateCondition(ctx, alert, expr, now)
+}
 
-	operator, ok := conditions["operator"].(string)
-	if !ok {
-		return fmt.Errorf("operator not specified in conditions")
+// checkPriceAlert checks price-based alerts
+func (s *AlertService) checkPriceAlert(ctx context.Context, leaf *ConditionLeaf, now time.Time) (*alertEvaluation, error) {
+	price, err := s.priceHistory.LatestPrice(ctx, leaf.Token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get price for %s: %w", leaf.Token, err)
+	}
+	// Don't fire on a price nobody has confirmed recently
+	if price.Stale {
+		return nil, fmt.Errorf("price for %s is stale (last updated %s)", leaf.Token, price.UpdatedAt.Format(time.RFC3339))
 	}
+	current := floatDecimal(price.Price)
 
-	targetValue, ok := conditions["value"].(float64)
-	if !ok {
-		return fmt.Errorf("value not specified in conditions")
+	var past *big.Rat
+	if isHistoryOperator(leaf.Operator) {
+		pastPrice, ok := s.priceHistory.PriceAt(leaf.Token, now.Add(-leaf.Window))
+		if !ok {
+			return nil, fmt.Errorf("no price recorded for %s %s ago", leaf.Token, leaf.Window)
+		}
+		past = floatDecimal(pastPrice)
 	}
 
-	// Mock current price (in production, fetch from API)
-	currentPrice := 1000.0 // Mock price for demonstration
+	met, data := compareLevel(leaf, current, past)
+	targetValue, _ := leaf.Value.Float64()
+	data["token"] = leaf.Token
+	data["current_price"] = price.Price
+	data["target_price"] = targetValue
+	data["operator"] = leaf.Operator
 
-	// Check condition
-	shouldTrigger := false
-	switch operator {
-	case ">":
-		shouldTrigger = currentPrice > targetValue
-	case "<":
-		shouldTrigger = currentPrice < targetValue
-	case ">=":
-		shouldTrigger = currentPrice >= targetValue
-	case "<=":
-		shouldTrigger = currentPrice <= targetValue
-	case "==":
-		shouldTrigger = currentPrice == targetValue
-	case "!=":
-		shouldTrigger = currentPrice != targetValue
+	return &alertEvaluation{met: met, current: current, target: leaf.Value, operator: leaf.Operator, data: data}, nil
+}
+
+// checkBalanceAlert checks balance-based alerts against the live balance of the
+// native token, or of the ERC-20 token named in the optional "token" field
+func (s *AlertService) checkBalanceAlert(ctx context.Context, leaf *ConditionLeaf) (*alertEvaluation, error) {
+	held, err := s.web3Service.GetHeldAmount(ctx, leaf.Address, leaf.Network, leaf.Token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get balance of %s on %s: %w", leaf.Address, leaf.Network, err)
+	}
+
+	return &alertEvaluation{
+		met:      compareDecimal(held.Units, leaf.Value, leaf.Operator),
+		current:  held.Units,
+		target:   leaf.Value,
+		operator: leaf.Operator,
+		data: map[string]interface{}{
+			"address":         leaf.Address,
+			"network":         leaf.Network,
+			"token":           held.Symbol,
+			"token_address":   held.TokenAddress,
+			"current_balance": formatDecimal(held.Units),
+			"target_balance":  formatDecimal(leaf.Value),
+			"operator":        leaf.Operator,
+		},
+	}, nil
+}
+
+// checkGasAlert compares a network's base fee, or the expected price at a fee
+// tier, with the condition in gwei
+func (s *AlertService) checkGasAlert(ctx context.Context, leaf *ConditionLeaf) (*alertEvaluation, error) {
+	fees, err := s.gasOracle.Fees(ctx, leaf.Network)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get gas fees on %s: %w", leaf.Network, err)
+	}
+	// Without EIP-1559 the base fee reads as zero, which would meet any "below"
+	if !fees.EIP1559 && leaf.Fee == GasFeeBase {
+		return nil, fmt.Errorf("%s has no base fee; use a slow, standard or fast fee", leaf.Network)
+	}
+	price, _ := fees.Price(leaf.Fee)
+	current := new(big.Rat).SetFrac(price, big.NewInt(1_000_000_000))
+
+	return &alertEvaluation{
+		met:      compareDecimal(current, leaf.Value, leaf.Operator),
+		current:  current,
+		target:   leaf.Value,
+		operator: leaf.Operator,
+		data: map[string]interface{}{
+			"network":       leaf.Network,
+			"fee":           leaf.Fee,
+			"current_gwei":  formatDecimal(current),
+			"target_gwei":   formatDecimal(leaf.Value),
+			"base_fee_gwei": FormatGwei(fees.BaseFee),
+			"block":         fees.Block,
+			"operator":      leaf.Operator,
+		},
+	}, nil
+}
+
+// checkTransactionAlert looks for transactions of the watched address indexed
+// since the alert last fired that pass the condition's filters and haven't
+// fired it before. The address must be in one of the user's portfolios for its
+// transactions to be indexed.
+func (s *AlertService) checkTransactionAlert(alert *models.Alert, leaf *ConditionLeaf, now time.Time) (*alertEvaluation, error) {
+	var watched int64
+	err := s.db.Model(&models.Address{}).
+		Joins("JOIN portfolios ON portfolios.id = addresses.portfolio_id").
+		Where("portfolios.user_id = ? AND LOWER(addresses.address) = ? AND addresses.network = ?", alert.UserID, strings.ToLower(leaf.Address), leaf.Network).
+		Count(&watched).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up watched address: %w", err)
+	}
+	if watched == 0 {
+		return nil, fmt.Errorf("address %s on %s is not in any of the user's portfolios", leaf.Address, leaf.Network)
 	}
 
-	if shouldTrigger {
-		return s.triggerAlert(alert, map[string]interface{}{
-			"token":         token,
-			"current_price": currentPrice,
-			"target_price":  targetValue,
-			"operator":      operator,
-		})
+	// Activity before the alert existed, such as an address's first backfill, doesn't count
+	since := alert.CreatedAt
+	if alert.LastTriggeredAt != nil {
+		since = *alert.LastTriggeredAt
+	}
+	var transactions []models.Transaction
+	err = s.db.Model(&models.Transaction{}).
+		Joins("JOIN portfolios ON portfolios.id = transactions.portfolio_id").
+		Where("portfolios.user_id = ? AND transactions.network = ?", alert.UserID, leaf.Network).
+		Where("LOWER(transactions.from_address) = ? OR LOWER(transactions.to_address) = ?", strings.ToLower(leaf.Address), strings.ToLower(leaf.Address)).
+		Where("transactions.created_at > ? AND transactions.created_at <= ? AND transactions.timestamp >= ?", since, now, alert.CreatedAt).
+		Order("transactions.block_number DESC, transactions.log_index DESC").
+		Find(&transactions).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get transactions of %s on %s: %w", leaf.Address, leaf.Network, err)
+	}
+	// A reorg deletes transactions and indexes them again, so a transfer the
+	// alert fired for can come back with a later created_at
+	seen, err := s.notifiedTransactions(alert, leaf.Network, transactions)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	data := map[string]interface{}{
+		"address": leaf.Address,
+		"network": leaf.Network,
+	}
+	// The same transfer is stored once per portfolio holding the address
+	var matches []models.Transaction
+	for _, tx := range transactions {
+		key := transactionKey(tx.TxHash, tx.LogIndex)
+		if seen[key] || !matchTransaction(leaf, &tx) {
+			continue
+		}
+		seen[key] = true
+		matches = append(matches, tx)
+	}
+	if len(matches) == 0 {
+		return &alertEvaluation{data: data}, nil
+	}
+
+	latest := matches[0]
+	data["message"] = "New transaction detected"
+	data["match_count"] = len(matches)
+	data["tx_hash"] = latest.TxHash
+	data["direction"] = transactionDirection(leaf.Address, &latest)
+	data["from"] = latest.FromAddress
+	data["to"] = latest.ToAddress
+	data["token"] = latest.TokenSymbol
+	data["token_address"] = latest.TokenAddress
+	data["amount"] = latest.Amount
+	data["method"] = latest.Method
+	data["status"] = latest.Status
+	data["block_number"] = latest.BlockNumber
+	data["timestamp"] = latest.Timestamp
+	if network, ok := s.web3Service.Network(leaf.Network); ok && network.ExplorerURL != "" {
+		data["explorer_url"] = strings.TrimRight(network.ExplorerURL, "/") + "/tx/" + latest.TxHash
+	}
+
+	transfers := make([]models.NotifiedTransaction, 0, len(matches))
+	for _, tx := range matches {
+		transfers = append(transfers, models.NotifiedTransaction{
+			AlertID:     alert.ID,
+			Network:     leaf.Network,
+			TxHash:      tx.TxHash,
+			LogIndex:    tx.LogIndex,
+			BlockNumber: tx.BlockNumber,
+		})
+	}
+	return &alertEvaluation{met: true, event: true, data: data, transactions: transfers}, nil
 }
 
-// checkBalanceAlert checks balance-based alerts
-func (s *AlertService) checkBalanceAlert(alert *models.Alert, conditions map[string]interface{}) error {
-	address, ok := conditions["address"].(string)
-	if !ok {
-		return fmt.Errorf("address not specified in conditions")
+// notifiedTransactions returns the keys of the transactions the alert has
+// already fired for
+func (s *AlertService) notifiedTransactions(alert *models.Alert, network string, transactions []models.Transaction) (map[string]bool, error) {
+	notified := make(map[string]bool)
+	if len(transactions) == 0 {
+		return notified, nil
+	}
+	hashes := make([]string, 0, len(transactions))
+	for _, tx := range transactions {
+		hashes = append(hashes, tx.TxHash)
 	}
 
-	network, ok := conditions["network"].(string)
-	if !ok {
-		return fmt.Errorf("network not specified in conditions")
+	var rows []models.NotifiedTransaction
+	err := s.db.Where("alert_id = ? AND network = ? AND tx_hash IN ?", alert.ID, network, hashes).Find(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get notified transactions: %w", err)
 	}
+	for _, row := range rows {
+		notified[transactionKey(row.TxHash, row.LogIndex)] = true
+	}
+	return notified, nil
+}
 
-	operator, ok := conditions["operator"].(string)
-	if !ok {
-		return fmt.Errorf("operator not specified in conditions")
+// transactionKey identifies a transfer across reorgs
+func transactionKey(txHash string, logIndex int) string {
+	return txHash + ":" + strconv.Itoa(logIndex)
+}
+
+// matchTransaction applies a transaction condition's filters to a transaction
+// sent from or to its address
+func matchTransaction(leaf *ConditionLeaf, tx *models.Transaction) bool {
+	flow := transactionDirection(leaf.Address, tx)
+	if leaf.Direction != "" && flow != leaf.Direction && flow != DirectionSelf {
+		return false
+	}
+
+	if leaf.Token != "" {
+		if common.IsHexAddress(leaf.Token) {
+			if tx.TokenAddress == "" || common.HexToAddress(tx.TokenAddress) != common.HexToAddress(leaf.Token) {
+				return false
+			}
+		} else if !strings.EqualFold(tx.TokenSymbol, leaf.Token) {
+			return false
+		}
 	}
 
-	targetValue, ok := conditions["value"].(float64)
-	if !ok {
-		return fmt.Errorf("value not specified in conditions")
+	if leaf.MinAmount != nil {
+		amount, ok := new(big.Rat).SetString(tx.Amount)
+		if !ok || amount.Cmp(leaf.MinAmount) < 0 {
+			return false
+		}
 	}
 
-	// Get current balance (in production, use Web3Service)
-	currentBalance := 0.5 // Mock balance for demonstration
+	counterparty := common.HexToAddress(tx.FromAddress)
+	if flow == DirectionOut {
+		counterparty = common.HexToAddress(tx.ToAddress)
+	}
+	if len(leaf.Counterparties) > 0 && !containsAddress(leaf.Counterparties, counterparty) {
+		return false
+	}
+	if containsAddress(leaf.ExcludeCounterparties, counterparty) {
+		return false
+	}
 
-	// Check condition
-	shouldTrigger := false
-	switch operator {
-	case ">":
-		shouldTrigger = currentBalance > targetValue
-	case "<":
-		shouldTrigger = currentBalance < targetValue
-	case ">=":
-		shouldTrigger = currentBalance >= targetValue
-	case "<=":
-		shouldTrigger = currentBalance <= targetValue
-	case "==":
-		shouldTrigger = currentBalance == targetValue
-	case "!=":
-		shouldTrigger = currentBalance != targetValue
+	if len(leaf.Methods) > 0 {
+		matched := false
+		for _, method := range leaf.Methods {
+			if (tx.Method != "" && strings.EqualFold(method, tx.Method)) || (tx.MethodID != "" && strings.EqualFold(method, tx.MethodID)) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
 	}
+	return true
+}
 
-	if shouldTrigger {
-		return s.triggerAlert(alert, map[string]interface{}{
-			"address":        address,
-			"network":        network,
-			"current_balance": currentBalance,
-			"target_balance": targetValue,
-			"operator":       operator,
-		})
+// transactionDirection tells whether a transaction enters or leaves address,
+// or is sent by address to itself
+func transactionDirection(address string, tx *models.Transaction) string {
+	watched := common.HexToAddress(address)
+	fromWatched := tx.FromAddress != "" && common.HexToAddress(tx.FromAddress) == watched
+	toWatched := tx.ToAddress != "" && common.HexToAddress(tx.ToAddress) == watched
+	switch {
+	case fromWatched && toWatched:
+		return DirectionSelf
+	case fromWatched:
+		return DirectionOut
+	default:
+		return DirectionIn
 	}
+}
 
-	return nil
+func containsAddress(addresses []common.Address, address common.Address) bool {
+	for _, candidate := range addresses {
+		if candidate == address {
+			return true
+		}
+	}
+	return false
 }
 
-// checkTransactionAlert checks transaction-based alerts
-func (s *AlertService) checkTransactionAlert(alert *models.Alert, conditions map[string]interface{}) error {
-	// This is a simplified implementation
-	// In production, you'd check recent transactions from blockchain APIs
-	
-	address, ok := conditions["address"].(string)
-	if !ok {
-		return fmt.Errorf("address not specified in conditions")
+// alertEvaluation is the outcome of checking an alert's condition. current and
+// target are nil for alerts without a level, such as transaction alerts. An
+// event is met by something new, such as a transaction, rather than by a level
+// that still holds, so it fires without waiting to re-arm.
+type alertEvaluation struct {
+	met          bool
+	event        bool
+	current      *big.Rat
+	target       *big.Rat
+	operator     string
+	data         map[string]interface{}
+	transactions []models.NotifiedTransaction // the transfers that met it
+}
+
+// triggerDecision is what a check does to an alert
+type triggerDecision int
+
+const (
+	triggerNone triggerDecision = iota
+	triggerFire
+	triggerRearm
+)
+
+// decideTrigger fires armed alerts whose condition holds outside their
+// cooldown, and re-arms disarmed ones once the condition has cleared
+func decideTrigger(alert *models.Alert, evaluation *alertEvaluation, now time.Time) triggerDecision {
+	if !alert.Armed && !evaluation.event {
+		if rearmed(evaluation, alert.RearmPercent) {
+			return triggerRearm
+		}
+		return triggerNone
 	}
 
-	network, ok := conditions["network"].(string)
-	if !ok {
-		return fmt.Errorf("network not specified in conditions")
+	if !evaluation.met {
+		return triggerNone
 	}
+	if alert.LastTriggeredAt != nil && now.Before(alert.LastTriggeredAt.Add(time.Duration(alert.CooldownSeconds)*time.Second)) {
+		return triggerNone
+	}
+	return triggerFire
+}
 
-	// Mock transaction detection (in production, check actual transactions)
-	hasNewTransaction := false // Mock value
+// rearmed reports whether a fired alert's condition has cleared by percent of
+// its target: for "> 100" with 2%, once the value is at or below 98
+func rearmed(evaluation *alertEvaluation, percent float64) bool {
+	if evaluation.met {
+		return false
+	}
+	if evaluation.current == nil || evaluation.target == nil || percent == 0 {
+		return true
+	}
+
+	// Parse the decimal form so 2% is exactly 2/100
+	fraction, _ := new(big.Rat).SetString(strconv.FormatFloat(percent, 'f', -1, 64))
+	fraction.Quo(fraction, big.NewRat(100, 1))
+	margin := new(big.Rat).Mul(new(big.Rat).Abs(evaluation.target), fraction)
+	switch evaluation.operator {
+	case ">", ">=":
+		return evaluation.current.Cmp(new(big.Rat).Sub(evaluation.target, margin)) <= 0
+	case "<", "<=":
+		return evaluation.current.Cmp(new(big.Rat).Add(evaluation.target, margin)) >= 0
+	default:
+		return true
+	}
+}
 
-	if hasNewTransaction {
-		return s.triggerAlert(alert, map[string]interface{}{
-			"address": address,
-			"network": network,
-			"message": "New transaction detected",
-		})
+// recordTrigger disarms a fired alert, or deactivates it when it fires once,
+// and remembers the transfers it fired for
+func recordTrigger(tx *gorm.DB, alert *models.Alert, evaluation *alertEvaluation, now time.Time) error {
+	triggered := *alert
+	triggered.LastTriggeredAt = &now
+	triggered.TriggerCount++
+	triggered.Armed = false
+	if triggered.FireOnce {
+		triggered.IsActive = false
+	}
+
+	err := tx.Model(&models.Alert{}).Where("id = ?", alert.ID).UpdateColumns(map[string]interface{}{
+		"last_triggered_at": triggered.LastTriggeredAt,
+		"trigger_count":     triggered.TriggerCount,
+		"armed":             triggered.Armed,
+		"is_active":         triggered.IsActive,
+	}).Error
+	if err != nil {
+		return fmt.Errorf("failed to record alert trigger: %w", err)
 	}
 
+	if len(evaluation.transactions) > 0 {
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&evaluation.transactions).Error; err != nil {
+			return fmt.Errorf("failed to record notified transactions: %w", err)
+		}
+	}
+	*alert = triggered
 	return nil
 }
 
-// triggerAlert creates a notification for a triggered alert
-func (s *AlertService) triggerAlert(alert *models.Alert, data map[string]interface{}) error {
+// triggerAlert stores a notification for a triggered alert together with the
+// trigger, so a failed check leaves neither behind and the next one tries
+// again. The notification is then published and delivered; delivery failures
+// are logged since the trigger already happened.
+func (s *AlertService) triggerAlert(ctx context.Context, alert *models.Alert, evaluation *alertEvaluation, now time.Time) error {
 	notification := &AlertNotification{
 		AlertID:   alert.ID.String(),
 		Type:      alert.Type,
 		Message:   fmt.Sprintf("Alert triggered: %s", alert.Name),
-		Data:      data,
-		Timestamp: time.Now(),
+		Data:      evaluation.data,
+		Timestamp: now,
 	}
 
-	// In production, you'd:
-	// 1. Save notification to database
-	// 2. Send email/SMS/push notification
-	// 3. Send to webhook if configured
-	// 4. Send to Discord if configured
+	var record *models.Notification
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if record, err = s.notifications.CreateNotification(tx, alert, notification); err != nil {
+			return err
+		}
+		return recordTrigger(tx, alert, evaluation, now)
+	})
+	if err != nil {
+		return err
+	}
 
-	fmt.Printf("Alert triggered: %s - %s\n", alert.Name, notification.Message)
-	
+	log.Printf("Alert triggered: %s - %s", alert.Name, notification.Message)
+	s.events.PublishToUser(alert.UserID, Event{Type: EventNotification, Time: record.CreatedAt, Data: record})
+
+	if err := s.notifications.Deliver(ctx, alert, record); err != nil {
+		log.Printf("❌ Failed to deliver notification %s of alert %s: %v", record.ID, alert.ID, err)
+	}
 	return nil
 }
 
-// validateConditions validates alert conditions
-func (s *AlertService) validateConditions(conditions map[string]interface{}) error {
-	// Check required fields based on alert type
-	alertType, ok := conditions["type"].(string)
-	if !ok {
-		return fmt.Errorf("alert type not specified")
+// ValidateAlertConditions parses alert conditions and checks them against the
+// alert type; errors name the offending field
+func ValidateAlertConditions(alertType string, conditions *models.AlertConditions) error {
+	expr, err := parseConditions(conditions)
+	if err != nil {
+		return err
 	}
 
-	switch alertType {
-	case "price":
-		return s.validatePriceConditions(conditions)
-	case "balance":
-		return s.validateBalanceConditions(conditions)
-	case "transaction":
-		return s.validateTransactionConditions(conditions)
-	default:
-		return fmt.Errorf("unknown alert type: %s", alertType)
+	if expr.AlertType() != alertType {
+		return fmt.Errorf("conditions are for a %s alert, not %s", expr.AlertType(), alertType)
 	}
+	return nil
 }
 
-// validatePriceConditions validates price alert conditions
-func (s *AlertService) validatePriceConditions(conditions map[string]interface{}) error {
-	required := []string{"token", "operator", "value"}
-	for _, field := range required {
-		if _, ok := conditions[field]; !ok {
-			return fmt.Errorf("required field missing: %s", field)
+// Helper functions
+
+// applySettings validates settings and sets the given ones on alert
+func applySettings(alert *models.Alert, settings AlertSettings) error {
+	if settings.Channels != nil {
+		alert.Channels = settings.Channels
+	}
+	// A new receiver gets a new secret, so the old one can't sign for it
+	if settings.WebhookURL != "" && settings.WebhookURL != alert.WebhookURL {
+		alert.WebhookURL = settings.WebhookURL
+		alert.WebhookSecret = ""
+	}
+	if err := validateChannels(alert.Channels, alert.WebhookURL); err != nil {
+		return err
+	}
+	if hasChannel(alert.Channels, ChannelWebhook) && alert.WebhookSecret == "" {
+		secret, err := generateWebhookSecret()
+		if err != nil {
+			return err
 		}
+		alert.WebhookSecret = secret
+		alert.NewWebhookSecret = secret
 	}
 
-	operator, ok := conditions["operator"].(string)
-	if !ok {
-		return fmt.Errorf("operator must be a string")
+	if settings.CooldownSeconds != nil {
+		if *settings.CooldownSeconds < 0 {
+			return fmt.Errorf("cooldown_seconds cannot be negative")
+		}
+		alert.CooldownSeconds = *settings.CooldownSeconds
 	}
-
-	if !isValidOperator(operator) {
-		return fmt.Errorf("invalid operator: %s", operator)
+	if settings.RearmPercent != nil {
+		if *settings.RearmPercent < 0 || *settings.RearmPercent >= 100 {
+			return fmt.Errorf("rearm_percent must be at least 0 and below 100")
+		}
+		alert.RearmPercent = *settings.RearmPercent
+	}
+	if settings.FireOnce != nil {
+		alert.FireOnce = *settings.FireOnce
 	}
-
 	return nil
 }
 
-// validateBalanceConditions validates balance alert conditions
-func (s *AlertService) validateBalanceConditions(conditions map[string]interface{}) error {
-	required := []string{"address", "network", "operator", "value"}
-	for _, field := range required {
-		if _, ok := conditions[field]; !ok {
-			return fmt.Errorf("required field missing: %s", field)
+// validateChannels checks an alert's delivery channels; webhooks need an
+// http(s) URL whose host resolves to public addresses
+func validateChannels(channels []string, webhookURL string) error {
+	seen := make(map[string]bool, len(channels))
+	for _, channel := range channels {
+		switch channel {
+		case ChannelWebhook, ChannelDiscord, ChannelEmail:
+		default:
+			return fmt.Errorf("invalid channel: %s", channel)
 		}
+		if seen[channel] {
+			return fmt.Errorf("duplicate channel: %s", channel)
+		}
+		seen[channel] = true
 	}
 
-	operator, ok := conditions["operator"].(string)
-	if !ok {
-		return fmt.Errorf("operator must be a string")
-	}
-
-	if !isValidOperator(operator) {
-		return fmt.Errorf("invalid operator: %s", operator)
+	if seen[ChannelWebhook] {
+		parsed, err := url.Parse(webhookURL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Hostname() == "" {
+			return fmt.Errorf("webhook channel needs an http(s) webhook_url")
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := checkWebhookHost(ctx, parsed.Hostname()); err != nil {
+			return err
+		}
 	}
-
 	return nil
 }
 
-// validateTransactionConditions validates transaction alert conditions
-func (s *AlertService) validateTransactionConditions(conditions map[string]interface{}) error {
-	required := []string{"address", "network"}
-	for _, field := range required {
-		if _, ok := conditions[field]; !ok {
-			return fmt.Errorf("required field missing: %s", field)
+// hasChannel reports whether channels include channel
+func hasChannel(channels []string, channel string) bool {
+	for _, c := range channels {
+		if c == channel {
+			return true
 		}
 	}
+	return false
+}
 
-	return nil
+// generateWebhookSecret creates a random key for signing an alert's webhooks
+func generateWebhookSecret() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", fmt.Errorf("failed to generate webhook secret: %w", err)
+	}
+	return "whsec_" + hex.EncodeToString(bytes), nil
 }
 
-// Helper functions
 func isValidAlertType(alertType string) bool {
-	validTypes := []string{"price", "balance", "transaction"}
+	validTypes := []string{"price", "balance", "transaction", "portfolio_value", "gas", "composite"}
 	for _, t := range validTypes {
 		if t == alertType {
 			return true
@@ -470,4 +794,40 @@ func isValidOperator(operator string) bool {
 		}
 	}
 	return false
-} 
\ No newline at end of file
+}
+
+// conditionDecimal reads a condition value, a JSON number or decimal string kept as written
+func conditionDecimal(value json.Number) (*big.Rat, error) {
+	amount, ok := new(big.Rat).SetString(strings.TrimSpace(value.String()))
+	if !ok {
+		return nil, fmt.Errorf("value must be a number, got %q", value.String())
+	}
+	return amount, nil
+}
+
+// compareDecimal applies a condition operator to two exact decimal amounts
+func compareDecimal(current, target *big.Rat, operator string) bool {
+	cmp := current.Cmp(target)
+	switch operator {
+	case ">":
+		return cmp > 0
+	case "<":
+		return cmp < 0
+	case ">=":
+		return cmp >= 0
+	case "<=":
+		return cmp <= 0
+	case "==":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	}
+	return false
+}
+
+// formatDecimal formats an exact amount without trailing zeros
+func formatDecimal(amount *big.Rat) string {
+	text := amount.FloatString(18)
+	text = strings.TrimRight(text, "0")
+	return strings.TrimSuffix(text, ".")
+}
@@ -0,0 +1,458 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/google/uuid"
+
+	"web3-portfolio-dashboard/backend/internal/models"
+)
+
+// Condition types
+const (
+	ConditionPrice          = "price"
+	ConditionBalance        = "balance"
+	ConditionTransaction    = "transaction"
+	ConditionPortfolioValue = "portfolio_value"
+	ConditionGas            = "gas"
+)
+
+// Operators comparing a value with its level at the start of a window
+const (
+	OperatorCrossesAbove = "crosses_above" // below or at value at the window start, above it now
+	OperatorCrossesBelow = "crosses_below"
+	OperatorRisesPct     = "rises_pct" // up at least value percent over the window
+	OperatorFallsPct     = "falls_pct" // down at least value percent over the window
+)
+
+// AlertTypeComposite is the type of alerts whose conditions are an AND/OR group
+const AlertTypeComposite = "composite"
+
+// defaultConditionWindow is the window of history operators that don't set one
+const defaultConditionWindow = time.Hour
+
+// Limits on composite conditions
+const (
+	maxConditionDepth  = 4
+	maxConditionLeaves = 20
+)
+
+// ConditionError is a condition that failed to parse, with the path to the
+// offending field such as "conditions.and[1].value"
+type ConditionError struct {
+	Path    string
+	Message string
+}
+
+func (e *ConditionError) Error() string {
+	return e.Path + ": " + e.Message
+}
+
+// ConditionExpr is a parsed alert condition: a group whose conditions must all
+// (And) or any (Or) hold, or a single comparison
+type ConditionExpr struct {
+	And  []*ConditionExpr
+	Or   []*ConditionExpr
+	Leaf *ConditionLeaf
+}
+
+// ConditionLeaf is a single comparison of a live value with a target
+type ConditionLeaf struct {
+	Type        string
+	Token       string
+	Address     string
+	Network     string
+	PortfolioID uuid.UUID
+	Operator    string
+	Value       *big.Rat
+	Window      time.Duration // for history operators
+	Fee         string        // for gas conditions, compared in gwei
+
+	// Transaction filters; empty ones match everything
+	Direction             string
+	MinAmount             *big.Rat
+	Counterparties        []common.Address
+	ExcludeCounterparties []common.Address
+	Methods               []string
+}
+
+// AlertType is the leaf's type, or AlertTypeComposite for groups
+func (e *ConditionExpr) AlertType() string {
+	if e.Leaf != nil {
+		return e.Leaf.Type
+	}
+	return AlertTypeComposite
+}
+
+// conditionParser turns typed alert conditions into a ConditionExpr
+type conditionParser struct {
+	leaves int
+}
+
+// parseConditions checks alert conditions, reporting errors with their path
+func parseConditions(conditions *models.AlertConditions) (*ConditionExpr, error) {
+	p := &conditionParser{}
+	return p.parse(&conditions.AlertCondition, "conditions", 0)
+}
+
+func (p *conditionParser) parse(condition *models.AlertCondition, path string, depth int) (*ConditionExpr, error) {
+	if condition.And != nil || condition.Or != nil {
+		join, items := "and", condition.And
+		if condition.And == nil {
+			join, items = "or", condition.Or
+		}
+		if condition.And != nil && condition.Or != nil || condition.Type != "" {
+			return nil, &ConditionError{Path: path, Message: fmt.Sprintf("a group has only an %q list", join)}
+		}
+		if depth >= maxConditionDepth {
+			return nil, &ConditionError{Path: path, Message: fmt.Sprintf("groups nest at most %d deep", maxConditionDepth)}
+		}
+		if len(items) == 0 {
+			return nil, &ConditionError{Path: path + "." + join, Message: "must be a non-empty list"}
+		}
+
+		group := make([]*ConditionExpr, 0, len(items))
+		for i := range items {
+			expr, err := p.parse(&items[i], fmt.Sprintf("%s.%s[%d]", path, join, i), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			group = append(group, expr)
+		}
+		if join == "and" {
+			return &ConditionExpr{And: group}, nil
+		}
+		return &ConditionExpr{Or: group}, nil
+	}
+
+	p.leaves++
+	if p.leaves > maxConditionLeaves {
+		return nil, &ConditionError{Path: path, Message: fmt.Sprintf("at most %d conditions are allowed", maxConditionLeaves)}
+	}
+	leaf, err := p.parseLeaf(condition, path)
+	if err != nil {
+		return nil, err
+	}
+	return &ConditionExpr{Leaf: leaf}, nil
+}
+
+func (p *conditionParser) parseLeaf(condition *models.AlertCondition, path string) (*ConditionLeaf, error) {
+	leaf := &ConditionLeaf{
+		Type:     condition.Type,
+		Token:    condition.Token,
+		Address:  condition.Address,
+		Network:  condition.Network,
+		Operator: condition.Operator,
+	}
+	required := func(field, value string) error {
+		if strings.TrimSpace(value) == "" {
+			return &ConditionError{Path: path + "." + field, Message: "is required"}
+		}
+		return nil
+	}
+	unused := func(field, value string) error {
+		if value != "" {
+			return &ConditionError{Path: path + "." + field, Message: fmt.Sprintf("does not apply to %s conditions", leaf.Type)}
+		}
+		return nil
+	}
+
+	if err := required("type", leaf.Type); err != nil {
+		return nil, err
+	}
+	if field := transactionFilter(condition); field != "" && leaf.Type != ConditionTransaction {
+		return nil, &ConditionError{Path: path + "." + field, Message: fmt.Sprintf("does not apply to %s conditions", leaf.Type)}
+	}
+	var checks []error
+	switch leaf.Type {
+	case ConditionPrice:
+		checks = []error{required("token", leaf.Token), unused("address", leaf.Address), unused("network", leaf.Network), unused("portfolio_id", condition.PortfolioID), unused("fee", condition.Fee)}
+	case ConditionBalance:
+		checks = []error{required("address", leaf.Address), required("network", leaf.Network), unused("portfolio_id", condition.PortfolioID), unused("window", condition.Window), unused("fee", condition.Fee)}
+	case ConditionTransaction:
+		checks = []error{required("address", leaf.Address), required("network", leaf.Network), unused("portfolio_id", condition.PortfolioID),
+			unused("operator", leaf.Operator), unused("value", condition.Value.String()), unused("window", condition.Window), unused("fee", condition.Fee)}
+	case ConditionPortfolioValue:
+		checks = []error{required("portfolio_id", condition.PortfolioID), unused("token", leaf.Token), unused("address", leaf.Address), unused("network", leaf.Network), unused("fee", condition.Fee)}
+	case ConditionGas:
+		checks = []error{required("network", leaf.Network), unused("token", leaf.Token), unused("address", leaf.Address), unused("portfolio_id", condition.PortfolioID), unused("window", condition.Window)}
+	default:
+		return nil, &ConditionError{Path: path + ".type", Message: fmt.Sprintf("unknown condition type %q", leaf.Type)}
+	}
+	for _, err := range checks {
+		if err != nil {
+			return nil, err
+		}
+	}
+	if leaf.Type == ConditionTransaction {
+		if err := parseTransactionFilters(leaf, condition, path); err != nil {
+			return nil, err
+		}
+		return leaf, nil
+	}
+
+	if leaf.Type == ConditionGas {
+		switch condition.Fee {
+		case "":
+			leaf.Fee = GasFeeBase
+		case GasFeeBase, GasFeeSlow, GasFeeStandard, GasFeeFast:
+			leaf.Fee = condition.Fee
+		default:
+			return nil, &ConditionError{Path: path + ".fee", Message: fmt.Sprintf("must be %s, %s, %s or %s", GasFeeBase, GasFeeSlow, GasFeeStandard, GasFeeFast)}
+		}
+	}
+
+	if leaf.Type == ConditionPortfolioValue {
+		portfolioID, err := uuid.Parse(condition.PortfolioID)
+		if err != nil {
+			return nil, &ConditionError{Path: path + ".portfolio_id", Message: "must be a portfolio ID"}
+		}
+		leaf.PortfolioID = portfolioID
+	}
+
+	if err := required("operator", leaf.Operator); err != nil {
+		return nil, err
+	}
+	history := isHistoryOperator(leaf.Operator)
+	if !history && !isValidOperator(leaf.Operator) {
+		return nil, &ConditionError{Path: path + ".operator", Message: fmt.Sprintf("unknown operator %q", leaf.Operator)}
+	}
+	if history && (leaf.Type == ConditionBalance || leaf.Type == ConditionGas) {
+		return nil, &ConditionError{Path: path + ".operator", Message: fmt.Sprintf("%s needs history, which %s conditions don't have", leaf.Operator, leaf.Type)}
+	}
+
+	if err := required("value", condition.Value.String()); err != nil {
+		return nil, err
+	}
+	value, err := conditionDecimal(condition.Value)
+	if err != nil {
+		return nil, &ConditionError{Path: path + ".value", Message: err.Error()}
+	}
+	leaf.Value = value
+	if leaf.Value.Sign() < 0 {
+		return nil, &ConditionError{Path: path + ".value", Message: "must not be negative"}
+	}
+	if (leaf.Operator == OperatorRisesPct || leaf.Operator == OperatorFallsPct) && leaf.Value.Sign() <= 0 {
+		return nil, &ConditionError{Path: path + ".value", Message: "must be a positive percentage"}
+	}
+
+	if condition.Window != "" {
+		if !history {
+			return nil, &ConditionError{Path: path + ".window", Message: fmt.Sprintf("only applies to %s, %s, %s and %s", OperatorCrossesAbove, OperatorCrossesBelow, OperatorRisesPct, OperatorFallsPct)}
+		}
+		if leaf.Window, err = ParseInterval(condition.Window); err != nil {
+			return nil, &ConditionError{Path: path + ".window", Message: err.Error()}
+		}
+	} else if history {
+		leaf.Window = defaultConditionWindow
+	}
+
+	return leaf, nil
+}
+
+// transactionFilter returns the first transaction filter a condition sets
+func transactionFilter(condition *models.AlertCondition) string {
+	switch {
+	case condition.Direction != "":
+		return "direction"
+	case condition.MinAmount != "":
+		return "min_amount"
+	case condition.Counterparties != nil:
+		return "counterparties"
+	case condition.ExcludeCounterparties != nil:
+		return "exclude_counterparties"
+	case condition.Methods != nil:
+		return "methods"
+	}
+	return ""
+}
+
+// parseTransactionFilters checks the filters of a transaction condition
+func parseTransactionFilters(leaf *ConditionLeaf, condition *models.AlertCondition, path string) error {
+	switch condition.Direction {
+	case "", DirectionIn, DirectionOut:
+		leaf.Direction = condition.Direction
+	default:
+		return &ConditionError{Path: path + ".direction", Message: fmt.Sprintf("must be %q or %q", DirectionIn, DirectionOut)}
+	}
+
+	if condition.MinAmount != "" {
+		amount, err := conditionDecimal(condition.MinAmount)
+		if err != nil {
+			return &ConditionError{Path: path + ".min_amount", Message: err.Error()}
+		}
+		if amount.Sign() < 0 {
+			return &ConditionError{Path: path + ".min_amount", Message: "must not be negative"}
+		}
+		leaf.MinAmount = amount
+	}
+
+	addresses := func(field string, values []string) ([]common.Address, error) {
+		parsed := make([]common.Address, 0, len(values))
+		for i, value := range values {
+			if !common.IsHexAddress(value) {
+				return nil, &ConditionError{Path: fmt.Sprintf("%s.%s[%d]", path, field, i), Message: "must be an address"}
+			}
+			parsed = append(parsed, common.HexToAddress(value))
+		}
+		return parsed, nil
+	}
+	var err error
+	if leaf.Counterparties, err = addresses("counterparties", condition.Counterparties); err != nil {
+		return err
+	}
+	if leaf.ExcludeCounterparties, err = addresses("exclude_counterparties", condition.ExcludeCounterparties); err != nil {
+		return err
+	}
+
+	for i, method := range condition.Methods {
+		if strings.TrimSpace(method) == "" {
+			return &ConditionError{Path: fmt.Sprintf("%s.methods[%d]", path, i), Message: "must not be empty"}
+		}
+		leaf.Methods = append(leaf.Methods, strings.TrimSpace(method))
+	}
+	return nil
+}
+
+func isHistoryOperator(operator string) bool {
+	switch operator {
+	case OperatorCrossesAbove, OperatorCrossesBelow, OperatorRisesPct, OperatorFallsPct:
+		return true
+	}
+	return false
+}
+
+// evaluateCondition checks a condition against live data. Groups stop at the
+// first condition that decides them.
+func (s *AlertService) evaluateCondition(ctx context.Context, alert *models.Alert, expr *ConditionExpr, now time.Time) (*alertEvaluation, error) {
+	if expr.Leaf != nil {
+		return s.evaluateLeaf(ctx, alert, expr.Leaf, now)
+	}
+
+	join, group, decidesOn := "and", expr.And, false
+	if expr.Or != nil {
+		join, group, decidesOn = "or", expr.Or, true
+	}
+
+	evaluation := &alertEvaluation{met: !decidesOn}
+	var results []map[string]interface{}
+	event := false
+	for _, item := range group {
+		result, err := s.evaluateCondition(ctx, alert, item, now)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result.data)
+		event = event || (result.met && result.event)
+		if result.met {
+			evaluation.transactions = append(evaluation.transactions, result.transactions...)
+		}
+		if result.met == decidesOn {
+			evaluation.met = decidesOn
+			break
+		}
+	}
+	// A group met with the help of an event is itself an event
+	evaluation.event = evaluation.met && event
+	evaluation.data = map[string]interface{}{join: results}
+	return evaluation, nil
+}
+
+// evaluateLeaf checks a single condition
+func (s *AlertService) evaluateLeaf(ctx context.Context, alert *models.Alert, leaf *ConditionLeaf, now time.Time) (*alertEvaluation, error) {
+	switch leaf.Type {
+	case ConditionPrice:
+		return s.checkPriceAlert(ctx, leaf, now)
+	case ConditionBalance:
+		return s.checkBalanceAlert(ctx, leaf)
+	case ConditionTransaction:
+		return s.checkTransactionAlert(alert, leaf, now)
+	case ConditionPortfolioValue:
+		return s.checkPortfolioValueAlert(alert, leaf, now)
+	case ConditionGas:
+		return s.checkGasAlert(ctx, leaf)
+	default:
+		return nil, fmt.Errorf("unknown condition type: %s", leaf.Type)
+	}
+}
+
+// compareLevel evaluates an operator against the current value and, for
+// history operators, the value at the start of the window
+func compareLevel(leaf *ConditionLeaf, current, past *big.Rat) (bool, map[string]interface{}) {
+	data := map[string]interface{}{}
+	switch leaf.Operator {
+	case OperatorCrossesAbove:
+		data["window_start_value"] = formatDecimal(past)
+		return past.Cmp(leaf.Value) <= 0 && current.Cmp(leaf.Value) > 0, data
+	case OperatorCrossesBelow:
+		data["window_start_value"] = formatDecimal(past)
+		return past.Cmp(leaf.Value) >= 0 && current.Cmp(leaf.Value) < 0, data
+	case OperatorRisesPct, OperatorFallsPct:
+		data["window_start_value"] = formatDecimal(past)
+		if past.Sign() == 0 {
+			return false, data
+		}
+		change := new(big.Rat).Sub(current, past)
+		change.Quo(change, past).Mul(change, big.NewRat(100, 1))
+		data["change_pct"] = new(big.Float).SetRat(change).Text('f', 2)
+		if leaf.Operator == OperatorFallsPct {
+			return change.Cmp(new(big.Rat).Neg(leaf.Value)) <= 0, data
+		}
+		return change.Cmp(leaf.Value) >= 0, data
+	default:
+		return compareDecimal(current, leaf.Value, leaf.Operator), data
+	}
+}
+
+// checkPortfolioValueAlert compares a portfolio's latest recorded total value
+// with the condition
+func (s *AlertService) checkPortfolioValueAlert(alert *models.Alert, leaf *ConditionLeaf, now time.Time) (*alertEvaluation, error) {
+	var portfolio models.Portfolio
+	if err := s.db.Where("id = ? AND user_id = ?", leaf.PortfolioID, alert.UserID).First(&portfolio).Error; err != nil {
+		return nil, fmt.Errorf("portfolio %s not found: %w", leaf.PortfolioID, err)
+	}
+
+	current, ok := s.portfolioValueAt(portfolio.ID, now)
+	if !ok {
+		return nil, fmt.Errorf("no value recorded for portfolio %s", portfolio.ID)
+	}
+
+	var past *big.Rat
+	if isHistoryOperator(leaf.Operator) {
+		if past, ok = s.portfolioValueAt(portfolio.ID, now.Add(-leaf.Window)); !ok {
+			return nil, fmt.Errorf("no value recorded for portfolio %s %s ago", portfolio.ID, leaf.Window)
+		}
+	}
+
+	met, data := compareLevel(leaf, current, past)
+	data["portfolio_id"] = portfolio.ID.String()
+	data["portfolio"] = portfolio.Name
+	data["current_value"] = formatDecimal(current)
+	data["target_value"] = formatDecimal(leaf.Value)
+	data["operator"] = leaf.Operator
+
+	return &alertEvaluation{met: met, current: current, target: leaf.Value, operator: leaf.Operator, data: data}, nil
+}
+
+// portfolioValueAt returns a portfolio's total value from its last snapshot at or before t
+func (s *AlertService) portfolioValueAt(portfolioID uuid.UUID, t time.Time) (*big.Rat, bool) {
+	var snapshot models.PortfolioSnapshot
+	err := s.db.Where("portfolio_id = ? AND timestamp <= ?", portfolioID, t).Order("timestamp DESC").First(&snapshot).Error
+	if err != nil {
+		return nil, false
+	}
+	value, ok := new(big.Rat).SetString(snapshot.TotalValue)
+	return value, ok
+}
+
+// floatDecimal converts a float to the decimal it prints as, so 0.1 is exactly 1/10
+func floatDecimal(value float64) *big.Rat {
+	amount, _ := new(big.Rat).SetString(strconv.FormatFloat(value, 'f', -1, 64))
+	return amount
+}
@@ -0,0 +1,230 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"web3-portfolio-dashboard/backend/internal/config"
+	"web3-portfolio-dashboard/backend/internal/models"
+)
+
+func mustDecodeConditions(t *testing.T, raw string) *models.AlertConditions {
+	conditions, err := models.DecodeAlertConditions([]byte(raw), true)
+	require.NoError(t, err)
+	return conditions
+}
+
+func TestParseConditions(t *testing.T) {
+	expr, err := parseConditions(mustDecodeConditions(t, `{"or": [
+		{"type": "price", "token": "ETH", "operator": "falls_pct", "value": 10, "window": "1h"},
+		{"and": [
+			{"type": "portfolio_value", "portfolio_id": "4f0c7c5e-9a51-4c55-9d2f-0b7b8f0e6a11", "operator": "crosses_below", "value": "25000"},
+			{"type": "balance", "address": "0x1", "network": "ethereum", "operator": "<", "value": 1}
+		]}
+	]}`))
+	require.NoError(t, err)
+	require.Equal(t, AlertTypeComposite, expr.AlertType())
+	require.Len(t, expr.Or, 2)
+
+	fall := expr.Or[0].Leaf
+	require.Equal(t, OperatorFallsPct, fall.Operator)
+	require.Equal(t, time.Hour, fall.Window)
+	require.Zero(t, fall.Value.Cmp(big.NewRat(10, 1)))
+
+	cross := expr.Or[1].And[0].Leaf
+	require.Equal(t, ConditionPortfolioValue, cross.Type)
+	require.Equal(t, defaultConditionWindow, cross.Window)
+	require.Equal(t, ConditionBalance, expr.Or[1].And[1].AlertType())
+
+	for raw, path := range map[string]string{
+		`{"type": "price", "operator": ">", "value": 1}`:   "conditions.token: is required",
+		`{"type": "weather", "operator": ">", "value": 1}`: "conditions.type: unknown condition type",
+		`{"and": []}`: "conditions.and: must be a non-empty list",
+		`{"and": [{"type": "price", "token": "ETH", "operator": ">"}]}`:                                         "conditions.and[0].value: is required",
+		`{"and": [{"or": [{"type": "price", "token": "ETH", "operator": "~", "value": 1}]}]}`:                   "conditions.and[0].or[0].operator: unknown operator",
+		`{"type": "price", "token": "ETH", "operator": ">", "value": 1, "window": "1h"}`:                        "conditions.window: only applies to",
+		`{"type": "price", "token": "ETH", "operator": "rises_pct", "value": 5, "window": "soon"}`:              "conditions.window: invalid interval",
+		`{"type": "price", "token": "ETH", "operator": "rises_pct", "value": 0}`:                                "conditions.value: must be a positive percentage",
+		`{"type": "balance", "address": "0x1", "network": "ethereum", "operator": "crosses_above", "value": 1}`: "conditions.operator: crosses_above needs history",
+		`{"type": "portfolio_value", "portfolio_id": "main", "operator": ">", "value": 1}`:                      "conditions.portfolio_id: must be a portfolio ID",
+		`{"type": "price", "token": "ETH", "operator": ">", "value": 1, "direction": "in"}`:                     "conditions.direction: does not apply to price conditions",
+		`{"type": "transaction", "address": "0x1", "network": "ethereum", "direction": "both"}`:                 "conditions.direction: must be",
+		`{"type": "transaction", "address": "0x1", "network": "ethereum", "min_amount": -1}`:                    "conditions.min_amount: must not be negative",
+		`{"type": "transaction", "address": "0x1", "network": "ethereum", "counterparties": ["binance"]}`:       "conditions.counterparties[0]: must be an address",
+		`{"type": "transaction", "address": "0x1", "network": "ethereum", "methods": [" "]}`:                    "conditions.methods[0]: must not be empty",
+		`{"and": [{"type": "transaction", "address": "0x1", "network": "ethereum"}], "or": []}`:                 "conditions: a group has only an \"and\" list",
+	} {
+		_, err := parseConditions(mustDecodeConditions(t, raw))
+		require.Error(t, err, raw)
+		require.True(t, strings.HasPrefix(err.Error(), path), "%s: got %q", raw, err)
+	}
+
+	nested := `{"type": "transaction", "address": "0x1", "network": "ethereum"}`
+	for i := 0; i <= maxConditionDepth; i++ {
+		nested = `{"and": [` + nested + `]}`
+	}
+	_, err = parseConditions(mustDecodeConditions(t, nested))
+	require.ErrorContains(t, err, "groups nest at most")
+}
+
+func TestDecodeAlertConditions(t *testing.T) {
+	for raw, path := range map[string]string{
+		`{"type": "price", "token": "ETH", "operator": ">", "value": 1, "tokn": "BTC"}`: "conditions.tokn: unknown field",
+		`{"or": [{"type": "price", "token": "ETH", "operator": ">", "value": 1}, 5]}`:   "conditions.or[1]: must be an object",
+		`{"type": "price", "token": 5, "operator": ">", "value": 1}`:                    "conditions.token: must be a string",
+		`{"type": "price", "token": "ETH", "operator": ">", "value": "lots"}`:           "conditions.value: must be a number or decimal string",
+		`{"type": "transaction", "methods": ["swap", 1]}`:                               "conditions.methods: must be a list of strings",
+		`{"and": {"type": "price"}}`:                                                    "conditions.and: must be a list",
+		`{"version": 7, "type": "price"}`:                                               "conditions.version: unsupported version",
+		`[1]`:                                                                           "conditions: must be an object",
+	} {
+		_, err := models.DecodeAlertConditions([]byte(raw), true)
+		require.Error(t, err, raw)
+		require.True(t, strings.HasPrefix(err.Error(), path), "%s: got %q", raw, err)
+	}
+
+	// Stored rows load leniently so older alerts keep working
+	conditions, err := models.DecodeAlertConditions([]byte(`{"type": "price", "token": "ETH", "operator": ">", "value": 1.5, "note": "x", "window": 5}`), false)
+	require.NoError(t, err)
+	require.Equal(t, 0, conditions.Version)
+	require.Equal(t, json.Number("1.5"), conditions.Value)
+	require.Empty(t, conditions.Window)
+
+	var request struct {
+		Conditions models.AlertConditions `json:"conditions"`
+	}
+	err = json.Unmarshal([]byte(`{"conditions": {"type": "price", "token": "ETH", "operator": ">", "value": "2000.5", "extra": true}}`), &request)
+	require.ErrorContains(t, err, "conditions.extra: unknown field")
+}
+
+func TestAlertConditionsSerializer(t *testing.T) {
+	type storedAlert struct {
+		ID         uint
+		Conditions models.AlertConditions `gorm:"serializer:alert_conditions;not null"`
+	}
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "alerts.db")), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&storedAlert{}))
+
+	alert := storedAlert{Conditions: *mustDecodeConditions(t, `{"or": [
+		{"type": "price", "token": "ETH", "operator": "falls_pct", "value": "12.5", "window": "4h"},
+		{"type": "transaction", "address": "0x1", "network": "ethereum"}
+	]}`)}
+	require.NoError(t, db.Create(&alert).Error)
+
+	var stored string
+	require.NoError(t, db.Table("stored_alerts").Select("conditions").Where("id = ?", alert.ID).Scan(&stored).Error)
+	require.JSONEq(t, `{"version": 1, "or": [
+		{"type": "price", "token": "ETH", "operator": "falls_pct", "value": 12.5, "window": "4h"},
+		{"type": "transaction", "address": "0x1", "network": "ethereum"}
+	]}`, stored)
+
+	var loaded storedAlert
+	require.NoError(t, db.First(&loaded, alert.ID).Error)
+	require.Equal(t, models.AlertConditionsVersion, loaded.Conditions.Version)
+	require.Equal(t, alert.Conditions.Or, loaded.Conditions.Or)
+
+	// Rows written before conditions were typed still load
+	require.NoError(t, db.Exec(`INSERT INTO stored_alerts (id, conditions) VALUES (2, '{"type":"price","token":"ETH","operator":">","value":2000,"note":"old"}')`).Error)
+	var legacy storedAlert
+	require.NoError(t, db.First(&legacy, 2).Error)
+	require.Equal(t, 0, legacy.Conditions.Version)
+	_, err = parseConditions(&legacy.Conditions)
+	require.NoError(t, err)
+}
+
+func TestValidateConditionsMatchesAlertType(t *testing.T) {
+	price := mustDecodeConditions(t, `{"type": "price", "token": "ETH", "operator": ">", "value": 1}`)
+	require.NoError(t, ValidateAlertConditions("price", price))
+	require.ErrorContains(t, ValidateAlertConditions("balance", price), "conditions are for a price alert")
+
+	group := mustDecodeConditions(t, `{"and": [{"type": "price", "token": "ETH", "operator": ">", "value": 1}]}`)
+	require.NoError(t, ValidateAlertConditions(AlertTypeComposite, group))
+	require.Error(t, ValidateAlertConditions("price", group))
+}
+
+func TestCompareLevel(t *testing.T) {
+	leaf := func(operator string, value int64) *ConditionLeaf {
+		return &ConditionLeaf{Operator: operator, Value: big.NewRat(value, 1)}
+	}
+	rat := func(value int64) *big.Rat { return big.NewRat(value, 1) }
+
+	met, _ := compareLevel(leaf(OperatorCrossesAbove, 2000), rat(2010), rat(1990))
+	require.True(t, met)
+	met, _ = compareLevel(leaf(OperatorCrossesAbove, 2000), rat(2010), rat(2005))
+	require.False(t, met)
+	met, _ = compareLevel(leaf(OperatorCrossesBelow, 2000), rat(1990), rat(2000))
+	require.True(t, met)
+
+	met, data := compareLevel(leaf(OperatorFallsPct, 10), rat(1800), rat(2000))
+	require.True(t, met)
+	require.Equal(t, "-10.00", data["change_pct"])
+	met, _ = compareLevel(leaf(OperatorFallsPct, 10), rat(1801), rat(2000))
+	require.False(t, met)
+	met, data = compareLevel(leaf(OperatorRisesPct, 5), rat(2100), rat(2000))
+	require.True(t, met)
+	require.Equal(t, "2000", data["window_start_value"])
+
+	met, _ = compareLevel(leaf(">=", 5), rat(5), nil)
+	require.True(t, met)
+	require.Zero(t, floatDecimal(0.1).Cmp(big.NewRat(1, 10)))
+}
+
+func TestEvaluateConditionGroups(t *testing.T) {
+	rich, poor := "0x1000000000000000000000000000000000000001", "0x2000000000000000000000000000000000000002"
+	var calls atomic.Int32
+	server := newFakeRPC(t, func(method string, params []json.RawMessage) (interface{}, *rpcErrorBody) {
+		if method != "eth_getBalance" {
+			return nil, &rpcErrorBody{Code: -32601, Message: "method not found"}
+		}
+		calls.Add(1)
+		var address string
+		require.NoError(t, json.Unmarshal(params[0], &address))
+		if strings.EqualFold(address, rich) {
+			return hexutil.EncodeBig(big.NewInt(5_000_000_000_000_000_000)), nil
+		}
+		return "0x0", nil
+	})
+
+	web3 := NewWeb3Service(&config.Config{
+		Networks: config.NewNetworkRegistry([]config.NetworkConfig{{Name: "testnet", NativeSymbol: "ETH", Decimals: 18, RPCURLs: []string{server.URL}}}),
+	})
+	defer web3.Close()
+	s := &AlertService{web3Service: web3}
+
+	balanceAbove := func(address string) string {
+		return `{"type": "balance", "address": "` + address + `", "network": "testnet", "operator": ">", "value": 1}`
+	}
+	evaluate := func(raw string) *alertEvaluation {
+		expr, err := parseConditions(mustDecodeConditions(t, raw))
+		require.NoError(t, err)
+		evaluation, err := s.evaluateCondition(context.Background(), &models.Alert{}, expr, time.Now())
+		require.NoError(t, err)
+		return evaluation
+	}
+
+	probes := calls.Load()
+	require.True(t, evaluate(`{"or": [`+balanceAbove(rich)+`, `+balanceAbove(poor)+`]}`).met)
+	require.Equal(t, probes+1, calls.Load()) // the first match decides an OR
+
+	evaluation := evaluate(`{"and": [` + balanceAbove(rich) + `, ` + balanceAbove(poor) + `]}`)
+	require.False(t, evaluation.met)
+	require.Nil(t, evaluation.current)
+	results := evaluation.data["and"].([]map[string]interface{})
+	require.Len(t, results, 2)
+	require.Equal(t, "5", results[0]["current_balance"])
+	require.Equal(t, "0", results[1]["current_balance"])
+
+	require.True(t, evaluate(`{"and": [`+balanceAbove(rich)+`, {"or": [`+balanceAbove(poor)+`, `+balanceAbove(rich)+`]}]}`).met)
+}
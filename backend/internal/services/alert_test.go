@@ -0,0 +1,335 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/glebarez/sqlite"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"web3-portfolio-dashboard/backend/internal/config"
+	"web3-portfolio-dashboard/backend/internal/models"
+)
+
+func TestGetHeldAmount(t *testing.T) {
+	holder := "0x1000000000000000000000000000000000000001"
+	usdc := common.HexToAddress("0x3000000000000000000000000000000000000003")
+
+	server := newFakeRPC(t, func(method string, params []json.RawMessage) (interface{}, *rpcErrorBody) {
+		switch method {
+		case "eth_getBalance":
+			return hexutil.EncodeBig(big.NewInt(1_500_000_000_000_000_000)), nil
+		case "eth_call":
+			var call struct {
+				To common.Address `json:"to"`
+			}
+			require.NoError(t, json.Unmarshal(params[0], &call))
+			require.Equal(t, usdc, call.To)
+			return hexutil.Bytes(common.BigToHash(big.NewInt(2_500_001)).Bytes()), nil
+		}
+		return nil, &rpcErrorBody{Code: -32601, Message: "method not found"}
+	})
+
+	cfg := &config.Config{
+		Networks: config.NewNetworkRegistry([]config.NetworkConfig{{
+			Name:         "testnet",
+			NativeSymbol: "ETH",
+			Decimals:     18,
+			RPCURLs:      []string{server.URL},
+			Tokens:       []config.TokenConfig{{Address: usdc.Hex(), Symbol: "USDC", Decimals: 6}},
+		}}),
+	}
+	web3 := NewWeb3Service(cfg)
+	defer web3.Close()
+	web3.cacheTokenMetadata("testnet", usdc, models.Token{Symbol: "USDC", Decimals: 6})
+
+	for token, want := range map[string]HeldAmount{
+		"":         {Symbol: "ETH", Units: big.NewRat(3, 2)},
+		"eth":      {Symbol: "ETH", Units: big.NewRat(3, 2)},
+		"usdc":     {Symbol: "USDC", TokenAddress: usdc.Hex(), Units: big.NewRat(2_500_001, 1_000_000)},
+		usdc.Hex(): {Symbol: "USDC", TokenAddress: usdc.Hex(), Units: big.NewRat(2_500_001, 1_000_000)},
+	} {
+		held, err := web3.GetHeldAmount(context.Background(), holder, "testnet", token)
+		require.NoError(t, err, token)
+		require.Equal(t, want.Symbol, held.Symbol, token)
+		require.Equal(t, want.TokenAddress, held.TokenAddress, token)
+		require.Zero(t, want.Units.Cmp(held.Units), token)
+	}
+
+	_, err := web3.GetHeldAmount(context.Background(), holder, "testnet", "DAI")
+	require.Error(t, err)
+}
+
+func TestBalanceConditionsCompareExactly(t *testing.T) {
+	held := unitsOf(big.NewInt(100_000), 6) // 0.1
+
+	for _, value := range []json.Number{"0.1", "0.100", "1e-1"} {
+		target, err := conditionDecimal(value)
+		require.NoError(t, err)
+		require.True(t, compareDecimal(held, target, "=="), value)
+		require.True(t, compareDecimal(held, target, ">="), value)
+		require.False(t, compareDecimal(held, target, "!="), value)
+	}
+
+	target, err := conditionDecimal(json.Number("0.1000001"))
+	require.NoError(t, err)
+	require.True(t, compareDecimal(held, target, "<"))
+	require.Equal(t, "0.1000001", formatDecimal(target))
+	require.Equal(t, "0.1", formatDecimal(held))
+	require.Equal(t, "2", formatDecimal(big.NewRat(2, 1)))
+
+	_, err = conditionDecimal("ten")
+	require.Error(t, err)
+	_, err = conditionDecimal("")
+	require.Error(t, err)
+}
+
+func TestValidateBalanceConditions(t *testing.T) {
+	conditions := &models.AlertConditions{AlertCondition: models.AlertCondition{
+		Type: "balance", Address: "0x1", Network: "ethereum", Operator: "<", Value: "100", Token: "USDC",
+	}}
+	require.NoError(t, ValidateAlertConditions("balance", conditions))
+
+	conditions.Value = "-1"
+	require.Error(t, ValidateAlertConditions("balance", conditions))
+	conditions.Value, conditions.Operator = "1", "~"
+	require.Error(t, ValidateAlertConditions("balance", conditions))
+}
+
+func TestDecideTrigger(t *testing.T) {
+	now := time.Now()
+	above := func(price int64) *alertEvaluation {
+		current, target := big.NewRat(price, 1), big.NewRat(100, 1)
+		return &alertEvaluation{met: compareDecimal(current, target, ">"), current: current, target: target, operator: ">"}
+	}
+	alert := &models.Alert{Armed: true, RearmPercent: 2, CooldownSeconds: 600}
+
+	require.Equal(t, triggerNone, decideTrigger(alert, above(99), now))
+	require.Equal(t, triggerFire, decideTrigger(alert, above(101), now))
+
+	// Fired alerts stay quiet until the price drops 2% below the threshold
+	alert.Armed = false
+	alert.LastTriggeredAt = &now
+	require.Equal(t, triggerNone, decideTrigger(alert, above(105), now))
+	require.Equal(t, triggerNone, decideTrigger(alert, above(99), now))
+	require.Equal(t, triggerRearm, decideTrigger(alert, above(98), now))
+
+	// Re-armed alerts still respect the cooldown
+	alert.Armed = true
+	require.Equal(t, triggerNone, decideTrigger(alert, above(101), now.Add(5*time.Minute)))
+	require.Equal(t, triggerFire, decideTrigger(alert, above(101), now.Add(10*time.Minute)))
+
+	// New transactions fire without re-arming, outside the cooldown
+	alert.Armed = false
+	transaction := &alertEvaluation{met: true, event: true}
+	require.Equal(t, triggerNone, decideTrigger(alert, transaction, now.Add(5*time.Minute)))
+	require.Equal(t, triggerFire, decideTrigger(alert, transaction, now.Add(10*time.Minute)))
+}
+
+func TestTransactionAlertDoesNotRefireAfterReorg(t *testing.T) {
+	watched := "0x1000000000000000000000000000000000000001"
+	userID, alertID, portfolioID := uuid.New(), uuid.New(), uuid.New()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "alerts.db")), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.NotifiedTransaction{}, &models.IndexedBlock{}))
+	for _, statement := range []string{
+		`CREATE TABLE portfolios (id TEXT PRIMARY KEY, user_id TEXT)`,
+		`CREATE TABLE addresses (id TEXT PRIMARY KEY, portfolio_id TEXT, network TEXT, address TEXT)`,
+		`CREATE TABLE sync_cursors (id TEXT PRIMARY KEY, address_id TEXT, last_block INTEGER, updated_at DATETIME)`,
+		`CREATE TABLE transactions (id TEXT PRIMARY KEY, portfolio_id TEXT, address_id TEXT, tx_hash TEXT, network TEXT, log_index INTEGER,
+			from_address TEXT, to_address TEXT, direction TEXT, status TEXT, token_address TEXT, token_symbol TEXT, token_decimals INTEGER,
+			amount TEXT, method_id TEXT, method TEXT, gas_used INTEGER, fee TEXT, fee_value REAL, block_number INTEGER, block_hash TEXT,
+			confirmations INTEGER, finalized NUMERIC, timestamp DATETIME, created_at DATETIME)`,
+		`CREATE TABLE alerts (id TEXT PRIMARY KEY, last_triggered_at DATETIME, trigger_count INTEGER, armed NUMERIC, is_active NUMERIC)`,
+		`CREATE TABLE notifications (id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))), user_id TEXT, alert_id TEXT,
+			type TEXT, message TEXT, data TEXT, read_at DATETIME, created_at DATETIME)`,
+		`INSERT INTO portfolios VALUES ('` + portfolioID.String() + `', '` + userID.String() + `')`,
+		`INSERT INTO addresses VALUES ('a1', '` + portfolioID.String() + `', 'testnet', '` + watched + `')`,
+		`INSERT INTO alerts VALUES ('` + alertID.String() + `', NULL, 0, true, true)`,
+	} {
+		require.NoError(t, db.Exec(statement).Error)
+	}
+
+	cfg := &config.Config{Networks: config.NewNetworkRegistry([]config.NetworkConfig{{Name: "testnet"}})}
+	web3 := NewWeb3Service(cfg)
+	defer web3.Close()
+	s := NewAlertService(db, web3, nil, nil, NewNotificationService(db, cfg, nil))
+	indexer := NewTransactionIndexer(db, cfg, web3, nil)
+
+	alert := &models.Alert{
+		ID:     alertID,
+		UserID: userID,
+		Type:   "transaction",
+		Name:   "Watched wallet",
+		Conditions: models.AlertConditions{Version: 1, AlertCondition: models.AlertCondition{
+			Type: ConditionTransaction, Address: watched, Network: "testnet",
+		}},
+		IsActive:  true,
+		Armed:     true,
+		CreatedAt: time.Now().Add(-time.Hour),
+	}
+	index := func(hash string, block uint64) {
+		require.NoError(t, db.Table("transactions").Create(map[string]interface{}{
+			"id": uuid.NewString(), "portfolio_id": portfolioID.String(), "address_id": uuid.NewString(), "network": "testnet", "tx_hash": hash, "log_index": 0, "block_number": block,
+			"from_address": "0x2000000000000000000000000000000000000002", "to_address": watched, "amount": "1", "status": "success",
+			"timestamp": time.Now().Add(-time.Minute), "created_at": time.Now(),
+		}).Error)
+	}
+	notifications := func() (count int64) {
+		require.NoError(t, db.Model(&models.Notification{}).Count(&count).Error)
+		return count
+	}
+
+	index("0xaa", 10)
+	require.NoError(t, s.checkAlert(context.Background(), alert))
+	require.EqualValues(t, 1, notifications())
+
+	// The reorg takes the transfer out and the indexer stores it again, now with a later created_at
+	_, err = indexer.rollback("testnet", 10)
+	require.NoError(t, err)
+	require.NoError(t, s.checkAlert(context.Background(), alert))
+	index("0xaa", 11)
+	require.NoError(t, s.checkAlert(context.Background(), alert))
+	require.EqualValues(t, 1, notifications())
+
+	// A transfer the alert hasn't seen still fires it
+	index("0xbb", 12)
+	require.NoError(t, s.checkAlert(context.Background(), alert))
+	require.EqualValues(t, 2, notifications())
+	require.Equal(t, 2, alert.TriggerCount)
+}
+
+func TestTriggerAlertStoresNotificationWithTrigger(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "alerts.db")), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.Exec(`CREATE TABLE notifications (id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))), user_id TEXT,
+		alert_id TEXT, type TEXT, message TEXT, data TEXT, read_at DATETIME, created_at DATETIME)`).Error)
+
+	cfg := &config.Config{}
+	s := NewAlertService(db, nil, nil, nil, NewNotificationService(db, cfg, DefaultNotifiers(cfg)))
+	alert := &models.Alert{ID: uuid.New(), UserID: uuid.New(), Type: "price", Name: "ETH above 3000", Channels: models.StringList{ChannelEmail}, IsActive: true, Armed: true}
+	evaluation := &alertEvaluation{met: true, data: map[string]interface{}{"token": "ETH"}}
+	notifications := func() (count int64) {
+		require.NoError(t, db.Model(&models.Notification{}).Count(&count).Error)
+		return count
+	}
+
+	// Without a recorded trigger the notification isn't kept, so the next check doesn't store it twice
+	require.Error(t, s.triggerAlert(context.Background(), alert, evaluation, time.Now()))
+	require.Zero(t, notifications())
+	require.True(t, alert.Armed)
+	require.Zero(t, alert.TriggerCount)
+
+	// A failed delivery (there is no users table) doesn't undo the trigger
+	require.NoError(t, db.Exec(`CREATE TABLE alerts (id TEXT PRIMARY KEY, last_triggered_at DATETIME, trigger_count INTEGER, armed NUMERIC, is_active NUMERIC)`).Error)
+	require.NoError(t, db.Exec(`INSERT INTO alerts VALUES (?, NULL, 0, true, true)`, alert.ID.String()).Error)
+	require.NoError(t, s.triggerAlert(context.Background(), alert, evaluation, time.Now()))
+	require.EqualValues(t, 1, notifications())
+
+	var stored struct {
+		TriggerCount int
+		Armed        bool
+	}
+	require.NoError(t, db.Table("alerts").Where("id = ?", alert.ID.String()).Take(&stored).Error)
+	require.Equal(t, 1, stored.TriggerCount)
+	require.False(t, stored.Armed)
+}
+
+func TestMatchTransaction(t *testing.T) {
+	watched := "0x1000000000000000000000000000000000000001"
+	exchange := "0x2000000000000000000000000000000000000002"
+	usdc := "0x3000000000000000000000000000000000000003"
+	parse := func(raw string) *ConditionLeaf {
+		expr, err := parseConditions(mustDecodeConditions(t, `{"type": "transaction", "address": "`+watched+`", "network": "ethereum", `+raw+`}`))
+		require.NoError(t, err)
+		return expr.Leaf
+	}
+	deposit := &models.Transaction{FromAddress: exchange, ToAddress: "0x1000000000000000000000000000000000000001", TokenAddress: usdc, TokenSymbol: "USDC", Amount: "2500.5", MethodID: "0xa9059cbb", Method: "transfer"}
+	withdrawal := &models.Transaction{FromAddress: watched, ToAddress: exchange, TokenSymbol: "ETH", Amount: "0.5"}
+
+	for raw, want := range map[string][2]bool{
+		`"direction": "in"`:                              {true, false},
+		`"direction": "out"`:                             {false, true},
+		`"token": "` + usdc + `"`:                        {true, false},
+		`"token": "eth"`:                                 {false, true},
+		`"min_amount": "2500.5"`:                         {true, false},
+		`"min_amount": 0.5`:                              {true, true},
+		`"counterparties": ["` + exchange + `"]`:         {true, true},
+		`"counterparties": ["` + usdc + `"]`:             {false, false},
+		`"exclude_counterparties": ["` + exchange + `"]`: {false, false},
+		`"methods": ["Transfer"]`:                        {true, false},
+		`"methods": ["0xa9059cbb", "swap"]`:              {true, false},
+	} {
+		leaf := parse(raw)
+		require.Equal(t, want[0], matchTransaction(leaf, deposit), "deposit with %s", raw)
+		require.Equal(t, want[1], matchTransaction(leaf, withdrawal), "withdrawal with %s", raw)
+	}
+
+	// Sending to itself counts both ways
+	self := &models.Transaction{FromAddress: watched, ToAddress: watched, TokenSymbol: "ETH", Amount: "1"}
+	require.Equal(t, DirectionSelf, transactionDirection(watched, self))
+	require.True(t, matchTransaction(parse(`"direction": "out"`), self))
+}
+
+func TestRearmed(t *testing.T) {
+	below := func(price string) *alertEvaluation {
+		current, _ := new(big.Rat).SetString(price)
+		target := big.NewRat(50, 1)
+		return &alertEvaluation{met: compareDecimal(current, target, "<"), current: current, target: target, operator: "<"}
+	}
+	require.False(t, rearmed(below("49"), 10))
+	require.False(t, rearmed(below("54.99"), 10))
+	require.True(t, rearmed(below("55"), 10))
+	require.True(t, rearmed(below("50"), 0))
+
+	// Alerts without a level re-arm as soon as the condition clears
+	require.True(t, rearmed(&alertEvaluation{}, 5))
+	require.False(t, rearmed(&alertEvaluation{met: true}, 5))
+}
+
+func TestApplySettings(t *testing.T) {
+	resolveWebhookHosts(t, map[string]string{"hooks.example.com": "93.184.216.34"})
+
+	alert := &models.Alert{Channels: []string{ChannelEmail}, CooldownSeconds: 60}
+	cooldown, rearm, once := 300, 2.5, true
+	require.NoError(t, applySettings(alert, AlertSettings{CooldownSeconds: &cooldown, RearmPercent: &rearm, FireOnce: &once}))
+	require.Equal(t, models.StringList{ChannelEmail}, alert.Channels)
+	require.Equal(t, 300, alert.CooldownSeconds)
+	require.Equal(t, 2.5, alert.RearmPercent)
+	require.True(t, alert.FireOnce)
+
+	cooldown, rearm = -1, 0
+	require.Error(t, applySettings(alert, AlertSettings{CooldownSeconds: &cooldown}))
+	rearm = 100
+	require.Error(t, applySettings(alert, AlertSettings{RearmPercent: &rearm}))
+	require.Empty(t, alert.WebhookSecret)
+
+	// Enabling webhooks issues the alert its own secret, shown once
+	webhook := AlertSettings{Channels: []string{ChannelWebhook}, WebhookURL: "https://hooks.example.com/a"}
+	require.NoError(t, applySettings(alert, webhook))
+	secret := alert.WebhookSecret
+	require.NotEmpty(t, secret)
+	require.Equal(t, secret, alert.NewWebhookSecret)
+
+	other := &models.Alert{}
+	require.NoError(t, applySettings(other, webhook))
+	require.NotEqual(t, secret, other.WebhookSecret)
+
+	// Saving again keeps it; a new receiver gets a new one
+	alert.NewWebhookSecret = ""
+	require.NoError(t, applySettings(alert, webhook))
+	require.Equal(t, secret, alert.WebhookSecret)
+	require.Empty(t, alert.NewWebhookSecret)
+	require.NoError(t, applySettings(alert, AlertSettings{WebhookURL: "https://hooks.example.com/b"}))
+	require.NotEqual(t, secret, alert.WebhookSecret)
+	require.Equal(t, alert.WebhookSecret, alert.NewWebhookSecret)
+}
@@ -0,0 +1,163 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// etherscanPageSize is the number of transactions requested per page; the API
+// stops paging at etherscanMaxResults, so long ranges are walked by start block instead
+const (
+	etherscanPageSize   = 1000
+	etherscanMaxResults = 10000
+)
+
+// EtherscanClient talks to an Etherscan-compatible account API (Etherscan v2,
+// the per-chain *scan explorers, Blockscout)
+type EtherscanClient struct {
+	baseURL string
+	apiKey  string
+	client  *http.Client
+}
+
+// explorerTx is a normal transaction as returned by action=txlist
+type explorerTx struct {
+	BlockNumber string `json:"blockNumber"`
+	BlockHash   string `json:"blockHash"`
+	TimeStamp   string `json:"timeStamp"`
+	Hash        string `json:"hash"`
+	From        string `json:"from"`
+	To          string `json:"to"`
+	Value       string `json:"value"`
+	Gas         string `json:"gas"`
+	GasPrice    string `json:"gasPrice"`
+	GasUsed     string `json:"gasUsed"`
+	IsError     string `json:"isError"`
+	Input       string `json:"input"`
+}
+
+func NewEtherscanClient(baseURL, apiKey string) *EtherscanClient {
+	return &EtherscanClient{
+		baseURL: baseURL,
+		apiKey:  apiKey,
+		client:  &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+// Enabled reports whether the client has an API key to call with
+func (c *EtherscanClient) Enabled() bool {
+	return c != nil && c.apiKey != ""
+}
+
+// NormalTransactions lists the transactions sent from or to an address between
+// two blocks (inclusive), oldest first
+func (c *EtherscanClient) NormalTransactions(ctx context.Context, baseURL string, chainID uint64, address string, startBlock, endBlock uint64) ([]explorerTx, error) {
+	if baseURL == "" {
+		baseURL = c.baseURL
+	}
+
+	var all []explorerTx
+	seen := make(map[string]bool)
+	add := func(page []explorerTx) {
+		for _, tx := range page {
+			if !seen[tx.Hash] {
+				seen[tx.Hash] = true
+				all = append(all, tx)
+			}
+		}
+	}
+
+	for startBlock <= endBlock {
+		page, err := c.txList(ctx, baseURL, chainID, address, startBlock, endBlock, 1)
+		if err != nil {
+			return nil, err
+		}
+		add(page)
+
+		if len(page) < etherscanPageSize {
+			break
+		}
+
+		// A full page may cut a block in half, so restart from the last block seen
+		last, err := strconv.ParseUint(page[len(page)-1].BlockNumber, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid block number in explorer response: %w", err)
+		}
+		if last > startBlock {
+			startBlock = last
+			continue
+		}
+
+		// The page holds nothing but the start block, so page through that block alone
+		for number := 2; len(page) == etherscanPageSize; number++ {
+			if number*etherscanPageSize > etherscanMaxResults {
+				return nil, fmt.Errorf("block %d has more than %d transactions of %s, more than the explorer can list", startBlock, etherscanMaxResults, address)
+			}
+			page, err = c.txList(ctx, baseURL, chainID, address, startBlock, startBlock, number)
+			if err != nil {
+				return nil, err
+			}
+			add(page)
+		}
+		startBlock++
+	}
+
+	return all, nil
+}
+
+// txList requests one page of an address's transactions between two blocks
+func (c *EtherscanClient) txList(ctx context.Context, baseURL string, chainID uint64, address string, startBlock, endBlock uint64, page int) ([]explorerTx, error) {
+	query := url.Values{}
+	query.Set("chainid", strconv.FormatUint(chainID, 10))
+	query.Set("module", "account")
+	query.Set("action", "txlist")
+	query.Set("address", address)
+	query.Set("startblock", strconv.FormatUint(startBlock, 10))
+	query.Set("endblock", strconv.FormatUint(endBlock, 10))
+	query.Set("page", strconv.Itoa(page))
+	query.Set("offset", strconv.Itoa(etherscanPageSize))
+	query.Set("sort", "asc")
+	query.Set("apikey", c.apiKey)
+	return c.get(ctx, baseURL, query)
+}
+
+func (c *EtherscanClient) get(ctx context.Context, baseURL string, query url.Values) ([]explorerTx, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("explorer request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("explorer returned status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Status  string          `json:"status"`
+		Message string          `json:"message"`
+		Result  json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("invalid explorer response: %w", err)
+	}
+
+	// Errors come back as status 0 with a string result; an empty list is status 0 too
+	var txs []explorerTx
+	if err := json.Unmarshal(body.Result, &txs); err != nil {
+		var message string
+		_ = json.Unmarshal(body.Result, &message)
+		return nil, fmt.Errorf("explorer error: %s", strings.TrimSpace(body.Message+" "+message))
+	}
+	return txs, nil
+}
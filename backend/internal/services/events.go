@@ -0,0 +1,208 @@
+package services
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Event types pushed to clients
+const (
+	EventNotification = "notification" // an alert fired
+	EventBalances     = "balances"     // a portfolio's balances were refreshed
+	EventPrice        = "price"        // a token's price was recorded
+)
+
+// Event is a message pushed to subscribed clients
+type Event struct {
+	ID          uint64      `json:"id"`
+	Type        string      `json:"type"`
+	PortfolioID string      `json:"portfolio_id,omitempty"`
+	Token       string      `json:"token,omitempty"`
+	Data        interface{} `json:"data"`
+	Time        time.Time   `json:"time"`
+}
+
+// EventHub fans events out to the subscriptions of each user. Publishing never
+// blocks: a subscription that falls a full buffer behind is closed, and its
+// client reconnects and reloads. A nil hub drops everything.
+type EventHub struct {
+	bufferSize int
+
+	mu     sync.RWMutex
+	users  map[uuid.UUID]map[*Subscription]struct{}
+	nextID uint64
+	closed bool
+}
+
+// Subscription receives a user's events, narrowed to some portfolios and
+// the price ticks of some tokens
+type Subscription struct {
+	UserID     uuid.UUID
+	events     chan Event
+	portfolios map[string]bool // every portfolio when empty
+	tokens     map[string]bool
+}
+
+func NewEventHub(bufferSize int) *EventHub {
+	if bufferSize < 1 {
+		bufferSize = 1
+	}
+	return &EventHub{bufferSize: bufferSize, users: make(map[uuid.UUID]map[*Subscription]struct{})}
+}
+
+// Events is closed when the subscription ends
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+func (s *Subscription) wants(event *Event) bool {
+	switch event.Type {
+	case EventPrice:
+		return s.tokens[strings.ToUpper(event.Token)]
+	case EventBalances:
+		return len(s.portfolios) == 0 || s.portfolios[event.PortfolioID]
+	default:
+		return true
+	}
+}
+
+// Subscribe starts a subscription for a user. Price ticks are only sent for
+// the given tokens.
+func (h *EventHub) Subscribe(userID uuid.UUID, portfolios, tokens []string) *Subscription {
+	sub := &Subscription{
+		UserID:     userID,
+		events:     make(chan Event, h.bufferSize),
+		portfolios: make(map[string]bool, len(portfolios)),
+		tokens:     make(map[string]bool, len(tokens)),
+	}
+	for _, portfolio := range portfolios {
+		sub.portfolios[portfolio] = true
+	}
+	for _, token := range tokens {
+		sub.tokens[strings.ToUpper(token)] = true
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		close(sub.events)
+		return sub
+	}
+	if h.users[userID] == nil {
+		h.users[userID] = make(map[*Subscription]struct{})
+	}
+	h.users[userID][sub] = struct{}{}
+	return sub
+}
+
+// Unsubscribe ends a subscription
+func (h *EventHub) Unsubscribe(sub *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.remove(sub)
+}
+
+// remove drops a subscription and closes its channel; h.mu must be held
+func (h *EventHub) remove(sub *Subscription) {
+	subs, ok := h.users[sub.UserID]
+	if !ok {
+		return
+	}
+	if _, ok := subs[sub]; !ok {
+		return
+	}
+	delete(subs, sub)
+	if len(subs) == 0 {
+		delete(h.users, sub.UserID)
+	}
+	close(sub.events)
+}
+
+// PublishToUser sends an event to a user's subscriptions
+func (h *EventHub) PublishToUser(userID uuid.UUID, event Event) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+	h.stamp(&event)
+	for sub := range h.users[userID] {
+		h.send(sub, event)
+	}
+}
+
+// Publish sends an event to every subscription that wants it, such as the
+// subscribers of a token's price
+func (h *EventHub) Publish(event Event) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+	h.stamp(&event)
+	for _, subs := range h.users {
+		for sub := range subs {
+			h.send(sub, event)
+		}
+	}
+}
+
+func (h *EventHub) stamp(event *Event) {
+	h.nextID++
+	event.ID = h.nextID
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+}
+
+// send queues an event for a subscription without blocking; h.mu must be held
+func (h *EventHub) send(sub *Subscription, event Event) {
+	if !sub.wants(&event) {
+		return
+	}
+	select {
+	case sub.events <- event:
+	default:
+		log.Printf("Closing event stream of user %s that fell %d events behind", sub.UserID, h.bufferSize)
+		h.remove(sub)
+	}
+}
+
+// Subscribers counts the open subscriptions
+func (h *EventHub) Subscribers() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	count := 0
+	for _, subs := range h.users {
+		count += len(subs)
+	}
+	return count
+}
+
+// Close ends every subscription and drops events published afterwards
+func (h *EventHub) Close() {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+	h.closed = true
+	for _, subs := range h.users {
+		for sub := range subs {
+			h.remove(sub)
+		}
+	}
+}
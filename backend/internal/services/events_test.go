@@ -0,0 +1,55 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventHubFanOut(t *testing.T) {
+	hub := NewEventHub(4)
+	alice, bob := uuid.New(), uuid.New()
+	phone := hub.Subscribe(alice, nil, []string{"eth"})
+	laptop := hub.Subscribe(alice, []string{"p1"}, nil)
+	other := hub.Subscribe(bob, nil, nil)
+
+	hub.PublishToUser(alice, Event{Type: EventNotification, Data: "fired"})
+	hub.PublishToUser(alice, Event{Type: EventBalances, PortfolioID: "p2"})
+	hub.Publish(Event{Type: EventPrice, Token: "ETH"})
+
+	// Every device of the user gets its notifications; balances and prices only when subscribed
+	require.Equal(t, EventNotification, (<-phone.Events()).Type)
+	require.Equal(t, EventBalances, (<-phone.Events()).Type)
+	require.Equal(t, EventNotification, (<-laptop.Events()).Type)
+	require.Empty(t, laptop.Events())
+	require.Empty(t, other.Events())
+
+	// A subscription that falls a full buffer behind is dropped
+	event := <-phone.Events()
+	require.Equal(t, EventPrice, event.Type)
+	require.Equal(t, uint64(3), event.ID)
+	for i := 0; i < 5; i++ {
+		hub.PublishToUser(alice, Event{Type: EventNotification})
+	}
+	require.Len(t, phone.Events(), 4)
+	for i := 0; i < 4; i++ {
+		<-phone.Events()
+	}
+	_, open := <-phone.Events()
+	require.False(t, open)
+	require.Equal(t, 1, hub.Subscribers())
+
+	hub.Unsubscribe(other)
+	hub.Unsubscribe(other)
+	hub.Close()
+	require.Zero(t, hub.Subscribers())
+	_, open = <-hub.Subscribe(alice, nil, nil).Events()
+	require.False(t, open)
+
+	// Services without a hub publish nowhere
+	var none *EventHub
+	none.PublishToUser(alice, Event{Type: EventNotification})
+	none.Publish(Event{Type: EventPrice})
+	none.Close()
+}
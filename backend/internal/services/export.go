@@ -0,0 +1,381 @@
+package services
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"web3-portfolio-dashboard/backend/internal/models"
+)
+
+// Tax export reports
+const (
+	ExportReportGains  = "gains"  // one row per disposed cost lot
+	ExportReportLedger = "ledger" // one row per transaction
+)
+
+// Tax export column layouts
+const (
+	ExportFormatGeneric     = "generic"
+	ExportFormatKoinly      = "koinly"
+	ExportFormatCoinTracker = "cointracker"
+)
+
+// UnknownPrice is written in place of a fiat amount when no price was recorded at the time
+const UnknownPrice = "UNKNOWN"
+
+// longTermHolding is how long a lot must be held for its gain to be long term
+const longTermHolding = 365 * 24 * time.Hour
+
+// ExportOptions selects a tax export
+type ExportOptions struct {
+	Report string
+	Format string
+	Year   int
+	Method string // cost-basis method, for the gains report
+}
+
+// ParseExportOptions validates export options, defaulting to a generic gains
+// report for the current year using FIFO
+func ParseExportOptions(report, format, year, method string) (ExportOptions, error) {
+	opts := ExportOptions{Report: strings.ToLower(report), Format: strings.ToLower(format), Year: time.Now().UTC().Year()}
+
+	switch opts.Report {
+	case "":
+		opts.Report = ExportReportGains
+	case ExportReportGains, ExportReportLedger:
+	default:
+		return opts, fmt.Errorf("unsupported report %q", report)
+	}
+	switch opts.Format {
+	case "":
+		opts.Format = ExportFormatGeneric
+	case ExportFormatGeneric, ExportFormatKoinly, ExportFormatCoinTracker:
+	default:
+		return opts, fmt.Errorf("unsupported format %q", format)
+	}
+	if year != "" {
+		parsed, err := strconv.Atoi(year)
+		if err != nil || parsed < 1970 || parsed > 9999 {
+			return opts, fmt.Errorf("invalid year %q", year)
+		}
+		opts.Year = parsed
+	}
+
+	var err error
+	opts.Method, err = ParseCostBasisMethod(method)
+	return opts, err
+}
+
+// Filename names the CSV file for an export of a portfolio
+func (o ExportOptions) Filename(portfolioID string) string {
+	return fmt.Sprintf("portfolio-%s-%s-%d-%s.csv", portfolioID, o.Report, o.Year, o.Format)
+}
+
+// ExportPortfolio writes a tax export of a user's portfolio as CSV
+func (s *PortfolioService) ExportPortfolio(userID, portfolioID string, opts ExportOptions, w io.Writer) error {
+	if _, err := s.GetPortfolio(userID, portfolioID); err != nil {
+		return err
+	}
+	portfolioUUID, _ := uuid.Parse(portfolioID)
+	return s.WriteExport(portfolioUUID, opts, w)
+}
+
+// WriteExport writes a tax export of a portfolio as CSV. Fiat amounts use the
+// price recorded at the time of each transaction.
+func (s *PortfolioService) WriteExport(portfolioID uuid.UUID, opts ExportOptions, w io.Writer) error {
+	from := time.Date(opts.Year, time.January, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(1, 0, 0).Add(-time.Nanosecond)
+
+	var rows [][]string
+	switch opts.Report {
+	case ExportReportGains:
+		ledger, err := s.replayTransactions(portfolioID, opts.Method, from, to)
+		if err != nil {
+			return err
+		}
+		rows = gainsRows(opts.Format, ledger.disposals)
+	case ExportReportLedger:
+		var transactions []models.Transaction
+		err := s.db.Where("portfolio_id = ? AND timestamp BETWEEN ? AND ?", portfolioID, from, to).
+			Order("timestamp, block_number, log_index").
+			Find(&transactions).Error
+		if err != nil {
+			return fmt.Errorf("failed to get transactions: %w", err)
+		}
+		rows = ledgerRows(opts.Format, transactions, s.priceHistory.PriceAt, s.web3Service.NativeTokenSymbol)
+	default:
+		return fmt.Errorf("unsupported report %q", opts.Report)
+	}
+
+	out := csv.NewWriter(w)
+	if err := out.WriteAll(rows); err != nil {
+		return fmt.Errorf("failed to write export: %w", err)
+	}
+	return nil
+}
+
+// gainsRows lays out disposals as a capital gains report, header first
+func gainsRows(format string, disposals []Disposal) [][]string {
+	var rows [][]string
+	switch format {
+	case ExportFormatKoinly:
+		rows = append(rows, []string{"Date Sold", "Date Acquired", "Asset", "Amount", "Cost (USD)", "Proceeds (USD)", "Gain / loss", "Notes"})
+	case ExportFormatCoinTracker:
+		rows = append(rows, []string{"Asset Amount", "Asset Name", "Received Date", "Date Sold", "Proceeds (USD)", "Cost Basis (USD)", "Gain (USD)", "Type"})
+	default:
+		rows = append(rows, []string{"Asset", "Network", "Token Address", "Amount", "Date Acquired", "Date Sold", "Proceeds (USD)", "Cost Basis (USD)", "Gain (USD)", "Term", "Tx Hash", "Notes"})
+	}
+
+	for _, d := range disposals {
+		proceeds := exportUSD(d.Proceeds, d.ProceedsKnown)
+		cost := exportUSD(d.CostBasis, d.CostKnown)
+		gain := exportUSD(d.Proceeds-d.CostBasis, d.ProceedsKnown && d.CostKnown)
+		amount := formatQuantity(d.Quantity)
+		notes := ""
+		if d.Gas {
+			notes = "gas fee"
+		}
+
+		switch format {
+		case ExportFormatKoinly:
+			rows = append(rows, []string{
+				exportTime(format, d.DisposedAt), acquiredDate(format, d), d.Symbol, amount, cost, proceeds, gain, notes,
+			})
+		case ExportFormatCoinTracker:
+			term := "Short Term"
+			if holdingTerm(d) == "long" {
+				term = "Long Term"
+			}
+			rows = append(rows, []string{
+				amount, d.Symbol, acquiredDate(format, d), exportTime(format, d.DisposedAt), proceeds, cost, gain, term,
+			})
+		default:
+			rows = append(rows, []string{
+				d.Symbol, d.Network, d.TokenAddress, amount, acquiredDate(format, d), exportTime(format, d.DisposedAt),
+				proceeds, cost, gain, holdingTerm(d), d.TxHash, notes,
+			})
+		}
+	}
+	return rows
+}
+
+// holdingTerm is "long" or "short", or "unknown" when the acquisition date is not known
+func holdingTerm(d Disposal) string {
+	switch {
+	case d.AcquiredAt.IsZero():
+		return "unknown"
+	case d.DisposedAt.Sub(d.AcquiredAt) > longTermHolding:
+		return "long"
+	default:
+		return "short"
+	}
+}
+
+// acquiredDate formats when a disposed lot was acquired: "various" for lots
+// pooled under average cost and UNKNOWN when no indexed lot covered it
+func acquiredDate(format string, d Disposal) string {
+	switch {
+	case d.Unmatched:
+		return UnknownPrice
+	case d.AcquiredAt.IsZero():
+		return "various"
+	default:
+		return exportTime(format, d.AcquiredAt)
+	}
+}
+
+// ledgerLeg is an amount of one asset moving in or out
+type ledgerLeg struct {
+	amount string
+	symbol string
+	value  float64
+	priced bool
+}
+
+// ledgerRows lays out transactions as a ledger, header first. The generic format
+// keeps one row per transfer; tax tool formats merge a transaction sending one
+// asset and receiving another into a single trade row.
+func ledgerRows(format string, transactions []models.Transaction, priceAt func(symbol string, t time.Time) (float64, bool), nativeSymbol func(network string) string) [][]string {
+	if format != ExportFormatKoinly && format != ExportFormatCoinTracker {
+		return genericLedgerRows(transactions, priceAt, nativeSymbol)
+	}
+
+	var rows [][]string
+	if format == ExportFormatKoinly {
+		rows = append(rows, []string{"Date", "Sent Amount", "Sent Currency", "Received Amount", "Received Currency", "Fee Amount", "Fee Currency", "Net Worth Amount", "Net Worth Currency", "Label", "Description", "TxHash"})
+	} else {
+		rows = append(rows, []string{"Date", "Received Quantity", "Received Currency", "Sent Quantity", "Sent Currency", "Fee Amount", "Fee Currency", "Tag"})
+	}
+
+	// Rows of one transaction are adjacent, ordered by log index
+	for start := 0; start < len(transactions); {
+		end := start + 1
+		for end < len(transactions) && sameTransaction(transactions[end], transactions[start]) {
+			end++
+		}
+		group := transactions[start:end]
+		start = end
+
+		var sent, received []ledgerLeg
+		fee, feeCurrency := "", ""
+		for _, tx := range group {
+			if amount, err := strconv.ParseFloat(tx.Fee, 64); err == nil && amount > 0 && fee == "" {
+				fee, feeCurrency = formatQuantity(amount), nativeSymbol(tx.Network)
+			}
+			leg, ok := transferLeg(tx, priceAt)
+			if !ok {
+				continue
+			}
+			switch tx.Direction {
+			case DirectionIn:
+				received = append(received, leg)
+			case DirectionOut:
+				sent = append(sent, leg)
+			}
+		}
+
+		first := group[0]
+		var entries [][2]*ledgerLeg
+		if len(sent) == 1 && len(received) == 1 {
+			entries = append(entries, [2]*ledgerLeg{&sent[0], &received[0]})
+		} else {
+			for i := range sent {
+				entries = append(entries, [2]*ledgerLeg{&sent[i], nil})
+			}
+			for i := range received {
+				entries = append(entries, [2]*ledgerLeg{nil, &received[i]})
+			}
+		}
+		if len(entries) == 0 && fee != "" {
+			// Approvals, failed and self transactions still cost gas
+			entries = append(entries, [2]*ledgerLeg{nil, nil})
+		}
+
+		for i, entry := range entries {
+			entryFee, entryFeeCurrency := fee, feeCurrency
+			if i > 0 {
+				entryFee, entryFeeCurrency = "", ""
+			}
+			out, in := entry[0], entry[1]
+			if format == ExportFormatKoinly {
+				rows = append(rows, koinlyLedgerRow(first, out, in, entryFee, entryFeeCurrency))
+			} else {
+				rows = append(rows, coinTrackerLedgerRow(first, out, in, entryFee, entryFeeCurrency))
+			}
+		}
+	}
+	return rows
+}
+
+func koinlyLedgerRow(tx models.Transaction, out, in *ledgerLeg, fee, feeCurrency string) []string {
+	row := []string{exportTime(ExportFormatKoinly, tx.Timestamp), "", "", "", "", fee, feeCurrency, "", "", "", tx.Method, tx.TxHash}
+	if out != nil {
+		row[1], row[2] = out.amount, out.symbol
+	}
+	if in != nil {
+		row[3], row[4] = in.amount, in.symbol
+	}
+
+	// Net worth is the value received, or the value sent when nothing was received
+	switch {
+	case in != nil:
+		row[7], row[8] = exportUSD(in.value, in.priced), "USD"
+	case out != nil:
+		row[7], row[8] = exportUSD(out.value, out.priced), "USD"
+	}
+	if out == nil && in == nil {
+		row[9] = "cost"
+	}
+	return row
+}
+
+func coinTrackerLedgerRow(tx models.Transaction, out, in *ledgerLeg, fee, feeCurrency string) []string {
+	row := []string{exportTime(ExportFormatCoinTracker, tx.Timestamp), "", "", "", "", fee, feeCurrency, ""}
+	if in != nil {
+		row[1], row[2] = in.amount, in.symbol
+	}
+	if out != nil {
+		row[3], row[4] = out.amount, out.symbol
+	}
+	return row
+}
+
+func genericLedgerRows(transactions []models.Transaction, priceAt func(symbol string, t time.Time) (float64, bool), nativeSymbol func(network string) string) [][]string {
+	rows := [][]string{{
+		"Date", "Network", "Tx Hash", "Log Index", "Direction", "Method", "Status", "From", "To",
+		"Asset", "Token Address", "Amount", "Price (USD)", "Value (USD)", "Fee", "Fee Currency", "Fee (USD)",
+	}}
+	for _, tx := range transactions {
+		amount, _ := strconv.ParseFloat(tx.Amount, 64)
+		price, priced := priceAt(tx.TokenSymbol, tx.Timestamp)
+		priceUSD := UnknownPrice
+		if priced {
+			priceUSD = strconv.FormatFloat(price, 'f', -1, 64)
+		}
+
+		fee, _ := strconv.ParseFloat(tx.Fee, 64)
+		feeUSD := ""
+		if fee > 0 {
+			feeUSD = UnknownPrice
+			if tx.FeeValue != nil {
+				feeUSD = *tx.FeeValue
+			}
+		}
+
+		rows = append(rows, []string{
+			exportTime(ExportFormatGeneric, tx.Timestamp), tx.Network, tx.TxHash, strconv.Itoa(tx.LogIndex),
+			tx.Direction, tx.Method, tx.Status, tx.FromAddress, tx.ToAddress,
+			tx.TokenSymbol, tx.TokenAddress, formatQuantity(amount), priceUSD, exportUSD(amount*price, priced),
+			formatQuantity(fee), nativeSymbol(tx.Network), feeUSD,
+		})
+	}
+	return rows
+}
+
+// transferLeg is the asset a transaction row moves, if it moved any
+func transferLeg(tx models.Transaction, priceAt func(symbol string, t time.Time) (float64, bool)) (ledgerLeg, bool) {
+	if tx.Status == TransactionStatusFailed || tx.TokenSymbol == "" {
+		return ledgerLeg{}, false
+	}
+	amount, err := strconv.ParseFloat(tx.Amount, 64)
+	if err != nil || amount <= 0 {
+		return ledgerLeg{}, false
+	}
+	price, ok := priceAt(tx.TokenSymbol, tx.Timestamp)
+	return ledgerLeg{amount: formatQuantity(amount), symbol: tx.TokenSymbol, value: amount * price, priced: ok}, true
+}
+
+func sameTransaction(a, b models.Transaction) bool {
+	return a.TxHash == b.TxHash && a.Network == b.Network
+}
+
+// exportTime formats a timestamp the way each tax tool imports it
+func exportTime(format string, t time.Time) string {
+	t = t.UTC()
+	switch format {
+	case ExportFormatKoinly:
+		return t.Format("2006-01-02 15:04 UTC")
+	case ExportFormatCoinTracker:
+		return t.Format("01/02/2006 15:04:05")
+	default:
+		return t.Format(time.RFC3339)
+	}
+}
+
+// exportUSD formats a fiat amount, or UNKNOWN when no price was recorded
+func exportUSD(value float64, known bool) string {
+	if !known {
+		return UnknownPrice
+	}
+	return formatUSD(value)
+}
+
+func formatQuantity(quantity float64) string {
+	return strconv.FormatFloat(quantity, 'f', -1, 64)
+}
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"web3-portfolio-dashboard/backend/internal/models"
+)
+
+func TestGainsRows(t *testing.T) {
+	// Buy 10 UNI at $1, a year and a half later sell 4 at $3 and 8 more than was indexed
+	priceAt := pnlPrices(map[string]map[int]float64{"UNI": {0: 1, 500: 3}})
+	ledger := newPnLLedger(CostBasisFIFO, pnlStart.AddDate(1, 0, 0), priceAt)
+	sell := pnlTransfer(500, DirectionOut, "14")
+	sell.TxHash = "0xsell"
+	for _, tx := range []models.Transaction{pnlTransfer(0, DirectionIn, "10"), sell} {
+		ledger.apply(tx, "ETH")
+	}
+
+	rows := gainsRows(ExportFormatGeneric, ledger.disposals)
+	require.Len(t, rows, 3)
+	require.Equal(t, []string{
+		"UNI", "ethereum", "0xToken", "10", "2024-01-01T00:00:00Z", "2025-05-15T00:00:00Z",
+		"30.00", "10.00", "20.00", "long", "0xsell", "",
+	}, rows[1])
+	require.Equal(t, []string{"UNKNOWN", "12.00", UnknownPrice, UnknownPrice, "unknown"},
+		[]string{rows[2][4], rows[2][6], rows[2][7], rows[2][8], rows[2][9]})
+
+	rows = gainsRows(ExportFormatKoinly, ledger.disposals)
+	require.Equal(t, []string{"2025-05-15 00:00 UTC", "2024-01-01 00:00 UTC", "UNI", "10", "10.00", "30.00", "20.00", ""}, rows[1])
+
+	rows = gainsRows(ExportFormatCoinTracker, ledger.disposals)
+	require.Equal(t, []string{"10", "UNI", "01/01/2024 00:00:00", "05/15/2025 00:00:00", "30.00", "10.00", "20.00", "Long Term"}, rows[1])
+}
+
+func TestLedgerRows(t *testing.T) {
+	priceAt := pnlPrices(map[string]map[int]float64{"UNI": {1: 5}, "ETH": {1: 2000}})
+	nativeSymbol := func(string) string { return "ETH" }
+
+	// A swap of 1 ETH for 400 UNI, then an approval that only paid gas
+	sent := pnlTransfer(1, DirectionOut, "1")
+	sent.TxHash, sent.LogIndex, sent.TokenAddress, sent.TokenSymbol = "0xswap", nativeLogIndex, "", "ETH"
+	sent.Method, sent.Fee = MethodSwap, "0.01"
+	received := pnlTransfer(1, DirectionIn, "400")
+	received.TxHash, received.LogIndex, received.Method = "0xswap", 3, MethodSwap
+	approve := pnlTransfer(2, DirectionOut, "0")
+	approve.TxHash, approve.LogIndex, approve.TokenAddress, approve.TokenSymbol = "0xapprove", nativeLogIndex, "", "ETH"
+	approve.Method, approve.Fee = MethodApprove, "0.002"
+	transactions := []models.Transaction{sent, received, approve}
+
+	rows := ledgerRows(ExportFormatKoinly, transactions, priceAt, nativeSymbol)
+	require.Len(t, rows, 3)
+	require.Equal(t, []string{
+		"2024-01-02 00:00 UTC", "1", "ETH", "400", "UNI", "0.01", "ETH", "2000.00", "USD", "", MethodSwap, "0xswap",
+	}, rows[1])
+	require.Equal(t, []string{
+		"2024-01-03 00:00 UTC", "", "", "", "", "0.002", "ETH", "", "", "cost", MethodApprove, "0xapprove",
+	}, rows[2])
+
+	rows = ledgerRows(ExportFormatCoinTracker, transactions, priceAt, nativeSymbol)
+	require.Equal(t, []string{"01/02/2024 00:00:00", "400", "UNI", "1", "ETH", "0.01", "ETH", ""}, rows[1])
+
+	rows = ledgerRows(ExportFormatGeneric, transactions, priceAt, nativeSymbol)
+	require.Len(t, rows, 4)
+	require.Equal(t, "2000.00", rows[2][13])
+	require.Equal(t, []string{"0", UnknownPrice, UnknownPrice, "0.002", "ETH", UnknownPrice}, rows[3][11:])
+}
+
+func TestParseExportOptions(t *testing.T) {
+	opts, err := ParseExportOptions("", "", "", "")
+	require.NoError(t, err)
+	require.Equal(t, ExportOptions{Report: ExportReportGains, Format: ExportFormatGeneric, Year: time.Now().UTC().Year(), Method: CostBasisFIFO}, opts)
+
+	opts, err = ParseExportOptions("Ledger", "Koinly", "2023", "lifo")
+	require.NoError(t, err)
+	require.Equal(t, "portfolio-abc-ledger-2023-koinly.csv", opts.Filename("abc"))
+
+	for _, bad := range [][4]string{{"trades", "", "", ""}, {"", "turbotax", "", ""}, {"", "", "23x", ""}, {"", "", "", "hifo"}} {
+		_, err := ParseExportOptions(bad[0], bad[1], bad[2], bad[3])
+		require.Error(t, err, bad)
+	}
+}
@@ -0,0 +1,225 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/rpc"
+
+	"web3-portfolio-dashboard/backend/internal/config"
+)
+
+// Gas fee tiers; a gas condition compares the base fee or a tier's expected price
+const (
+	GasFeeBase     = "base_fee"
+	GasFeeSlow     = "slow"
+	GasFeeStandard = "standard"
+	GasFeeFast     = "fast"
+)
+
+// gasPercentiles are the priority fee percentiles read from eth_feeHistory,
+// backing the slow, standard and fast tiers
+var gasPercentiles = []float64{10, 50, 90}
+
+// GasEstimate is what to offer for a transaction to be included at a tier
+type GasEstimate struct {
+	MaxPriorityFee *big.Int
+	MaxFee         *big.Int
+}
+
+// GasFees are a network's current fees, in wei
+type GasFees struct {
+	Network      string
+	EIP1559      bool
+	Block        uint64     // the next block, which BaseFee is for
+	BaseFee      *big.Int   // zero on networks without EIP-1559
+	PriorityFees []*big.Int // median of each of gasPercentiles over the recent blocks
+	Slow         GasEstimate
+	Standard     GasEstimate
+	Fast         GasEstimate
+	UpdatedAt    time.Time
+}
+
+// Price is what a transaction is expected to pay per gas at a tier: the base
+// fee plus the tier's priority fee. GasFeeBase is the base fee alone.
+func (f *GasFees) Price(fee string) (*big.Int, bool) {
+	var estimate GasEstimate
+	switch fee {
+	case GasFeeBase:
+		return f.BaseFee, true
+	case GasFeeSlow:
+		estimate = f.Slow
+	case GasFeeStandard:
+		estimate = f.Standard
+	case GasFeeFast:
+		estimate = f.Fast
+	default:
+		return nil, false
+	}
+	return new(big.Int).Add(f.BaseFee, estimate.MaxPriorityFee), true
+}
+
+// GasOracle estimates EIP-1559 fees from recent blocks, falling back to
+// eth_gasPrice on networks without fee history
+type GasOracle struct {
+	web3Service *Web3Service
+	ttl         time.Duration
+	blocks      uint64
+
+	cache   map[string]*GasFees
+	cacheMu sync.RWMutex
+}
+
+func NewGasOracle(cfg *config.Config, web3 *Web3Service) *GasOracle {
+	blocks := cfg.GasHistoryBlocks
+	if blocks <= 0 {
+		blocks = 20
+	}
+	return &GasOracle{
+		web3Service: web3,
+		ttl:         cfg.GasCacheTTL,
+		blocks:      uint64(blocks),
+		cache:       make(map[string]*GasFees),
+	}
+}
+
+// Fees returns a network's current fees, cached for the oracle's TTL
+func (o *GasOracle) Fees(ctx context.Context, network string) (*GasFees, error) {
+	o.cacheMu.RLock()
+	cached, ok := o.cache[network]
+	o.cacheMu.RUnlock()
+	if ok && time.Since(cached.UpdatedAt) < o.ttl {
+		return cached, nil
+	}
+
+	fees, err := o.estimate(ctx, network)
+	if err != nil {
+		return nil, err
+	}
+
+	o.cacheMu.Lock()
+	o.cache[network] = fees
+	o.cacheMu.Unlock()
+	return fees, nil
+}
+
+func (o *GasOracle) estimate(ctx context.Context, network string) (*GasFees, error) {
+	if _, ok := o.web3Service.Network(network); !ok {
+		return nil, fmt.Errorf("network %s not supported", network)
+	}
+
+	// Nodes without eth_feeHistory only have a single gas price to offer. Any
+	// other failure is returned: the fallback has no base fee to compare.
+	history, err := o.web3Service.FeeHistory(ctx, network, o.blocks, gasPercentiles)
+	if err != nil && !isMethodUnsupported(err) {
+		return nil, err
+	}
+	if err != nil || len(history.BaseFee) == 0 {
+		gasPrice, err := o.web3Service.GetGasPrice(network)
+		if err != nil {
+			return nil, err
+		}
+		return legacyGasFees(network, gasPrice), nil
+	}
+	return feesFromHistory(network, history.OldestBlock, history.BaseFee, history.Reward, history.GasUsedRatio), nil
+}
+
+// isMethodUnsupported reports whether a node rejected a call because it
+// doesn't implement the method
+func isMethodUnsupported(err error) bool {
+	var rpcErr rpc.Error
+	if !errors.As(err, &rpcErr) {
+		return false
+	}
+	if rpcErr.ErrorCode() == -32601 {
+		return true
+	}
+	message := strings.ToLower(rpcErr.Error())
+	return strings.Contains(message, "method not found") || strings.Contains(message, "not supported") ||
+		strings.Contains(message, "does not exist") || strings.Contains(message, "not available")
+}
+
+// feesFromHistory turns an eth_feeHistory result into fee estimates. baseFees
+// has one more entry than the blocks, for the block after them.
+func feesFromHistory(network string, oldest *big.Int, baseFees []*big.Int, rewards [][]*big.Int, gasUsed []float64) *GasFees {
+	fees := &GasFees{Network: network, BaseFee: new(big.Int), UpdatedAt: time.Now()}
+	if next := baseFees[len(baseFees)-1]; next != nil {
+		fees.BaseFee.Set(next)
+	}
+	fees.EIP1559 = fees.BaseFee.Sign() > 0
+	if oldest != nil {
+		fees.Block = oldest.Uint64() + uint64(len(baseFees)-1)
+	}
+
+	// Empty blocks report zero rewards that say nothing about the going rate
+	for i := range gasPercentiles {
+		var samples []*big.Int
+		for block, reward := range rewards {
+			if (block < len(gasUsed) && gasUsed[block] == 0) || i >= len(reward) || reward[i] == nil {
+				continue
+			}
+			samples = append(samples, reward[i])
+		}
+		fees.PriorityFees = append(fees.PriorityFees, medianInt(samples))
+	}
+
+	estimate := func(priorityFee *big.Int) GasEstimate {
+		// Twice the base fee stays valid through six full blocks of 12.5% increases
+		maxFee := new(big.Int).Mul(fees.BaseFee, big.NewInt(2))
+		return GasEstimate{MaxPriorityFee: priorityFee, MaxFee: maxFee.Add(maxFee, priorityFee)}
+	}
+	fees.Slow = estimate(fees.PriorityFees[0])
+	fees.Standard = estimate(fees.PriorityFees[1])
+	fees.Fast = estimate(fees.PriorityFees[2])
+	return fees
+}
+
+// legacyGasFees offers the node's gas price at every tier
+func legacyGasFees(network string, gasPrice *big.Int) *GasFees {
+	estimate := GasEstimate{MaxPriorityFee: gasPrice, MaxFee: gasPrice}
+	priorityFees := make([]*big.Int, len(gasPercentiles))
+	for i := range priorityFees {
+		priorityFees[i] = gasPrice
+	}
+	return &GasFees{
+		Network:      network,
+		BaseFee:      new(big.Int),
+		PriorityFees: priorityFees,
+		Slow:         estimate,
+		Standard:     estimate,
+		Fast:         estimate,
+		UpdatedAt:    time.Now(),
+	}
+}
+
+// medianInt returns the median of values, or zero when there are none
+func medianInt(values []*big.Int) *big.Int {
+	if len(values) == 0 {
+		return new(big.Int)
+	}
+	sorted := append([]*big.Int(nil), values...)
+	sort.Slice(sorted, func(a, b int) bool { return sorted[a].Cmp(sorted[b]) < 0 })
+
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 1 {
+		return new(big.Int).Set(sorted[middle])
+	}
+	sum := new(big.Int).Add(sorted[middle-1], sorted[middle])
+	return sum.Rsh(sum, 1)
+}
+
+// GasPercentiles are the priority fee percentiles GasFees.PriorityFees hold
+func GasPercentiles() []float64 {
+	return append([]float64(nil), gasPercentiles...)
+}
+
+// FormatGwei formats a wei amount in gwei without rounding
+func FormatGwei(wei *big.Int) string {
+	return formatUnits(wei, 9)
+}
@@ -0,0 +1,163 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/stretchr/testify/require"
+
+	"web3-portfolio-dashboard/backend/internal/config"
+	"web3-portfolio-dashboard/backend/internal/models"
+)
+
+func gwei(amount int64) *big.Int {
+	return new(big.Int).Mul(big.NewInt(amount), big.NewInt(1_000_000_000))
+}
+
+func newGasTestOracle(t *testing.T, feeHistory bool) (*GasOracle, *atomic.Int32) {
+	var calls atomic.Int32
+	server := newFakeRPC(t, func(method string, params []json.RawMessage) (interface{}, *rpcErrorBody) {
+		switch {
+		case method == "eth_feeHistory" && feeHistory:
+			calls.Add(1)
+			fees := func(amounts ...int64) []string {
+				encoded := make([]string, len(amounts))
+				for i, amount := range amounts {
+					encoded[i] = hexutil.EncodeBig(gwei(amount))
+				}
+				return encoded
+			}
+			return map[string]interface{}{
+				"oldestBlock":   "0x64",
+				"baseFeePerGas": fees(10, 11, 10, 11, 12),
+				"gasUsedRatio":  []float64{0.5, 0, 0.9, 0.4},
+				"reward":        [][]string{fees(10, 20, 30), fees(0, 0, 0), fees(1, 2, 3), fees(5, 6, 7)},
+			}, nil
+		case method == "eth_gasPrice":
+			calls.Add(1)
+			return hexutil.EncodeBig(gwei(3)), nil
+		}
+		return nil, &rpcErrorBody{Code: -32601, Message: "method not found"}
+	})
+
+	cfg := &config.Config{
+		GasCacheTTL: time.Minute,
+		Networks:    config.NewNetworkRegistry([]config.NetworkConfig{{Name: "testnet", NativeSymbol: "ETH", Decimals: 18, RPCURLs: []string{server.URL}}}),
+	}
+	web3 := NewWeb3Service(cfg)
+	t.Cleanup(web3.Close)
+	return NewGasOracle(cfg, web3), &calls
+}
+
+func TestGasOracleFeeHistory(t *testing.T) {
+	oracle, calls := newGasTestOracle(t, true)
+
+	fees, err := oracle.Fees(context.Background(), "testnet")
+	require.NoError(t, err)
+	require.True(t, fees.EIP1559)
+	require.Equal(t, uint64(104), fees.Block)
+	require.Equal(t, gwei(12), fees.BaseFee)
+
+	// The empty block's zero rewards are left out of the medians
+	require.Equal(t, []*big.Int{gwei(5), gwei(6), gwei(7)}, fees.PriorityFees)
+	require.Equal(t, gwei(6), fees.Standard.MaxPriorityFee)
+	require.Equal(t, gwei(30), fees.Standard.MaxFee)
+	require.Equal(t, gwei(31), fees.Fast.MaxFee)
+
+	price, ok := fees.Price(GasFeeSlow)
+	require.True(t, ok)
+	require.Equal(t, gwei(17), price)
+	price, _ = fees.Price(GasFeeBase)
+	require.Equal(t, gwei(12), price)
+
+	// Fees are cached
+	_, err = oracle.Fees(context.Background(), "testnet")
+	require.NoError(t, err)
+	require.Equal(t, int32(1), calls.Load())
+
+	_, err = oracle.Fees(context.Background(), "unknown")
+	require.Error(t, err)
+}
+
+func TestGasOracleLegacyFallback(t *testing.T) {
+	oracle, _ := newGasTestOracle(t, false)
+
+	fees, err := oracle.Fees(context.Background(), "testnet")
+	require.NoError(t, err)
+	require.False(t, fees.EIP1559)
+	require.Zero(t, fees.BaseFee.Sign())
+	price, _ := fees.Price(GasFeeFast)
+	require.Equal(t, gwei(3), price)
+	require.Equal(t, "3", FormatGwei(fees.Standard.MaxFee))
+}
+
+func TestGasOracleReturnsFeeHistoryFailures(t *testing.T) {
+	var gasPriceCalls atomic.Int32
+	server := newFakeRPC(t, func(method string, params []json.RawMessage) (interface{}, *rpcErrorBody) {
+		switch method {
+		case "eth_feeHistory":
+			return nil, &rpcErrorBody{Code: -32000, Message: "request timed out"}
+		case "eth_gasPrice":
+			gasPriceCalls.Add(1)
+			return hexutil.EncodeBig(gwei(3)), nil
+		}
+		return nil, &rpcErrorBody{Code: -32601, Message: "method not found"}
+	})
+	cfg := &config.Config{
+		GasCacheTTL: time.Minute,
+		Networks:    config.NewNetworkRegistry([]config.NetworkConfig{{Name: "testnet", NativeSymbol: "ETH", Decimals: 18, RPCURLs: []string{server.URL}}}),
+	}
+	web3 := NewWeb3Service(cfg)
+	t.Cleanup(web3.Close)
+
+	// Only a node without eth_feeHistory gets the legacy gas price
+	_, err := NewGasOracle(cfg, web3).Fees(context.Background(), "testnet")
+	require.ErrorContains(t, err, "request timed out")
+	require.Zero(t, gasPriceCalls.Load())
+}
+
+func TestCheckGasAlert(t *testing.T) {
+	oracle, _ := newGasTestOracle(t, true)
+	s := &AlertService{gasOracle: oracle}
+
+	evaluate := func(raw string) *alertEvaluation {
+		expr, err := parseConditions(mustDecodeConditions(t, raw))
+		require.NoError(t, err)
+		evaluation, err := s.evaluateCondition(context.Background(), &models.Alert{}, expr, time.Now())
+		require.NoError(t, err)
+		return evaluation
+	}
+
+	evaluation := evaluate(`{"type": "gas", "network": "testnet", "operator": "<", "value": 15}`)
+	require.True(t, evaluation.met)
+	require.Equal(t, GasFeeBase, evaluation.data["fee"])
+	require.Equal(t, "12", evaluation.data["current_gwei"])
+
+	require.False(t, evaluate(`{"type": "gas", "network": "testnet", "fee": "fast", "operator": "<", "value": "18.5"}`).met)
+	require.True(t, evaluate(`{"type": "gas", "network": "testnet", "fee": "slow", "operator": "<=", "value": "17"}`).met)
+
+	for raw, path := range map[string]string{
+		`{"type": "gas", "operator": "<", "value": 15}`:                                          "conditions.network: is required",
+		`{"type": "gas", "network": "testnet", "fee": "priority", "operator": "<", "value": 15}`: "conditions.fee: must be",
+		`{"type": "gas", "network": "testnet", "operator": "falls_pct", "value": 15}`:            "conditions.operator: falls_pct needs history",
+		`{"type": "price", "token": "ETH", "fee": "fast", "operator": "<", "value": 15}`:         "conditions.fee: does not apply to price conditions",
+		`{"type": "gas", "network": "testnet", "token": "ETH", "operator": "<", "value": 15}`:    "conditions.token: does not apply to gas conditions",
+	} {
+		_, err := parseConditions(mustDecodeConditions(t, raw))
+		require.ErrorContains(t, err, path, raw)
+	}
+
+	// A network without EIP-1559 has no base fee to compare, only tier prices
+	legacy, _ := newGasTestOracle(t, false)
+	s.gasOracle = legacy
+	expr, err := parseConditions(mustDecodeConditions(t, `{"type": "gas", "network": "testnet", "operator": "<", "value": 15}`))
+	require.NoError(t, err)
+	_, err = s.evaluateCondition(context.Background(), &models.Alert{}, expr, time.Now())
+	require.ErrorContains(t, err, "has no base fee")
+	require.True(t, evaluate(`{"type": "gas", "network": "testnet", "fee": "standard", "operator": "<", "value": 15}`).met)
+}
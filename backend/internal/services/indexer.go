@@ -0,0 +1,509 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"web3-portfolio-dashboard/backend/internal/config"
+	"web3-portfolio-dashboard/backend/internal/models"
+)
+
+// nativeLogIndex is the log index stored for a transaction's native value transfer
+const nativeLogIndex = -1
+
+// erc20TransferTopic is the topic of Transfer(address,address,uint256)
+var erc20TransferTopic = crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)"))
+
+// TransactionIndexer ingests the transfers of portfolio addresses from ERC-20
+// Transfer logs and, when an API key is set, native transfers from an explorer
+type TransactionIndexer struct {
+	db           *gorm.DB
+	web3Service  *Web3Service
+	explorer     *EtherscanClient
+	priceHistory *PriceHistoryService
+	lookback     uint64
+	blockRange   uint64
+}
+
+// Transaction directions, relative to the portfolio
+const (
+	DirectionIn   = "in"
+	DirectionOut  = "out"
+	DirectionSelf = "self" // between two addresses of the same portfolio
+)
+
+// Transaction statuses
+const (
+	TransactionStatusSuccess = "success"
+	TransactionStatusFailed  = "failed"
+)
+
+func NewTransactionIndexer(db *gorm.DB, cfg *config.Config, web3 *Web3Service, priceHistory *PriceHistoryService) *TransactionIndexer {
+	blockRange := cfg.IndexerBlockRange
+	if blockRange == 0 {
+		blockRange = 2000
+	}
+	return &TransactionIndexer{
+		db:           db,
+		web3Service:  web3,
+		explorer:     NewEtherscanClient(cfg.EtherscanAPIURL, cfg.EtherscanAPIKey),
+		priceHistory: priceHistory,
+		lookback:     cfg.IndexerLookbackBlocks,
+		blockRange:   blockRange,
+	}
+}
+
+// SyncAddresses indexes new activity of every address and returns the transactions stored.
+// Each network's recent blocks are first checked for reorgs, rolling back what was
+// orphaned. Addresses that fail are logged and skipped; their cursor stays put for the next run.
+func (i *TransactionIndexer) SyncAddresses(ctx context.Context, addresses []models.Address) ([]models.Transaction, error) {
+	heads := make(map[string]uint64)
+	for _, address := range addresses {
+		if _, followed := heads[address.Network]; followed {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return []models.Transaction{}, err
+		}
+		head, err := i.followChain(ctx, address.Network)
+		if err != nil {
+			log.Printf("❌ Failed to check %s for reorgs: %v", address.Network, err)
+		}
+		heads[address.Network] = head
+	}
+
+	stored := []models.Transaction{}
+	for _, address := range addresses {
+		if err := ctx.Err(); err != nil {
+			return stored, err
+		}
+		head := heads[address.Network]
+		if head == 0 {
+			continue
+		}
+
+		transactions, err := i.syncAddress(ctx, address, head)
+		stored = append(stored, transactions...)
+		if err != nil {
+			log.Printf("❌ Failed to index %s on %s: %v", address.Address, address.Network, err)
+		}
+	}
+	return stored, nil
+}
+
+// syncAddress indexes an address from its sync cursor up to head and returns
+// the transactions stored. A new address starts lookback blocks back.
+func (i *TransactionIndexer) syncAddress(ctx context.Context, address models.Address, head uint64) ([]models.Transaction, error) {
+	if !common.IsHexAddress(address.Address) {
+		return nil, fmt.Errorf("invalid address format: %s", address.Address)
+	}
+	network, ok := i.web3Service.Network(address.Network)
+	if !ok {
+		return nil, fmt.Errorf("network %s not supported", address.Network)
+	}
+	holder := common.HexToAddress(address.Address)
+
+	var cursor models.SyncCursor
+	err := i.db.Where("address_id = ?", address.ID).First(&cursor).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		cursor = models.SyncCursor{AddressID: address.ID}
+		if head > i.lookback {
+			cursor.LastBlock = head - i.lookback
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get sync cursor: %w", err)
+	}
+
+	if cursor.LastBlock >= head {
+		return []models.Transaction{}, nil
+	}
+
+	owned, err := i.ownedAddresses(address.PortfolioID, network.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	// Native transactions come from one explorer query over the whole range
+	explorerTxs, err := i.explorerTransactions(ctx, network, holder, cursor.LastBlock+1, head)
+	if err != nil {
+		return nil, err
+	}
+
+	stored := []models.Transaction{}
+	for from := cursor.LastBlock + 1; from <= head; from += i.blockRange {
+		to := from + i.blockRange - 1
+		if to > head {
+			to = head
+		}
+
+		transfers, err := i.tokenTransfers(ctx, network, holder, from, to)
+		if err != nil {
+			return stored, err
+		}
+		var infos []TransactionInfo
+		for _, info := range explorerTxs {
+			if info.BlockNumber >= from && info.BlockNumber <= to {
+				infos = append(infos, info)
+			}
+		}
+
+		transactions, err := i.buildTransactions(ctx, network, owned, transfers, infos)
+		if err != nil {
+			return stored, err
+		}
+		for j := range transactions {
+			transactions[j].PortfolioID = address.PortfolioID
+			transactions[j].AddressID = address.ID
+			transactions[j].Confirmations, transactions[j].Finalized = confirmationsAt(transactions[j].BlockNumber, head, network.Finality)
+		}
+
+		saved, err := i.store(address.ID, transactions, to)
+		stored = append(stored, saved...)
+		if err != nil {
+			return stored, err
+		}
+	}
+
+	return stored, nil
+}
+
+// buildTransactions merges token transfers with the native side of the transactions,
+// filling in sender, method, status, direction and the gas fee. Transactions the
+// explorer didn't return are read from the node.
+func (i *TransactionIndexer) buildTransactions(ctx context.Context, network *config.NetworkConfig, owned map[common.Address]bool, transfers []models.Transaction, infos []TransactionInfo) ([]models.Transaction, error) {
+	details := make(map[string]TransactionInfo, len(infos))
+	transactions := make([]models.Transaction, 0, len(transfers)+len(infos))
+	for _, info := range infos {
+		details[strings.ToLower(info.TxHash)] = info
+		transactions = append(transactions, i.nativeTransaction(network, info))
+	}
+
+	var missing []common.Hash
+	for _, transfer := range transfers {
+		if _, ok := details[transfer.TxHash]; !ok {
+			details[transfer.TxHash] = TransactionInfo{}
+			missing = append(missing, common.HexToHash(transfer.TxHash))
+		}
+	}
+	if len(missing) > 0 {
+		fetched, err := i.web3Service.GetTransactions(ctx, network.Name, missing)
+		if err != nil {
+			return nil, err
+		}
+		for hash, info := range fetched {
+			details[strings.ToLower(hash.Hex())] = info
+		}
+	}
+	transactions = append(transactions, transfers...)
+	sortTransactions(transactions)
+
+	// The fee goes on the first row of each transaction an address of the
+	// portfolio sent: the native row when there is one, otherwise the first
+	// transfer. Rows are stored once per portfolio, so a transfer between its
+	// addresses carries the fee whichever of them is synced first.
+	charged := make(map[string]bool)
+	for j := range transactions {
+		tx := &transactions[j]
+		info := details[tx.TxHash]
+
+		tx.MethodID = info.MethodID
+		if info.TxHash != "" {
+			tx.Method = decodeMethod(info.To, info.MethodID)
+			tx.GasUsed = info.GasUsed
+			if !info.Success {
+				tx.Status = TransactionStatusFailed
+			}
+		}
+		tx.Direction = direction(owned, tx.FromAddress, tx.ToAddress)
+
+		tx.Fee = "0"
+		if info.TxHash != "" && !charged[tx.TxHash] && owned[common.HexToAddress(info.From)] {
+			charged[tx.TxHash] = true
+			i.chargeFee(network, tx, info)
+		}
+	}
+
+	return transactions, nil
+}
+
+// chargeFee sets the gas fee of a transaction, in the native token and in USD at the time
+func (i *TransactionIndexer) chargeFee(network *config.NetworkConfig, tx *models.Transaction, info TransactionInfo) {
+	gasPrice, ok := new(big.Int).SetString(info.GasPrice, 10)
+	if !ok {
+		return
+	}
+	fee := new(big.Int).Mul(gasPrice, new(big.Int).SetUint64(info.GasUsed))
+	tx.Fee = formatUnits(fee, network.Decimals)
+
+	if i.priceHistory == nil {
+		return
+	}
+	if price, ok := i.priceHistory.PriceAt(network.NativeSymbol, tx.Timestamp); ok {
+		value := tokenValue(fee, network.Decimals, formatPrice(price))
+		tx.FeeValue = &value
+	}
+}
+
+// nativeTransaction is the row for the native value moved by a transaction
+func (i *TransactionIndexer) nativeTransaction(network *config.NetworkConfig, info TransactionInfo) models.Transaction {
+	// A reverted transaction moves no value
+	amount := "0"
+	if value, ok := new(big.Int).SetString(info.Value, 10); ok && info.Success {
+		amount = formatUnits(value, network.Decimals)
+	}
+
+	return models.Transaction{
+		TxHash:        strings.ToLower(info.TxHash),
+		Network:       network.Name,
+		LogIndex:      nativeLogIndex,
+		FromAddress:   info.From,
+		ToAddress:     info.To,
+		Status:        TransactionStatusSuccess,
+		TokenSymbol:   network.NativeSymbol,
+		TokenDecimals: network.Decimals,
+		Amount:        amount,
+		BlockNumber:   info.BlockNumber,
+		BlockHash:     info.BlockHash,
+		Timestamp:     info.Timestamp,
+	}
+}
+
+// ownedAddresses returns the addresses a portfolio has on a network
+func (i *TransactionIndexer) ownedAddresses(portfolioID uuid.UUID, network string) (map[common.Address]bool, error) {
+	var addresses []string
+	err := i.db.Model(&models.Address{}).Where("portfolio_id = ? AND network = ?", portfolioID, network).Pluck("address", &addresses).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get portfolio addresses: %w", err)
+	}
+
+	owned := make(map[common.Address]bool, len(addresses))
+	for _, address := range addresses {
+		owned[common.HexToAddress(address)] = true
+	}
+	return owned, nil
+}
+
+// direction tells whether a transfer enters, leaves or stays within the portfolio
+func direction(owned map[common.Address]bool, from, to string) string {
+	fromOwned := from != "" && owned[common.HexToAddress(from)]
+	toOwned := to != "" && owned[common.HexToAddress(to)]
+	switch {
+	case fromOwned && toOwned:
+		return DirectionSelf
+	case fromOwned:
+		return DirectionOut
+	default:
+		return DirectionIn
+	}
+}
+
+// tokenTransfers reads the ERC-20 Transfer logs sent from or to holder between two blocks.
+// Logs of tokens without decimals(), such as ERC-721 transfers, are skipped.
+func (i *TransactionIndexer) tokenTransfers(ctx context.Context, network *config.NetworkConfig, holder common.Address, from, to uint64) ([]models.Transaction, error) {
+	holderTopic := common.BytesToHash(holder.Bytes())
+	queries := []ethereum.FilterQuery{
+		{Topics: [][]common.Hash{{erc20TransferTopic}, {holderTopic}}},
+		{Topics: [][]common.Hash{{erc20TransferTopic}, nil, {holderTopic}}},
+	}
+
+	var logs []types.Log
+	seen := make(map[string]bool)
+	for _, query := range queries {
+		query.FromBlock = new(big.Int).SetUint64(from)
+		query.ToBlock = new(big.Int).SetUint64(to)
+
+		found, err := i.web3Service.FilterLogs(ctx, network.Name, query)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range found {
+			// ERC-721 Transfer has the same signature but an indexed token ID
+			if entry.Removed || len(entry.Topics) != 3 || len(entry.Data) != 32 {
+				continue
+			}
+			key := entry.TxHash.Hex() + ":" + strconv.FormatUint(uint64(entry.Index), 10)
+			if !seen[key] {
+				seen[key] = true
+				logs = append(logs, entry)
+			}
+		}
+	}
+	if len(logs) == 0 {
+		return nil, nil
+	}
+
+	var tokens []common.Address
+	var blocks []uint64
+	seenToken := make(map[common.Address]bool)
+	seenBlock := make(map[uint64]bool)
+	for _, entry := range logs {
+		if !seenToken[entry.Address] {
+			seenToken[entry.Address] = true
+			tokens = append(tokens, entry.Address)
+		}
+		if !seenBlock[entry.BlockNumber] {
+			seenBlock[entry.BlockNumber] = true
+			blocks = append(blocks, entry.BlockNumber)
+		}
+	}
+
+	metadata := i.web3Service.TokenMetadata(ctx, network.Name, tokens)
+	headers, err := i.web3Service.BlockHeaders(ctx, network.Name, blocks)
+	if err != nil {
+		return nil, err
+	}
+
+	transactions := make([]models.Transaction, 0, len(logs))
+	for _, entry := range logs {
+		meta, ok := metadata[entry.Address]
+		if !ok {
+			continue
+		}
+		transactions = append(transactions, models.Transaction{
+			TxHash:        entry.TxHash.Hex(),
+			Network:       network.Name,
+			LogIndex:      int(entry.Index),
+			FromAddress:   common.BytesToAddress(entry.Topics[1].Bytes()).Hex(),
+			ToAddress:     common.BytesToAddress(entry.Topics[2].Bytes()).Hex(),
+			Status:        TransactionStatusSuccess,
+			TokenAddress:  entry.Address.Hex(),
+			TokenSymbol:   meta.Symbol,
+			TokenDecimals: meta.Decimals,
+			Amount:        formatUnits(new(big.Int).SetBytes(entry.Data), meta.Decimals),
+			BlockNumber:   entry.BlockNumber,
+			BlockHash:     entry.BlockHash.Hex(),
+			Timestamp:     headers[entry.BlockNumber].Time,
+		})
+	}
+	return transactions, nil
+}
+
+// explorerTransactions lists the transactions sent from or to holder between two blocks.
+// Without an explorer API key there is no affordable way to find them, so none are returned.
+func (i *TransactionIndexer) explorerTransactions(ctx context.Context, network *config.NetworkConfig, holder common.Address, from, to uint64) ([]TransactionInfo, error) {
+	if !i.explorer.Enabled() {
+		return nil, nil
+	}
+
+	txs, err := i.explorer.NormalTransactions(ctx, network.ExplorerAPIURL, network.ChainID, holder.Hex(), from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list native transfers: %w", err)
+	}
+
+	infos := make([]TransactionInfo, 0, len(txs))
+	for _, tx := range txs {
+		block, err := strconv.ParseUint(tx.BlockNumber, 10, 64)
+		if err != nil {
+			continue
+		}
+		timestamp, err := strconv.ParseInt(tx.TimeStamp, 10, 64)
+		if err != nil {
+			continue
+		}
+		gasUsed, _ := strconv.ParseUint(tx.GasUsed, 10, 64)
+
+		info := TransactionInfo{
+			TxHash:      tx.Hash,
+			Network:     network.Name,
+			From:        checksumAddress(tx.From),
+			To:          checksumAddress(tx.To),
+			Value:       tx.Value,
+			BlockNumber: block,
+			BlockHash:   tx.BlockHash,
+			Timestamp:   time.Unix(timestamp, 0).UTC(),
+			GasUsed:     gasUsed,
+			GasPrice:    tx.GasPrice,
+			Success:     tx.IsError != "1",
+		}
+		if input, err := hexutil.Decode(tx.Input); err == nil && len(input) >= 4 {
+			info.MethodID = hexutil.Encode(input[:4])
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// checksumAddress returns an address in checksum form, or "" if it isn't one
+func checksumAddress(address string) string {
+	if !common.IsHexAddress(address) {
+		return ""
+	}
+	return common.HexToAddress(address).Hex()
+}
+
+// store inserts transactions that aren't stored yet and moves the address's cursor
+// to lastBlock, in one database transaction. It returns the rows inserted.
+func (i *TransactionIndexer) store(addressID uuid.UUID, transactions []models.Transaction, lastBlock uint64) ([]models.Transaction, error) {
+	var inserted []models.Transaction
+	err := i.db.Transaction(func(tx *gorm.DB) error {
+		for _, transaction := range transactions {
+			result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&transaction)
+			if result.Error != nil {
+				return fmt.Errorf("failed to save transaction %s: %w", transaction.TxHash, result.Error)
+			}
+			if result.RowsAffected == 1 {
+				inserted = append(inserted, transaction)
+			}
+		}
+
+		cursor := models.SyncCursor{AddressID: addressID, LastBlock: lastBlock}
+		err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "address_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"last_block", "updated_at"}),
+		}).Create(&cursor).Error
+		if err != nil {
+			return fmt.Errorf("failed to save sync cursor: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return inserted, nil
+}
+
+// sortTransactions orders transactions by block, then by log index
+func sortTransactions(transactions []models.Transaction) {
+	sort.SliceStable(transactions, func(a, b int) bool {
+		if transactions[a].BlockNumber != transactions[b].BlockNumber {
+			return transactions[a].BlockNumber < transactions[b].BlockNumber
+		}
+		return transactions[a].LogIndex < transactions[b].LogIndex
+	})
+}
+
+// formatUnits converts an amount in base units to a decimal string
+func formatUnits(amount *big.Int, decimals uint8) string {
+	if decimals == 0 {
+		return amount.String()
+	}
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)
+	whole, fraction := new(big.Int).QuoRem(new(big.Int).Abs(amount), scale, new(big.Int))
+
+	result := whole.String()
+	if fraction.Sign() != 0 {
+		digits := fraction.String()
+		digits = strings.Repeat("0", int(decimals)-len(digits)) + digits
+		result += "." + strings.TrimRight(digits, "0")
+	}
+	if amount.Sign() < 0 {
+		result = "-" + result
+	}
+	return result
+}
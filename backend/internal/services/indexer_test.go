@@ -0,0 +1,371 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/require"
+
+	"web3-portfolio-dashboard/backend/internal/config"
+	"web3-portfolio-dashboard/backend/internal/models"
+)
+
+func transferLog(token common.Address, from, to common.Address, amount int64, block uint64, index uint) types.Log {
+	return types.Log{
+		Address:     token,
+		Topics:      []common.Hash{erc20TransferTopic, common.BytesToHash(from.Bytes()), common.BytesToHash(to.Bytes())},
+		Data:        common.LeftPadBytes(big.NewInt(amount).Bytes(), 32),
+		BlockNumber: block,
+		BlockHash:   common.BigToHash(big.NewInt(int64(block) << 32)),
+		TxHash:      common.BigToHash(big.NewInt(int64(block))),
+		Index:       index,
+	}
+}
+
+func TestIndexerReadsTokenTransfers(t *testing.T) {
+	holder := common.HexToAddress("0x1000000000000000000000000000000000000001")
+	other := common.HexToAddress("0x2000000000000000000000000000000000000002")
+	usdc := common.HexToAddress("0x3000000000000000000000000000000000000003")
+	unknown := common.HexToAddress("0x4000000000000000000000000000000000000004")
+
+	sent := transferLog(usdc, holder, other, 1500000, 100, 3)
+	received := transferLog(usdc, other, holder, 250000, 101, 1)
+	notERC20 := transferLog(unknown, other, holder, 1, 101, 2)
+	nft := transferLog(usdc, other, holder, 0, 101, 4)
+	nft.Topics = append(nft.Topics, common.BigToHash(big.NewInt(7)))
+	nft.Data = nil
+
+	server := newFakeRPC(t, func(method string, params []json.RawMessage) (interface{}, *rpcErrorBody) {
+		switch method {
+		case "eth_getLogs":
+			var query struct {
+				FromBlock string          `json:"fromBlock"`
+				ToBlock   string          `json:"toBlock"`
+				Topics    [][]common.Hash `json:"topics"`
+			}
+			require.NoError(t, json.Unmarshal(params[0], &query))
+			require.Equal(t, "0x64", query.FromBlock)
+			require.Equal(t, "0xc8", query.ToBlock)
+			if len(query.Topics) == 2 {
+				return []types.Log{sent}, nil
+			}
+			// A self-transfer would match both queries; it must only be kept once
+			return []types.Log{sent, received, notERC20, nft}, nil
+		case "eth_getBlockByNumber":
+			var block hexutil.Uint64
+			require.NoError(t, json.Unmarshal(params[0], &block))
+			return map[string]string{"timestamp": hexutil.EncodeUint64(1700000000 + uint64(block))}, nil
+		case "eth_call":
+			return nil, &rpcErrorBody{Code: 3, Message: "execution reverted"}
+		}
+		return nil, &rpcErrorBody{Code: -32601, Message: "method not found"}
+	})
+
+	cfg := &config.Config{
+		Networks: config.NewNetworkRegistry([]config.NetworkConfig{{Name: "testnet", RPCURLs: []string{server.URL}}}),
+	}
+	web3 := NewWeb3Service(cfg)
+	defer web3.Close()
+	web3.cacheTokenMetadata("testnet", usdc, models.Token{Symbol: "USDC", Decimals: 6})
+
+	indexer := NewTransactionIndexer(nil, cfg, web3, nil)
+	network, _ := web3.Network("testnet")
+	transactions, err := indexer.tokenTransfers(context.Background(), network, holder, 100, 200)
+	require.NoError(t, err)
+	sortTransactions(transactions)
+
+	require.Equal(t, []models.Transaction{
+		{
+			TxHash:        sent.TxHash.Hex(),
+			Network:       "testnet",
+			LogIndex:      3,
+			FromAddress:   holder.Hex(),
+			ToAddress:     other.Hex(),
+			Status:        TransactionStatusSuccess,
+			TokenAddress:  usdc.Hex(),
+			TokenSymbol:   "USDC",
+			TokenDecimals: 6,
+			Amount:        "1.5",
+			BlockNumber:   100,
+			BlockHash:     sent.BlockHash.Hex(),
+			Timestamp:     time.Unix(1700000100, 0).UTC(),
+		},
+		{
+			TxHash:        received.TxHash.Hex(),
+			Network:       "testnet",
+			LogIndex:      1,
+			FromAddress:   other.Hex(),
+			ToAddress:     holder.Hex(),
+			Status:        TransactionStatusSuccess,
+			TokenAddress:  usdc.Hex(),
+			TokenSymbol:   "USDC",
+			TokenDecimals: 6,
+			Amount:        "0.25",
+			BlockNumber:   101,
+			BlockHash:     received.BlockHash.Hex(),
+			Timestamp:     time.Unix(1700000101, 0).UTC(),
+		},
+	}, transactions)
+}
+
+func TestIndexerReadsNativeTransfersFromExplorer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		require.Equal(t, "1", query.Get("chainid"))
+		require.Equal(t, "txlist", query.Get("action"))
+		require.Equal(t, "100", query.Get("startblock"))
+		require.Equal(t, "200", query.Get("endblock"))
+		require.Equal(t, "test-key", query.Get("apikey"))
+
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  "1",
+			"message": "OK",
+			"result": []explorerTx{
+				{
+					BlockNumber: "120", TimeStamp: "1700000000", Hash: "0xabc",
+					From: "0x1000000000000000000000000000000000000001", To: "0x2000000000000000000000000000000000000002",
+					Value: "1230000000000000000", GasUsed: "21000", GasPrice: "30000000000", IsError: "0", Input: "0x",
+				},
+				{
+					BlockNumber: "150", TimeStamp: "1700000600", Hash: "0xdef",
+					From: "0x1000000000000000000000000000000000000001", To: "0x3000000000000000000000000000000000000003",
+					Value: "0", GasUsed: "50000", GasPrice: "30000000000", IsError: "1", Input: "0x095ea7b3000000",
+				},
+			},
+		})
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		EtherscanAPIURL: server.URL,
+		EtherscanAPIKey: "test-key",
+		Networks:        config.NewNetworkRegistry([]config.NetworkConfig{{Name: "ethereum", ChainID: 1}}),
+	}
+	web3 := NewWeb3Service(cfg)
+	defer web3.Close()
+
+	indexer := NewTransactionIndexer(nil, cfg, web3, nil)
+	network, _ := web3.Network("ethereum")
+	infos, err := indexer.explorerTransactions(context.Background(), network, common.HexToAddress("0x1"), 100, 200)
+	require.NoError(t, err)
+
+	require.Equal(t, []TransactionInfo{
+		{
+			TxHash:      "0xabc",
+			Network:     "ethereum",
+			From:        "0x1000000000000000000000000000000000000001",
+			To:          "0x2000000000000000000000000000000000000002",
+			Value:       "1230000000000000000",
+			BlockNumber: 120,
+			Timestamp:   time.Unix(1700000000, 0).UTC(),
+			GasUsed:     21000,
+			GasPrice:    "30000000000",
+			Success:     true,
+		},
+		{
+			TxHash:      "0xdef",
+			Network:     "ethereum",
+			From:        "0x1000000000000000000000000000000000000001",
+			To:          "0x3000000000000000000000000000000000000003",
+			Value:       "0",
+			BlockNumber: 150,
+			Timestamp:   time.Unix(1700000600, 0).UTC(),
+			MethodID:    "0x095ea7b3",
+			GasUsed:     50000,
+			GasPrice:    "30000000000",
+		},
+	}, infos)
+}
+
+func TestBuildTransactions(t *testing.T) {
+	holder := common.HexToAddress("0x1000000000000000000000000000000000000001")
+	savings := common.HexToAddress("0x5000000000000000000000000000000000000005")
+	other := common.HexToAddress("0x2000000000000000000000000000000000000002")
+	router := common.HexToAddress("0x6000000000000000000000000000000000000006")
+	usdc := common.HexToAddress("0x3000000000000000000000000000000000000003")
+	dai := common.HexToAddress("0x4000000000000000000000000000000000000004")
+
+	swapHash := common.HexToHash("0x01")
+	airdropHash := common.HexToHash("0x02")
+	sweepHash := common.HexToHash("0x04")
+
+	server := newFakeRPC(t, func(method string, params []json.RawMessage) (interface{}, *rpcErrorBody) {
+		var hash common.Hash
+		require.NoError(t, json.Unmarshal(params[0], &hash))
+		sender, input := holder, "0x38ed1739"
+		switch hash {
+		case airdropHash:
+			sender, input = other, "0xdeadbeef"
+		case sweepHash:
+			sender, input = savings, "0xa9059cbb"
+		}
+
+		switch method {
+		case "eth_getTransactionByHash":
+			return map[string]interface{}{"from": sender, "to": router, "value": "0x0", "input": input, "gasPrice": "0x1"}, nil
+		case "eth_getTransactionReceipt":
+			return map[string]interface{}{"blockNumber": "0x64", "gasUsed": "0x186a0", "effectiveGasPrice": "0x3b9aca00", "status": "0x1"}, nil
+		}
+		return nil, &rpcErrorBody{Code: -32601, Message: "method not found"}
+	})
+
+	cfg := &config.Config{
+		Networks: config.NewNetworkRegistry([]config.NetworkConfig{{Name: "testnet", NativeSymbol: "ETH", RPCURLs: []string{server.URL}}}),
+	}
+	web3 := NewWeb3Service(cfg)
+	defer web3.Close()
+	network, _ := web3.Network("testnet")
+
+	transfer := func(hash common.Hash, index int, token, from, to common.Address) models.Transaction {
+		return models.Transaction{
+			TxHash: hash.Hex(), LogIndex: index, BlockNumber: 100, Status: TransactionStatusSuccess,
+			TokenAddress: token.Hex(), FromAddress: from.Hex(), ToAddress: to.Hex(), Amount: "1",
+		}
+	}
+	transfers := []models.Transaction{
+		transfer(swapHash, 5, dai, router, holder),
+		transfer(swapHash, 2, usdc, holder, router),
+		transfer(airdropHash, 9, usdc, other, holder),
+		transfer(sweepHash, 12, usdc, savings, holder),
+	}
+	// A plain send to another address of the portfolio, as reported by the explorer
+	infos := []TransactionInfo{{
+		TxHash: "0x03", From: holder.Hex(), To: savings.Hex(), Value: "2000000000000000000",
+		BlockNumber: 100, GasUsed: 21000, GasPrice: "1000000000", Success: true,
+	}}
+
+	indexer := NewTransactionIndexer(nil, cfg, web3, nil)
+	owned := map[common.Address]bool{holder: true, savings: true}
+	transactions, err := indexer.buildTransactions(context.Background(), network, owned, transfers, infos)
+	require.NoError(t, err)
+	require.Len(t, transactions, 5)
+
+	send := transactions[0]
+	require.Equal(t, "0x03", send.TxHash)
+	require.Equal(t, nativeLogIndex, send.LogIndex)
+	require.Equal(t, "2", send.Amount)
+	require.Equal(t, "ETH", send.TokenSymbol)
+	require.Equal(t, DirectionSelf, send.Direction)
+	require.Equal(t, MethodTransfer, send.Method)
+	require.Equal(t, "0.000021", send.Fee)
+
+	// The swap's fee is charged once, on its first transfer
+	require.Equal(t, swapHash.Hex(), transactions[1].TxHash)
+	require.Equal(t, 2, transactions[1].LogIndex)
+	require.Equal(t, DirectionOut, transactions[1].Direction)
+	require.Equal(t, MethodSwap, transactions[1].Method)
+	require.Equal(t, "0x38ed1739", transactions[1].MethodID)
+	require.Equal(t, uint64(100000), transactions[1].GasUsed)
+	require.Equal(t, "0.0001", transactions[1].Fee)
+
+	require.Equal(t, DirectionIn, transactions[2].Direction)
+	require.Equal(t, "0", transactions[2].Fee)
+
+	// Someone else paid for the airdrop
+	airdrop := transactions[3]
+	require.Equal(t, airdropHash.Hex(), airdrop.TxHash)
+	require.Equal(t, DirectionIn, airdrop.Direction)
+	require.Equal(t, MethodCall, airdrop.Method)
+	require.Equal(t, "0", airdrop.Fee)
+	require.Nil(t, airdrop.FeeValue)
+
+	// Savings paid to sweep into the synced address; the portfolio's one row carries the fee
+	sweep := transactions[4]
+	require.Equal(t, sweepHash.Hex(), sweep.TxHash)
+	require.Equal(t, DirectionSelf, sweep.Direction)
+	require.Equal(t, "0.0001", sweep.Fee)
+}
+
+func TestDecodeMethod(t *testing.T) {
+	require.Equal(t, MethodTransfer, decodeMethod("0x1", "0xa9059cbb"))
+	require.Equal(t, MethodApprove, decodeMethod("0x1", "0x095ea7b3"))
+	require.Equal(t, MethodSwap, decodeMethod("0x1", "0x7ff36ab5")) // swapExactETHForTokens
+	require.Equal(t, MethodSwap, decodeMethod("0x1", "0x414bf389")) // exactInputSingle
+	require.Equal(t, MethodSwap, decodeMethod("0x1", "0x3593564c")) // Universal Router execute
+	require.Equal(t, MethodWrap, decodeMethod("0x1", "0xd0e30db0")) // WETH deposit
+	require.Equal(t, MethodTransfer, decodeMethod("0x1", ""))
+	require.Equal(t, MethodDeploy, decodeMethod("", "0x60806040"))
+	require.Equal(t, MethodCall, decodeMethod("0x1", "0xdeadbeef"))
+}
+
+func TestEtherscanClientErrors(t *testing.T) {
+	result := `[]`
+	message := "No transactions found"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"status":"0","message":"` + message + `","result":` + result + `}`))
+	}))
+	defer server.Close()
+
+	client := NewEtherscanClient(server.URL, "key")
+	txs, err := client.NormalTransactions(context.Background(), "", 1, "0x1", 0, 10)
+	require.NoError(t, err)
+	require.Empty(t, txs)
+
+	result = `"Invalid API Key"`
+	message = "NOTOK"
+	_, err = client.NormalTransactions(context.Background(), "", 1, "0x1", 0, 10)
+	require.ErrorContains(t, err, "Invalid API Key")
+
+	require.False(t, NewEtherscanClient(server.URL, "").Enabled())
+}
+
+func TestEtherscanClientPagesThroughFullBlock(t *testing.T) {
+	// 2,500 transactions in block 100, then 3 in block 101
+	var txs []explorerTx
+	for i := 0; i < 2503; i++ {
+		block := "100"
+		if i >= 2500 {
+			block = "101"
+		}
+		txs = append(txs, explorerTx{BlockNumber: block, Hash: fmt.Sprintf("0x%x", i)})
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		start, _ := strconv.ParseUint(query.Get("startblock"), 10, 64)
+		end, _ := strconv.ParseUint(query.Get("endblock"), 10, 64)
+		page, _ := strconv.Atoi(query.Get("page"))
+		offset, _ := strconv.Atoi(query.Get("offset"))
+
+		var matching []explorerTx
+		for _, tx := range txs {
+			if block, _ := strconv.ParseUint(tx.BlockNumber, 10, 64); block >= start && block <= end {
+				matching = append(matching, tx)
+			}
+		}
+		from, to := min((page-1)*offset, len(matching)), min(page*offset, len(matching))
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"status": "1", "message": "OK", "result": matching[from:to]})
+	}))
+	defer server.Close()
+
+	client := NewEtherscanClient(server.URL, "key")
+	got, err := client.NormalTransactions(context.Background(), "", 1, "0x1", 100, 101)
+	require.NoError(t, err)
+	require.Len(t, got, len(txs))
+	require.Equal(t, txs[2499].Hash, got[2499].Hash)
+	require.Equal(t, "101", got[2502].BlockNumber)
+
+	// A block with more transactions than the explorer can list is an error, not a gap
+	for i := 0; i <= etherscanMaxResults; i++ {
+		txs = append(txs, explorerTx{BlockNumber: "102", Hash: fmt.Sprintf("0x102%x", i)})
+	}
+	_, err = client.NormalTransactions(context.Background(), "", 1, "0x1", 102, 102)
+	require.ErrorContains(t, err, "block 102 has more than 10000 transactions")
+}
+
+func TestFormatUnits(t *testing.T) {
+	require.Equal(t, "1.5", formatUnits(big.NewInt(1500000), 6))
+	require.Equal(t, "0.000001", formatUnits(big.NewInt(1), 6))
+	require.Equal(t, "42", formatUnits(big.NewInt(42000000), 6))
+	require.Equal(t, "7", formatUnits(big.NewInt(7), 0))
+	require.Equal(t, "-0.5", formatUnits(big.NewInt(-5), 1))
+}
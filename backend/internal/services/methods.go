@@ -0,0 +1,129 @@
+package services
+
+import (
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Transaction methods shown on the transactions endpoint
+const (
+	MethodTransfer        = "transfer"
+	MethodApprove         = "approve"
+	MethodSwap            = "swap"
+	MethodWrap            = "wrap"
+	MethodUnwrap          = "unwrap"
+	MethodAddLiquidity    = "add_liquidity"
+	MethodRemoveLiquidity = "remove_liquidity"
+	MethodDeposit         = "deposit"
+	MethodWithdraw        = "withdraw"
+	MethodBorrow          = "borrow"
+	MethodRepay           = "repay"
+	MethodStake           = "stake"
+	MethodClaim           = "claim"
+	MethodMint            = "mint"
+	MethodMulticall       = "multicall"
+	MethodDeploy          = "deploy"
+	MethodCall            = "call" // a contract call not in the signature table
+)
+
+// methodSignatures maps well-known function signatures to the method they perform
+var methodSignatures = map[string]string{
+	// ERC-20 / ERC-721
+	"transfer(address,uint256)":                                     MethodTransfer,
+	"transferFrom(address,address,uint256)":                         MethodTransfer,
+	"safeTransferFrom(address,address,uint256)":                     MethodTransfer,
+	"safeTransferFrom(address,address,uint256,bytes)":               MethodTransfer,
+	"approve(address,uint256)":                                      MethodApprove,
+	"increaseAllowance(address,uint256)":                            MethodApprove,
+	"setApprovalForAll(address,bool)":                               MethodApprove,
+	"permit(address,address,uint256,uint256,uint8,bytes32,bytes32)": MethodApprove,
+
+	// WETH
+	"deposit()":         MethodWrap,
+	"withdraw(uint256)": MethodUnwrap,
+
+	// Uniswap V2 router and forks
+	"swapExactTokensForTokens(uint256,uint256,address[],address,uint256)":                              MethodSwap,
+	"swapTokensForExactTokens(uint256,uint256,address[],address,uint256)":                              MethodSwap,
+	"swapExactETHForTokens(uint256,address[],address,uint256)":                                         MethodSwap,
+	"swapTokensForExactETH(uint256,uint256,address[],address,uint256)":                                 MethodSwap,
+	"swapExactTokensForETH(uint256,uint256,address[],address,uint256)":                                 MethodSwap,
+	"swapETHForExactTokens(uint256,address[],address,uint256)":                                         MethodSwap,
+	"swapExactTokensForTokensSupportingFeeOnTransferTokens(uint256,uint256,address[],address,uint256)": MethodSwap,
+	"swapExactETHForTokensSupportingFeeOnTransferTokens(uint256,address[],address,uint256)":            MethodSwap,
+	"swapExactTokensForETHSupportingFeeOnTransferTokens(uint256,uint256,address[],address,uint256)":    MethodSwap,
+	"addLiquidity(address,address,uint256,uint256,uint256,uint256,address,uint256)":                    MethodAddLiquidity,
+	"addLiquidityETH(address,uint256,uint256,uint256,address,uint256)":                                 MethodAddLiquidity,
+	"removeLiquidity(address,address,uint256,uint256,uint256,address,uint256)":                         MethodRemoveLiquidity,
+	"removeLiquidityETH(address,uint256,uint256,uint256,address,uint256)":                              MethodRemoveLiquidity,
+
+	// Uniswap V3 routers and Universal Router
+	"exactInputSingle((address,address,uint24,address,uint256,uint256,uint256,uint160))":         MethodSwap,
+	"exactInput((bytes,address,uint256,uint256,uint256))":                                        MethodSwap,
+	"exactOutputSingle((address,address,uint24,address,uint256,uint256,uint256,uint160))":        MethodSwap,
+	"exactOutput((bytes,address,uint256,uint256,uint256))":                                       MethodSwap,
+	"exactInputSingle((address,address,uint24,address,uint256,uint256,uint160))":                 MethodSwap,
+	"exactInput((bytes,address,uint256,uint256))":                                                MethodSwap,
+	"execute(bytes,bytes[],uint256)":                                                             MethodSwap,
+	"execute(bytes,bytes[])":                                                                     MethodSwap,
+	"multicall(bytes[])":                                                                         MethodMulticall,
+	"multicall(uint256,bytes[])":                                                                 MethodMulticall,
+	"multicall(bytes32,bytes[])":                                                                 MethodMulticall,
+	"mint((address,address,uint24,int24,int24,uint256,uint256,uint256,uint256,address,uint256))": MethodAddLiquidity,
+	"increaseLiquidity((uint256,uint256,uint256,uint256,uint256,uint256))":                       MethodAddLiquidity,
+	"decreaseLiquidity((uint256,uint128,uint256,uint256,uint256))":                               MethodRemoveLiquidity,
+
+	// Aggregators
+	"swap(address,(address,address,address,address,uint256,uint256,uint256),bytes,bytes)": MethodSwap,
+	"unoswap(address,uint256,uint256,uint256[])":                                          MethodSwap,
+	"uniswapV3Swap(uint256,uint256,uint256[])":                                            MethodSwap,
+	"transformERC20(address,address,uint256,uint256,(uint32,bytes)[])":                    MethodSwap,
+
+	// Lending (Aave V2/V3, Compound)
+	"supply(address,uint256,address,uint16)":         MethodDeposit,
+	"deposit(address,uint256,address,uint16)":        MethodDeposit,
+	"withdraw(address,uint256,address)":              MethodWithdraw,
+	"borrow(address,uint256,uint256,uint16,address)": MethodBorrow,
+	"repay(address,uint256,uint256,address)":         MethodRepay,
+	"mint(uint256)":                                  MethodMint,
+	"redeem(uint256)":                                MethodWithdraw,
+	"redeemUnderlying(uint256)":                      MethodWithdraw,
+	"borrow(uint256)":                                MethodBorrow,
+	"repayBorrow(uint256)":                           MethodRepay,
+
+	// Vaults, staking and rewards
+	"deposit(uint256)":                  MethodDeposit,
+	"deposit(uint256,address)":          MethodDeposit,
+	"withdraw()":                        MethodWithdraw,
+	"withdraw(uint256,address,address)": MethodWithdraw,
+	"redeem(uint256,address,address)":   MethodWithdraw,
+	"stake(uint256)":                    MethodStake,
+	"submit(address)":                   MethodStake,
+	"claim()":                           MethodClaim,
+	"getReward()":                       MethodClaim,
+	"claimRewards(address[],uint256,address,address)": MethodClaim,
+	"mint()": MethodMint,
+}
+
+// methodSelectors maps 4-byte selectors (0x-prefixed hex) to methods
+var methodSelectors = func() map[string]string {
+	selectors := make(map[string]string, len(methodSignatures))
+	for signature, method := range methodSignatures {
+		selectors[hexutil.Encode(crypto.Keccak256([]byte(signature))[:4])] = method
+	}
+	return selectors
+}()
+
+// decodeMethod names what a transaction does from its recipient and 4-byte selector
+func decodeMethod(to, methodID string) string {
+	switch {
+	case to == "":
+		return MethodDeploy
+	case methodID == "":
+		return MethodTransfer
+	}
+	if method, ok := methodSelectors[methodID]; ok {
+		return method
+	}
+	return MethodCall
+}
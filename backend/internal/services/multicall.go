@@ -0,0 +1,269 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+const (
+	// Calls per aggregate3 eth_call; keeps each call well under node gas caps
+	multicallChunkSize = 300
+	// eth_call requests per JSON-RPC batch; most hosted providers cap batches at 100
+	rpcBatchChunkSize = 100
+)
+
+const erc20ABIJSON = `[
+	{"constant":true,"inputs":[{"name":"_owner","type":"address"}],"name":"balanceOf","outputs":[{"name":"balance","type":"uint256"}],"type":"function"},
+	{"constant":true,"inputs":[],"name":"decimals","outputs":[{"name":"","type":"uint8"}],"type":"function"},
+	{"constant":true,"inputs":[],"name":"symbol","outputs":[{"name":"","type":"string"}],"type":"function"},
+	{"constant":true,"inputs":[],"name":"name","outputs":[{"name":"","type":"string"}],"type":"function"}
+]`
+
+const multicall3ABIJSON = `[
+	{"inputs":[{"components":[{"name":"target","type":"address"},{"name":"allowFailure","type":"bool"},{"name":"callData","type":"bytes"}],"name":"calls","type":"tuple[]"}],
+	 "name":"aggregate3","outputs":[{"components":[{"name":"success","type":"bool"},{"name":"returnData","type":"bytes"}],"name":"returnData","type":"tuple[]"}],
+	 "stateMutability":"payable","type":"function"}
+]`
+
+// ABIs are parsed once instead of on every call
+var (
+	erc20ABI      = mustParseABI(erc20ABIJSON)
+	multicall3ABI = mustParseABI(multicall3ABIJSON)
+)
+
+func mustParseABI(definition string) abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(definition))
+	if err != nil {
+		panic(fmt.Sprintf("invalid ABI: %v", err))
+	}
+	return parsed
+}
+
+// contractCall is a single read-only call to batch
+type contractCall struct {
+	Target common.Address
+	Data   []byte
+}
+
+// callResult is the outcome of a batched call; a failed call doesn't fail the batch
+type callResult struct {
+	Success bool
+	Data    []byte
+}
+
+// batchCaller executes a list of calls, returning one result per call in order
+type batchCaller func(ctx context.Context, calls []contractCall) ([]callResult, error)
+
+// multicall3Call and multicall3Result mirror the Multicall3 Call3 and Result structs
+type multicall3Call struct {
+	Target       common.Address
+	AllowFailure bool
+	CallData     []byte
+}
+
+type multicall3Result struct {
+	Success    bool
+	ReturnData []byte
+}
+
+// aggregate3 runs calls through a Multicall3 contract, one eth_call per chunk
+func aggregate3(ctx context.Context, caller ethereum.ContractCaller, multicall common.Address, calls []contractCall) ([]callResult, error) {
+	results := make([]callResult, 0, len(calls))
+
+	for start := 0; start < len(calls); start += multicallChunkSize {
+		end := start + multicallChunkSize
+		if end > len(calls) {
+			end = len(calls)
+		}
+
+		batch := make([]multicall3Call, 0, end-start)
+		for _, call := range calls[start:end] {
+			batch = append(batch, multicall3Call{Target: call.Target, AllowFailure: true, CallData: call.Data})
+		}
+
+		data, err := multicall3ABI.Pack("aggregate3", batch)
+		if err != nil {
+			return nil, fmt.Errorf("failed to pack aggregate3: %w", err)
+		}
+
+		output, err := caller.CallContract(ctx, ethereum.CallMsg{To: &multicall, Data: data}, nil)
+		if err != nil {
+			return nil, fmt.Errorf("aggregate3 call failed: %w", err)
+		}
+
+		unpacked, err := multicall3ABI.Unpack("aggregate3", output)
+		if err != nil || len(unpacked) != 1 {
+			return nil, fmt.Errorf("failed to unpack aggregate3 result (is Multicall3 deployed at %s?): %v", multicall.Hex(), err)
+		}
+
+		decoded := *abi.ConvertType(unpacked[0], new([]multicall3Result)).(*[]multicall3Result)
+		if len(decoded) != len(batch) {
+			return nil, fmt.Errorf("unexpected aggregate3 result for %d calls", len(batch))
+		}
+
+		for _, r := range decoded {
+			results = append(results, callResult{Success: r.Success, Data: r.ReturnData})
+		}
+	}
+
+	return results, nil
+}
+
+// batchEthCall sends calls as JSON-RPC batches of eth_call, for chains without Multicall3
+func batchEthCall(ctx context.Context, client *rpc.Client, calls []contractCall) ([]callResult, error) {
+	results := make([]callResult, 0, len(calls))
+
+	for start := 0; start < len(calls); start += rpcBatchChunkSize {
+		end := start + rpcBatchChunkSize
+		if end > len(calls) {
+			end = len(calls)
+		}
+
+		outputs := make([]hexutil.Bytes, end-start)
+		elems := make([]rpc.BatchElem, 0, end-start)
+		for i, call := range calls[start:end] {
+			elems = append(elems, rpc.BatchElem{
+				Method: "eth_call",
+				Args: []interface{}{
+					map[string]interface{}{"to": call.Target, "data": hexutil.Bytes(call.Data)},
+					"latest",
+				},
+				Result: &outputs[i],
+			})
+		}
+
+		if err := client.BatchCallContext(ctx, elems); err != nil {
+			return nil, fmt.Errorf("eth_call batch failed: %w", err)
+		}
+
+		for i, elem := range elems {
+			results = append(results, callResult{Success: elem.Error == nil, Data: outputs[i]})
+		}
+	}
+
+	return results, nil
+}
+
+// erc20Metadata is what a token reports about itself on-chain
+type erc20Metadata struct {
+	Symbol      string
+	Name        string
+	Decimals    uint8
+	HasDecimals bool
+}
+
+// erc20BatchResult holds balances keyed by holder then token, and metadata keyed by token
+type erc20BatchResult struct {
+	Balances map[common.Address]map[common.Address]*big.Int
+	Metadata map[common.Address]erc20Metadata
+}
+
+// readERC20Batch reads balanceOf for every holder/token pair plus decimals,
+// symbol and name for the tokens in metadataFor, in as few round trips as the
+// caller allows. Tokens whose balanceOf call fails are left out.
+func readERC20Batch(ctx context.Context, call batchCaller, holders, tokens, metadataFor []common.Address) (*erc20BatchResult, error) {
+	type slot struct {
+		kind   string
+		holder common.Address
+		token  common.Address
+	}
+
+	var calls []contractCall
+	var slots []slot
+
+	for _, holder := range holders {
+		data, err := erc20ABI.Pack("balanceOf", holder)
+		if err != nil {
+			return nil, fmt.Errorf("failed to pack balanceOf: %w", err)
+		}
+		for _, token := range tokens {
+			calls = append(calls, contractCall{Target: token, Data: data})
+			slots = append(slots, slot{kind: "balanceOf", holder: holder, token: token})
+		}
+	}
+
+	for _, token := range metadataFor {
+		for _, method := range []string{"decimals", "symbol", "name"} {
+			data, err := erc20ABI.Pack(method)
+			if err != nil {
+				return nil, fmt.Errorf("failed to pack %s: %w", method, err)
+			}
+			calls = append(calls, contractCall{Target: token, Data: data})
+			slots = append(slots, slot{kind: method, token: token})
+		}
+	}
+
+	result := &erc20BatchResult{
+		Balances: make(map[common.Address]map[common.Address]*big.Int),
+		Metadata: make(map[common.Address]erc20Metadata),
+	}
+	if len(calls) == 0 {
+		return result, nil
+	}
+
+	outputs, err := call(ctx, calls)
+	if err != nil {
+		return nil, err
+	}
+	if len(outputs) != len(calls) {
+		return nil, fmt.Errorf("expected %d call results, got %d", len(calls), len(outputs))
+	}
+
+	for i, out := range outputs {
+		s := slots[i]
+		if !out.Success || len(out.Data) == 0 {
+			continue
+		}
+
+		switch s.kind {
+		case "balanceOf":
+			if len(out.Data) < 32 {
+				continue
+			}
+			if result.Balances[s.holder] == nil {
+				result.Balances[s.holder] = make(map[common.Address]*big.Int)
+			}
+			result.Balances[s.holder][s.token] = new(big.Int).SetBytes(out.Data[:32])
+		case "decimals":
+			meta := result.Metadata[s.token]
+			if values, err := erc20ABI.Unpack("decimals", out.Data); err == nil && len(values) == 1 {
+				meta.Decimals = values[0].(uint8)
+				meta.HasDecimals = true
+			}
+			result.Metadata[s.token] = meta
+		case "symbol", "name":
+			meta := result.Metadata[s.token]
+			if value, ok := decodeTokenString(out.Data); ok {
+				if s.kind == "symbol" {
+					meta.Symbol = value
+				} else {
+					meta.Name = value
+				}
+			}
+			result.Metadata[s.token] = meta
+		}
+	}
+
+	return result, nil
+}
+
+// decodeTokenString decodes a symbol() or name() result. Most tokens return a
+// string; some early ones such as MKR return a null-padded bytes32 instead.
+func decodeTokenString(data []byte) (string, bool) {
+	var value string
+	if values, err := erc20ABI.Unpack("symbol", data); err == nil && len(values) == 1 {
+		value = values[0].(string)
+	} else if len(data) == 32 {
+		value = strings.TrimRight(string(data), "\x00")
+	}
+	value = strings.ToValidUTF8(value, "")
+	return value, value != ""
+}
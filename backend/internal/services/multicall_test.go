@@ -0,0 +1,230 @@
+package services
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/stretchr/testify/require"
+
+	"web3-portfolio-dashboard/backend/internal/config"
+)
+
+// mockTokenCode is a minimal ERC-20 stand-in. balanceOf(a) returns storage
+// slot a; any other selector s returns the three words at slots s, s+1, s+2,
+// which is enough to serve canned decimals(), symbol() and name() answers.
+var mockTokenCode = common.FromHex(
+	"600035" + "60e01c" + "80" + "6370a08231" + "14" + "602a57" +
+		"8054600052" + "8060010154602052" + "8060020154604052" + "60606000f3" +
+		"5b" + "6004355460005260206000f3")
+
+// revertingCode reverts on every call
+var revertingCode = common.FromHex("60006000fd")
+
+var (
+	testToken     = common.HexToAddress("0x00000000000000000000000000000000000a11ce")
+	testReverting = common.HexToAddress("0x00000000000000000000000000000000000dead0")
+	testMulticall = common.HexToAddress(config.Multicall3Address)
+	testHolder1   = common.HexToAddress("0x1111111111111111111111111111111111111111")
+	testHolder2   = common.HexToAddress("0x2222222222222222222222222222222222222222")
+)
+
+// tokenStorage builds the mock token's storage for the given answers
+func tokenStorage(t *testing.T, balances map[common.Address]int64, decimals uint8, symbol, name string) map[common.Hash]common.Hash {
+	t.Helper()
+
+	storage := make(map[common.Hash]common.Hash)
+	for holder, amount := range balances {
+		storage[common.BytesToHash(holder.Bytes())] = common.BigToHash(big.NewInt(amount))
+	}
+
+	setReturn := func(method string, ret []byte) {
+		selector := new(big.Int).SetBytes(erc20ABI.Methods[method].ID)
+		for i := 0; i*32 < len(ret) && i < 3; i++ {
+			key := new(big.Int).Add(selector, big.NewInt(int64(i)))
+			storage[common.BigToHash(key)] = common.BytesToHash(ret[i*32 : i*32+32])
+		}
+	}
+
+	ret, err := erc20ABI.Methods["decimals"].Outputs.Pack(decimals)
+	require.NoError(t, err)
+	setReturn("decimals", ret)
+	ret, err = erc20ABI.Methods["symbol"].Outputs.Pack(symbol)
+	require.NoError(t, err)
+	setReturn("symbol", ret)
+	ret, err = erc20ABI.Methods["name"].Outputs.Pack(name)
+	require.NoError(t, err)
+	setReturn("name", ret)
+
+	return storage
+}
+
+func newTestChain(t *testing.T) *backends.SimulatedBackend {
+	t.Helper()
+
+	sim := backends.NewSimulatedBackend(core.GenesisAlloc{
+		testToken: {
+			Code:    mockTokenCode,
+			Balance: big.NewInt(0),
+			Storage: tokenStorage(t, map[common.Address]int64{testHolder1: 1_500_000}, 6, "USDC", "USD Coin"),
+		},
+		testReverting: {Code: revertingCode, Balance: big.NewInt(0)},
+	}, 30_000_000)
+	t.Cleanup(func() { _ = sim.Close() })
+	return sim
+}
+
+// multicallEmulator serves Multicall3.aggregate3 on top of a simulated chain,
+// executing each sub-call against the chain's EVM
+type multicallEmulator struct {
+	t       *testing.T
+	backend *backends.SimulatedBackend
+	calls   int
+}
+
+func (m *multicallEmulator) CallContract(ctx context.Context, msg ethereum.CallMsg, block *big.Int) ([]byte, error) {
+	if msg.To == nil || *msg.To != testMulticall {
+		return m.backend.CallContract(ctx, msg, block)
+	}
+	m.calls++
+
+	method, err := multicall3ABI.MethodById(msg.Data[:4])
+	require.NoError(m.t, err)
+	require.Equal(m.t, "aggregate3", method.Name)
+
+	args, err := method.Inputs.Unpack(msg.Data[4:])
+	require.NoError(m.t, err)
+	calls := *abi.ConvertType(args[0], new([]multicall3Call)).(*[]multicall3Call)
+
+	results := make([]multicall3Result, 0, len(calls))
+	for _, call := range calls {
+		target := call.Target
+		out, err := m.backend.CallContract(ctx, ethereum.CallMsg{To: &target, Data: call.CallData}, nil)
+		if err != nil && !call.AllowFailure {
+			return nil, fmt.Errorf("Multicall3: call failed")
+		}
+		results = append(results, multicall3Result{Success: err == nil, ReturnData: out})
+	}
+	return method.Outputs.Pack(results)
+}
+
+func TestMulticall3Selector(t *testing.T) {
+	require.Equal(t, "82ad56cb", hex.EncodeToString(multicall3ABI.Methods["aggregate3"].ID))
+}
+
+func TestReadERC20BatchThroughMulticall3(t *testing.T) {
+	sim := newTestChain(t)
+	emulator := &multicallEmulator{t: t, backend: sim}
+
+	caller := func(ctx context.Context, calls []contractCall) ([]callResult, error) {
+		return aggregate3(ctx, emulator, testMulticall, calls)
+	}
+
+	tokens := []common.Address{testToken, testReverting}
+	result, err := readERC20Batch(context.Background(), caller, []common.Address{testHolder1, testHolder2}, tokens, tokens)
+	require.NoError(t, err)
+
+	// Two holders x two tokens plus three metadata reads per token, in one eth_call
+	require.Equal(t, 1, emulator.calls)
+
+	require.Equal(t, int64(1_500_000), result.Balances[testHolder1][testToken].Int64())
+	require.Equal(t, int64(0), result.Balances[testHolder2][testToken].Int64())
+	require.NotContains(t, result.Balances[testHolder1], testReverting)
+
+	require.Equal(t, erc20Metadata{Symbol: "USDC", Name: "USD Coin", Decimals: 6, HasDecimals: true}, result.Metadata[testToken])
+	require.False(t, result.Metadata[testReverting].HasDecimals)
+}
+
+// newSimulatedRPC serves eth_call from a simulated chain and counts the calls
+func newSimulatedRPC(t *testing.T, sim *backends.SimulatedBackend) (*httptest.Server, *int) {
+	t.Helper()
+
+	var ethCalls int
+	server := newFakeRPC(t, func(method string, params []json.RawMessage) (interface{}, *rpcErrorBody) {
+		switch method {
+		case "eth_blockNumber":
+			return "0x1", nil
+		case "eth_call":
+			ethCalls++
+			var call struct {
+				To   common.Address `json:"to"`
+				Data hexutil.Bytes  `json:"data"`
+			}
+			require.NoError(t, json.Unmarshal(params[0], &call))
+			out, err := sim.CallContract(context.Background(), ethereum.CallMsg{To: &call.To, Data: call.Data}, nil)
+			if err != nil {
+				return nil, &rpcErrorBody{Code: 3, Message: "execution reverted"}
+			}
+			return hexutil.Bytes(out), nil
+		}
+		return nil, &rpcErrorBody{Code: -32601, Message: "method not found"}
+	})
+	return server, &ethCalls
+}
+
+func TestTokenBalancesFallBackToRPCBatch(t *testing.T) {
+	server, ethCalls := newSimulatedRPC(t, newTestChain(t))
+
+	// No Multicall3 code exists on the simulated chain, so the aggregate call
+	// returns nothing and the service has to fall back to a JSON-RPC batch
+	cfg := &config.Config{
+		Networks: config.NewNetworkRegistry([]config.NetworkConfig{{
+			Name:       "testnet",
+			RPCURLs:    []string{server.URL},
+			Multicall3: config.Multicall3Address,
+			Tokens:     []config.TokenConfig{{Address: testToken.Hex(), Symbol: "USDC", Name: "USD Coin"}},
+		}}),
+	}
+	service := NewWeb3Service(cfg)
+	defer service.Close()
+	service.SetPriceService(NewPriceService(cfg, []PriceProvider{
+		&staticPriceProvider{name: "static", prices: map[string]float64{"USDC": 1}},
+	}))
+
+	balances, err := service.GetTokenBalancesForAddresses(context.Background(), []string{testHolder1.Hex(), testHolder2.Hex()}, "testnet")
+	require.NoError(t, err)
+
+	// One aggregate3 attempt, then balanceOf x2 plus decimals, symbol and name
+	require.Equal(t, 6, *ethCalls)
+
+	require.Len(t, balances[testHolder1.Hex()], 1)
+	require.Empty(t, balances[testHolder2.Hex()])
+
+	usdc := balances[testHolder1.Hex()][0]
+	require.Equal(t, "1500000", usdc.Amount)
+	require.Equal(t, uint8(6), usdc.Decimals)
+	require.Equal(t, "1.50000000", usdc.Value)
+
+	// Metadata is cached, so a second read only fetches balances
+	*ethCalls = 0
+	_, err = service.GetTokenBalancesForAddresses(context.Background(), []string{testHolder1.Hex()}, "testnet")
+	require.NoError(t, err)
+	require.Equal(t, 2, *ethCalls)
+}
+
+func TestDecodeTokenStringHandlesBytes32(t *testing.T) {
+	packed, err := erc20ABI.Methods["symbol"].Outputs.Pack("USDC")
+	require.NoError(t, err)
+	value, ok := decodeTokenString(packed)
+	require.True(t, ok)
+	require.Equal(t, "USDC", value)
+
+	// MKR's symbol() returns bytes32("MKR")
+	mkr := common.RightPadBytes([]byte("MKR"), 32)
+	value, ok = decodeTokenString(mkr)
+	require.True(t, ok)
+	require.Equal(t, "MKR", value)
+
+	_, ok = decodeTokenString(make([]byte, 32))
+	require.False(t, ok)
+}
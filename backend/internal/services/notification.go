@@ -0,0 +1,240 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"web3-portfolio-dashboard/backend/internal/config"
+	"web3-portfolio-dashboard/backend/internal/models"
+)
+
+type NotificationService struct {
+	db          *gorm.DB
+	notifiers   map[string]Notifier
+	maxAttempts int
+	backoff     time.Duration
+	deliveries  sync.WaitGroup
+}
+
+func NewNotificationService(db *gorm.DB, cfg *config.Config, notifiers []Notifier) *NotificationService {
+	s := &NotificationService{
+		db:          db,
+		notifiers:   make(map[string]Notifier, len(notifiers)),
+		maxAttempts: cfg.NotifyMaxAttempts,
+		backoff:     cfg.NotifyRetryBackoff,
+	}
+	if s.maxAttempts < 1 {
+		s.maxAttempts = 1
+	}
+	for _, notifier := range notifiers {
+		s.notifiers[notifier.Channel()] = notifier
+	}
+	return s
+}
+
+// CreateNotification stores a triggered alert for the alert's user in tx
+func (s *NotificationService) CreateNotification(tx *gorm.DB, alert *models.Alert, notification *AlertNotification) (*models.Notification, error) {
+	data, err := json.Marshal(notification.Data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize notification data: %w", err)
+	}
+
+	record := &models.Notification{
+		UserID:    alert.UserID,
+		AlertID:   alert.ID,
+		Type:      notification.Type,
+		Message:   notification.Message,
+		Data:      models.JSON(data),
+		CreatedAt: notification.Timestamp,
+	}
+	if err := tx.Create(record).Error; err != nil {
+		return nil, fmt.Errorf("failed to save notification: %w", err)
+	}
+
+	return record, nil
+}
+
+// Deliver sends a stored notification on each of the alert's channels in the
+// background. Failed deliveries are retried with exponential backoff and end
+// up in the dead letters.
+func (s *NotificationService) Deliver(ctx context.Context, alert *models.Alert, notification *models.Notification) error {
+	if len(alert.Channels) == 0 {
+		return nil
+	}
+
+	var user models.User
+	if err := s.db.First(&user, "id = ?", alert.UserID).Error; err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+	delivery := &Delivery{User: user, Alert: *alert, Notification: *notification}
+
+	for _, channel := range alert.Channels {
+		notifier, ok := s.notifiers[channel]
+		if !ok {
+			s.deadLetter(delivery, channel, 0, fmt.Errorf("%s notifications are not configured", channel))
+			continue
+		}
+
+		s.deliveries.Add(1)
+		go func() {
+			defer s.deliveries.Done()
+			if attempts, err := s.send(ctx, notifier, delivery); err != nil {
+				s.deadLetter(delivery, channel, attempts, err)
+			}
+		}()
+	}
+
+	return nil
+}
+
+// Wait blocks until background deliveries finish
+func (s *NotificationService) Wait() {
+	s.deliveries.Wait()
+}
+
+// send tries a delivery until it succeeds, fails permanently, runs out of
+// attempts or ctx is cancelled, and returns how many attempts it made
+func (s *NotificationService) send(ctx context.Context, notifier Notifier, delivery *Delivery) (int, error) {
+	backoff := s.backoff
+	for attempt := 1; ; attempt++ {
+		err := notifier.Notify(ctx, delivery)
+		if err == nil {
+			return attempt, nil
+		}
+		if isPermanent(err) || attempt >= s.maxAttempts {
+			return attempt, err
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return attempt, fmt.Errorf("%w (last error: %v)", ctx.Err(), err)
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+// deadLetter records a delivery that was given up on
+func (s *NotificationService) deadLetter(delivery *Delivery, channel string, attempts int, err error) {
+	log.Printf("❌ Failed to deliver notification %s by %s after %d attempts: %v", delivery.Notification.ID, channel, attempts, err)
+
+	record := &models.NotificationDeadLetter{
+		NotificationID: delivery.Notification.ID,
+		UserID:         delivery.User.ID,
+		Channel:        channel,
+		Attempts:       attempts,
+		Error:          err.Error(),
+	}
+	if err := s.db.Create(record).Error; err != nil {
+		log.Printf("❌ Failed to record undelivered notification %s: %v", delivery.Notification.ID, err)
+	}
+}
+
+// GetNotifications retrieves a page of a user's notifications, newest first
+func (s *NotificationService) GetNotifications(userID string, page, limit int, unreadOnly bool) ([]models.Notification, int64, error) {
+	userUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid user ID: %w", err)
+	}
+
+	query := s.db.Model(&models.Notification{}).Where("user_id = ?", userUUID)
+	if unreadOnly {
+		query = query.Where("read_at IS NULL")
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count notifications: %w", err)
+	}
+
+	var notifications []models.Notification
+	err = query.Order("created_at DESC").Offset((page - 1) * limit).Limit(limit).Find(&notifications).Error
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get notifications: %w", err)
+	}
+
+	return notifications, total, nil
+}
+
+// UnreadCount counts a user's unread notifications
+func (s *NotificationService) UnreadCount(userID string) (int64, error) {
+	userUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return 0, fmt.Errorf("invalid user ID: %w", err)
+	}
+
+	var count int64
+	err = s.db.Model(&models.Notification{}).Where("user_id = ? AND read_at IS NULL", userUUID).Count(&count).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to count notifications: %w", err)
+	}
+
+	return count, nil
+}
+
+// MarkRead marks a user's notifications as read, or all of them when ids is
+// empty, and returns how many changed
+func (s *NotificationService) MarkRead(userID string, ids []string) (int64, error) {
+	query, err := s.userNotifications(userID, ids)
+	if err != nil {
+		return 0, err
+	}
+
+	result := query.Where("read_at IS NULL").Update("read_at", time.Now())
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to mark notifications read: %w", result.Error)
+	}
+
+	return result.RowsAffected, nil
+}
+
+// DismissNotifications deletes a user's notifications and returns how many were deleted
+func (s *NotificationService) DismissNotifications(userID string, ids []string) (int64, error) {
+	if len(ids) == 0 {
+		return 0, fmt.Errorf("no notifications given")
+	}
+
+	query, err := s.userNotifications(userID, ids)
+	if err != nil {
+		return 0, err
+	}
+
+	result := query.Delete(&models.Notification{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to dismiss notifications: %w", result.Error)
+	}
+
+	return result.RowsAffected, nil
+}
+
+// userNotifications scopes a query to a user's notifications, limited to ids when given
+func (s *NotificationService) userNotifications(userID string, ids []string) (*gorm.DB, error) {
+	userUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid user ID: %w", err)
+	}
+
+	query := s.db.Model(&models.Notification{}).Where("user_id = ?", userUUID)
+	if len(ids) > 0 {
+		notificationUUIDs := make([]uuid.UUID, 0, len(ids))
+		for _, id := range ids {
+			notificationUUID, err := uuid.Parse(id)
+			if err != nil {
+				return nil, fmt.Errorf("invalid notification ID: %s", id)
+			}
+			notificationUUIDs = append(notificationUUIDs, notificationUUID)
+		}
+		query = query.Where("id IN ?", notificationUUIDs)
+	}
+
+	return query, nil
+}
@@ -0,0 +1,415 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"web3-portfolio-dashboard/backend/internal/config"
+	"web3-portfolio-dashboard/backend/internal/models"
+)
+
+// Notification channels an alert can deliver to besides the in-app list
+const (
+	ChannelWebhook = "webhook"
+	ChannelDiscord = "discord"
+	ChannelEmail   = "email"
+)
+
+// Webhook signature headers. The signature is the hex HMAC-SHA256 of
+// "<timestamp>.<body>" keyed with the alert's webhook secret.
+const (
+	WebhookSignatureHeader = "X-Webhook-Signature"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+)
+
+// Notifier delivers a notification over one channel
+type Notifier interface {
+	Channel() string
+	Notify(ctx context.Context, delivery *Delivery) error
+}
+
+// Delivery is a stored notification on its way to a user
+type Delivery struct {
+	User         models.User
+	Alert        models.Alert
+	Notification models.Notification
+}
+
+// permanentError marks a failure that retrying cannot fix
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+func permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+func isPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
+
+// DefaultNotifiers builds the notifiers the configuration enables. Webhooks
+// are always available since each alert brings its own URL.
+func DefaultNotifiers(cfg *config.Config) []Notifier {
+	client := &http.Client{Timeout: 10 * time.Second}
+	notifiers := []Notifier{&WebhookNotifier{client: newWebhookClient()}}
+	if cfg.DiscordBotToken != "" || cfg.DiscordWebhookURL != "" {
+		notifiers = append(notifiers, &DiscordNotifier{
+			client:     client,
+			apiURL:     strings.TrimRight(cfg.DiscordAPIURL, "/"),
+			botToken:   cfg.DiscordBotToken,
+			webhookURL: cfg.DiscordWebhookURL,
+		})
+	}
+	if cfg.SMTPHost != "" {
+		notifiers = append(notifiers, NewEmailNotifier(cfg))
+	}
+	return notifiers
+}
+
+// webhookPayload is the JSON body posted to alert webhooks
+type webhookPayload struct {
+	ID        string          `json:"id"`
+	AlertID   string          `json:"alert_id"`
+	AlertName string          `json:"alert_name"`
+	Type      string          `json:"type"`
+	Message   string          `json:"message"`
+	Data      json.RawMessage `json:"data"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// WebhookNotifier posts notifications to the alert's webhook URL, signed with
+// the alert's secret
+type WebhookNotifier struct {
+	client *http.Client
+}
+
+func (n *WebhookNotifier) Channel() string { return ChannelWebhook }
+
+func (n *WebhookNotifier) Notify(ctx context.Context, delivery *Delivery) error {
+	if delivery.Alert.WebhookURL == "" {
+		return permanent(fmt.Errorf("alert has no webhook URL"))
+	}
+	if delivery.Alert.WebhookSecret == "" {
+		return permanent(fmt.Errorf("alert has no webhook secret; update the alert to issue one"))
+	}
+
+	data := json.RawMessage(delivery.Notification.Data)
+	if len(data) == 0 {
+		data = json.RawMessage("null")
+	}
+	body, err := json.Marshal(webhookPayload{
+		ID:        delivery.Notification.ID.String(),
+		AlertID:   delivery.Alert.ID.String(),
+		AlertName: delivery.Alert.Name,
+		Type:      delivery.Notification.Type,
+		Message:   delivery.Notification.Message,
+		Data:      data,
+		CreatedAt: delivery.Notification.CreatedAt,
+	})
+	if err != nil {
+		return permanent(fmt.Errorf("failed to serialize webhook payload: %w", err))
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	headers := http.Header{}
+	headers.Set(WebhookTimestampHeader, timestamp)
+	headers.Set(WebhookSignatureHeader, "sha256="+signWebhook(delivery.Alert.WebhookSecret, timestamp, body))
+	return postJSON(ctx, n.client, delivery.Alert.WebhookURL, headers, body, nil)
+}
+
+// newWebhookClient returns the client for user-supplied webhook URLs. It only
+// connects to public addresses, checked on the address actually dialed so a
+// host that resolves differently later can't get around it, and doesn't
+// follow redirects.
+func newWebhookClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return permanent(err)
+			}
+			if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+				return permanent(fmt.Errorf("webhook address %s is not public", host))
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, _ []*http.Request) error {
+			return permanent(fmt.Errorf("webhook redirected to %s; redirects are not followed", req.URL.Host))
+		},
+	}
+}
+
+// nonPublicNetworks are ranges outside those the net.IP methods cover that
+// still don't reach the public internet
+var nonPublicNetworks = func() []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range []string{"0.0.0.0/8", "100.64.0.0/10", "192.0.0.0/24", "198.18.0.0/15", "64:ff9b::/96"} {
+		_, network, _ := net.ParseCIDR(cidr)
+		networks = append(networks, network)
+	}
+	return networks
+}()
+
+// isPublicIP reports whether ip is a public unicast address, and not a
+// loopback, private, link-local or otherwise internal one
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, network := range nonPublicNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// lookupWebhookHost resolves webhook hosts when alerts are saved
+var lookupWebhookHost = net.DefaultResolver.LookupIPAddr
+
+// checkWebhookHost rejects webhook hosts that resolve to an address that
+// isn't public. Deliveries check the dialed address again.
+func checkWebhookHost(ctx context.Context, host string) error {
+	addrs, err := lookupWebhookHost(ctx, host)
+	if err != nil {
+		return fmt.Errorf("webhook_url host %s doesn't resolve: %w", host, err)
+	}
+	if len(addrs) == 0 {
+		return fmt.Errorf("webhook_url host %s doesn't resolve", host)
+	}
+	for _, addr := range addrs {
+		if !isPublicIP(addr.IP) {
+			return fmt.Errorf("webhook_url host %s resolves to %s, which is not a public address", host, addr.IP)
+		}
+	}
+	return nil
+}
+
+// signWebhook computes the signature receivers check against WebhookSignatureHeader
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// DiscordNotifier sends a direct message from the bot to users with a Discord
+// ID, or posts to the channel webhook otherwise
+type DiscordNotifier struct {
+	client     *http.Client
+	apiURL     string
+	botToken   string
+	webhookURL string
+}
+
+func (n *DiscordNotifier) Channel() string { return ChannelDiscord }
+
+func (n *DiscordNotifier) Notify(ctx context.Context, delivery *Delivery) error {
+	content := notificationText(&delivery.Notification)
+	discordID := delivery.User.DiscordID
+
+	if n.botToken != "" && discordID != "" {
+		headers := http.Header{}
+		headers.Set("Authorization", "Bot "+n.botToken)
+
+		// Opening a DM channel returns the existing one if there is one
+		body, _ := json.Marshal(map[string]string{"recipient_id": discordID})
+		var channel struct {
+			ID string `json:"id"`
+		}
+		if err := postJSON(ctx, n.client, n.apiURL+"/users/@me/channels", headers, body, &channel); err != nil {
+			return fmt.Errorf("failed to open DM channel: %w", err)
+		}
+		if channel.ID == "" {
+			return fmt.Errorf("discord returned no DM channel")
+		}
+
+		body, _ = json.Marshal(map[string]string{"content": content})
+		return postJSON(ctx, n.client, n.apiURL+"/channels/"+channel.ID+"/messages", headers, body, nil)
+	}
+
+	if n.webhookURL == "" {
+		return permanent(fmt.Errorf("user has no Discord ID and no Discord webhook is configured"))
+	}
+	if discordID != "" {
+		content = fmt.Sprintf("<@%s> %s", discordID, content)
+	}
+	body, _ := json.Marshal(map[string]string{"content": content})
+	return postJSON(ctx, n.client, n.webhookURL, nil, body, nil)
+}
+
+// postJSON posts body and decodes the response into out when given. Client
+// errors other than 429 are permanent.
+func postJSON(ctx context.Context, client *http.Client, url string, headers http.Header, body []byte, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return permanent(fmt.Errorf("failed to create request: %w", err))
+	}
+	for key, values := range headers {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		err := fmt.Errorf("%s returned status %d", req.URL.Host, resp.StatusCode)
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			return permanent(err)
+		}
+		return err
+	}
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("failed to decode response: %w", err)
+		}
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// smtpTimeout bounds one email delivery
+const smtpTimeout = 30 * time.Second
+
+// EmailNotifier emails notifications to the user's address over SMTP
+type EmailNotifier struct {
+	addr string
+	host string
+	from string
+	auth smtp.Auth
+}
+
+func NewEmailNotifier(cfg *config.Config) *EmailNotifier {
+	n := &EmailNotifier{
+		addr: net.JoinHostPort(cfg.SMTPHost, cfg.SMTPPort),
+		host: cfg.SMTPHost,
+		from: cfg.SMTPFrom,
+	}
+	if cfg.SMTPUsername != "" {
+		n.auth = smtp.PlainAuth("", cfg.SMTPUsername, cfg.SMTPPassword, cfg.SMTPHost)
+	}
+	return n
+}
+
+func (n *EmailNotifier) Channel() string { return ChannelEmail }
+
+func (n *EmailNotifier) Notify(ctx context.Context, delivery *Delivery) error {
+	to := delivery.User.Email
+	if to == "" {
+		return permanent(fmt.Errorf("user has no email address"))
+	}
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", n.addr)
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(smtpTimeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	_ = conn.SetDeadline(deadline)
+
+	client, err := smtp.NewClient(conn, n.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: n.host}); err != nil {
+			return err
+		}
+	}
+	if n.auth != nil {
+		if err := client.Auth(n.auth); err != nil {
+			return permanent(fmt.Errorf("SMTP authentication failed: %w", err))
+		}
+	}
+	if err := client.Mail(n.from); err != nil {
+		return err
+	}
+	if err := client.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(emailMessage(n.from, to, &delivery.Alert, &delivery.Notification)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// emailMessage renders a plain-text email for a notification
+func emailMessage(from, to string, alert *models.Alert, notification *models.Notification) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", to)
+	fmt.Fprintf(&b, "Subject: %s\r\n", strings.NewReplacer("\r", "", "\n", " ").Replace(alert.Name))
+	fmt.Fprintf(&b, "Date: %s\r\n", notification.CreatedAt.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(notificationText(notification), "\n", "\r\n"))
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
+
+// notificationText is the message followed by one "key: value" line per data field
+func notificationText(notification *models.Notification) string {
+	var data map[string]interface{}
+	_ = json.Unmarshal([]byte(notification.Data), &data)
+
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	lines := []string{notification.Message}
+	for _, key := range keys {
+		lines = append(lines, fmt.Sprintf("%s: %v", key, data[key]))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,349 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"web3-portfolio-dashboard/backend/internal/config"
+	"web3-portfolio-dashboard/backend/internal/models"
+)
+
+func testDelivery() *Delivery {
+	alertID := uuid.New()
+	return &Delivery{
+		User:  models.User{ID: uuid.New(), Email: "user@example.com", DiscordID: "1234"},
+		Alert: models.Alert{ID: alertID, Name: "ETH below 2000", Type: "price"},
+		Notification: models.Notification{
+			ID:        uuid.New(),
+			AlertID:   alertID,
+			Type:      "price",
+			Message:   "Alert triggered: ETH below 2000",
+			Data:      `{"token":"ETH","current_price":1990.5}`,
+			CreatedAt: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		},
+	}
+}
+
+// resolveWebhookHosts answers webhook host lookups from hosts instead of DNS
+func resolveWebhookHosts(t *testing.T, hosts map[string]string) {
+	t.Helper()
+	lookup := lookupWebhookHost
+	t.Cleanup(func() { lookupWebhookHost = lookup })
+	lookupWebhookHost = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		if ip := net.ParseIP(host); ip != nil {
+			return []net.IPAddr{{IP: ip}}, nil
+		}
+		if ip, ok := hosts[host]; ok {
+			return []net.IPAddr{{IP: net.ParseIP(ip)}}, nil
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+}
+
+func TestWebhookNotifierSignsPayload(t *testing.T) {
+	status := http.StatusOK
+	var body []byte
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		header = r.Header.Clone()
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	require.Equal(t, ChannelWebhook, DefaultNotifiers(&config.Config{})[0].Channel())
+	notifier := &WebhookNotifier{client: server.Client()}
+
+	delivery := testDelivery()
+	delivery.Alert.WebhookURL = server.URL
+	delivery.Alert.WebhookSecret = "s3cret"
+	require.NoError(t, notifier.Notify(context.Background(), delivery))
+
+	timestamp := header.Get(WebhookTimestampHeader)
+	require.NotEmpty(t, timestamp)
+	require.Equal(t, "sha256="+signWebhook("s3cret", timestamp, body), header.Get(WebhookSignatureHeader))
+
+	var payload webhookPayload
+	require.NoError(t, json.Unmarshal(body, &payload))
+	require.Equal(t, delivery.Notification.ID.String(), payload.ID)
+	require.Equal(t, "ETH below 2000", payload.AlertName)
+	require.JSONEq(t, string(delivery.Notification.Data), string(payload.Data))
+
+	// Receivers rejecting the request won't change their mind; outages might pass
+	status = http.StatusBadRequest
+	require.True(t, isPermanent(notifier.Notify(context.Background(), delivery)))
+	status = http.StatusServiceUnavailable
+	err := notifier.Notify(context.Background(), delivery)
+	require.Error(t, err)
+	require.False(t, isPermanent(err))
+
+	// Alerts without their own secret are never sent unsigned
+	delivery.Alert.WebhookSecret = ""
+	require.True(t, isPermanent(notifier.Notify(context.Background(), delivery)))
+	delivery.Alert.WebhookURL = ""
+	require.True(t, isPermanent(notifier.Notify(context.Background(), delivery)))
+}
+
+func TestWebhookClientOnlyReachesPublicAddresses(t *testing.T) {
+	reached := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}))
+	defer server.Close()
+
+	// The test server listens on loopback, which the webhook client won't dial
+	delivery := testDelivery()
+	delivery.Alert.WebhookURL = server.URL
+	delivery.Alert.WebhookSecret = "s3cret"
+	err := DefaultNotifiers(&config.Config{})[0].Notify(context.Background(), delivery)
+	require.Error(t, err)
+	require.True(t, isPermanent(err))
+	require.False(t, reached)
+
+	client := newWebhookClient()
+	req := httptest.NewRequest(http.MethodPost, "http://169.254.169.254/latest/meta-data", nil)
+	require.True(t, isPermanent(client.CheckRedirect(req, nil)))
+
+	for ip, public := range map[string]bool{
+		"93.184.216.34":   true,
+		"2606:4700::1111": true,
+		"127.0.0.1":       false,
+		"10.0.0.8":        false,
+		"172.16.5.4":      false,
+		"192.168.1.1":     false,
+		"169.254.169.254": false,
+		"100.64.0.1":      false,
+		"0.0.0.0":         false,
+		"::1":             false,
+		"fe80::1":         false,
+		"fd00::1":         false,
+		"::ffff:10.0.0.1": false,
+	} {
+		require.Equal(t, public, isPublicIP(net.ParseIP(ip)), ip)
+	}
+}
+
+func TestDiscordNotifier(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	var contents []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, r.Method+" "+r.URL.Path+" "+r.Header.Get("Authorization"))
+
+		var body map[string]string
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		switch r.URL.Path {
+		case "/api/users/@me/channels":
+			require.Equal(t, "1234", body["recipient_id"])
+			_, _ = w.Write([]byte(`{"id":"dm-1"}`))
+		default:
+			contents = append(contents, body["content"])
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	delivery := testDelivery()
+	cfg := &config.Config{DiscordAPIURL: server.URL + "/api/", DiscordBotToken: "token", DiscordWebhookURL: server.URL + "/hook"}
+	notifiers := DefaultNotifiers(cfg)
+	require.Len(t, notifiers, 2)
+	discord := notifiers[1]
+	require.Equal(t, ChannelDiscord, discord.Channel())
+
+	// Users with a Discord ID get a DM from the bot
+	require.NoError(t, discord.Notify(context.Background(), delivery))
+	require.Equal(t, []string{
+		"POST /api/users/@me/channels Bot token",
+		"POST /api/channels/dm-1/messages Bot token",
+	}, requests)
+	require.Equal(t, "Alert triggered: ETH below 2000\ncurrent_price: 1990.5\ntoken: ETH", contents[0])
+
+	// Everyone else is mentioned on the channel webhook
+	requests, contents = nil, nil
+	cfg.DiscordBotToken = ""
+	require.NoError(t, DefaultNotifiers(cfg)[1].Notify(context.Background(), delivery))
+	require.Equal(t, []string{"POST /hook "}, requests)
+	require.True(t, strings.HasPrefix(contents[0], "<@1234> Alert triggered"))
+
+	cfg.DiscordWebhookURL = ""
+	require.Len(t, DefaultNotifiers(cfg), 1)
+}
+
+// fakeSMTP accepts one session of mail and returns what was sent
+func fakeSMTP(t *testing.T) (string, <-chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+		reply := func(line string) { _, _ = conn.Write([]byte(line + "\r\n")) }
+		reply("220 localhost ESMTP")
+
+		var session strings.Builder
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			command := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+				reply("250 localhost")
+			case strings.HasPrefix(command, "MAIL"), strings.HasPrefix(command, "RCPT"):
+				session.WriteString(strings.TrimSpace(line) + "\n")
+				reply("250 OK")
+			case command == "DATA":
+				reply("354 Go ahead")
+				for {
+					data, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if data == ".\r\n" {
+						break
+					}
+					session.WriteString(data)
+				}
+				reply("250 OK")
+			case command == "QUIT":
+				reply("221 Bye")
+				received <- session.String()
+				return
+			default:
+				reply("502 Not implemented")
+			}
+		}
+	}()
+
+	return listener.Addr().String(), received
+}
+
+func TestEmailNotifier(t *testing.T) {
+	addr, received := fakeSMTP(t)
+	host, port, err := net.SplitHostPort(addr)
+	require.NoError(t, err)
+
+	notifiers := DefaultNotifiers(&config.Config{SMTPHost: host, SMTPPort: port, SMTPFrom: "alerts@example.com"})
+	require.Len(t, notifiers, 2)
+	email := notifiers[1]
+	require.Equal(t, ChannelEmail, email.Channel())
+
+	require.NoError(t, email.Notify(context.Background(), testDelivery()))
+	session := <-received
+	require.Contains(t, session, "MAIL FROM:<alerts@example.com>")
+	require.Contains(t, session, "RCPT TO:<user@example.com>")
+	require.Contains(t, session, "Subject: ETH below 2000\r\n")
+	require.Contains(t, session, "\r\n\r\nAlert triggered: ETH below 2000\r\ncurrent_price: 1990.5\r\ntoken: ETH\r\n")
+
+	delivery := testDelivery()
+	delivery.User.Email = ""
+	require.True(t, isPermanent(email.Notify(context.Background(), delivery)))
+}
+
+type flakyNotifier struct {
+	failures int
+	err      error
+	calls    int
+}
+
+func (n *flakyNotifier) Channel() string { return "flaky" }
+
+func (n *flakyNotifier) Notify(ctx context.Context, delivery *Delivery) error {
+	n.calls++
+	if n.calls <= n.failures {
+		return n.err
+	}
+	return nil
+}
+
+func TestNotificationDeliveryRetries(t *testing.T) {
+	s := NewNotificationService(nil, &config.Config{NotifyMaxAttempts: 4, NotifyRetryBackoff: time.Millisecond}, nil)
+	outage := errors.New("connection refused")
+
+	notifier := &flakyNotifier{failures: 2, err: outage}
+	attempts, err := s.send(context.Background(), notifier, testDelivery())
+	require.NoError(t, err)
+	require.Equal(t, 3, attempts)
+
+	notifier = &flakyNotifier{failures: 10, err: outage}
+	attempts, err = s.send(context.Background(), notifier, testDelivery())
+	require.ErrorIs(t, err, outage)
+	require.Equal(t, 4, attempts)
+
+	notifier = &flakyNotifier{failures: 10, err: permanent(outage)}
+	attempts, err = s.send(context.Background(), notifier, testDelivery())
+	require.ErrorIs(t, err, outage)
+	require.Equal(t, 1, attempts)
+
+	// Shutdown cuts the backoff short
+	s.backoff = time.Hour
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	notifier = &flakyNotifier{failures: 10, err: outage}
+	attempts, err = s.send(ctx, notifier, testDelivery())
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, 1, attempts)
+}
+
+func TestValidateChannels(t *testing.T) {
+	resolveWebhookHosts(t, map[string]string{"example.com": "93.184.216.34", "internal.example.com": "10.0.0.8"})
+
+	require.NoError(t, validateChannels(nil, ""))
+	require.NoError(t, validateChannels([]string{ChannelEmail, ChannelDiscord}, ""))
+	require.NoError(t, validateChannels([]string{ChannelWebhook}, "https://example.com/hook"))
+	require.Error(t, validateChannels([]string{ChannelWebhook}, ""))
+	require.Error(t, validateChannels([]string{ChannelWebhook}, "ftp://example.com"))
+	require.Error(t, validateChannels([]string{ChannelWebhook}, "http://127.0.0.1:8080/hook"))
+	require.Error(t, validateChannels([]string{ChannelWebhook}, "http://169.254.169.254/latest/meta-data"))
+	require.Error(t, validateChannels([]string{ChannelWebhook}, "http://[::1]/hook"))
+	require.Error(t, validateChannels([]string{ChannelWebhook}, "https://internal.example.com/hook"))
+	require.Error(t, validateChannels([]string{ChannelWebhook}, "https://unknown.example.com/hook"))
+	require.Error(t, validateChannels([]string{"sms"}, ""))
+	require.Error(t, validateChannels([]string{ChannelEmail, ChannelEmail}, ""))
+}
+
+func TestAlertChannelsMigrateOnSQLite(t *testing.T) {
+	type storedAlert struct {
+		ID       uint
+		Channels models.StringList `gorm:"serializer:json"`
+	}
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "channels.db")), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&storedAlert{}))
+	columns, err := db.Migrator().ColumnTypes(&storedAlert{})
+	require.NoError(t, err)
+	require.Equal(t, "channels", columns[1].Name())
+	require.Equal(t, "TEXT", strings.ToUpper(columns[1].DatabaseTypeName()))
+
+	alert := storedAlert{Channels: models.StringList{ChannelWebhook, ChannelEmail}}
+	require.NoError(t, db.Create(&alert).Error)
+	var loaded storedAlert
+	require.NoError(t, db.First(&loaded, alert.ID).Error)
+	require.Equal(t, alert.Channels, loaded.Channels)
+}
@@ -0,0 +1,423 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"web3-portfolio-dashboard/backend/internal/models"
+)
+
+// Cost-basis methods for matching disposals against acquisitions
+const (
+	CostBasisFIFO    = "fifo"
+	CostBasisLIFO    = "lifo"
+	CostBasisAverage = "average"
+)
+
+// pnlDust is the quantity below which a lot or an unmatched disposal is ignored
+const pnlDust = 1e-12
+
+// PnLOptions selects how and over which period profit and loss is computed
+type PnLOptions struct {
+	Method string    // fifo, lifo or average
+	From   time.Time // disposals before From are not counted as realized; zero counts all
+	To     time.Time // transactions after To are ignored and holdings are valued at To; zero is now
+}
+
+// PortfolioPnL is the cost basis and profit or loss of a portfolio, in USD
+type PortfolioPnL struct {
+	Method        string     `json:"method"`
+	From          *time.Time `json:"from,omitempty"`
+	To            time.Time  `json:"to"`
+	CostBasis     string     `json:"cost_basis"`
+	CurrentValue  string     `json:"current_value"`
+	RealizedPnL   string     `json:"realized_pnl"`
+	UnrealizedPnL string     `json:"unrealized_pnl"`
+	TotalPnL      string     `json:"total_pnl"`
+	FeesPaid      string     `json:"fees_paid"`
+	// Incomplete is set when a price was missing or more was sent than the indexed history received
+	Incomplete bool       `json:"incomplete"`
+	Assets     []AssetPnL `json:"assets"`
+}
+
+// AssetPnL is the cost basis and profit or loss of one asset
+type AssetPnL struct {
+	Symbol        string `json:"symbol"`
+	Network       string `json:"network"`
+	TokenAddress  string `json:"token_address"`
+	Quantity      string `json:"quantity"`
+	CostBasis     string `json:"cost_basis"`
+	AverageCost   string `json:"average_cost"`
+	Price         string `json:"price"`
+	CurrentValue  string `json:"current_value"`
+	RealizedPnL   string `json:"realized_pnl"`
+	UnrealizedPnL string `json:"unrealized_pnl"`
+	Incomplete    bool   `json:"incomplete"`
+
+	quantity, costBasis, value, realized, unrealized float64
+}
+
+// ParseCostBasisMethod validates a cost-basis method; empty means FIFO
+func ParseCostBasisMethod(method string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(method)) {
+	case "", CostBasisFIFO:
+		return CostBasisFIFO, nil
+	case CostBasisLIFO:
+		return CostBasisLIFO, nil
+	case CostBasisAverage, "avg":
+		return CostBasisAverage, nil
+	default:
+		return "", fmt.Errorf("invalid cost basis method: %s (expected fifo, lifo or average)", method)
+	}
+}
+
+// GetPortfolioPnL replays a portfolio's transactions to compute cost basis and
+// realized and unrealized gains. Incoming transfers are acquisitions and outgoing
+// ones disposals, both at the recorded price of the time; gas spent is a disposal
+// of the native token.
+func (s *PortfolioService) GetPortfolioPnL(userID, portfolioID string, opts PnLOptions) (*PortfolioPnL, error) {
+	balances, err := s.GetPortfolioBalances(userID, portfolioID)
+	if err != nil {
+		return nil, err
+	}
+	method, err := ParseCostBasisMethod(opts.Method)
+	if err != nil {
+		return nil, err
+	}
+
+	portfolioUUID, _ := uuid.Parse(portfolioID)
+	now := time.Now()
+	to := opts.To
+	if to.IsZero() || to.After(now) {
+		to = now
+	}
+
+	ledger, err := s.replayTransactions(portfolioUUID, method, opts.From, to)
+	if err != nil {
+		return nil, err
+	}
+
+	// Holdings are valued at the stored balance prices, or at the recorded price at To
+	valueNow := opts.To.IsZero() || !opts.To.Before(now)
+	prices := make(map[string]float64)
+	if valueNow {
+		for _, balance := range balances {
+			if price, err := strconv.ParseFloat(balance.Price, 64); err == nil && price > 0 {
+				prices[assetKey(balance.Address.Network, balance.TokenAddress)] = price
+			}
+		}
+	}
+	priceOf := func(asset *assetLedger) (float64, bool) {
+		if price, ok := prices[assetKey(asset.network, asset.tokenAddress)]; ok {
+			return price, true
+		}
+		return s.priceHistory.PriceAt(asset.symbol, to)
+	}
+
+	pnl := ledger.report(priceOf)
+	pnl.Method = method
+	pnl.To = to
+	if !opts.From.IsZero() {
+		from := opts.From
+		pnl.From = &from
+	}
+	return pnl, nil
+}
+
+// replayTransactions runs a portfolio's transactions up to a point in time through a ledger
+func (s *PortfolioService) replayTransactions(portfolioID uuid.UUID, method string, from, to time.Time) (*pnlLedger, error) {
+	var transactions []models.Transaction
+	err := s.db.Where("portfolio_id = ? AND timestamp <= ?", portfolioID, to).
+		Order("timestamp, block_number, log_index").
+		Find(&transactions).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get transactions: %w", err)
+	}
+
+	ledger := newPnLLedger(method, from, s.priceHistory.PriceAt)
+	for _, tx := range transactions {
+		ledger.apply(tx, s.web3Service.NativeTokenSymbol(tx.Network))
+	}
+	return ledger, nil
+}
+
+// costLot is a quantity of an asset acquired together, with its total USD cost
+type costLot struct {
+	quantity float64
+	cost     float64
+	acquired time.Time // zero for a pooled average-cost lot spanning several dates
+	priced   bool      // false when the acquisition price wasn't known and cost is 0
+}
+
+// assetLedger holds the open lots and realized gains of one asset
+type assetLedger struct {
+	symbol       string
+	network      string
+	tokenAddress string
+	method       string
+	lots         []costLot
+	realized     float64
+	incomplete   bool
+}
+
+func (a *assetLedger) acquire(lot costLot) {
+	if a.method == CostBasisAverage && len(a.lots) > 0 {
+		pool := &a.lots[0]
+		pool.quantity += lot.quantity
+		pool.cost += lot.cost
+		pool.priced = pool.priced && lot.priced
+		if !pool.acquired.Equal(lot.acquired) {
+			pool.acquired = time.Time{}
+		}
+		return
+	}
+	a.lots = append(a.lots, lot)
+}
+
+// dispose takes quantity out of the open lots and returns the parts of the lots
+// it used, plus any quantity no lot covered. FIFO takes the oldest lots first,
+// LIFO the newest; average cost has a single pooled lot.
+func (a *assetLedger) dispose(quantity float64) (matched []costLot, unmatched float64) {
+	for quantity > pnlDust && len(a.lots) > 0 {
+		i := 0
+		if a.method == CostBasisLIFO {
+			i = len(a.lots) - 1
+		}
+		lot := &a.lots[i]
+
+		if lot.quantity <= quantity+pnlDust {
+			matched = append(matched, *lot)
+			quantity -= lot.quantity
+			a.lots = append(a.lots[:i], a.lots[i+1:]...)
+			continue
+		}
+
+		part := *lot
+		part.quantity = quantity
+		part.cost = lot.cost * quantity / lot.quantity
+		matched = append(matched, part)
+		lot.cost -= part.cost
+		lot.quantity -= quantity
+		quantity = 0
+	}
+
+	// Sending more than was received means part of the history wasn't indexed
+	if quantity > pnlDust {
+		a.incomplete = true
+		return matched, quantity
+	}
+	return matched, 0
+}
+
+func (a *assetLedger) holdings() (quantity, cost float64) {
+	for _, lot := range a.lots {
+		quantity += lot.quantity
+		cost += lot.cost
+	}
+	return quantity, cost
+}
+
+// Disposal is part of an asset leaving the portfolio, matched against one cost lot
+type Disposal struct {
+	Symbol       string
+	Network      string
+	TokenAddress string
+	TxHash       string
+	Gas          bool // spent on a transaction fee
+	Quantity     float64
+	AcquiredAt   time.Time // zero when unmatched or pooled under average cost
+	Unmatched    bool      // no indexed lot covered this quantity
+	DisposedAt   time.Time
+	Proceeds     float64
+	CostBasis    float64
+	// Known flags are false when no price was recorded, or no lot covered the quantity
+	ProceedsKnown bool
+	CostKnown     bool
+}
+
+// pnlLedger replays transactions, oldest first, into per-asset ledgers
+type pnlLedger struct {
+	method    string
+	from      time.Time
+	priceAt   func(symbol string, t time.Time) (float64, bool)
+	assets    map[string]*assetLedger
+	order     []string
+	fees      float64
+	disposals []Disposal // disposals from the reporting period
+}
+
+func newPnLLedger(method string, from time.Time, priceAt func(symbol string, t time.Time) (float64, bool)) *pnlLedger {
+	return &pnlLedger{
+		method:  method,
+		from:    from,
+		priceAt: priceAt,
+		assets:  make(map[string]*assetLedger),
+	}
+}
+
+func (l *pnlLedger) asset(network, tokenAddress, symbol string) *assetLedger {
+	key := assetKey(network, tokenAddress)
+	asset, ok := l.assets[key]
+	if !ok {
+		asset = &assetLedger{symbol: symbol, network: network, tokenAddress: tokenAddress, method: l.method}
+		l.assets[key] = asset
+		l.order = append(l.order, key)
+	}
+	return asset
+}
+
+func (l *pnlLedger) apply(tx models.Transaction, nativeSymbol string) {
+	// Gas is paid whether or not the transaction succeeded
+	if fee, err := strconv.ParseFloat(tx.Fee, 64); err == nil && fee > 0 {
+		native := l.asset(tx.Network, "", nativeSymbol)
+		if price, ok := l.dispose(native, fee, tx, true); ok && !tx.Timestamp.Before(l.from) {
+			l.fees += fee * price
+		}
+	}
+
+	if tx.Status == TransactionStatusFailed || tx.TokenSymbol == "" {
+		return
+	}
+	amount, err := strconv.ParseFloat(tx.Amount, 64)
+	if err != nil || amount <= 0 {
+		return
+	}
+
+	asset := l.asset(tx.Network, tx.TokenAddress, tx.TokenSymbol)
+	switch tx.Direction {
+	case DirectionIn:
+		price, ok := l.priceAt(asset.symbol, tx.Timestamp)
+		if !ok {
+			asset.incomplete = true
+		}
+		asset.acquire(costLot{quantity: amount, cost: amount * price, acquired: tx.Timestamp, priced: ok})
+	case DirectionOut:
+		l.dispose(asset, amount, tx, false)
+	}
+	// Moves between the portfolio's own addresses change nothing
+}
+
+// dispose removes quantity from an asset at the price of the time, realizing the
+// gain if the disposal falls in the reporting period. It returns the price used.
+func (l *pnlLedger) dispose(asset *assetLedger, quantity float64, tx models.Transaction, gas bool) (float64, bool) {
+	matched, unmatched := asset.dispose(quantity)
+	price, ok := l.priceAt(asset.symbol, tx.Timestamp)
+	if !ok {
+		asset.incomplete = true
+	}
+	if tx.Timestamp.Before(l.from) {
+		return price, ok
+	}
+
+	record := func(lot costLot, unmatched bool) {
+		l.disposals = append(l.disposals, Disposal{
+			Symbol:        asset.symbol,
+			Network:       asset.network,
+			TokenAddress:  asset.tokenAddress,
+			TxHash:        tx.TxHash,
+			Gas:           gas,
+			Quantity:      lot.quantity,
+			AcquiredAt:    lot.acquired,
+			DisposedAt:    tx.Timestamp,
+			Proceeds:      lot.quantity * price,
+			CostBasis:     lot.cost,
+			Unmatched:     unmatched,
+			ProceedsKnown: ok,
+			CostKnown:     !unmatched && lot.priced,
+		})
+	}
+	cost := 0.0
+	for _, lot := range matched {
+		cost += lot.cost
+		record(lot, false)
+	}
+	if unmatched > 0 {
+		record(costLot{quantity: unmatched}, true)
+	}
+
+	if ok {
+		asset.realized += quantity*price - cost
+	}
+	return price, ok
+}
+
+// report values the open lots and totals the ledgers
+func (l *pnlLedger) report(priceOf func(asset *assetLedger) (float64, bool)) *PortfolioPnL {
+	pnl := &PortfolioPnL{Assets: []AssetPnL{}}
+
+	var costBasis, value, realized, unrealized float64
+	for _, key := range l.order {
+		asset := l.assets[key]
+		quantity, cost := asset.holdings()
+		if quantity <= pnlDust && asset.realized == 0 {
+			continue
+		}
+
+		entry := AssetPnL{
+			Symbol:       asset.symbol,
+			Network:      asset.network,
+			TokenAddress: asset.tokenAddress,
+			Incomplete:   asset.incomplete,
+			quantity:     quantity,
+			costBasis:    cost,
+			realized:     asset.realized,
+		}
+		if quantity > pnlDust {
+			if price, ok := priceOf(asset); ok {
+				entry.Price = formatPrice(price)
+				entry.value = quantity * price
+				entry.unrealized = entry.value - cost
+			} else {
+				entry.Incomplete = true
+			}
+			entry.AverageCost = formatUSD(cost / quantity)
+		} else {
+			entry.quantity = 0
+			entry.costBasis = 0
+			entry.AverageCost = formatUSD(0)
+		}
+
+		entry.Quantity = formatQuantity(entry.quantity)
+		entry.CostBasis = formatUSD(entry.costBasis)
+		entry.CurrentValue = formatUSD(entry.value)
+		entry.RealizedPnL = formatUSD(entry.realized)
+		entry.UnrealizedPnL = formatUSD(entry.unrealized)
+
+		costBasis += entry.costBasis
+		value += entry.value
+		realized += entry.realized
+		unrealized += entry.unrealized
+		pnl.Incomplete = pnl.Incomplete || entry.Incomplete
+		pnl.Assets = append(pnl.Assets, entry)
+	}
+
+	sort.SliceStable(pnl.Assets, func(i, j int) bool {
+		return pnl.Assets[i].value > pnl.Assets[j].value
+	})
+
+	pnl.CostBasis = formatUSD(costBasis)
+	pnl.CurrentValue = formatUSD(value)
+	pnl.RealizedPnL = formatUSD(realized)
+	pnl.UnrealizedPnL = formatUSD(unrealized)
+	pnl.TotalPnL = formatUSD(realized + unrealized)
+	pnl.FeesPaid = formatUSD(l.fees)
+	return pnl
+}
+
+// assetKey identifies an asset by network and token address ("" for the native token)
+func assetKey(network, tokenAddress string) string {
+	return network + ":" + strings.ToLower(tokenAddress)
+}
+
+func formatUSD(value float64) string {
+	if math.Abs(value) < 0.005 {
+		value = 0 // no "-0.00"
+	}
+	return strconv.FormatFloat(value, 'f', 2, 64)
+}
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"web3-portfolio-dashboard/backend/internal/models"
+)
+
+var pnlStart = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// pnlPrices returns a priceAt func from symbol -> day offset -> price
+func pnlPrices(prices map[string]map[int]float64) func(string, time.Time) (float64, bool) {
+	return func(symbol string, t time.Time) (float64, bool) {
+		price, ok := prices[symbol][int(t.Sub(pnlStart).Hours()/24)]
+		return price, ok
+	}
+}
+
+func pnlTransfer(day int, direction, amount string) models.Transaction {
+	return models.Transaction{
+		Network:       "ethereum",
+		TokenAddress:  "0xToken",
+		TokenSymbol:   "UNI",
+		TokenDecimals: 18,
+		Direction:     direction,
+		Status:        TransactionStatusSuccess,
+		Amount:        amount,
+		Fee:           "0",
+		Timestamp:     pnlStart.AddDate(0, 0, day),
+	}
+}
+
+func TestPnLCostBasisMethods(t *testing.T) {
+	// Buy 10 at $1, buy 10 at $3, sell 10 at $4, value the remaining 10 at $5
+	transactions := []models.Transaction{
+		pnlTransfer(0, DirectionIn, "10"),
+		pnlTransfer(1, DirectionIn, "10"),
+		pnlTransfer(2, DirectionOut, "10"),
+		pnlTransfer(3, DirectionSelf, "10"),
+	}
+	priceAt := pnlPrices(map[string]map[int]float64{"UNI": {0: 1, 1: 3, 2: 4}})
+	current := func(*assetLedger) (float64, bool) { return 5, true }
+
+	for method, want := range map[string][4]string{
+		//                 cost basis, realized, unrealized, total
+		CostBasisFIFO:    {"30.00", "30.00", "20.00", "50.00"},
+		CostBasisLIFO:    {"10.00", "10.00", "40.00", "50.00"},
+		CostBasisAverage: {"20.00", "20.00", "30.00", "50.00"},
+	} {
+		ledger := newPnLLedger(method, time.Time{}, priceAt)
+		for _, tx := range transactions {
+			ledger.apply(tx, "ETH")
+		}
+		pnl := ledger.report(current)
+
+		require.Equal(t, want[0], pnl.CostBasis, method)
+		require.Equal(t, want[1], pnl.RealizedPnL, method)
+		require.Equal(t, want[2], pnl.UnrealizedPnL, method)
+		require.Equal(t, want[3], pnl.TotalPnL, method)
+		require.Equal(t, "50.00", pnl.CurrentValue, method)
+		require.False(t, pnl.Incomplete, method)
+
+		require.Len(t, pnl.Assets, 1)
+		require.Equal(t, "10", pnl.Assets[0].Quantity)
+	}
+}
+
+func TestPnLGasAndReportingPeriod(t *testing.T) {
+	receive := pnlTransfer(0, DirectionIn, "1")
+	receive.TokenAddress, receive.TokenSymbol = "", "ETH"
+
+	// Gas on day 1 falls before the reporting period, gas on day 2 inside it
+	early := pnlTransfer(1, DirectionOut, "0")
+	early.Fee = "0.1"
+	late := pnlTransfer(2, DirectionOut, "0")
+	late.Fee = "0.1"
+	late.Status = TransactionStatusFailed
+
+	priceAt := pnlPrices(map[string]map[int]float64{"ETH": {0: 1000, 1: 2000, 2: 3000}})
+	ledger := newPnLLedger(CostBasisFIFO, pnlStart.AddDate(0, 0, 2), priceAt)
+	for _, tx := range []models.Transaction{receive, early, late} {
+		ledger.apply(tx, "ETH")
+	}
+	pnl := ledger.report(func(*assetLedger) (float64, bool) { return 3000, true })
+
+	require.Equal(t, "300.00", pnl.FeesPaid)
+	require.Equal(t, "200.00", pnl.RealizedPnL) // 0.1 ETH bought at 1000 spent at 3000
+	require.Equal(t, "800.00", pnl.CostBasis)
+	require.Equal(t, "1600.00", pnl.UnrealizedPnL)
+	require.Equal(t, "0.8", pnl.Assets[0].Quantity)
+}
+
+func TestPnLFlagsIncompleteHistory(t *testing.T) {
+	priceAt := pnlPrices(map[string]map[int]float64{"UNI": {1: 2}})
+	ledger := newPnLLedger(CostBasisFIFO, time.Time{}, priceAt)
+
+	// Received with no recorded price, then sent more than was received
+	ledger.apply(pnlTransfer(0, DirectionIn, "5"), "ETH")
+	ledger.apply(pnlTransfer(1, DirectionOut, "8"), "ETH")
+	pnl := ledger.report(func(*assetLedger) (float64, bool) { return 0, false })
+
+	require.True(t, pnl.Incomplete)
+	require.Equal(t, "16.00", pnl.RealizedPnL)
+	require.Equal(t, "0", pnl.Assets[0].Quantity)
+}
+
+func TestParseCostBasisMethod(t *testing.T) {
+	for value, want := range map[string]string{"": CostBasisFIFO, "LIFO": CostBasisLIFO, "avg": CostBasisAverage} {
+		got, err := ParseCostBasisMethod(value)
+		require.NoError(t, err)
+		require.Equal(t, want, got)
+	}
+	_, err := ParseCostBasisMethod("hifo")
+	require.Error(t, err)
+}
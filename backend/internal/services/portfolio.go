@@ -1,20 +1,28 @@
 package services
 
 import (
+	"context"
 	"fmt"
+	"log"
 	"math/big"
+	"sort"
 	"strconv"
 	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
 	"web3-portfolio-dashboard/backend/internal/models"
 )
 
 type PortfolioService struct {
-	db          *gorm.DB
-	web3Service *Web3Service
+	db           *gorm.DB
+	web3Service  *Web3Service
+	priceHistory *PriceHistoryService
+	indexer      *TransactionIndexer
+	refresher    *BalanceRefresher
+	events       *EventHub
 }
 
 type PortfolioSummary struct {
@@ -26,17 +34,26 @@ type PortfolioSummary struct {
 	NetworkCount      int               `json:"network_count"`
 	TopAssets         []PortfolioAsset  `json:"top_assets"`
 	NetworkAllocation map[string]string `json:"network_allocation"`
+	CostBasis         string            `json:"cost_basis"` // FIFO
+	RealizedPnL       string            `json:"realized_pnl"`
+	UnrealizedPnL     string            `json:"unrealized_pnl"`
 }
 
 type PortfolioAsset struct {
-	Symbol    string `json:"symbol"`
-	Name      string `json:"name"`
-	Amount    string `json:"amount"`
-	Value     string `json:"value"`
-	Change24h string `json:"change_24h"`
-	Network   string `json:"network"`
+	Symbol        string `json:"symbol"`
+	Name          string `json:"name"`
+	Amount        string `json:"amount"`
+	Value         string `json:"value"`
+	Change24h     string `json:"change_24h"`
+	Network       string `json:"network"`
+	CostBasis     string `json:"cost_basis"`
+	RealizedPnL   string `json:"realized_pnl"`
+	UnrealizedPnL string `json:"unrealized_pnl"`
 }
 
+// topAssetCount is how many holdings the summary lists
+const topAssetCount = 5
+
 type PortfolioPerformance struct {
 	Period      string                 `json:"period"`
 	Data        []PerformanceDataPoint `json:"data"`
@@ -81,13 +98,21 @@ type HistoryDataPoint struct {
 	Volume string `json:"volume"`
 }
 
-func NewPortfolioService(db *gorm.DB, web3 *Web3Service) *PortfolioService {
+func NewPortfolioService(db *gorm.DB, web3 *Web3Service, priceHistory *PriceHistoryService, indexer *TransactionIndexer, refresher *BalanceRefresher) *PortfolioService {
 	return &PortfolioService{
-		db:          db,
-		web3Service: web3,
+		db:           db,
+		web3Service:  web3,
+		priceHistory: priceHistory,
+		indexer:      indexer,
+		refresher:    refresher,
 	}
 }
 
+// SetEventHub makes balance refreshes push to subscribed clients
+func (s *PortfolioService) SetEventHub(events *EventHub) {
+	s.events = events
+}
+
 // GetPortfolios retrieves all portfolios for a user
 func (s *PortfolioService) GetPortfolios(userID string) ([]models.Portfolio, error) {
 	userUUID, err := uuid.Parse(userID)
@@ -278,6 +303,11 @@ func (s *PortfolioService) DeleteAddress(userID, portfolioID, addressID string)
 		return fmt.Errorf("failed to delete address: %w", err)
 	}
 
+	// Forget how far the address was indexed, so adding it back starts over
+	if err := s.db.Where("address_id = ?", addressUUID).Delete(&models.SyncCursor{}).Error; err != nil {
+		return fmt.Errorf("failed to delete sync cursor: %w", err)
+	}
+
 	return nil
 }
 
@@ -298,65 +328,147 @@ func (s *PortfolioService) GetPortfolioBalances(userID, portfolioID string) ([]m
 	return balances, nil
 }
 
-// RefreshPortfolioBalances updates balances for all addresses in a portfolio
-func (s *PortfolioService) RefreshPortfolioBalances(userID, portfolioID string) ([]models.Balance, error) {
+// RefreshPortfolioBalances reads fresh balances for all addresses in a portfolio
+// and saves those that were read in full. Addresses that failed are reported
+// alongside the balances.
+func (s *PortfolioService) RefreshPortfolioBalances(ctx context.Context, userID, portfolioID string) (*BalanceRefresh, error) {
 	addresses, err := s.GetPortfolioAddresses(userID, portfolioID)
 	if err != nil {
 		return nil, err
 	}
 
-	var allBalances []models.Balance
+	refresh := s.refresher.Refresh(ctx, addresses)
+	for _, failed := range refresh.Errors {
+		log.Printf("Failed to refresh %s on %s: %s", failed.Address, failed.Network, failed.Error)
+	}
 
-	for _, address := range addresses {
-		// Get native token balance
-		balance, err := s.web3Service.GetBalance(address.Address, address.Network)
-		if err != nil {
-			continue // Skip failed addresses
+	if err := s.saveBalances(refresh, time.Now()); err != nil {
+		return nil, err
+	}
+
+	// Record the new value for performance history
+	if portfolioUUID, err := uuid.Parse(portfolioID); err == nil {
+		if _, err := s.RecordSnapshot(portfolioUUID); err != nil {
+			log.Printf("Failed to snapshot portfolio %s: %v", portfolioID, err)
 		}
+	}
+	if userUUID, err := uuid.Parse(userID); err == nil {
+		s.events.PublishToUser(userUUID, Event{Type: EventBalances, PortfolioID: portfolioID, Data: refresh})
+	}
 
-		// Get token balances
-		tokenBalances, err := s.web3Service.GetTokenBalances(address.Address, address.Network)
-		if err != nil {
-			continue
+	return refresh, nil
+}
+
+// RefreshAllBalances refreshes the balances of every portfolio and returns how
+// many portfolios were refreshed. Addresses that fail are logged and skipped.
+func (s *PortfolioService) RefreshAllBalances(ctx context.Context) (int, error) {
+	var addresses []models.Address
+	if err := s.db.Where("is_active = ?", true).Order("portfolio_id").Find(&addresses).Error; err != nil {
+		return 0, fmt.Errorf("failed to get addresses: %w", err)
+	}
+
+	refreshed, err := s.refreshByPortfolio(ctx, addresses)
+	for _, portfolioID := range refreshed {
+		if _, err := s.RecordSnapshot(portfolioID); err != nil {
+			log.Printf("Failed to snapshot portfolio %s: %v", portfolioID, err)
 		}
+	}
+	return len(refreshed), err
+}
 
-		// Save native token balance
-		if balance.Cmp(big.NewInt(0)) > 0 {
-			balanceRecord := models.Balance{
-				AddressID:    address.ID,
-				TokenAddress: "", // Native token
-				Symbol:       getNativeTokenSymbol(address.Network),
-				Name:         getNativeTokenName(address.Network),
-				Amount:       balance.String(),
-				Decimals:     18,
-				UpdatedAt:    time.Now(),
-			}
-			allBalances = append(allBalances, balanceRecord)
+// RefreshAddresses refreshes the balances of some addresses, such as those a
+// new block touched, and returns how many portfolios they were in. Unlike a
+// full refresh it records no snapshot.
+func (s *PortfolioService) RefreshAddresses(ctx context.Context, addresses []models.Address) (int, error) {
+	sorted := append([]models.Address(nil), addresses...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].PortfolioID.String() < sorted[j].PortfolioID.String()
+	})
+	refreshed, err := s.refreshByPortfolio(ctx, sorted)
+	return len(refreshed), err
+}
+
+// refreshByPortfolio refreshes addresses ordered by portfolio, one portfolio
+// at a time, and returns the portfolios whose balances were saved
+func (s *PortfolioService) refreshByPortfolio(ctx context.Context, addresses []models.Address) ([]uuid.UUID, error) {
+	var refreshed []uuid.UUID
+	for start := 0; start < len(addresses); {
+		end := start + 1
+		for end < len(addresses) && addresses[end].PortfolioID == addresses[start].PortfolioID {
+			end++
 		}
+		portfolioID := addresses[start].PortfolioID
+		portfolioAddresses := addresses[start:end]
+		start = end
 
-		// Save token balances
-		for _, token := range tokenBalances {
-			balanceRecord := models.Balance{
-				AddressID:    address.ID,
-				TokenAddress: token.TokenAddress,
-				Symbol:       token.Symbol,
-				Name:         token.Name,
-				Amount:       token.Amount,
-				Decimals:     token.Decimals,
-				Price:        token.Price,
-				Value:        token.Value,
-				UpdatedAt:    time.Now(),
-			}
-			allBalances = append(allBalances, balanceRecord)
+		if err := ctx.Err(); err != nil {
+			return refreshed, err
 		}
+		refresh := s.refresher.Refresh(ctx, portfolioAddresses)
+		for _, failed := range refresh.Errors {
+			log.Printf("Failed to refresh %s on %s: %s", failed.Address, failed.Network, failed.Error)
+		}
+		if err := s.saveBalances(refresh, time.Now()); err != nil {
+			log.Printf("❌ Failed to save balances of portfolio %s: %v", portfolioID, err)
+			continue
+		}
+		s.publishRefresh(portfolioID, refresh)
+		refreshed = append(refreshed, portfolioID)
 	}
+	return refreshed, nil
+}
 
-	// Save to database
-	for _, balance := range allBalances {
-		s.db.Save(&balance)
+// publishRefresh pushes refreshed balances to the portfolio owner's clients
+func (s *PortfolioService) publishRefresh(portfolioID uuid.UUID, refresh *BalanceRefresh) {
+	if s.events == nil {
+		return
+	}
+	var portfolio models.Portfolio
+	if err := s.db.Select("id", "user_id").First(&portfolio, "id = ?", portfolioID).Error; err != nil {
+		log.Printf("Failed to get owner of portfolio %s: %v", portfolioID, err)
+		return
 	}
+	s.events.PublishToUser(portfolio.UserID, Event{Type: EventBalances, PortfolioID: portfolioID.String(), Data: refresh})
+}
+
+// saveBalances replaces the stored balances of the addresses that were read in
+// full, in one transaction: holdings are upserted, holdings that went to zero
+// are deleted and each address is marked as refreshed
+func (s *PortfolioService) saveBalances(refresh *BalanceRefresh, refreshedAt time.Time) error {
+	held := make(map[uuid.UUID][]string)
+	for _, balance := range refresh.Balances {
+		held[balance.AddressID] = append(held[balance.AddressID], balance.TokenAddress)
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if len(refresh.Balances) > 0 {
+			err := tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "address_id"}, {Name: "network"}, {Name: "token_address"}},
+				DoUpdates: clause.AssignmentColumns([]string{"symbol", "name", "amount", "decimals", "price", "value", "updated_at"}),
+			}).Create(&refresh.Balances).Error
+			if err != nil {
+				return fmt.Errorf("failed to save balances: %w", err)
+			}
+		}
 
-	return allBalances, nil
+		for _, addressID := range refresh.refreshed {
+			// An address that holds nothing loses every row; an empty NOT IN list
+			// renders as NOT IN (NULL) on some drivers and would match none
+			var stale *gorm.DB
+			if tokens := held[addressID]; len(tokens) == 0 {
+				stale = tx.Where("address_id = ?", addressID)
+			} else {
+				stale = tx.Where("address_id = ? AND token_address NOT IN ?", addressID, tokens)
+			}
+			if err := stale.Delete(&models.Balance{}).Error; err != nil {
+				return fmt.Errorf("failed to delete stale balances: %w", err)
+			}
+			if err := tx.Model(&models.Address{}).Where("id = ?", addressID).UpdateColumn("last_refreshed_at", refreshedAt).Error; err != nil {
+				return fmt.Errorf("failed to mark address refreshed: %w", err)
+			}
+		}
+		return nil
+	})
 }
 
 // GetPortfolioTransactions retrieves transactions for a portfolio
@@ -385,38 +497,27 @@ func (s *PortfolioService) GetPortfolioTransactions(userID, portfolioID string,
 	return transactions, total, nil
 }
 
-// RefreshPortfolioTransactions fetches and stores new transactions
-func (s *PortfolioService) RefreshPortfolioTransactions(userID, portfolioID string) ([]models.Transaction, error) {
+// RefreshPortfolioTransactions indexes new transfers of every address in a portfolio
+// and returns the ones stored
+func (s *PortfolioService) RefreshPortfolioTransactions(ctx context.Context, userID, portfolioID string) ([]models.Transaction, error) {
 	addresses, err := s.GetPortfolioAddresses(userID, portfolioID)
 	if err != nil {
 		return nil, err
 	}
 
-	portfolioUUID, _ := uuid.Parse(portfolioID)
-	var allTransactions []models.Transaction
-
-	// This is a simplified implementation
-	// In production, you'd want to use blockchain APIs to fetch actual transactions
-	for _, address := range addresses {
-		// Mock transaction data
-		transaction := models.Transaction{
-			PortfolioID:  portfolioUUID,
-			TxHash:       "0x" + generateRandomHash(),
-			Network:      address.Network,
-			TokenAddress: "",
-			Amount:       "0.1",
-			BlockNumber:  12345678,
-			Timestamp:    time.Now(),
-		}
-		allTransactions = append(allTransactions, transaction)
-	}
+	return s.indexer.SyncAddresses(ctx, addresses)
+}
 
-	// Save to database
-	for _, transaction := range allTransactions {
-		s.db.Save(&transaction)
+// SyncAllTransactions indexes new transfers of every active address and returns
+// how many transactions were stored
+func (s *PortfolioService) SyncAllTransactions(ctx context.Context) (int, error) {
+	var addresses []models.Address
+	if err := s.db.Where("is_active = ?", true).Find(&addresses).Error; err != nil {
+		return 0, fmt.Errorf("failed to get addresses: %w", err)
 	}
 
-	return allTransactions, nil
+	stored, err := s.indexer.SyncAddresses(ctx, addresses)
+	return len(stored), err
 }
 
 // GetPortfolioSummary gets a summary of portfolio performance
@@ -453,13 +554,122 @@ func (s *PortfolioService) GetPortfolioSummary(userID, portfolioID string) (*Por
 	summary.TotalValue = totalValue.String()
 	summary.NetworkCount = len(networkMap)
 
+	now := time.Now()
+	summary.TotalChange24h = s.holdingsChange(balances, now.Add(-24*time.Hour))
+	summary.TotalChange7d = s.holdingsChange(balances, now.AddDate(0, 0, -7))
+	summary.TotalChange30d = s.holdingsChange(balances, now.AddDate(0, 0, -30))
+
+	// The summary uses FIFO lots; the pnl endpoint lets callers pick another method
+	pnl, err := s.GetPortfolioPnL(userID, portfolioID, PnLOptions{Method: CostBasisFIFO})
+	if err != nil {
+		return nil, err
+	}
+	summary.CostBasis = pnl.CostBasis
+	summary.RealizedPnL = pnl.RealizedPnL
+	summary.UnrealizedPnL = pnl.UnrealizedPnL
+	summary.TopAssets = s.topAssets(balances, pnl, now)
+
 	return summary, nil
 }
 
-// GetPortfolioPerformance gets performance data for a portfolio
+// topAssets returns the largest holdings by value with their 24h price change and PnL
+func (s *PortfolioService) topAssets(balances []models.Balance, pnl *PortfolioPnL, now time.Time) []PortfolioAsset {
+	assetPnL := make(map[string]AssetPnL, len(pnl.Assets))
+	for _, asset := range pnl.Assets {
+		assetPnL[assetKey(asset.Network, asset.TokenAddress)] = asset
+	}
+
+	sorted := make([]models.Balance, len(balances))
+	copy(sorted, balances)
+	valueOf := func(balance models.Balance) float64 {
+		value, _ := strconv.ParseFloat(balance.Value, 64)
+		return value
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return valueOf(sorted[i]) > valueOf(sorted[j])
+	})
+	if len(sorted) > topAssetCount {
+		sorted = sorted[:topAssetCount]
+	}
+
+	assets := make([]PortfolioAsset, 0, len(sorted))
+	for _, balance := range sorted {
+		asset := PortfolioAsset{
+			Symbol:        balance.Symbol,
+			Name:          balance.Name,
+			Amount:        balance.Amount,
+			Value:         balance.Value,
+			Change24h:     "0",
+			Network:       balance.Address.Network,
+			CostBasis:     "0.00",
+			RealizedPnL:   "0.00",
+			UnrealizedPnL: "0.00",
+		}
+
+		price, err := strconv.ParseFloat(balance.Price, 64)
+		if err == nil {
+			if pastPrice, ok := s.priceHistory.PriceAt(balance.Symbol, now.Add(-24*time.Hour)); ok {
+				if change, ok := percentChange(pastPrice, price); ok {
+					asset.Change24h = formatPercent(change)
+				}
+			}
+		}
+
+		if entry, ok := assetPnL[assetKey(balance.Address.Network, balance.TokenAddress)]; ok {
+			asset.CostBasis = entry.CostBasis
+			asset.RealizedPnL = entry.RealizedPnL
+			asset.UnrealizedPnL = entry.UnrealizedPnL
+		}
+		assets = append(assets, asset)
+	}
+	return assets
+}
+
+// holdingsChange returns how the value of the current holdings changed since
+// a point in time, as a signed percentage. Assets without a recorded price at
+// that time count as unchanged.
+func (s *PortfolioService) holdingsChange(balances []models.Balance, since time.Time) string {
+	var current, previous float64
+	for _, balance := range balances {
+		value, err := strconv.ParseFloat(balance.Value, 64)
+		if err != nil || value == 0 {
+			continue
+		}
+		price, err := strconv.ParseFloat(balance.Price, 64)
+		if err != nil || price == 0 {
+			continue
+		}
+
+		current += value
+		if pastPrice, ok := s.priceHistory.PriceAt(balance.Symbol, since); ok {
+			previous += value / price * pastPrice
+		} else {
+			previous += value
+		}
+	}
+
+	if previous == 0 {
+		return "0"
+	}
+	return fmt.Sprintf("%+.2f%%", (current-previous)/previous*100)
+}
+
+// GetPortfolioPerformance gets performance data for a portfolio from its recorded snapshots
 func (s *PortfolioService) GetPortfolioPerformance(userID, portfolioID, period string) (*PortfolioPerformance, error) {
-	// This is a simplified implementation
-	// In production, you'd calculate actual performance from historical data
+	if _, err := s.GetPortfolio(userID, portfolioID); err != nil {
+		return nil, err
+	}
+
+	since, err := PeriodStart(period, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	points, err := s.snapshotSeries(portfolioID, since)
+	if err != nil {
+		return nil, err
+	}
+
 	performance := &PortfolioPerformance{
 		Period:      period,
 		TotalReturn: "0",
@@ -468,15 +678,27 @@ func (s *PortfolioService) GetPortfolioPerformance(userID, portfolioID, period s
 		Data:        []PerformanceDataPoint{},
 	}
 
-	// Generate mock performance data
-	for i := 0; i < 30; i++ {
-		date := time.Now().AddDate(0, 0, -i)
-		dataPoint := PerformanceDataPoint{
-			Date:   date.Format("2006-01-02"),
-			Value:  "1000.00",
-			Change: "0.00",
+	series, layout := seriesFor(period, points)
+	for i, point := range series {
+		change := 0.0
+		if i > 0 {
+			change, _ = percentChange(series[i-1].Value, point.Value)
+		}
+		performance.Data = append(performance.Data, PerformanceDataPoint{
+			Date:   point.Time.Format(layout),
+			Value:  strconv.FormatFloat(point.Value, 'f', 2, 64),
+			Change: strconv.FormatFloat(change, 'f', 2, 64),
+		})
+	}
+
+	if len(points) > 1 {
+		if totalReturn, ok := percentChange(points[0].Value, points[len(points)-1].Value); ok {
+			performance.TotalReturn = formatPercent(totalReturn)
 		}
-		performance.Data = append(performance.Data, dataPoint)
+	}
+	if best, worst, ok := bestAndWorstDay(dailyCloses(points)); ok {
+		performance.BestDay = formatPercent(best)
+		performance.WorstDay = formatPercent(worst)
 	}
 
 	return performance, nil
@@ -519,60 +741,40 @@ func (s *PortfolioService) GetPortfolioAllocation(userID, portfolioID string) (*
 	return allocation, nil
 }
 
-// GetPortfolioHistory gets historical data for a portfolio
+// GetPortfolioHistory gets the value history of a portfolio from its recorded snapshots
 func (s *PortfolioService) GetPortfolioHistory(userID, portfolioID, period string) (*PortfolioHistory, error) {
+	if _, err := s.GetPortfolio(userID, portfolioID); err != nil {
+		return nil, err
+	}
+
+	since, err := PeriodStart(period, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	points, err := s.snapshotSeries(portfolioID, since)
+	if err != nil {
+		return nil, err
+	}
+
 	history := &PortfolioHistory{
 		Period: period,
 		Data:   []HistoryDataPoint{},
 	}
 
-	// Generate mock historical data
-	for i := 0; i < 30; i++ {
-		date := time.Now().AddDate(0, 0, -i)
-		dataPoint := HistoryDataPoint{
-			Date:   date.Format("2006-01-02"),
-			Value:  "1000.00",
-			Change: "0.00",
-			Volume: "100.00",
+	series, layout := seriesFor(period, points)
+	for i, point := range series {
+		change := 0.0
+		if i > 0 {
+			change = point.Value - series[i-1].Value
 		}
-		history.Data = append(history.Data, dataPoint)
+		history.Data = append(history.Data, HistoryDataPoint{
+			Date:   point.Time.Format(layout),
+			Value:  strconv.FormatFloat(point.Value, 'f', 2, 64),
+			Change: strconv.FormatFloat(change, 'f', 2, 64),
+			Volume: "0", // Transfer volume isn't priced yet
+		})
 	}
 
 	return history, nil
 }
-
-// Helper functions
-func getNativeTokenSymbol(network string) string {
-	switch network {
-	case "ethereum":
-		return "ETH"
-	case "polygon":
-		return "MATIC"
-	case "bsc":
-		return "BNB"
-	case "arbitrum":
-		return "ETH"
-	default:
-		return "NATIVE"
-	}
-}
-
-func getNativeTokenName(network string) string {
-	switch network {
-	case "ethereum":
-		return "Ethereum"
-	case "polygon":
-		return "Polygon"
-	case "bsc":
-		return "Binance Smart Chain"
-	case "arbitrum":
-		return "Arbitrum"
-	default:
-		return "Native Token"
-	}
-}
-
-func generateRandomHash() string {
-	// Simplified random hash generation
-	return fmt.Sprintf("%064x", time.Now().UnixNano())
-}
@@ -0,0 +1,189 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+
+	"web3-portfolio-dashboard/backend/internal/models"
+)
+
+// SnapshotAsset is the value of one holding in a portfolio snapshot
+type SnapshotAsset struct {
+	Symbol       string `json:"symbol"`
+	Network      string `json:"network"`
+	TokenAddress string `json:"token_address"`
+	Amount       string `json:"amount"`
+	Price        string `json:"price"`
+	Value        string `json:"value"`
+}
+
+// valuePoint is a portfolio value at a point in time
+type valuePoint struct {
+	Time  time.Time
+	Value float64
+}
+
+// PeriodStart returns where a performance period (24h, 7d, 30d, 1y or all) begins.
+// "all" starts at the zero time.
+func PeriodStart(period string, now time.Time) (time.Time, error) {
+	switch period {
+	case "24h":
+		return now.Add(-24 * time.Hour), nil
+	case "7d":
+		return now.AddDate(0, 0, -7), nil
+	case "30d":
+		return now.AddDate(0, 0, -30), nil
+	case "1y":
+		return now.AddDate(-1, 0, 0), nil
+	case "all":
+		return time.Time{}, nil
+	default:
+		return time.Time{}, fmt.Errorf("invalid period: %s (expected 24h, 7d, 30d, 1y or all)", period)
+	}
+}
+
+// RecordSnapshot stores the current value of a portfolio, from its stored balances
+func (s *PortfolioService) RecordSnapshot(portfolioID uuid.UUID) (*models.PortfolioSnapshot, error) {
+	var balances []models.Balance
+	err := s.db.Preload("Address").Where("address_id IN (SELECT id FROM addresses WHERE portfolio_id = ?)", portfolioID).Find(&balances).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get balances: %w", err)
+	}
+
+	total := 0.0
+	assets := make([]SnapshotAsset, 0, len(balances))
+	for _, balance := range balances {
+		if value, err := strconv.ParseFloat(balance.Value, 64); err == nil {
+			total += value
+		}
+		assets = append(assets, SnapshotAsset{
+			Symbol:       balance.Symbol,
+			Network:      balance.Address.Network,
+			TokenAddress: balance.TokenAddress,
+			Amount:       balance.Amount,
+			Price:        balance.Price,
+			Value:        balance.Value,
+		})
+	}
+
+	assetsJSON, err := json.Marshal(assets)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize assets: %w", err)
+	}
+
+	snapshot := &models.PortfolioSnapshot{
+		PortfolioID: portfolioID,
+		TotalValue:  strconv.FormatFloat(total, 'f', 8, 64),
+		Assets:      models.JSON(assetsJSON),
+		Timestamp:   time.Now().UTC(),
+	}
+	if err := s.db.Create(snapshot).Error; err != nil {
+		return nil, fmt.Errorf("failed to save portfolio snapshot: %w", err)
+	}
+
+	return snapshot, nil
+}
+
+// RecordAllSnapshots stores the current value of every portfolio
+func (s *PortfolioService) RecordAllSnapshots(ctx context.Context) (int, error) {
+	var portfolioIDs []uuid.UUID
+	if err := s.db.Model(&models.Portfolio{}).Pluck("id", &portfolioIDs).Error; err != nil {
+		return 0, fmt.Errorf("failed to get portfolios: %w", err)
+	}
+
+	recorded := 0
+	for _, portfolioID := range portfolioIDs {
+		if err := ctx.Err(); err != nil {
+			return recorded, err
+		}
+		if _, err := s.RecordSnapshot(portfolioID); err != nil {
+			log.Printf("❌ Failed to snapshot portfolio %s: %v", portfolioID, err)
+			continue
+		}
+		recorded++
+	}
+	return recorded, nil
+}
+
+// snapshotSeries loads a portfolio's recorded values since a point in time, oldest first
+func (s *PortfolioService) snapshotSeries(portfolioID string, since time.Time) ([]valuePoint, error) {
+	portfolioUUID, err := uuid.Parse(portfolioID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid portfolio ID: %w", err)
+	}
+
+	var snapshots []models.PortfolioSnapshot
+	err = s.db.Select("total_value", "timestamp").
+		Where("portfolio_id = ? AND timestamp >= ?", portfolioUUID, since).
+		Order("timestamp").
+		Find(&snapshots).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get portfolio snapshots: %w", err)
+	}
+
+	points := make([]valuePoint, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		value, err := strconv.ParseFloat(snapshot.TotalValue, 64)
+		if err != nil {
+			continue
+		}
+		points = append(points, valuePoint{Time: snapshot.Timestamp, Value: value})
+	}
+	return points, nil
+}
+
+// dailyCloses keeps the last value of each UTC day
+func dailyCloses(points []valuePoint) []valuePoint {
+	var closes []valuePoint
+	for _, point := range points {
+		day := point.Time.UTC().Truncate(24 * time.Hour)
+		if n := len(closes); n > 0 && closes[n-1].Time.Equal(day) {
+			closes[n-1].Value = point.Value
+			continue
+		}
+		closes = append(closes, valuePoint{Time: day, Value: point.Value})
+	}
+	return closes
+}
+
+// seriesFor returns the points to chart for a period: every snapshot for 24h, daily closes otherwise
+func seriesFor(period string, points []valuePoint) ([]valuePoint, string) {
+	if period == "24h" {
+		return points, time.RFC3339
+	}
+	return dailyCloses(points), "2006-01-02"
+}
+
+// percentChange returns the change from previous to current as a signed percentage
+func percentChange(previous, current float64) (float64, bool) {
+	if previous == 0 {
+		return 0, false
+	}
+	return (current - previous) / previous * 100, true
+}
+
+func formatPercent(change float64) string {
+	return fmt.Sprintf("%+.2f%%", change)
+}
+
+// bestAndWorstDay returns the largest rise and fall between consecutive daily closes
+func bestAndWorstDay(closes []valuePoint) (best, worst float64, ok bool) {
+	var changes []float64
+	for i := 1; i < len(closes); i++ {
+		if change, valid := percentChange(closes[i-1].Value, closes[i].Value); valid {
+			changes = append(changes, change)
+		}
+	}
+	if len(changes) == 0 {
+		return 0, 0, false
+	}
+	sort.Float64s(changes)
+	return changes[len(changes)-1], changes[0], true
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeriodStart(t *testing.T) {
+	now := time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC)
+
+	for period, want := range map[string]time.Time{
+		"24h": now.Add(-24 * time.Hour),
+		"7d":  time.Date(2024, 3, 24, 12, 0, 0, 0, time.UTC),
+		"30d": time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+		"1y":  time.Date(2023, 3, 31, 12, 0, 0, 0, time.UTC),
+		"all": {},
+	} {
+		got, err := PeriodStart(period, now)
+		require.NoError(t, err, period)
+		require.Equal(t, want, got, period)
+	}
+
+	_, err := PeriodStart("90m", now)
+	require.Error(t, err)
+}
+
+func TestDailyClosesAndBestWorstDay(t *testing.T) {
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	points := []valuePoint{
+		{Time: day.Add(1 * time.Hour), Value: 90},
+		{Time: day.Add(23 * time.Hour), Value: 100},
+		{Time: day.Add(30 * time.Hour), Value: 110},
+		{Time: day.Add(50 * time.Hour), Value: 88},
+	}
+
+	closes := dailyCloses(points)
+	require.Equal(t, []valuePoint{
+		{Time: day, Value: 100},
+		{Time: day.Add(24 * time.Hour), Value: 110},
+		{Time: day.Add(48 * time.Hour), Value: 88},
+	}, closes)
+
+	best, worst, ok := bestAndWorstDay(closes)
+	require.True(t, ok)
+	require.InDelta(t, 10.0, best, 1e-9)
+	require.InDelta(t, -20.0, worst, 1e-9)
+	require.Equal(t, "+10.00%", formatPercent(best))
+
+	_, _, ok = bestAndWorstDay(closes[:1])
+	require.False(t, ok)
+}
@@ -0,0 +1,543 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"web3-portfolio-dashboard/backend/internal/config"
+)
+
+// TokenPrice is a USD price quote
+type TokenPrice struct {
+	Symbol    string    `json:"symbol"`
+	Price     float64   `json:"price"`
+	Source    string    `json:"source"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Stale     bool      `json:"stale"`
+}
+
+// PriceProvider fetches USD prices for token symbols. Symbols the provider
+// doesn't know are left out of the result rather than failing the call.
+type PriceProvider interface {
+	Name() string
+	GetPrices(ctx context.Context, symbols []string) (map[string]TokenPrice, error)
+}
+
+// PriceHistoryProvider can return past USD prices of a token, oldest first
+type PriceHistoryProvider interface {
+	Name() string
+	GetPriceHistory(ctx context.Context, symbol string, from, to time.Time) ([]TokenPrice, error)
+}
+
+// PriceService aggregates prices across providers and caches the result
+type PriceService struct {
+	providers    []PriceProvider
+	ttl          time.Duration
+	staleAfter   time.Duration
+	maxDeviation float64
+
+	cache   map[string]cachedPrice
+	cacheMu sync.RWMutex
+}
+
+type cachedPrice struct {
+	price     TokenPrice
+	fetchedAt time.Time
+}
+
+// NewPriceService creates a price service. Providers are listed in priority
+// order; the first one breaks ties when providers disagree.
+func NewPriceService(cfg *config.Config, providers []PriceProvider) *PriceService {
+	s := &PriceService{
+		providers:    providers,
+		ttl:          cfg.PriceCacheTTL,
+		staleAfter:   cfg.PriceStaleAfter,
+		maxDeviation: cfg.PriceMaxDeviation,
+		cache:        make(map[string]cachedPrice),
+	}
+	if s.ttl <= 0 {
+		s.ttl = time.Minute
+	}
+	if s.staleAfter <= 0 {
+		s.staleAfter = 10 * time.Minute
+	}
+	if s.maxDeviation <= 0 {
+		s.maxDeviation = 0.05
+	}
+	return s
+}
+
+// DefaultPriceProviders returns CoinGecko followed by a Chainlink provider for
+// every network with price feeds
+func DefaultPriceProviders(cfg *config.Config, web3 *Web3Service) []PriceProvider {
+	providers := []PriceProvider{NewCoinGeckoProvider(cfg.CoinGeckoAPIURL, cfg.CoinGeckoAPIKey)}
+	for _, network := range web3.networks.All() {
+		if len(network.PriceFeeds) > 0 {
+			providers = append(providers, NewChainlinkProvider(web3, network.Name, network.PriceFeeds))
+		}
+	}
+	return providers
+}
+
+// GetPrice gets the USD price of a token
+func (s *PriceService) GetPrice(ctx context.Context, symbol string) (*TokenPrice, error) {
+	prices, err := s.GetPrices(ctx, []string{symbol})
+	if err != nil {
+		return nil, err
+	}
+
+	price, ok := prices[strings.ToUpper(symbol)]
+	if !ok {
+		return nil, fmt.Errorf("price not available for %s", symbol)
+	}
+	return &price, nil
+}
+
+// GetPrices gets USD prices for several tokens, keyed by upper-case symbol.
+// Cached prices are served until the TTL expires; when every provider fails
+// or the providers disagree, the last known price is returned flagged as stale.
+func (s *PriceService) GetPrices(ctx context.Context, symbols []string) (map[string]TokenPrice, error) {
+	now := time.Now()
+	prices := make(map[string]TokenPrice)
+
+	var missing []string
+	for _, symbol := range symbols {
+		symbol = strings.ToUpper(strings.TrimSpace(symbol))
+		if symbol == "" {
+			continue
+		}
+		if cached, ok := s.cached(symbol); ok && now.Sub(cached.fetchedAt) < s.ttl {
+			prices[symbol] = s.withStaleness(cached.price, now)
+			continue
+		}
+		missing = append(missing, symbol)
+	}
+
+	if len(missing) == 0 {
+		return prices, nil
+	}
+
+	quotes := s.fetchQuotes(ctx, missing)
+	for _, symbol := range missing {
+		price, agreed := aggregateQuotes(quotes[symbol], s.maxDeviation)
+		price.Symbol = symbol
+		if agreed {
+			s.store(symbol, price, now)
+			prices[symbol] = s.withStaleness(price, now)
+			continue
+		}
+
+		// Fall back to the last known price, or to the disputed quote
+		if cached, ok := s.cached(symbol); ok {
+			price := s.withStaleness(cached.price, now)
+			price.Stale = true
+			prices[symbol] = price
+		} else if len(quotes[symbol]) > 0 {
+			price.Stale = true
+			prices[symbol] = price
+		}
+	}
+
+	return prices, nil
+}
+
+// fetchQuotes asks every provider in parallel, returning quotes per symbol in provider order
+func (s *PriceService) fetchQuotes(ctx context.Context, symbols []string) map[string][]TokenPrice {
+	results := make([]map[string]TokenPrice, len(s.providers))
+
+	var wg sync.WaitGroup
+	for i, provider := range s.providers {
+		wg.Add(1)
+		go func(i int, provider PriceProvider) {
+			defer wg.Done()
+			prices, err := provider.GetPrices(ctx, symbols)
+			if err != nil {
+				log.Printf("Price provider %s failed: %v", provider.Name(), err)
+				return
+			}
+			results[i] = prices
+		}(i, provider)
+	}
+	wg.Wait()
+
+	quotes := make(map[string][]TokenPrice)
+	for _, prices := range results {
+		for _, symbol := range symbols {
+			if price, ok := prices[symbol]; ok && price.Price > 0 && !math.IsInf(price.Price, 0) {
+				quotes[symbol] = append(quotes[symbol], price)
+			}
+		}
+	}
+	return quotes
+}
+
+func (s *PriceService) cached(symbol string) (cachedPrice, bool) {
+	s.cacheMu.RLock()
+	defer s.cacheMu.RUnlock()
+	cached, ok := s.cache[symbol]
+	return cached, ok
+}
+
+func (s *PriceService) store(symbol string, price TokenPrice, fetchedAt time.Time) {
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+	s.cache[symbol] = cachedPrice{price: price, fetchedAt: fetchedAt}
+}
+
+func (s *PriceService) withStaleness(price TokenPrice, now time.Time) TokenPrice {
+	price.Stale = now.Sub(price.UpdatedAt) > s.staleAfter
+	return price
+}
+
+// aggregateQuotes takes the median of the largest group of quotes that lie
+// within maxDeviation of each other, provided it is a majority. When there is
+// no majority, such as two providers that disagree, it reports false along
+// with the quote of the highest-priority provider.
+func aggregateQuotes(quotes []TokenPrice, maxDeviation float64) (TokenPrice, bool) {
+	if len(quotes) == 0 {
+		return TokenPrice{}, false
+	}
+	if len(quotes) == 1 {
+		return quotes[0], true
+	}
+
+	var kept []TokenPrice
+	for _, anchor := range quotes {
+		var group []TokenPrice
+		for _, quote := range quotes {
+			if math.Abs(quote.Price-anchor.Price)/anchor.Price <= maxDeviation {
+				group = append(group, quote)
+			}
+		}
+		if len(group) > len(kept) {
+			kept = group
+		}
+	}
+	if len(kept)*2 <= len(quotes) {
+		log.Printf("Price providers disagree on %s, not using their quotes", quotes[0].Symbol)
+		return quotes[0], false
+	}
+
+	result := TokenPrice{Symbol: kept[0].Symbol, Price: medianPrice(kept)}
+	var sources []string
+	for _, quote := range kept {
+		sources = append(sources, quote.Source)
+		if quote.UpdatedAt.After(result.UpdatedAt) {
+			result.UpdatedAt = quote.UpdatedAt
+		}
+	}
+	result.Source = strings.Join(sources, ",")
+	return result, true
+}
+
+func medianPrice(quotes []TokenPrice) float64 {
+	values := make([]float64, 0, len(quotes))
+	for _, quote := range quotes {
+		values = append(values, quote.Price)
+	}
+	sort.Float64s(values)
+
+	mid := len(values) / 2
+	if len(values)%2 == 0 {
+		return (values[mid-1] + values[mid]) / 2
+	}
+	return values[mid]
+}
+
+// coinGeckoIDs maps token symbols to CoinGecko coin IDs; wrapped tokens share
+// the price of the underlying asset
+var coinGeckoIDs = map[string]string{
+	"ETH":    "ethereum",
+	"WETH":   "ethereum",
+	"BTC":    "bitcoin",
+	"WBTC":   "bitcoin",
+	"USDC":   "usd-coin",
+	"USDT":   "tether",
+	"DAI":    "dai",
+	"MATIC":  "matic-network",
+	"WMATIC": "matic-network",
+	"BNB":    "binancecoin",
+	"WBNB":   "binancecoin",
+	"AVAX":   "avalanche-2",
+	"WAVAX":  "avalanche-2",
+	"ARB":    "arbitrum",
+	"OP":     "optimism",
+	"LINK":   "chainlink",
+	"UNI":    "uniswap",
+	"MKR":    "maker",
+	"AAVE":   "aave",
+}
+
+// CoinGeckoProvider fetches prices from the CoinGecko simple price API
+type CoinGeckoProvider struct {
+	baseURL string
+	apiKey  string
+	client  *http.Client
+}
+
+func NewCoinGeckoProvider(baseURL, apiKey string) *CoinGeckoProvider {
+	if baseURL == "" {
+		baseURL = "https://api.coingecko.com/api/v3"
+	}
+	return &CoinGeckoProvider{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		apiKey:  apiKey,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Name returns the provider name
+func (p *CoinGeckoProvider) Name() string {
+	return "coingecko"
+}
+
+// GetPrices gets USD prices for the symbols CoinGecko knows
+func (p *CoinGeckoProvider) GetPrices(ctx context.Context, symbols []string) (map[string]TokenPrice, error) {
+	idsBySymbol := make(map[string]string)
+	seen := make(map[string]bool)
+	var ids []string
+	for _, symbol := range symbols {
+		id, ok := coinGeckoIDs[symbol]
+		if !ok {
+			continue
+		}
+		idsBySymbol[symbol] = id
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return map[string]TokenPrice{}, nil
+	}
+
+	query := url.Values{}
+	query.Set("ids", strings.Join(ids, ","))
+	query.Set("vs_currencies", "usd")
+	query.Set("include_last_updated_at", "true")
+
+	var body map[string]struct {
+		USD           float64 `json:"usd"`
+		LastUpdatedAt int64   `json:"last_updated_at"`
+	}
+	if err := p.get(ctx, "/simple/price?"+query.Encode(), &body); err != nil {
+		return nil, err
+	}
+
+	prices := make(map[string]TokenPrice)
+	for symbol, id := range idsBySymbol {
+		quote, ok := body[id]
+		if !ok || quote.USD <= 0 {
+			continue
+		}
+		updatedAt := time.Now()
+		if quote.LastUpdatedAt > 0 {
+			updatedAt = time.Unix(quote.LastUpdatedAt, 0)
+		}
+		prices[symbol] = TokenPrice{
+			Symbol:    symbol,
+			Price:     quote.USD,
+			Source:    p.Name(),
+			UpdatedAt: updatedAt,
+		}
+	}
+	return prices, nil
+}
+
+// GetPriceHistory gets past USD prices from the CoinGecko market chart API.
+// CoinGecko picks the granularity: 5-minutely within a day, hourly up to 90 days, daily beyond.
+func (p *CoinGeckoProvider) GetPriceHistory(ctx context.Context, symbol string, from, to time.Time) ([]TokenPrice, error) {
+	symbol = strings.ToUpper(symbol)
+	id, ok := coinGeckoIDs[symbol]
+	if !ok {
+		return nil, fmt.Errorf("coingecko has no id for %s", symbol)
+	}
+
+	query := url.Values{}
+	query.Set("vs_currency", "usd")
+	query.Set("from", strconv.FormatInt(from.Unix(), 10))
+	query.Set("to", strconv.FormatInt(to.Unix(), 10))
+
+	var body struct {
+		Prices [][2]float64 `json:"prices"`
+	}
+	if err := p.get(ctx, "/coins/"+id+"/market_chart/range?"+query.Encode(), &body); err != nil {
+		return nil, err
+	}
+
+	prices := make([]TokenPrice, 0, len(body.Prices))
+	for _, point := range body.Prices {
+		if point[1] <= 0 {
+			continue
+		}
+		prices = append(prices, TokenPrice{
+			Symbol:    symbol,
+			Price:     point[1],
+			Source:    p.Name(),
+			UpdatedAt: time.UnixMilli(int64(point[0])),
+		})
+	}
+	return prices, nil
+}
+
+// get sends an authenticated GET request to the CoinGecko API and decodes the JSON response
+func (p *CoinGeckoProvider) get(ctx context.Context, path string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.baseURL+path, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if p.apiKey != "" {
+		// Pro keys only work against pro-api.coingecko.com, demo keys against the public API
+		if strings.Contains(p.baseURL, "pro-api.coingecko.com") {
+			req.Header.Set("x-cg-pro-api-key", p.apiKey)
+		} else {
+			req.Header.Set("x-cg-demo-api-key", p.apiKey)
+		}
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("coingecko request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("coingecko returned status %d", resp.StatusCode)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("invalid coingecko response: %w", err)
+	}
+	return nil
+}
+
+// chainlinkWrapped maps wrapped tokens to the symbol of their Chainlink feed
+var chainlinkWrapped = map[string]string{
+	"WETH":   "ETH",
+	"WBTC":   "BTC",
+	"WMATIC": "MATIC",
+	"WBNB":   "BNB",
+	"WAVAX":  "AVAX",
+}
+
+const chainlinkABIJSON = `[
+	{"inputs":[],"name":"decimals","outputs":[{"name":"","type":"uint8"}],"stateMutability":"view","type":"function"},
+	{"inputs":[],"name":"latestRoundData","outputs":[{"name":"roundId","type":"uint80"},{"name":"answer","type":"int256"},{"name":"startedAt","type":"uint256"},{"name":"updatedAt","type":"uint256"},{"name":"answeredInRound","type":"uint80"}],"stateMutability":"view","type":"function"}
+]`
+
+var chainlinkABI = mustParseABI(chainlinkABIJSON)
+
+// ChainlinkProvider reads USD prices from Chainlink aggregators on one network
+type ChainlinkProvider struct {
+	web3    *Web3Service
+	network string
+	feeds   map[string]common.Address
+}
+
+func NewChainlinkProvider(web3 *Web3Service, network string, feeds map[string]string) *ChainlinkProvider {
+	p := &ChainlinkProvider{
+		web3:    web3,
+		network: network,
+		feeds:   make(map[string]common.Address),
+	}
+	for symbol, address := range feeds {
+		if common.IsHexAddress(address) {
+			p.feeds[strings.ToUpper(symbol)] = common.HexToAddress(address)
+		}
+	}
+	return p
+}
+
+// Name returns the provider name
+func (p *ChainlinkProvider) Name() string {
+	return "chainlink:" + p.network
+}
+
+// GetPrices reads decimals() and latestRoundData() of the feeds for the symbols, in one batch
+func (p *ChainlinkProvider) GetPrices(ctx context.Context, symbols []string) (map[string]TokenPrice, error) {
+	decimalsData, err := chainlinkABI.Pack("decimals")
+	if err != nil {
+		return nil, fmt.Errorf("failed to pack decimals: %w", err)
+	}
+	roundData, err := chainlinkABI.Pack("latestRoundData")
+	if err != nil {
+		return nil, fmt.Errorf("failed to pack latestRoundData: %w", err)
+	}
+
+	var calls []contractCall
+	var feedSymbols []string
+	for _, symbol := range symbols {
+		feedSymbol := symbol
+		if underlying, ok := chainlinkWrapped[symbol]; ok {
+			feedSymbol = underlying
+		}
+		feed, ok := p.feeds[feedSymbol]
+		if !ok {
+			continue
+		}
+		calls = append(calls,
+			contractCall{Target: feed, Data: decimalsData},
+			contractCall{Target: feed, Data: roundData},
+		)
+		feedSymbols = append(feedSymbols, symbol)
+	}
+	if len(calls) == 0 {
+		return map[string]TokenPrice{}, nil
+	}
+
+	results, err := p.web3.batchCaller(p.network)(ctx, calls)
+	if err != nil {
+		return nil, fmt.Errorf("chainlink read failed: %w", err)
+	}
+	if len(results) != len(calls) {
+		return nil, fmt.Errorf("expected %d call results, got %d", len(calls), len(results))
+	}
+
+	prices := make(map[string]TokenPrice)
+	for i, symbol := range feedSymbols {
+		decimalsResult, roundResult := results[2*i], results[2*i+1]
+		if !decimalsResult.Success || !roundResult.Success {
+			continue
+		}
+
+		decimals, err := chainlinkABI.Unpack("decimals", decimalsResult.Data)
+		if err != nil || len(decimals) != 1 {
+			continue
+		}
+		round, err := chainlinkABI.Unpack("latestRoundData", roundResult.Data)
+		if err != nil || len(round) != 5 {
+			continue
+		}
+
+		answer := round[1].(*big.Int)
+		updatedAt := round[3].(*big.Int)
+		if answer.Sign() <= 0 || updatedAt.Sign() == 0 {
+			continue // Feed not initialised or reporting a bogus answer
+		}
+
+		scale := new(big.Float).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals[0].(uint8))), nil))
+		price, _ := new(big.Float).Quo(new(big.Float).SetInt(answer), scale).Float64()
+
+		prices[symbol] = TokenPrice{
+			Symbol:    symbol,
+			Price:     price,
+			Source:    p.Name(),
+			UpdatedAt: time.Unix(updatedAt.Int64(), 0),
+		}
+	}
+	return prices, nil
+}
@@ -0,0 +1,274 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"web3-portfolio-dashboard/backend/internal/config"
+	"web3-portfolio-dashboard/backend/internal/models"
+)
+
+// maxCandles caps the size of a history response
+const maxCandles = 2000
+
+// PriceHistoryService records price snapshots and serves them as time series
+type PriceHistoryService struct {
+	db          *gorm.DB
+	prices      *PriceService
+	web3Service *Web3Service
+	backfill    PriceHistoryProvider
+	events      *EventHub
+}
+
+// Candle is an OHLC price bucket
+type Candle struct {
+	Time    time.Time `json:"time"`
+	Open    float64   `json:"open"`
+	High    float64   `json:"high"`
+	Low     float64   `json:"low"`
+	Close   float64   `json:"close"`
+	Samples int       `json:"samples"`
+}
+
+func NewPriceHistoryService(db *gorm.DB, cfg *config.Config, prices *PriceService, web3 *Web3Service) *PriceHistoryService {
+	return &PriceHistoryService{
+		db:          db,
+		prices:      prices,
+		web3Service: web3,
+		backfill:    NewCoinGeckoProvider(cfg.CoinGeckoAPIURL, cfg.CoinGeckoAPIKey),
+	}
+}
+
+// SetEventHub makes collected prices push to clients subscribed to the token
+func (s *PriceHistoryService) SetEventHub(events *EventHub) {
+	s.events = events
+}
+
+// Collect records the current price of every tracked symbol. Stale prices are not recorded.
+func (s *PriceHistoryService) Collect(ctx context.Context) (int, error) {
+	symbols, err := s.trackedSymbols()
+	if err != nil {
+		return 0, err
+	}
+
+	prices, err := s.prices.GetPrices(ctx, symbols)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get prices: %w", err)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	var snapshots []models.PriceSnapshot
+	for symbol, price := range prices {
+		if price.Stale {
+			continue
+		}
+		snapshots = append(snapshots, models.PriceSnapshot{
+			Symbol:    symbol,
+			Price:     price.Price,
+			Source:    price.Source,
+			Timestamp: now,
+		})
+	}
+
+	if err := s.save(snapshots); err != nil {
+		return 0, err
+	}
+	for _, snapshot := range snapshots {
+		s.events.Publish(Event{Type: EventPrice, Token: snapshot.Symbol, Time: snapshot.Timestamp, Data: map[string]interface{}{
+			"symbol": snapshot.Symbol,
+			"price":  formatPrice(snapshot.Price),
+			"source": snapshot.Source,
+		}})
+	}
+	return len(snapshots), nil
+}
+
+// Backfill loads past prices for symbols from CoinGecko. Existing snapshots are kept.
+// With no symbols given, every tracked symbol is backfilled.
+func (s *PriceHistoryService) Backfill(ctx context.Context, symbols []string, from, to time.Time) (int, error) {
+	if len(symbols) == 0 {
+		tracked, err := s.trackedSymbols()
+		if err != nil {
+			return 0, err
+		}
+		symbols = tracked
+	}
+
+	total := 0
+	for _, symbol := range symbols {
+		history, err := s.backfill.GetPriceHistory(ctx, symbol, from, to)
+		if err != nil {
+			log.Printf("❌ Failed to backfill %s: %v", symbol, err)
+			continue
+		}
+
+		snapshots := make([]models.PriceSnapshot, 0, len(history))
+		for _, price := range history {
+			snapshots = append(snapshots, models.PriceSnapshot{
+				Symbol:    strings.ToUpper(symbol),
+				Price:     price.Price,
+				Source:    price.Source,
+				Timestamp: price.UpdatedAt.UTC().Truncate(time.Second),
+			})
+		}
+		if err := s.save(snapshots); err != nil {
+			return total, err
+		}
+
+		log.Printf("✅ Backfilled %d %s prices", len(snapshots), symbol)
+		total += len(snapshots)
+	}
+
+	return total, nil
+}
+
+// GetCandles returns OHLC candles for a symbol between from and to
+func (s *PriceHistoryService) GetCandles(symbol string, from, to time.Time, interval time.Duration) ([]Candle, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("interval must be positive")
+	}
+	if !to.After(from) {
+		return nil, fmt.Errorf("from must be before to")
+	}
+	if to.Sub(from)/interval > maxCandles {
+		return nil, fmt.Errorf("range too large for interval: at most %d candles", maxCandles)
+	}
+
+	var snapshots []models.PriceSnapshot
+	err := s.db.Where("symbol = ? AND timestamp >= ? AND timestamp < ?", strings.ToUpper(symbol), from, to).
+		Order("timestamp").
+		Find(&snapshots).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get price history: %w", err)
+	}
+
+	return buildCandles(snapshots, interval), nil
+}
+
+// PriceAt returns the last recorded price of a symbol at or up to a day before t
+func (s *PriceHistoryService) PriceAt(symbol string, t time.Time) (float64, bool) {
+	var snapshot models.PriceSnapshot
+	err := s.db.Where("symbol = ? AND timestamp <= ? AND timestamp > ?", strings.ToUpper(symbol), t, t.Add(-24*time.Hour)).
+		Order("timestamp DESC").
+		First(&snapshot).Error
+	if err != nil {
+		return 0, false
+	}
+	return snapshot.Price, true
+}
+
+// LatestPrice returns the most recent recorded price if it is still fresh,
+// otherwise the live price
+func (s *PriceHistoryService) LatestPrice(ctx context.Context, symbol string) (*TokenPrice, error) {
+	var snapshot models.PriceSnapshot
+	err := s.db.Where("symbol = ?", strings.ToUpper(symbol)).Order("timestamp DESC").First(&snapshot).Error
+	if err == nil && time.Since(snapshot.Timestamp) <= s.prices.staleAfter {
+		return &TokenPrice{
+			Symbol:    snapshot.Symbol,
+			Price:     snapshot.Price,
+			Source:    snapshot.Source,
+			UpdatedAt: snapshot.Timestamp,
+		}, nil
+	}
+	return s.prices.GetPrice(ctx, symbol)
+}
+
+// trackedSymbols returns the native and registry token symbols of every network
+// plus any symbol currently held in a portfolio
+func (s *PriceHistoryService) trackedSymbols() ([]string, error) {
+	seen := make(map[string]bool)
+	var symbols []string
+	add := func(symbol string) {
+		symbol = strings.ToUpper(strings.TrimSpace(symbol))
+		if symbol != "" && !seen[symbol] {
+			seen[symbol] = true
+			symbols = append(symbols, symbol)
+		}
+	}
+
+	for _, network := range s.web3Service.networks.All() {
+		add(network.NativeSymbol)
+		for _, token := range network.Tokens {
+			add(token.Symbol)
+		}
+	}
+
+	var held []string
+	if err := s.db.Model(&models.Balance{}).Distinct("symbol").Pluck("symbol", &held).Error; err != nil {
+		return nil, fmt.Errorf("failed to get held symbols: %w", err)
+	}
+	for _, symbol := range held {
+		add(symbol)
+	}
+
+	return symbols, nil
+}
+
+func (s *PriceHistoryService) save(snapshots []models.PriceSnapshot) error {
+	if len(snapshots) == 0 {
+		return nil
+	}
+	err := s.db.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(snapshots, 500).Error
+	if err != nil {
+		return fmt.Errorf("failed to save price snapshots: %w", err)
+	}
+	return nil
+}
+
+// buildCandles buckets time-ordered snapshots into OHLC candles aligned to interval
+func buildCandles(snapshots []models.PriceSnapshot, interval time.Duration) []Candle {
+	candles := []Candle{}
+	for _, snapshot := range snapshots {
+		bucket := snapshot.Timestamp.UTC().Truncate(interval)
+
+		if n := len(candles); n > 0 && candles[n-1].Time.Equal(bucket) {
+			candle := &candles[n-1]
+			if snapshot.Price > candle.High {
+				candle.High = snapshot.Price
+			}
+			if snapshot.Price < candle.Low {
+				candle.Low = snapshot.Price
+			}
+			candle.Close = snapshot.Price
+			candle.Samples++
+			continue
+		}
+
+		candles = append(candles, Candle{
+			Time:    bucket,
+			Open:    snapshot.Price,
+			High:    snapshot.Price,
+			Low:     snapshot.Price,
+			Close:   snapshot.Price,
+			Samples: 1,
+		})
+	}
+	return candles
+}
+
+// ParseInterval parses a Go duration, or a whole number of days or weeks such as "1d" or "2w"
+func ParseInterval(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if strings.HasSuffix(value, suffix) {
+			n, err := strconv.Atoi(strings.TrimSuffix(value, suffix))
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid interval: %s", value)
+			}
+			return time.Duration(n) * unit, nil
+		}
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid interval: %s", value)
+	}
+	return d, nil
+}
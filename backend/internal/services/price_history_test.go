@@ -0,0 +1,77 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"web3-portfolio-dashboard/backend/internal/models"
+)
+
+func TestBuildCandles(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	snapshot := func(offset time.Duration, price float64) models.PriceSnapshot {
+		return models.PriceSnapshot{Symbol: "ETH", Price: price, Timestamp: start.Add(offset)}
+	}
+
+	candles := buildCandles([]models.PriceSnapshot{
+		snapshot(0, 100),
+		snapshot(10*time.Minute, 120),
+		snapshot(20*time.Minute, 90),
+		snapshot(50*time.Minute, 110),
+		snapshot(2*time.Hour+5*time.Minute, 130),
+	}, time.Hour)
+
+	require.Equal(t, []Candle{
+		{Time: start, Open: 100, High: 120, Low: 90, Close: 110, Samples: 4},
+		{Time: start.Add(2 * time.Hour), Open: 130, High: 130, Low: 130, Close: 130, Samples: 1},
+	}, candles)
+
+	require.Empty(t, buildCandles(nil, time.Hour))
+}
+
+func TestParseInterval(t *testing.T) {
+	for value, want := range map[string]time.Duration{
+		"15m": 15 * time.Minute,
+		"1h":  time.Hour,
+		"1d":  24 * time.Hour,
+		"2w":  14 * 24 * time.Hour,
+	} {
+		got, err := ParseInterval(value)
+		require.NoError(t, err, value)
+		require.Equal(t, want, got, value)
+	}
+
+	for _, value := range []string{"", "0h", "-1h", "xd", "0d", "soon"} {
+		_, err := ParseInterval(value)
+		require.Error(t, err, value)
+	}
+}
+
+func TestCoinGeckoPriceHistory(t *testing.T) {
+	from := time.Unix(1700000000, 0)
+	to := from.Add(2 * time.Hour)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/coins/ethereum/market_chart/range", r.URL.Path)
+		require.Equal(t, "1700000000", r.URL.Query().Get("from"))
+		require.Equal(t, "1700007200", r.URL.Query().Get("to"))
+
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"prices": [][2]float64{{1700000000000, 2000}, {1700003600000, 2010.5}},
+		})
+	}))
+	defer server.Close()
+
+	history, err := NewCoinGeckoProvider(server.URL, "").GetPriceHistory(context.Background(), "weth", from, to)
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	require.Equal(t, "WETH", history[1].Symbol)
+	require.Equal(t, 2010.5, history[1].Price)
+	require.Equal(t, time.Unix(1700003600, 0), history[1].UpdatedAt)
+}
@@ -0,0 +1,223 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/stretchr/testify/require"
+
+	"web3-portfolio-dashboard/backend/internal/config"
+)
+
+// staticPriceProvider serves fixed prices, or fails with err
+type staticPriceProvider struct {
+	name      string
+	prices    map[string]float64
+	updatedAt time.Time
+	err       error
+	calls     int32
+}
+
+func (p *staticPriceProvider) Name() string {
+	return p.name
+}
+
+func (p *staticPriceProvider) GetPrices(ctx context.Context, symbols []string) (map[string]TokenPrice, error) {
+	atomic.AddInt32(&p.calls, 1)
+	if p.err != nil {
+		return nil, p.err
+	}
+
+	updatedAt := p.updatedAt
+	if updatedAt.IsZero() {
+		updatedAt = time.Now()
+	}
+
+	prices := make(map[string]TokenPrice)
+	for _, symbol := range symbols {
+		if price, ok := p.prices[symbol]; ok {
+			prices[symbol] = TokenPrice{Symbol: symbol, Price: price, Source: p.name, UpdatedAt: updatedAt}
+		}
+	}
+	return prices, nil
+}
+
+func TestCoinGeckoProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/simple/price", r.URL.Path)
+		require.Equal(t, "ethereum,usd-coin", r.URL.Query().Get("ids"))
+		require.Equal(t, "usd", r.URL.Query().Get("vs_currencies"))
+		require.Equal(t, "demo-key", r.Header.Get("x-cg-demo-api-key"))
+
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"ethereum": map[string]interface{}{"usd": 2012.5, "last_updated_at": 1700000000},
+			"usd-coin": map[string]interface{}{"usd": 0.9998, "last_updated_at": 1700000000},
+		})
+	}))
+	defer server.Close()
+
+	provider := NewCoinGeckoProvider(server.URL, "demo-key")
+	prices, err := provider.GetPrices(context.Background(), []string{"ETH", "WETH", "USDC", "NOPE"})
+	require.NoError(t, err)
+
+	require.Len(t, prices, 3)
+	require.Equal(t, 2012.5, prices["ETH"].Price)
+	require.Equal(t, 2012.5, prices["WETH"].Price)
+	require.Equal(t, 0.9998, prices["USDC"].Price)
+	require.Equal(t, "coingecko", prices["USDC"].Source)
+	require.Equal(t, time.Unix(1700000000, 0), prices["ETH"].UpdatedAt)
+}
+
+func TestCoinGeckoProviderRateLimited(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	_, err := NewCoinGeckoProvider(server.URL, "").GetPrices(context.Background(), []string{"ETH"})
+	require.ErrorContains(t, err, "429")
+}
+
+func TestChainlinkProvider(t *testing.T) {
+	ethFeed := common.HexToAddress("0x00000000000000000000000000000000000feed1")
+	brokenFeed := common.HexToAddress("0x00000000000000000000000000000000000feed2")
+	updatedAt := time.Now().Add(-time.Minute).Truncate(time.Second)
+
+	server := newFakeRPC(t, func(method string, params []json.RawMessage) (interface{}, *rpcErrorBody) {
+		switch method {
+		case "eth_blockNumber":
+			return "0x1", nil
+		case "eth_call":
+			var call struct {
+				To   common.Address `json:"to"`
+				Data hexutil.Bytes  `json:"data"`
+			}
+			require.NoError(t, json.Unmarshal(params[0], &call))
+
+			method, err := chainlinkABI.MethodById(call.Data)
+			require.NoError(t, err)
+
+			var out []byte
+			switch method.Name {
+			case "decimals":
+				out, err = method.Outputs.Pack(uint8(8))
+			case "latestRoundData":
+				answer := big.NewInt(201234000000) // 2012.34 with 8 decimals
+				if call.To == brokenFeed {
+					answer = big.NewInt(0)
+				}
+				out, err = method.Outputs.Pack(big.NewInt(1), answer, big.NewInt(updatedAt.Unix()), big.NewInt(updatedAt.Unix()), big.NewInt(1))
+			}
+			require.NoError(t, err)
+			return hexutil.Bytes(out), nil
+		}
+		return nil, &rpcErrorBody{Code: -32601, Message: "method not found"}
+	})
+
+	cfg := &config.Config{
+		Networks: config.NewNetworkRegistry([]config.NetworkConfig{{
+			Name:    "testnet",
+			RPCURLs: []string{server.URL},
+		}}),
+	}
+	web3 := NewWeb3Service(cfg)
+	defer web3.Close()
+
+	provider := NewChainlinkProvider(web3, "testnet", map[string]string{
+		"ETH":  ethFeed.Hex(),
+		"LINK": brokenFeed.Hex(),
+	})
+	prices, err := provider.GetPrices(context.Background(), []string{"WETH", "LINK", "USDC"})
+	require.NoError(t, err)
+
+	// WETH reads the ETH feed; a zero answer is rejected
+	require.Len(t, prices, 1)
+	require.InDelta(t, 2012.34, prices["WETH"].Price, 1e-9)
+	require.Equal(t, "chainlink:testnet", prices["WETH"].Source)
+	require.Equal(t, updatedAt, prices["WETH"].UpdatedAt)
+}
+
+func TestPriceServiceRejectsOutliers(t *testing.T) {
+	service := NewPriceService(&config.Config{}, []PriceProvider{
+		&staticPriceProvider{name: "a", prices: map[string]float64{"ETH": 2000}},
+		&staticPriceProvider{name: "b", prices: map[string]float64{"ETH": 2010}},
+		&staticPriceProvider{name: "c", prices: map[string]float64{"ETH": 2600}},
+	})
+
+	price, err := service.GetPrice(context.Background(), "eth")
+	require.NoError(t, err)
+	require.Equal(t, "ETH", price.Symbol)
+	require.Equal(t, 2005.0, price.Price)
+	require.Equal(t, "a,b", price.Source)
+	require.False(t, price.Stale)
+
+	_, err = service.GetPrice(context.Background(), "NOPE")
+	require.Error(t, err)
+}
+
+func TestPriceServiceDoesNotBlendTwoDisagreeingProviders(t *testing.T) {
+	coingecko := &staticPriceProvider{name: "coingecko", prices: map[string]float64{"ETH": 2000}}
+	chainlink := &staticPriceProvider{name: "chainlink:testnet", prices: map[string]float64{"ETH": 2008}}
+	service := NewPriceService(&config.Config{PriceCacheTTL: time.Nanosecond, PriceMaxDeviation: 0.02}, []PriceProvider{coingecko, chainlink})
+
+	price, err := service.GetPrice(context.Background(), "ETH")
+	require.NoError(t, err)
+	require.Equal(t, 2004.0, price.Price)
+	require.False(t, price.Stale)
+
+	// A bad quote is neither averaged in nor trusted: the last agreed price is served as stale
+	chainlink.prices["ETH"] = 2500
+	price, err = service.GetPrice(context.Background(), "ETH")
+	require.NoError(t, err)
+	require.Equal(t, 2004.0, price.Price)
+	require.True(t, price.Stale)
+
+	// Without one, the first provider's quote is returned flagged as stale
+	_, err = service.GetPrice(context.Background(), "BTC")
+	require.Error(t, err)
+	coingecko.prices["BTC"], chainlink.prices["BTC"] = 60000, 30000
+	price, err = service.GetPrice(context.Background(), "BTC")
+	require.NoError(t, err)
+	require.Equal(t, 60000.0, price.Price)
+	require.True(t, price.Stale)
+}
+
+func TestPriceServiceCachesAndFlagsStale(t *testing.T) {
+	provider := &staticPriceProvider{
+		name:      "a",
+		prices:    map[string]float64{"ETH": 2000},
+		updatedAt: time.Now().Add(-time.Hour),
+	}
+	service := NewPriceService(&config.Config{PriceCacheTTL: time.Hour, PriceStaleAfter: 10 * time.Minute}, []PriceProvider{provider})
+
+	for i := 0; i < 3; i++ {
+		price, err := service.GetPrice(context.Background(), "ETH")
+		require.NoError(t, err)
+		require.True(t, price.Stale)
+	}
+	require.Equal(t, int32(1), atomic.LoadInt32(&provider.calls))
+}
+
+func TestPriceServiceServesLastKnownPriceWhenProvidersFail(t *testing.T) {
+	provider := &staticPriceProvider{name: "a", prices: map[string]float64{"ETH": 2000}}
+	service := NewPriceService(&config.Config{PriceCacheTTL: time.Nanosecond}, []PriceProvider{provider})
+
+	price, err := service.GetPrice(context.Background(), "ETH")
+	require.NoError(t, err)
+	require.False(t, price.Stale)
+
+	provider.err = errors.New("down")
+	price, err = service.GetPrice(context.Background(), "ETH")
+	require.NoError(t, err)
+	require.Equal(t, 2000.0, price.Price)
+	require.True(t, price.Stale)
+}
@@ -0,0 +1,193 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"web3-portfolio-dashboard/backend/internal/config"
+	"web3-portfolio-dashboard/backend/internal/models"
+)
+
+// BalanceRefresh is the outcome of refreshing a set of addresses. Balances of
+// addresses that were read in full are kept even when others failed.
+type BalanceRefresh struct {
+	Balances []models.Balance `json:"balances"`
+	Errors   []AddressError   `json:"errors"`
+
+	refreshed []uuid.UUID // addresses read in full, whose stored balances are replaced
+}
+
+// AddressError is why an address could not be refreshed
+type AddressError struct {
+	AddressID uuid.UUID `json:"address_id"`
+	Address   string    `json:"address"`
+	Network   string    `json:"network"`
+	Error     string    `json:"error"`
+}
+
+// BalanceRefresher reads the balances of many addresses at once, fanning out
+// across networks with a limit on concurrent RPC work per network
+type BalanceRefresher struct {
+	web3Service *Web3Service
+	concurrency int // RPC calls in flight per network
+	batchSize   int // addresses per token balance batch
+}
+
+func NewBalanceRefresher(cfg *config.Config, web3Service *Web3Service) *BalanceRefresher {
+	concurrency := cfg.RefreshConcurrency
+	if concurrency <= 0 {
+		concurrency = 4
+	}
+	batchSize := cfg.RefreshBatchSize
+	if batchSize <= 0 {
+		batchSize = 25
+	}
+	return &BalanceRefresher{web3Service: web3Service, concurrency: concurrency, batchSize: batchSize}
+}
+
+// addressRead is what was read for one address. Its native and token fields
+// are written by different jobs.
+type addressRead struct {
+	native     *big.Int
+	nativeErr  error
+	tokens     []TokenBalance
+	tokensRead bool
+	tokensErr  error
+}
+
+// err is why the address could not be read in full
+func (a addressRead) err(ctx context.Context) error {
+	switch {
+	case a.nativeErr != nil:
+		return a.nativeErr
+	case a.tokensErr != nil:
+		return fmt.Errorf("failed to read token balances: %w", a.tokensErr)
+	case a.native == nil || !a.tokensRead:
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("refresh stopped: %w", err)
+		}
+		return fmt.Errorf("refresh stopped")
+	}
+	return nil
+}
+
+// Refresh reads native and token balances of every address. An address whose
+// native or token read fails, or is cut short by ctx, is reported in Errors and
+// contributes no balances.
+func (r *BalanceRefresher) Refresh(ctx context.Context, addresses []models.Address) *BalanceRefresh {
+	reads := make([]addressRead, len(addresses))
+
+	byNetwork := make(map[string][]int)
+	for i, address := range addresses {
+		byNetwork[address.Network] = append(byNetwork[address.Network], i)
+	}
+
+	var wg sync.WaitGroup
+	for network, indexes := range byNetwork {
+		slots := make(chan struct{}, r.concurrency)
+		run := func(fn func()) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				select {
+				case slots <- struct{}{}:
+				case <-ctx.Done():
+					return
+				}
+				defer func() { <-slots }()
+				if ctx.Err() == nil {
+					fn()
+				}
+			}()
+		}
+
+		// Token balances are read one batch of addresses per call, native balances one address per call
+		for start := 0; start < len(indexes); start += r.batchSize {
+			batch := indexes[start:min(start+r.batchSize, len(indexes))]
+			run(func() {
+				holders := make([]string, len(batch))
+				for j, i := range batch {
+					holders[j] = addresses[i].Address
+				}
+				tokenBalances, err := r.web3Service.GetTokenBalancesForAddresses(ctx, holders, network)
+				for _, i := range batch {
+					reads[i].tokens, reads[i].tokensErr, reads[i].tokensRead = tokenBalances[addresses[i].Address], err, true
+				}
+			})
+			for _, i := range batch {
+				run(func() {
+					reads[i].native, reads[i].nativeErr = r.web3Service.GetBalance(ctx, addresses[i].Address, network)
+				})
+			}
+		}
+	}
+	wg.Wait()
+
+	refresh := &BalanceRefresh{Balances: []models.Balance{}, Errors: []AddressError{}}
+	for i, address := range addresses {
+		if err := reads[i].err(ctx); err != nil {
+			refresh.Errors = append(refresh.Errors, AddressError{
+				AddressID: address.ID,
+				Address:   address.Address,
+				Network:   address.Network,
+				Error:     err.Error(),
+			})
+			continue
+		}
+		refresh.Balances = append(refresh.Balances, r.balances(ctx, address, reads[i])...)
+		refresh.refreshed = append(refresh.refreshed, address.ID)
+	}
+	return refresh
+}
+
+// balances turns what was read for an address into balance records. Unpriced
+// holdings get a zero price and value.
+func (r *BalanceRefresher) balances(ctx context.Context, address models.Address, read addressRead) []models.Balance {
+	var balances []models.Balance
+	now := time.Now()
+
+	if read.native.Sign() > 0 {
+		balance := models.Balance{
+			AddressID:    address.ID,
+			Network:      address.Network,
+			TokenAddress: "", // Native token
+			Symbol:       r.web3Service.NativeTokenSymbol(address.Network),
+			Name:         r.web3Service.NativeTokenName(address.Network),
+			Amount:       read.native.String(),
+			Decimals:     r.web3Service.NativeTokenDecimals(address.Network),
+			Price:        "0",
+			Value:        "0",
+			UpdatedAt:    now,
+		}
+		if price, err := r.web3Service.GetTokenPrice(ctx, balance.Symbol); err == nil {
+			balance.Price = price
+			balance.Value = tokenValue(read.native, balance.Decimals, price)
+		}
+		balances = append(balances, balance)
+	}
+
+	for _, token := range read.tokens {
+		balance := models.Balance{
+			AddressID:    address.ID,
+			Network:      address.Network,
+			TokenAddress: token.TokenAddress,
+			Symbol:       token.Symbol,
+			Name:         token.Name,
+			Amount:       token.Amount,
+			Decimals:     token.Decimals,
+			Price:        token.Price,
+			Value:        token.Value,
+			UpdatedAt:    now,
+		}
+		if balance.Price == "" {
+			balance.Price, balance.Value = "0", "0"
+		}
+		balances = append(balances, balance)
+	}
+	return balances
+}
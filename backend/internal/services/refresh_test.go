@@ -0,0 +1,148 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"web3-portfolio-dashboard/backend/internal/config"
+	"web3-portfolio-dashboard/backend/internal/models"
+)
+
+func TestBalanceRefresherReportsFailures(t *testing.T) {
+	failing := "0x2000000000000000000000000000000000000002"
+	var inFlight, maxInFlight atomic.Int32
+
+	server := newFakeRPC(t, func(method string, params []json.RawMessage) (interface{}, *rpcErrorBody) {
+		if method != "eth_getBalance" {
+			return nil, &rpcErrorBody{Code: -32601, Message: "method not found"}
+		}
+		current := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			peak := maxInFlight.Load()
+			if current <= peak || maxInFlight.CompareAndSwap(peak, current) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+
+		var address string
+		require.NoError(t, json.Unmarshal(params[0], &address))
+		if strings.EqualFold(address, failing) {
+			return nil, &rpcErrorBody{Code: -32000, Message: "boom"}
+		}
+		return "0xde0b6b3a7640000", nil
+	})
+
+	cfg := &config.Config{
+		Networks:           config.NewNetworkRegistry([]config.NetworkConfig{{Name: "testnet", NativeSymbol: "TST", Decimals: 18, RPCURLs: []string{server.URL}}}),
+		RefreshConcurrency: 2,
+		RefreshBatchSize:   2,
+	}
+	web3 := NewWeb3Service(cfg)
+	defer web3.Close()
+
+	addresses := []models.Address{
+		{ID: uuid.New(), Address: "0x1000000000000000000000000000000000000001", Network: "testnet"},
+		{ID: uuid.New(), Address: failing, Network: "testnet"},
+		{ID: uuid.New(), Address: "0x3000000000000000000000000000000000000003", Network: "testnet"},
+		{ID: uuid.New(), Address: "0x4000000000000000000000000000000000000004", Network: "testnet"},
+		{ID: uuid.New(), Address: "0x5000000000000000000000000000000000000005", Network: "unknown"},
+	}
+	refresh := NewBalanceRefresher(cfg, web3).Refresh(context.Background(), addresses)
+
+	require.LessOrEqual(t, maxInFlight.Load(), int32(2))
+	require.Len(t, refresh.Balances, 3)
+	for i, balance := range refresh.Balances {
+		require.Equal(t, addresses[[]int{0, 2, 3}[i]].ID, balance.AddressID)
+		require.Equal(t, "1000000000000000000", balance.Amount)
+		require.Equal(t, "TST", balance.Symbol)
+		require.Equal(t, "testnet", balance.Network)
+		require.Equal(t, "0", balance.Price) // no price service
+	}
+	require.Equal(t, []uuid.UUID{addresses[0].ID, addresses[2].ID, addresses[3].ID}, refresh.refreshed)
+
+	require.Len(t, refresh.Errors, 2)
+	require.Equal(t, failing, refresh.Errors[0].Address)
+	require.Contains(t, refresh.Errors[0].Error, "boom")
+	require.Equal(t, "unknown", refresh.Errors[1].Network)
+}
+
+func TestBalanceRefresherStopsWhenCancelled(t *testing.T) {
+	var calls atomic.Int32
+	server := newFakeRPC(t, func(method string, params []json.RawMessage) (interface{}, *rpcErrorBody) {
+		calls.Add(1)
+		return "0x1", nil
+	})
+
+	cfg := &config.Config{
+		Networks: config.NewNetworkRegistry([]config.NetworkConfig{{Name: "testnet", RPCURLs: []string{server.URL}}}),
+	}
+	web3 := NewWeb3Service(cfg)
+	defer web3.Close()
+	probes := calls.Load()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	refresh := NewBalanceRefresher(cfg, web3).Refresh(ctx, []models.Address{
+		{ID: uuid.New(), Address: "0x1000000000000000000000000000000000000001", Network: "testnet"},
+	})
+
+	require.Empty(t, refresh.Balances)
+	require.Empty(t, refresh.refreshed)
+	require.Len(t, refresh.Errors, 1)
+	require.Contains(t, refresh.Errors[0].Error, context.Canceled.Error())
+	require.Equal(t, probes, calls.Load())
+}
+
+func TestSaveBalancesClearsAddressThatHoldsNothing(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "balances.db")), &gorm.Config{})
+	require.NoError(t, err)
+	for _, statement := range []string{
+		`CREATE TABLE addresses (id TEXT PRIMARY KEY, last_refreshed_at DATETIME)`,
+		`CREATE TABLE balances (id TEXT PRIMARY KEY, address_id TEXT, network TEXT, token_address TEXT, symbol TEXT, name TEXT,
+			amount TEXT, decimals INTEGER, price TEXT, value TEXT, updated_at DATETIME)`,
+		`CREATE UNIQUE INDEX idx_balances_holding ON balances (address_id, network, token_address)`,
+	} {
+		require.NoError(t, db.Exec(statement).Error)
+	}
+	holder, other := uuid.New(), uuid.New()
+	require.NoError(t, db.Exec(`INSERT INTO addresses (id) VALUES (?), (?)`, holder, other).Error)
+	service := NewPortfolioService(db, nil, nil, nil, nil)
+
+	holding := func(addressID uuid.UUID, token string) models.Balance {
+		return models.Balance{ID: uuid.New(), AddressID: addressID, Network: "testnet", TokenAddress: token, Amount: "1"}
+	}
+	tokens := func(addressID uuid.UUID) []string {
+		var held []string
+		require.NoError(t, db.Model(&models.Balance{}).Where("address_id = ?", addressID).Order("token_address").Pluck("token_address", &held).Error)
+		return held
+	}
+
+	require.NoError(t, service.saveBalances(&BalanceRefresh{
+		Balances:  []models.Balance{holding(holder, ""), holding(holder, "0xusdc"), holding(other, "0xdai")},
+		refreshed: []uuid.UUID{holder, other},
+	}, time.Now()))
+	require.Equal(t, []string{"", "0xusdc"}, tokens(holder))
+
+	// The holder sold the token, then emptied the address
+	require.NoError(t, service.saveBalances(&BalanceRefresh{
+		Balances:  []models.Balance{holding(holder, "")},
+		refreshed: []uuid.UUID{holder},
+	}, time.Now()))
+	require.Equal(t, []string{""}, tokens(holder))
+
+	require.NoError(t, service.saveBalances(&BalanceRefresh{refreshed: []uuid.UUID{holder}}, time.Now()))
+	require.Empty(t, tokens(holder))
+	require.Equal(t, []string{"0xdai"}, tokens(other))
+}
@@ -0,0 +1,180 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"gorm.io/gorm"
+
+	"web3-portfolio-dashboard/backend/internal/models"
+)
+
+// followChain reads a network's latest blocks up to its finality depth and
+// compares them with the blocks stored last time. When a stored block is no
+// longer on the chain, the transactions from it on are rolled back so they are
+// indexed again. It then stores the latest blocks, updates the confirmations
+// of stored transactions and returns the head.
+func (i *TransactionIndexer) followChain(ctx context.Context, name string) (uint64, error) {
+	network, ok := i.web3Service.Network(name)
+	if !ok {
+		return 0, fmt.Errorf("network %s not supported", name)
+	}
+	head, err := i.web3Service.BlockNumber(ctx, name)
+	if err != nil {
+		return 0, err
+	}
+
+	// Blocks stored above the head are kept for when a lagging node catches up
+	var stored []models.IndexedBlock
+	if err := i.db.Where("network = ? AND number <= ?", name, head).Order("number").Find(&stored).Error; err != nil {
+		return 0, fmt.Errorf("failed to get indexed blocks: %w", err)
+	}
+
+	// The unfinalized blocks, plus the stored ones in case they fell behind finality since
+	low := head + 1 - min(head+1, network.Finality)
+	numbers := make([]uint64, 0, head-low+1+uint64(len(stored)))
+	for number := low; number <= head; number++ {
+		numbers = append(numbers, number)
+	}
+	for _, block := range stored {
+		if block.Number < low {
+			numbers = append(numbers, block.Number)
+		}
+	}
+	headers, err := i.web3Service.BlockHeaders(ctx, name, numbers)
+	if err != nil {
+		return 0, err
+	}
+	for number := low + 1; number <= head; number++ {
+		if headers[number].ParentHash != headers[number-1].Hash {
+			return 0, fmt.Errorf("block %d does not follow block %d; the chain changed while it was read", number, number-1)
+		}
+	}
+
+	if fork, reorged := findReorg(stored, headers); reorged {
+		if _, final := confirmationsAt(fork, head, network.Finality); final {
+			log.Printf("❌ %s reorged from block %d, deeper than its finality of %d blocks", name, fork, network.Finality)
+		}
+		removed, err := i.rollback(name, fork)
+		if err != nil {
+			return 0, err
+		}
+		log.Printf("Reorg on %s from block %d: rolled back %d transactions to index again", name, fork, removed)
+	}
+
+	if err := i.saveBlocks(name, headers, low, head); err != nil {
+		return 0, err
+	}
+	if err := i.confirm(name, head, network.Finality); err != nil {
+		return 0, err
+	}
+	return head, nil
+}
+
+// findReorg returns the first stored block that is no longer on the chain:
+// either the chain has another block at its number, or the next block's
+// parent hash isn't its hash
+func findReorg(stored []models.IndexedBlock, chain map[uint64]BlockHeader) (uint64, bool) {
+	for _, block := range stored {
+		hash := common.HexToHash(block.Hash)
+		if header, ok := chain[block.Number]; ok && header.Hash != hash {
+			return block.Number, true
+		}
+		if child, ok := chain[block.Number+1]; ok && child.ParentHash != hash {
+			return block.Number, true
+		}
+	}
+	return 0, false
+}
+
+// rollback deletes a network's transactions from a block on and moves the
+// cursors of its addresses back before it, so the orphaned range is indexed
+// again. It returns how many transactions were deleted.
+func (i *TransactionIndexer) rollback(network string, fork uint64) (int64, error) {
+	var removed int64
+	err := i.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("network = ? AND block_number >= ?", network, fork).Delete(&models.Transaction{})
+		if result.Error != nil {
+			return fmt.Errorf("failed to roll back transactions: %w", result.Error)
+		}
+		removed = result.RowsAffected
+
+		var before uint64
+		if fork > 0 {
+			before = fork - 1
+		}
+		err := tx.Model(&models.SyncCursor{}).
+			Where("last_block >= ? AND address_id IN (SELECT id FROM addresses WHERE network = ?)", fork, network).
+			Update("last_block", before).Error
+		if err != nil {
+			return fmt.Errorf("failed to rewind sync cursors: %w", err)
+		}
+
+		if err := tx.Where("network = ? AND number >= ?", network, fork).Delete(&models.IndexedBlock{}).Error; err != nil {
+			return fmt.Errorf("failed to delete orphaned blocks: %w", err)
+		}
+		return nil
+	})
+	return removed, err
+}
+
+// saveBlocks replaces a network's stored blocks up to head with the blocks
+// from low to head, which later runs compare the chain against
+func (i *TransactionIndexer) saveBlocks(network string, headers map[uint64]BlockHeader, low, head uint64) error {
+	blocks := make([]models.IndexedBlock, 0, head-low+1)
+	for number, header := range headers {
+		if number >= low {
+			blocks = append(blocks, models.IndexedBlock{
+				Network:    network,
+				Number:     number,
+				Hash:       header.Hash.Hex(),
+				ParentHash: header.ParentHash.Hex(),
+			})
+		}
+	}
+	sort.Slice(blocks, func(a, b int) bool { return blocks[a].Number < blocks[b].Number })
+
+	return i.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("network = ? AND number <= ?", network, head).Delete(&models.IndexedBlock{}).Error; err != nil {
+			return fmt.Errorf("failed to prune indexed blocks: %w", err)
+		}
+		if err := tx.CreateInBatches(blocks, 500).Error; err != nil {
+			return fmt.Errorf("failed to save indexed blocks: %w", err)
+		}
+		return nil
+	})
+}
+
+// confirm updates the confirmations of a network's unfinalized transactions
+// at a head, marking those deep enough as finalized
+func (i *TransactionIndexer) confirm(network string, head, finality uint64) error {
+	err := i.db.Model(&models.Transaction{}).
+		Where("network = ? AND finalized = ? AND block_number + ? <= ?", network, false, finality, head+1).
+		Updates(map[string]interface{}{"confirmations": finality, "finalized": true}).Error
+	if err != nil {
+		return fmt.Errorf("failed to finalize transactions: %w", err)
+	}
+
+	err = i.db.Model(&models.Transaction{}).
+		Where("network = ? AND finalized = ? AND block_number <= ?", network, false, head).
+		Update("confirmations", gorm.Expr("? - block_number + 1", head)).Error
+	if err != nil {
+		return fmt.Errorf("failed to update confirmations: %w", err)
+	}
+	return nil
+}
+
+// confirmationsAt counts the blocks from block up to head, stopping at the
+// network's finality, and reports whether block is final
+func confirmationsAt(block, head, finality uint64) (uint64, bool) {
+	if block > head {
+		return 0, false
+	}
+	if confirmations := head - block + 1; confirmations < finality {
+		return confirmations, false
+	}
+	return finality, true
+}
@@ -0,0 +1,166 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"web3-portfolio-dashboard/backend/internal/config"
+	"web3-portfolio-dashboard/backend/internal/models"
+)
+
+func TestFindReorg(t *testing.T) {
+	hash := func(n int64) common.Hash { return common.BigToHash(big.NewInt(n)) }
+	stored := []models.IndexedBlock{
+		{Number: 10, Hash: hash(10).Hex()},
+		{Number: 11, Hash: hash(11).Hex()},
+		{Number: 12, Hash: hash(12).Hex()},
+	}
+	chain := map[uint64]BlockHeader{
+		11: {Hash: hash(11), ParentHash: hash(10)},
+		12: {Hash: hash(12), ParentHash: hash(11)},
+		13: {Hash: hash(13), ParentHash: hash(12)},
+	}
+	_, reorged := findReorg(stored, chain)
+	require.False(t, reorged)
+
+	// A replaced block is found by its hash, or by its child's parent hash when it wasn't read again
+	chain[12] = BlockHeader{Hash: hash(99), ParentHash: hash(11)}
+	fork, reorged := findReorg(stored, chain)
+	require.True(t, reorged)
+	require.Equal(t, uint64(12), fork)
+
+	chain[11] = BlockHeader{Hash: hash(98), ParentHash: hash(97)}
+	delete(chain, 10)
+	fork, _ = findReorg(stored, chain)
+	require.Equal(t, uint64(10), fork)
+
+	confirmations, final := confirmationsAt(98, 100, 64)
+	require.Equal(t, uint64(3), confirmations)
+	require.False(t, final)
+	confirmations, final = confirmationsAt(20, 100, 64)
+	require.Equal(t, uint64(64), confirmations)
+	require.True(t, final)
+}
+
+func TestFollowChain(t *testing.T) {
+	var head, fork, unlinked atomic.Uint64
+	hashOf := func(n uint64) common.Hash {
+		if f := fork.Load(); f > 0 && n >= f {
+			return common.BigToHash(new(big.Int).SetUint64(n + 1000))
+		}
+		return common.BigToHash(new(big.Int).SetUint64(n))
+	}
+	server := newFakeRPC(t, func(method string, params []json.RawMessage) (interface{}, *rpcErrorBody) {
+		switch method {
+		case "eth_blockNumber":
+			return hexutil.EncodeUint64(head.Load()), nil
+		case "eth_getBlockByNumber":
+			var number hexutil.Uint64
+			require.NoError(t, json.Unmarshal(params[0], &number))
+			if uint64(number) > head.Load() {
+				return nil, nil
+			}
+			parent := hashOf(uint64(number) - 1)
+			if uint64(number) == unlinked.Load() {
+				parent = common.Hash{}
+			}
+			return map[string]interface{}{
+				"hash":       hashOf(uint64(number)),
+				"parentHash": parent,
+				"timestamp":  hexutil.EncodeUint64(uint64(number)),
+			}, nil
+		}
+		return nil, &rpcErrorBody{Code: -32601, Message: "method not found"}
+	})
+	head.Store(10)
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "chain.db")), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.IndexedBlock{}))
+	for _, statement := range []string{
+		`CREATE TABLE transactions (id TEXT PRIMARY KEY, network TEXT, tx_hash TEXT, block_number INTEGER, confirmations INTEGER, finalized NUMERIC)`,
+		`CREATE TABLE addresses (id TEXT PRIMARY KEY, network TEXT)`,
+		`CREATE TABLE sync_cursors (id TEXT PRIMARY KEY, address_id TEXT, last_block INTEGER, updated_at DATETIME)`,
+		`INSERT INTO addresses VALUES ('a1', 'testnet'), ('a2', 'othernet')`,
+		`INSERT INTO sync_cursors VALUES ('c1', 'a1', 10, NULL), ('c2', 'a2', 10, NULL)`,
+		`INSERT INTO transactions VALUES ('t1', 'testnet', '0x5', 5, 0, false), ('t2', 'testnet', '0x8', 8, 0, false),
+			('t3', 'testnet', '0xa', 10, 0, false), ('t4', 'othernet', '0xa', 10, 0, false)`,
+	} {
+		require.NoError(t, db.Exec(statement).Error)
+	}
+
+	cfg := &config.Config{
+		Networks: config.NewNetworkRegistry([]config.NetworkConfig{{Name: "testnet", RPCURLs: []string{server.URL}, Finality: 4}}),
+	}
+	web3 := NewWeb3Service(cfg)
+	defer web3.Close()
+	indexer := NewTransactionIndexer(db, cfg, web3, nil)
+
+	confirmations := func() map[string][2]int {
+		var rows []struct {
+			TxHash        string
+			Confirmations int
+			Finalized     bool
+		}
+		require.NoError(t, db.Table("transactions").Where("network = ?", "testnet").Find(&rows).Error)
+		states := make(map[string][2]int)
+		for _, row := range rows {
+			final := 0
+			if row.Finalized {
+				final = 1
+			}
+			states[row.TxHash] = [2]int{row.Confirmations, final}
+		}
+		return states
+	}
+	cursor := func(id string) (last uint64) {
+		require.NoError(t, db.Table("sync_cursors").Where("id = ?", id).Pluck("last_block", &last).Error)
+		return last
+	}
+
+	got, err := indexer.followChain(context.Background(), "testnet")
+	require.NoError(t, err)
+	require.Equal(t, uint64(10), got)
+	require.Equal(t, map[string][2]int{"0x5": {4, 1}, "0x8": {3, 0}, "0xa": {1, 0}}, confirmations())
+
+	var blocks []models.IndexedBlock
+	require.NoError(t, db.Order("number").Find(&blocks).Error)
+	require.Len(t, blocks, 4)
+	require.Equal(t, uint64(7), blocks[0].Number)
+	require.Equal(t, hashOf(10).Hex(), blocks[3].Hash)
+
+	// Blocks 9 and 10 are replaced: what was indexed from 9 on is rolled back
+	fork.Store(9)
+	head.Store(11)
+	_, err = indexer.followChain(context.Background(), "testnet")
+	require.NoError(t, err)
+	require.Equal(t, map[string][2]int{"0x5": {4, 1}, "0x8": {4, 1}}, confirmations())
+	require.Equal(t, uint64(8), cursor("c1"))
+	require.Equal(t, uint64(10), cursor("c2"))
+
+	blocks = nil
+	require.NoError(t, db.Order("number").Find(&blocks).Error)
+	require.Len(t, blocks, 4)
+	require.Equal(t, uint64(8), blocks[0].Number)
+	require.Equal(t, hashOf(11).Hex(), blocks[3].Hash)
+	require.Equal(t, hashOf(9).Hex(), blocks[1].Hash)
+
+	// Blocks that don't link up were read while the chain changed; nothing is indexed on them
+	unlinked.Store(12)
+	head.Store(12)
+	_, err = indexer.followChain(context.Background(), "testnet")
+	require.ErrorContains(t, err, "block 12 does not follow block 11")
+	var count int64
+	require.NoError(t, db.Model(&models.IndexedBlock{}).Count(&count).Error)
+	require.Equal(t, int64(4), count)
+}
@@ -0,0 +1,389 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Circuit breaker states for an RPC endpoint
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half_open"
+)
+
+// errNoEndpoint is returned when every endpoint of a pool has an open breaker
+var errNoEndpoint = errors.New("no healthy RPC endpoint available")
+
+// RPCPoolOptions tunes failover and health checking of an RPC pool
+type RPCPoolOptions struct {
+	Timeout          time.Duration
+	ProbeInterval    time.Duration
+	FailureThreshold int
+	Cooldown         time.Duration
+}
+
+func (o RPCPoolOptions) withDefaults() RPCPoolOptions {
+	if o.Timeout <= 0 {
+		o.Timeout = 10 * time.Second
+	}
+	if o.ProbeInterval <= 0 {
+		o.ProbeInterval = 30 * time.Second
+	}
+	if o.FailureThreshold <= 0 {
+		o.FailureThreshold = 3
+	}
+	if o.Cooldown <= 0 {
+		o.Cooldown = time.Minute
+	}
+	return o
+}
+
+// EndpointStatus is the health snapshot of a single RPC endpoint
+type EndpointStatus struct {
+	Endpoint      string     `json:"endpoint"`
+	State         string     `json:"state"`
+	LatencyMs     int64      `json:"latency_ms"`
+	LastBlock     uint64     `json:"last_block"`
+	Failures      int        `json:"failures"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastCheckedAt *time.Time `json:"last_checked_at,omitempty"`
+}
+
+// rpcEndpoint is one RPC URL with its client and breaker state
+type rpcEndpoint struct {
+	url   string
+	label string
+
+	mu          sync.Mutex
+	client      *ethclient.Client
+	state       string
+	failures    int
+	openedAt    time.Time
+	trial       bool // a half-open endpoint has its one trial call in flight
+	latency     time.Duration
+	lastBlock   uint64
+	lastError   string
+	lastChecked time.Time
+}
+
+// rpcPool spreads calls for one network over several endpoints, failing over
+// on errors and taking unhealthy endpoints out of rotation
+type rpcPool struct {
+	network   string
+	endpoints []*rpcEndpoint
+	opts      RPCPoolOptions
+}
+
+func newRPCPool(network string, urls []string, opts RPCPoolOptions) *rpcPool {
+	pool := &rpcPool{network: network, opts: opts.withDefaults()}
+	for _, u := range urls {
+		pool.endpoints = append(pool.endpoints, &rpcEndpoint{
+			url:   u,
+			label: redactURL(u),
+			state: BreakerClosed,
+		})
+	}
+	return pool
+}
+
+// Do runs fn against the healthiest endpoint, failing over to the next one
+// when the endpoint errors or times out
+func (p *rpcPool) Do(ctx context.Context, fn func(ctx context.Context, client *ethclient.Client) error) error {
+	candidates := p.candidates()
+	if len(candidates) == 0 {
+		return fmt.Errorf("%s: %w", p.network, errNoEndpoint)
+	}
+
+	var lastErr error
+	for _, ep := range candidates {
+		if !ep.admit() {
+			continue
+		}
+		client, err := ep.connect(ctx, p.opts.Timeout)
+		if err != nil {
+			ep.recordFailure(err, p.opts.FailureThreshold)
+			lastErr = err
+			continue
+		}
+
+		callCtx, cancel := context.WithTimeout(ctx, p.opts.Timeout)
+		start := time.Now()
+		err = fn(callCtx, client)
+		cancel()
+
+		if err == nil || isApplicationError(err) {
+			ep.recordSuccess(time.Since(start), 0)
+			return err
+		}
+
+		// The caller gave up — don't blame the endpoint
+		if ctx.Err() != nil {
+			ep.release()
+			return ctx.Err()
+		}
+
+		ep.recordFailure(err, p.opts.FailureThreshold)
+		lastErr = err
+	}
+
+	if lastErr == nil {
+		return fmt.Errorf("%s: %w", p.network, errNoEndpoint)
+	}
+	return fmt.Errorf("all %s RPC endpoints failed: %w", p.network, lastErr)
+}
+
+// candidates returns the endpoints that may receive traffic, healthiest first
+func (p *rpcPool) candidates() []*rpcEndpoint {
+	type ranked struct {
+		ep       *rpcEndpoint
+		halfOpen bool
+		latency  time.Duration
+	}
+
+	var list []ranked
+	now := time.Now()
+	for _, ep := range p.endpoints {
+		ep.mu.Lock()
+		if ep.state == BreakerOpen && now.Sub(ep.openedAt) >= p.opts.Cooldown {
+			ep.state = BreakerHalfOpen
+		}
+		if ep.state != BreakerOpen {
+			list = append(list, ranked{ep: ep, halfOpen: ep.state == BreakerHalfOpen, latency: ep.latency})
+		}
+		ep.mu.Unlock()
+	}
+
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].halfOpen != list[j].halfOpen {
+			return !list[i].halfOpen
+		}
+		return list[i].latency < list[j].latency
+	})
+
+	endpoints := make([]*rpcEndpoint, len(list))
+	for i, r := range list {
+		endpoints[i] = r.ep
+	}
+	return endpoints
+}
+
+// Probe checks every endpoint, including ones with an open breaker, so that
+// recovered endpoints are reconnected and put back into rotation. It returns
+// how many endpoints answered.
+func (p *rpcPool) Probe(ctx context.Context) int {
+	var wg sync.WaitGroup
+	var answered atomic.Int32
+	for _, ep := range p.endpoints {
+		wg.Add(1)
+		go func(ep *rpcEndpoint) {
+			defer wg.Done()
+
+			client, err := ep.connect(ctx, p.opts.Timeout)
+			if err != nil {
+				ep.recordFailure(err, p.opts.FailureThreshold)
+				return
+			}
+
+			probeCtx, cancel := context.WithTimeout(ctx, p.opts.Timeout)
+			defer cancel()
+
+			start := time.Now()
+			block, err := client.BlockNumber(probeCtx)
+			if err != nil {
+				ep.recordFailure(err, p.opts.FailureThreshold)
+				return
+			}
+			ep.recordSuccess(time.Since(start), block)
+			answered.Add(1)
+		}(ep)
+	}
+	wg.Wait()
+	return int(answered.Load())
+}
+
+// Run probes the pool until ctx is cancelled
+func (p *rpcPool) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.opts.ProbeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.Probe(ctx)
+		}
+	}
+}
+
+// Healthy reports whether at least one endpoint has a closed breaker. Failures
+// below the breaker threshold don't take an endpoint out of rotation.
+func (p *rpcPool) Healthy() bool {
+	for _, ep := range p.endpoints {
+		ep.mu.Lock()
+		ok := ep.state == BreakerClosed
+		ep.mu.Unlock()
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Status returns a snapshot of every endpoint in the pool
+func (p *rpcPool) Status() []EndpointStatus {
+	statuses := make([]EndpointStatus, 0, len(p.endpoints))
+	for _, ep := range p.endpoints {
+		ep.mu.Lock()
+		status := EndpointStatus{
+			Endpoint:  ep.label,
+			State:     ep.state,
+			LatencyMs: ep.latency.Milliseconds(),
+			LastBlock: ep.lastBlock,
+			Failures:  ep.failures,
+			LastError: ep.lastError,
+		}
+		if !ep.lastChecked.IsZero() {
+			checked := ep.lastChecked
+			status.LastCheckedAt = &checked
+		}
+		ep.mu.Unlock()
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// Close closes every open client in the pool
+func (p *rpcPool) Close() {
+	for _, ep := range p.endpoints {
+		ep.mu.Lock()
+		if ep.client != nil {
+			ep.client.Close()
+			ep.client = nil
+		}
+		ep.mu.Unlock()
+	}
+}
+
+// connect returns the endpoint's client, dialing it if needed
+func (ep *rpcEndpoint) connect(ctx context.Context, timeout time.Duration) (*ethclient.Client, error) {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+
+	if ep.client != nil {
+		return ep.client, nil
+	}
+
+	dialCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	client, err := ethclient.DialContext(dialCtx, ep.url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial %s: %w", ep.label, err)
+	}
+	ep.client = client
+	return client, nil
+}
+
+// admit reports whether a call may use the endpoint. A half-open endpoint
+// lets a single trial call through until that call succeeds or fails.
+func (ep *rpcEndpoint) admit() bool {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+
+	switch ep.state {
+	case BreakerOpen:
+		return false
+	case BreakerHalfOpen:
+		if ep.trial {
+			return false
+		}
+		ep.trial = true
+	}
+	return true
+}
+
+// release ends a trial call that says nothing about the endpoint's health
+func (ep *rpcEndpoint) release() {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+	ep.trial = false
+}
+
+func (ep *rpcEndpoint) recordSuccess(latency time.Duration, block uint64) {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+
+	if ep.state != BreakerClosed {
+		log.Printf("✅ RPC endpoint %s recovered", ep.label)
+	}
+	ep.state = BreakerClosed
+	ep.trial = false
+	ep.failures = 0
+	ep.lastError = ""
+	ep.latency = latency
+	ep.lastChecked = time.Now()
+	if block > ep.lastBlock {
+		ep.lastBlock = block
+	}
+}
+
+func (ep *rpcEndpoint) recordFailure(err error, threshold int) {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+
+	ep.failures++
+	ep.trial = false
+	ep.lastError = err.Error()
+	ep.lastChecked = time.Now()
+
+	if ep.state == BreakerHalfOpen || ep.failures >= threshold {
+		if ep.state != BreakerOpen {
+			log.Printf("❌ RPC endpoint %s taken out of rotation: %v", ep.label, err)
+		}
+		ep.state = BreakerOpen
+		ep.openedAt = time.Now()
+
+		// Drop the client so the endpoint is re-dialed when it comes back
+		if ep.client != nil {
+			ep.client.Close()
+			ep.client = nil
+		}
+	}
+}
+
+// isApplicationError reports whether err came from a healthy node rejecting
+// the request (e.g. a reverted call) rather than from the endpoint failing
+func isApplicationError(err error) bool {
+	if errors.Is(err, ethereum.NotFound) {
+		return true
+	}
+
+	var rpcErr rpc.Error
+	if errors.As(err, &rpcErr) {
+		// -32005 is the de-facto "limit exceeded" code used by hosted providers
+		return rpcErr.ErrorCode() != -32005
+	}
+	return false
+}
+
+// redactURL strips credentials, paths and query strings, which commonly carry API keys
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return "endpoint"
+	}
+	return u.Scheme + "://" + u.Host
+}
@@ -0,0 +1,254 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"web3-portfolio-dashboard/backend/internal/config"
+	"web3-portfolio-dashboard/backend/internal/models"
+)
+
+// Scheduled jobs
+const (
+	JobPriceCollect      = "price_collect"
+	JobPortfolioSnapshot = "portfolio_snapshot"
+	JobBalanceRefresh    = "balance_refresh"
+	JobTransactionSync   = "transaction_sync"
+	JobAlertCheck        = "alert_check"
+)
+
+// Job run statuses
+const (
+	JobStatusRunning = "running"
+	JobStatusSuccess = "success"
+	JobStatusFailed  = "failed"
+)
+
+// schedulerLockName is the lock whose holder runs the jobs
+const schedulerLockName = "scheduler"
+
+// jobRunRetention is how long job run history is kept
+const jobRunRetention = 7 * 24 * time.Hour
+
+// JobFunc does one run of a job and returns how many items it processed
+type JobFunc func(ctx context.Context) (int, error)
+
+// ScheduledJob is a registered job and when it last ran
+type ScheduledJob struct {
+	Name     string         `json:"name"`
+	Interval string         `json:"interval"`
+	LastRun  *models.JobRun `json:"last_run"`
+
+	interval time.Duration
+	run      JobFunc
+}
+
+// Scheduler runs background jobs on their intervals. When several replicas
+// share a database, only the one holding the leader lease runs jobs.
+type Scheduler struct {
+	db       *gorm.DB
+	instance string
+	jitter   float64
+	leaseTTL time.Duration
+	jobs     []*ScheduledJob
+	leader   atomic.Bool
+}
+
+func NewScheduler(db *gorm.DB, cfg *config.Config) *Scheduler {
+	hostname, _ := os.Hostname()
+	leaseTTL := cfg.SchedulerLeaseTTL
+	if leaseTTL <= 0 {
+		leaseTTL = 30 * time.Second
+	}
+	return &Scheduler{
+		db:       db,
+		instance: fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), uuid.NewString()[:8]),
+		jitter:   cfg.SchedulerJitter,
+		leaseTTL: leaseTTL,
+	}
+}
+
+// Register adds a job. Jobs with a zero interval are skipped.
+func (s *Scheduler) Register(name string, interval time.Duration, run JobFunc) {
+	if interval <= 0 {
+		log.Printf("Job %s is disabled", name)
+		return
+	}
+	s.jobs = append(s.jobs, &ScheduledJob{Name: name, Interval: interval.String(), interval: interval, run: run})
+}
+
+// Instance identifies this replica in the leader lock and job history
+func (s *Scheduler) Instance() string {
+	return s.instance
+}
+
+// IsLeader reports whether this replica currently runs the jobs
+func (s *Scheduler) IsLeader() bool {
+	return s.leader.Load()
+}
+
+// Run holds the leader lease and runs jobs until ctx is cancelled, then waits
+// for running jobs to stop and releases the lease
+func (s *Scheduler) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.holdLease(ctx)
+	}()
+	for _, job := range s.jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.runJob(ctx, job)
+		}()
+	}
+	wg.Wait()
+
+	if err := s.releaseLease(); err != nil {
+		log.Printf("❌ Failed to release scheduler lock: %v", err)
+	}
+	log.Println("Scheduler stopped")
+}
+
+// holdLease takes the leader lease when it is free and renews it while held
+func (s *Scheduler) holdLease(ctx context.Context) {
+	ticker := time.NewTicker(s.leaseTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		leader, err := s.acquireLease(time.Now())
+		if err != nil {
+			log.Printf("❌ Failed to acquire scheduler lock: %v", err)
+		}
+		if leader != s.leader.Swap(leader) {
+			if leader {
+				log.Printf("✅ %s is now running background jobs", s.instance)
+			} else {
+				log.Printf("%s is no longer running background jobs", s.instance)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// acquireLease takes or renews the leader lease. It succeeds when the lease is
+// free, expired or already held by this instance.
+func (s *Scheduler) acquireLease(now time.Time) (bool, error) {
+	lock := models.SchedulerLock{Name: schedulerLockName}
+	if err := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&lock).Error; err != nil {
+		return false, err
+	}
+
+	result := s.db.Model(&models.SchedulerLock{}).
+		Where("name = ? AND (holder = ? OR expires_at < ?)", schedulerLockName, s.instance, now).
+		Updates(map[string]interface{}{"holder": s.instance, "expires_at": now.Add(s.leaseTTL)})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// releaseLease lets another replica take over without waiting for expiry
+func (s *Scheduler) releaseLease() error {
+	s.leader.Store(false)
+	return s.db.Model(&models.SchedulerLock{}).
+		Where("name = ? AND holder = ?", schedulerLockName, s.instance).
+		Updates(map[string]interface{}{"holder": "", "expires_at": time.Now()}).Error
+}
+
+// runJob runs a job every interval, shifted by jitter, while this replica leads.
+// The first run comes after a jittered fraction of the interval.
+func (s *Scheduler) runJob(ctx context.Context, job *ScheduledJob) {
+	timer := time.NewTimer(time.Duration(rand.Float64() * s.jitter * float64(job.interval)))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		if s.IsLeader() {
+			s.execute(ctx, job)
+		}
+		timer.Reset(jittered(job.interval, s.jitter, rand.Float64()))
+	}
+}
+
+// execute runs a job once and records the run
+func (s *Scheduler) execute(ctx context.Context, job *ScheduledJob) {
+	run := &models.JobRun{Job: job.Name, Instance: s.instance, Status: JobStatusRunning, StartedAt: time.Now()}
+	if err := s.db.Create(run).Error; err != nil {
+		log.Printf("❌ Failed to record %s run: %v", job.Name, err)
+	}
+
+	processed, err := job.run(ctx)
+	finished := time.Now()
+	run.Processed, run.FinishedAt, run.Status = processed, &finished, JobStatusSuccess
+	if err != nil {
+		run.Status, run.Error = JobStatusFailed, err.Error()
+		log.Printf("❌ Job %s failed after %s: %v", job.Name, finished.Sub(run.StartedAt).Round(time.Millisecond), err)
+	} else {
+		log.Printf("Job %s processed %d in %s", job.Name, processed, finished.Sub(run.StartedAt).Round(time.Millisecond))
+	}
+
+	if err := s.db.Save(run).Error; err != nil {
+		log.Printf("❌ Failed to record %s run: %v", job.Name, err)
+	}
+	if err := s.db.Where("job = ? AND started_at < ?", job.Name, finished.Add(-jobRunRetention)).Delete(&models.JobRun{}).Error; err != nil {
+		log.Printf("❌ Failed to prune %s runs: %v", job.Name, err)
+	}
+}
+
+// jittered shifts interval by up to ±jitter of itself; r is uniform in [0, 1)
+func jittered(interval time.Duration, jitter, r float64) time.Duration {
+	return interval + time.Duration((2*r-1)*jitter*float64(interval))
+}
+
+// Jobs lists the registered jobs with their latest run
+func (s *Scheduler) Jobs() ([]ScheduledJob, error) {
+	jobs := make([]ScheduledJob, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		entry := *job
+		var runs []models.JobRun
+		if err := s.db.Where("job = ?", job.Name).Order("started_at DESC").Limit(1).Find(&runs).Error; err != nil {
+			return nil, fmt.Errorf("failed to get job runs: %w", err)
+		}
+		if len(runs) > 0 {
+			entry.LastRun = &runs[0]
+		}
+		jobs = append(jobs, entry)
+	}
+	return jobs, nil
+}
+
+// JobRuns returns the latest runs of a job, newest first, or of every job when name is empty
+func (s *Scheduler) JobRuns(name string, limit int) ([]models.JobRun, error) {
+	query := s.db.Order("started_at DESC").Limit(limit)
+	if name != "" {
+		query = query.Where("job = ?", name)
+	}
+
+	var runs []models.JobRun
+	if err := query.Find(&runs).Error; err != nil {
+		return nil, fmt.Errorf("failed to get job runs: %w", err)
+	}
+	return runs, nil
+}
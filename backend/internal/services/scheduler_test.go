@@ -0,0 +1,57 @@
+package services
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"web3-portfolio-dashboard/backend/internal/config"
+	"web3-portfolio-dashboard/backend/internal/models"
+)
+
+func TestSchedulerLeaderLease(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "lock.db")), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.SchedulerLock{}))
+
+	cfg := &config.Config{SchedulerLeaseTTL: time.Minute}
+	first, second := NewScheduler(db, cfg), NewScheduler(db, cfg)
+	require.NotEqual(t, first.Instance(), second.Instance())
+	now := time.Now()
+
+	leader, err := first.acquireLease(now)
+	require.NoError(t, err)
+	require.True(t, leader)
+
+	// Only the holder can renew while the lease is live
+	leader, err = second.acquireLease(now.Add(30 * time.Second))
+	require.NoError(t, err)
+	require.False(t, leader)
+	leader, err = first.acquireLease(now.Add(30 * time.Second))
+	require.NoError(t, err)
+	require.True(t, leader)
+
+	// An expired lease can be taken over
+	leader, err = second.acquireLease(now.Add(2 * time.Minute))
+	require.NoError(t, err)
+	require.True(t, leader)
+	leader, err = first.acquireLease(now.Add(2 * time.Minute))
+	require.NoError(t, err)
+	require.False(t, leader)
+
+	// A released lease is free straight away
+	require.NoError(t, second.releaseLease())
+	leader, err = first.acquireLease(time.Now().Add(time.Second))
+	require.NoError(t, err)
+	require.True(t, leader)
+}
+
+func TestJittered(t *testing.T) {
+	require.Equal(t, 9*time.Minute, jittered(10*time.Minute, 0.1, 0))
+	require.Equal(t, 10*time.Minute, jittered(10*time.Minute, 0.1, 0.5))
+	require.Equal(t, 10*time.Minute, jittered(10*time.Minute, 0, 0.9))
+}
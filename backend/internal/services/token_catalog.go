@@ -0,0 +1,295 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"web3-portfolio-dashboard/backend/internal/models"
+)
+
+// Token catalog sources
+const (
+	TokenSourceOnChain   = "onchain"
+	TokenSourceTokenList = "tokenlist"
+	TokenSourceAdmin     = "admin"
+)
+
+// Token catalog lookup errors
+var (
+	ErrTokenNotFound = errors.New("token not found in the catalog")
+	ErrNotERC20      = errors.New("address does not look like an ERC-20 token")
+)
+
+// TokenCatalogService persists ERC-20 metadata per (network, address)
+type TokenCatalogService struct {
+	db          *gorm.DB
+	web3Service *Web3Service
+}
+
+// tokenList is the Uniswap token list format (https://tokenlists.org)
+type tokenList struct {
+	Name   string `json:"name"`
+	Tokens []struct {
+		ChainID  uint64 `json:"chainId"`
+		Address  string `json:"address"`
+		Name     string `json:"name"`
+		Symbol   string `json:"symbol"`
+		Decimals uint8  `json:"decimals"`
+		LogoURI  string `json:"logoURI"`
+	} `json:"tokens"`
+}
+
+func NewTokenCatalogService(db *gorm.DB, web3 *Web3Service) *TokenCatalogService {
+	return &TokenCatalogService{
+		db:          db,
+		web3Service: web3,
+	}
+}
+
+// ListTokens retrieves catalog tokens, optionally filtered by network
+func (s *TokenCatalogService) ListTokens(network string) ([]models.Token, error) {
+	query := s.db.Order("network, symbol")
+	if network != "" {
+		query = query.Where("network = ?", strings.ToLower(network))
+	}
+
+	var tokens []models.Token
+	if err := query.Find(&tokens).Error; err != nil {
+		return nil, fmt.Errorf("failed to get tokens: %w", err)
+	}
+	return tokens, nil
+}
+
+// GetToken retrieves a catalog token. Tokens that aren't in the catalog yet
+// return ErrTokenNotFound; Discover adds them.
+func (s *TokenCatalogService) GetToken(network, address string) (*models.Token, error) {
+	network, tokenAddr, err := s.normalize(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return s.find(network, tokenAddr)
+}
+
+// Discover reads decimals, symbol and name on-chain and stores them in the catalog.
+// Addresses that don't answer decimals() are skipped.
+// ErrNotERC20 is returned when none of them does.
+func (s *TokenCatalogService) Discover(network string, addresses []string) ([]models.Token, error) {
+	var tokenAddrs []common.Address
+	for _, address := range addresses {
+		n, tokenAddr, err := s.normalize(network, address)
+		if err != nil {
+			return nil, err
+		}
+		network = n
+		tokenAddrs = append(tokenAddrs, tokenAddr)
+	}
+
+	tokens, err := s.web3Service.ReadTokenMetadata(context.Background(), network, tokenAddrs)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, ErrNotERC20
+	}
+	if err := s.saveDiscovered(tokens); err != nil {
+		return nil, fmt.Errorf("failed to save discovered tokens: %w", err)
+	}
+	return tokens, nil
+}
+
+// SetOverride pins admin-curated metadata for a token; discovery and imports never overwrite it
+func (s *TokenCatalogService) SetOverride(network, address, symbol, name string, decimals uint8) (*models.Token, error) {
+	network, tokenAddr, err := s.normalize(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	token := models.Token{
+		Network:    network,
+		Address:    tokenAddr.Hex(),
+		Symbol:     symbol,
+		Name:       name,
+		Decimals:   decimals,
+		Source:     TokenSourceAdmin,
+		IsOverride: true,
+	}
+
+	err = s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "network"}, {Name: "address"}},
+		DoUpdates: clause.AssignmentColumns([]string{"symbol", "name", "decimals", "source", "is_override", "updated_at"}),
+	}).Create(&token).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to save token override: %w", err)
+	}
+
+	s.web3Service.forgetToken(network, tokenAddr)
+	return s.find(network, tokenAddr)
+}
+
+// ClearOverride drops an admin override and refreshes the token from the chain
+func (s *TokenCatalogService) ClearOverride(network, address string) (*models.Token, error) {
+	network, tokenAddr, err := s.normalize(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.Model(&models.Token{}).
+		Where("network = ? AND address = ?", network, tokenAddr.Hex()).
+		Update("is_override", false).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to clear token override: %w", err)
+	}
+
+	s.web3Service.forgetToken(network, tokenAddr)
+	if _, err := s.Discover(network, []string{tokenAddr.Hex()}); err != nil && !errors.Is(err, ErrNotERC20) {
+		return nil, err
+	}
+	return s.find(network, tokenAddr)
+}
+
+// ImportTokenList loads a Uniswap-format token list from a local JSON file.
+// Tokens on chains that aren't in the network registry are ignored.
+func (s *TokenCatalogService) ImportTokenList(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read token list: %w", err)
+	}
+
+	var list tokenList
+	if err := json.Unmarshal(data, &list); err != nil {
+		return 0, fmt.Errorf("invalid token list: %w", err)
+	}
+
+	networksByChain := make(map[uint64]string)
+	for _, network := range s.web3Service.networks.All() {
+		networksByChain[network.ChainID] = network.Name
+	}
+
+	var tokens []models.Token
+	for _, t := range list.Tokens {
+		network, ok := networksByChain[t.ChainID]
+		if !ok || !common.IsHexAddress(t.Address) {
+			continue
+		}
+		tokens = append(tokens, models.Token{
+			Network:  network,
+			Address:  common.HexToAddress(t.Address).Hex(),
+			Symbol:   t.Symbol,
+			Name:     t.Name,
+			Decimals: t.Decimals,
+			LogoURI:  t.LogoURI,
+			Source:   TokenSourceTokenList,
+		})
+	}
+
+	if err := s.upsert(tokens, []string{"symbol", "name", "decimals", "logo_uri", "source", "updated_at"}); err != nil {
+		return 0, fmt.Errorf("failed to import token list: %w", err)
+	}
+
+	for _, token := range tokens {
+		s.web3Service.forgetToken(token.Network, common.HexToAddress(token.Address))
+	}
+	return len(tokens), nil
+}
+
+// transferredTokens returns the ERC-20 tokens that indexed transfers of the
+// holders on a network involve
+func (s *TokenCatalogService) transferredTokens(network string, holders []common.Address) ([]common.Address, error) {
+	if len(holders) == 0 {
+		return nil, nil
+	}
+	lowered := make([]string, 0, len(holders))
+	for _, holder := range holders {
+		lowered = append(lowered, strings.ToLower(holder.Hex()))
+	}
+
+	var hexAddrs []string
+	err := s.db.Model(&models.Transaction{}).
+		Distinct("token_address").
+		Where("network = ? AND token_address <> ''", network).
+		Where("address_id IN (SELECT id FROM addresses WHERE network = ? AND LOWER(address) IN ?)", network, lowered).
+		Pluck("token_address", &hexAddrs).Error
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := make([]common.Address, 0, len(hexAddrs))
+	for _, hexAddr := range hexAddrs {
+		if common.IsHexAddress(hexAddr) {
+			tokens = append(tokens, common.HexToAddress(hexAddr))
+		}
+	}
+	return tokens, nil
+}
+
+// lookup returns the catalog entries for a set of token addresses
+func (s *TokenCatalogService) lookup(network string, tokenAddrs []common.Address) (map[common.Address]models.Token, error) {
+	found := make(map[common.Address]models.Token)
+	if len(tokenAddrs) == 0 {
+		return found, nil
+	}
+
+	hexAddrs := make([]string, 0, len(tokenAddrs))
+	for _, addr := range tokenAddrs {
+		hexAddrs = append(hexAddrs, addr.Hex())
+	}
+
+	var tokens []models.Token
+	if err := s.db.Where("network = ? AND address IN ?", network, hexAddrs).Find(&tokens).Error; err != nil {
+		return nil, err
+	}
+	for _, token := range tokens {
+		found[common.HexToAddress(token.Address)] = token
+	}
+	return found, nil
+}
+
+// saveDiscovered upserts on-chain metadata, leaving admin overrides alone
+func (s *TokenCatalogService) saveDiscovered(tokens []models.Token) error {
+	return s.upsert(tokens, []string{"symbol", "name", "decimals", "source", "updated_at"})
+}
+
+func (s *TokenCatalogService) upsert(tokens []models.Token, columns []string) error {
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	return s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "network"}, {Name: "address"}},
+		DoUpdates: clause.AssignmentColumns(columns),
+		Where: clause.Where{Exprs: []clause.Expression{
+			clause.Expr{SQL: "tokens.is_override = ?", Vars: []interface{}{false}},
+		}},
+	}).CreateInBatches(tokens, 200).Error
+}
+
+func (s *TokenCatalogService) find(network string, tokenAddr common.Address) (*models.Token, error) {
+	var token models.Token
+	err := s.db.Where("network = ? AND address = ?", network, tokenAddr.Hex()).First(&token).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrTokenNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get token: %w", err)
+	}
+	return &token, nil
+}
+
+func (s *TokenCatalogService) normalize(network, address string) (string, common.Address, error) {
+	networkConfig, exists := s.web3Service.Network(network)
+	if !exists {
+		return "", common.Address{}, fmt.Errorf("unknown network: %s", network)
+	}
+	if !common.IsHexAddress(address) {
+		return "", common.Address{}, fmt.Errorf("invalid token address: %s", address)
+	}
+	return networkConfig.Name, common.HexToAddress(address), nil
+}
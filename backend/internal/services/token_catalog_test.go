@@ -0,0 +1,114 @@
+package services
+
+import (
+	"context"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"web3-portfolio-dashboard/backend/internal/config"
+)
+
+func TestTrackedTokensSkipUntransferredCatalogTokens(t *testing.T) {
+	holder := common.HexToAddress("0x00000000000000000000000000000000000a11ce")
+	usdc := common.HexToAddress("0x0000000000000000000000000000000000000001")
+	dai := common.HexToAddress("0x0000000000000000000000000000000000000002")
+	listed := common.HexToAddress("0x0000000000000000000000000000000000000003")
+	elsewhere := common.HexToAddress("0x0000000000000000000000000000000000000004")
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "catalog.db")), &gorm.Config{})
+	require.NoError(t, err)
+	for _, statement := range []string{
+		`CREATE TABLE tokens (id TEXT PRIMARY KEY, network TEXT, address TEXT, symbol TEXT)`,
+		`CREATE TABLE addresses (id TEXT PRIMARY KEY, network TEXT, address TEXT)`,
+		`CREATE TABLE transactions (id TEXT PRIMARY KEY, address_id TEXT, network TEXT, token_address TEXT)`,
+		`INSERT INTO tokens VALUES ('k1', 'testnet', '` + dai.Hex() + `', 'DAI'), ('k2', 'testnet', '` + listed.Hex() + `', 'LIST')`,
+		`INSERT INTO addresses VALUES ('a1', 'testnet', '` + strings.ToLower(holder.Hex()) + `'), ('a2', 'othernet', '` + holder.Hex() + `')`,
+		`INSERT INTO transactions VALUES ('t1', 'a1', 'testnet', '` + dai.Hex() + `'), ('t2', 'a1', 'testnet', '` + dai.Hex() + `'),
+			('t3', 'a1', 'testnet', NULL), ('t4', 'a2', 'othernet', '` + elsewhere.Hex() + `')`,
+	} {
+		require.NoError(t, db.Exec(statement).Error)
+	}
+
+	cfg := &config.Config{
+		Networks: config.NewNetworkRegistry([]config.NetworkConfig{{
+			Name:   "testnet",
+			Tokens: []config.TokenConfig{{Address: usdc.Hex(), Symbol: "USDC", Decimals: 6}},
+		}}),
+	}
+	web3 := NewWeb3Service(cfg)
+	defer web3.Close()
+	web3.SetTokenCatalog(NewTokenCatalogService(db, web3))
+	network, _ := cfg.Networks.Get("testnet")
+
+	// Registry tokens plus the tokens the holder moved; imported list entries are left out
+	var tracked []string
+	for _, token := range web3.trackedTokens(network, []common.Address{holder}) {
+		tracked = append(tracked, token.Address)
+	}
+	require.Equal(t, []string{usdc.Hex(), dai.Hex()}, tracked)
+
+	require.Len(t, web3.trackedTokens(network, nil), 1)
+}
+
+// balanceOnlyTokenCode answers balanceOf(a) with storage slot a and reverts on
+// anything else, like a token whose metadata can't be read
+var balanceOnlyTokenCode = common.FromHex(
+	"600035" + "60e01c" + "6370a08231" + "14" + "601457" + "60006000fd" +
+		"5b" + "600435" + "54" + "600052" + "60206000f3")
+
+func TestTokenBalancesSkipTransferredTokensWithoutMetadata(t *testing.T) {
+	opaque := common.HexToAddress("0x00000000000000000000000000000000000b0a7d")
+	sim := backends.NewSimulatedBackend(core.GenesisAlloc{
+		testToken: {
+			Code:    mockTokenCode,
+			Balance: big.NewInt(0),
+			Storage: tokenStorage(t, map[common.Address]int64{testHolder1: 1_500_000}, 6, "USDC", "USD Coin"),
+		},
+		opaque: {
+			Code:    balanceOnlyTokenCode,
+			Balance: big.NewInt(0),
+			Storage: map[common.Hash]common.Hash{common.BytesToHash(testHolder1.Bytes()): common.BigToHash(big.NewInt(7_000_000))},
+		},
+	}, 30_000_000)
+	t.Cleanup(func() { _ = sim.Close() })
+	server, _ := newSimulatedRPC(t, sim)
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "catalog.db")), &gorm.Config{})
+	require.NoError(t, err)
+	for _, statement := range []string{
+		`CREATE TABLE tokens (id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))), network TEXT, address TEXT, symbol TEXT, name TEXT,
+			decimals INTEGER, logo_uri TEXT, source TEXT, is_override NUMERIC DEFAULT false, created_at DATETIME, updated_at DATETIME,
+			UNIQUE (network, address))`,
+		`CREATE TABLE addresses (id TEXT PRIMARY KEY, network TEXT, address TEXT)`,
+		`CREATE TABLE transactions (id TEXT PRIMARY KEY, address_id TEXT, network TEXT, token_address TEXT)`,
+		`INSERT INTO addresses VALUES ('a1', 'testnet', '` + testHolder1.Hex() + `')`,
+		`INSERT INTO transactions VALUES ('t1', 'a1', 'testnet', '` + testToken.Hex() + `'), ('t2', 'a1', 'testnet', '` + opaque.Hex() + `')`,
+	} {
+		require.NoError(t, db.Exec(statement).Error)
+	}
+
+	cfg := &config.Config{
+		Networks: config.NewNetworkRegistry([]config.NetworkConfig{{Name: "testnet", RPCURLs: []string{server.URL}}}),
+	}
+	web3 := NewWeb3Service(cfg)
+	defer web3.Close()
+	web3.SetTokenCatalog(NewTokenCatalogService(db, web3))
+
+	// The opaque token's balance has no decimals to read it with, so it is left out
+	balances, err := web3.GetTokenBalancesForAddresses(context.Background(), []string{testHolder1.Hex()}, "testnet")
+	require.NoError(t, err)
+	require.Len(t, balances[testHolder1.Hex()], 1)
+	usdc := balances[testHolder1.Hex()][0]
+	require.Equal(t, testToken.Hex(), usdc.TokenAddress)
+	require.Equal(t, "USDC", usdc.Symbol)
+	require.Equal(t, uint8(6), usdc.Decimals)
+}
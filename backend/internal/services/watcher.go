@@ -0,0 +1,405 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"gorm.io/gorm"
+
+	"web3-portfolio-dashboard/backend/internal/config"
+	"web3-portfolio-dashboard/backend/internal/models"
+)
+
+const (
+	// watchFlushDelay gathers the Transfer logs of a block before refreshing
+	watchFlushDelay = time.Second
+	// watchReloadInterval is how often newly added or removed addresses are picked up
+	watchReloadInterval = time.Minute
+	// watchMaxGap is how many missed blocks are scanned before every tracked
+	// address is refreshed instead
+	watchMaxGap = 64
+	// watchMaxBackoff caps the wait between reconnects
+	watchMaxBackoff = 5 * time.Minute
+)
+
+// RefreshFunc refreshes the balances of some addresses and returns how many
+// portfolios were refreshed
+type RefreshFunc func(ctx context.Context, addresses []models.Address) (int, error)
+
+// BlockWatcher follows new blocks over WebSocket on networks with a ws_url.
+// A block that moves native tokens to or from a tracked address, or emits a
+// Transfer log naming one, gets that address's balances refreshed; the address
+// is read again once the block is final, in case it was reorged out.
+type BlockWatcher struct {
+	db       *gorm.DB
+	networks []*config.NetworkConfig
+	refresh  RefreshFunc
+	isLeader func() bool // only the leader refreshes; nil always refreshes
+
+	flushDelay     time.Duration
+	reloadInterval time.Duration
+	reconnectDelay time.Duration
+}
+
+func NewBlockWatcher(db *gorm.DB, cfg *config.Config, refresh RefreshFunc, isLeader func() bool) *BlockWatcher {
+	var networks []*config.NetworkConfig
+	for _, network := range cfg.Networks.All() {
+		if network.WSURL != "" {
+			networks = append(networks, network)
+		}
+	}
+	return &BlockWatcher{
+		db:             db,
+		networks:       networks,
+		refresh:        refresh,
+		isLeader:       isLeader,
+		flushDelay:     watchFlushDelay,
+		reloadInterval: watchReloadInterval,
+		reconnectDelay: 5 * time.Second,
+	}
+}
+
+// Run watches every network with a ws_url until ctx is cancelled
+func (w *BlockWatcher) Run(ctx context.Context) {
+	if len(w.networks) == 0 {
+		return
+	}
+	var wg sync.WaitGroup
+	for _, network := range w.networks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.watchNetwork(ctx, network)
+		}()
+	}
+	wg.Wait()
+	log.Println("Block watcher stopped")
+}
+
+// watchNetwork follows a network, reconnecting with backoff when the
+// connection drops. Touches and pending re-checks survive reconnects.
+func (w *BlockWatcher) watchNetwork(ctx context.Context, network *config.NetworkConfig) {
+	state := newBlockTouches(network.Finality)
+	delay := w.reconnectDelay
+	for {
+		connected, err := w.follow(ctx, network, state)
+		if ctx.Err() != nil {
+			return
+		}
+		if connected {
+			delay = w.reconnectDelay
+		}
+		log.Printf("❌ %s block subscription lost, reconnecting in %s: %v", network.Name, delay, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, watchMaxBackoff)
+	}
+}
+
+// follow subscribes to a network's new heads and the Transfer logs of its
+// tracked addresses, and refreshes touched addresses until the connection
+// drops. connected reports whether the subscriptions were set up.
+func (w *BlockWatcher) follow(ctx context.Context, network *config.NetworkConfig, state *blockTouches) (connected bool, err error) {
+	client, err := rpc.DialContext(ctx, network.WSURL)
+	if err != nil {
+		return false, fmt.Errorf("failed to dial %s: %w", redactURL(network.WSURL), err)
+	}
+	defer client.Close()
+	eth := ethclient.NewClient(client)
+
+	heads := make(chan *types.Header, 16)
+	headSub, err := eth.SubscribeNewHead(ctx, heads)
+	if err != nil {
+		return false, fmt.Errorf("failed to subscribe to new heads: %w", err)
+	}
+	defer headSub.Unsubscribe()
+
+	tracked, err := w.trackedAddresses(network.Name)
+	if err != nil {
+		return false, err
+	}
+	logs := make(chan types.Log, 256)
+	logSubs, err := subscribeTransfers(ctx, eth, tracked, logs)
+	if err != nil {
+		return false, err
+	}
+	defer func() { unsubscribeAll(logSubs) }()
+	log.Printf("✅ Watching %s blocks for %d addresses", network.Name, len(tracked))
+
+	// Blocks and their logs went by unseen while disconnected, so everything is
+	// read again rather than scanning the missed blocks
+	if state.head > 0 {
+		w.refreshAddresses(ctx, network.Name, tracked, allAddresses(tracked))
+		state.head = 0
+	}
+
+	reload := time.NewTicker(w.reloadInterval)
+	defer reload.Stop()
+	flush := time.NewTimer(w.flushDelay)
+	flush.Stop()
+	flushing := false
+
+	for {
+		select {
+		case <-ctx.Done():
+			return true, ctx.Err()
+
+		case err := <-headSub.Err():
+			return true, subscriptionEnded("new heads", err)
+		case err := <-subscriptionErr(logSubs, 0):
+			return true, subscriptionEnded("Transfer logs", err)
+		case err := <-subscriptionErr(logSubs, 1):
+			return true, subscriptionEnded("Transfer logs", err)
+
+		case head := <-heads:
+			if err := w.scanBlocks(ctx, client, tracked, state, head.Number.Uint64()); err != nil {
+				log.Printf("Failed to scan %s block %s: %v", network.Name, head.Number, err)
+			}
+			w.refreshAddresses(ctx, network.Name, tracked, state.take(state.head))
+
+		case entry := <-logs:
+			if len(entry.Topics) == 0 {
+				continue
+			}
+			for _, topic := range entry.Topics[1:] {
+				if address := common.BytesToAddress(topic.Bytes()); tracked[address] != nil {
+					state.touch(address, entry.BlockNumber)
+				}
+			}
+			if !flushing && state.pending() {
+				flush.Reset(w.flushDelay)
+				flushing = true
+			}
+
+		case <-flush.C:
+			flushing = false
+			w.refreshAddresses(ctx, network.Name, tracked, state.take(state.head))
+
+		case <-reload.C:
+			latest, err := w.trackedAddresses(network.Name)
+			if err != nil {
+				log.Printf("Failed to reload %s addresses: %v", network.Name, err)
+				continue
+			}
+			if sameAddresses(tracked, latest) {
+				tracked = latest
+				continue
+			}
+			unsubscribeAll(logSubs)
+			tracked = latest
+			if logSubs, err = subscribeTransfers(ctx, eth, tracked, logs); err != nil {
+				return true, err
+			}
+			log.Printf("Watching %s blocks for %d addresses", network.Name, len(tracked))
+		}
+	}
+}
+
+// scanBlocks touches the senders and recipients of the native transfers in
+// the blocks since the last head. Past watchMaxGap missed blocks every tracked
+// address is touched instead.
+func (w *BlockWatcher) scanBlocks(ctx context.Context, client *rpc.Client, tracked map[common.Address][]models.Address, state *blockTouches, head uint64) error {
+	from := head
+	if state.head > 0 && state.head < head {
+		from = state.head + 1
+	}
+	state.head = max(state.head, head)
+	if head-from >= watchMaxGap {
+		for address := range tracked {
+			state.touch(address, head)
+		}
+		return nil
+	}
+
+	for number := from; number <= head; number++ {
+		var block *watchedBlock
+		if err := client.CallContext(ctx, &block, "eth_getBlockByNumber", hexutil.EncodeUint64(number), true); err != nil {
+			return err
+		}
+		if block == nil {
+			return fmt.Errorf("block %d not found", number)
+		}
+		for _, tx := range block.Transactions {
+			if tracked[tx.From] != nil {
+				state.touch(tx.From, number)
+			}
+			if tx.To != nil && tracked[*tx.To] != nil {
+				state.touch(*tx.To, number)
+			}
+		}
+	}
+	return nil
+}
+
+// watchedBlock is the part of a block the watcher reads. Decoding only
+// senders and recipients keeps chain-specific transaction types readable.
+type watchedBlock struct {
+	Transactions []struct {
+		From common.Address  `json:"from"`
+		To   *common.Address `json:"to"`
+	} `json:"transactions"`
+}
+
+// refreshAddresses refreshes the tracked addresses given, when this replica leads
+func (w *BlockWatcher) refreshAddresses(ctx context.Context, network string, tracked map[common.Address][]models.Address, touched []common.Address) {
+	if len(touched) == 0 || (w.isLeader != nil && !w.isLeader()) {
+		return
+	}
+	var addresses []models.Address
+	for _, address := range touched {
+		addresses = append(addresses, tracked[address]...)
+	}
+	if len(addresses) == 0 {
+		return
+	}
+	if _, err := w.refresh(ctx, addresses); err != nil && ctx.Err() == nil {
+		log.Printf("❌ Failed to refresh touched %s addresses: %v", network, err)
+	}
+}
+
+// trackedAddresses returns the active addresses on a network. The same
+// address may be in several portfolios.
+func (w *BlockWatcher) trackedAddresses(network string) (map[common.Address][]models.Address, error) {
+	var addresses []models.Address
+	if err := w.db.Where("is_active = ? AND network = ?", true, network).Find(&addresses).Error; err != nil {
+		return nil, fmt.Errorf("failed to get addresses: %w", err)
+	}
+	tracked := make(map[common.Address][]models.Address)
+	for _, address := range addresses {
+		if !common.IsHexAddress(address.Address) {
+			continue
+		}
+		key := common.HexToAddress(address.Address)
+		tracked[key] = append(tracked[key], address)
+	}
+	return tracked, nil
+}
+
+// subscribeTransfers subscribes to Transfer logs sent from and to the tracked
+// addresses; a filter can't match either topic, so it takes two subscriptions
+func subscribeTransfers(ctx context.Context, eth *ethclient.Client, tracked map[common.Address][]models.Address, logs chan<- types.Log) ([]ethereum.Subscription, error) {
+	if len(tracked) == 0 {
+		return nil, nil
+	}
+	topics := make([]common.Hash, 0, len(tracked))
+	for address := range tracked {
+		topics = append(topics, common.BytesToHash(address.Bytes()))
+	}
+
+	var subs []ethereum.Subscription
+	for _, query := range []ethereum.FilterQuery{
+		{Topics: [][]common.Hash{{erc20TransferTopic}, topics}},
+		{Topics: [][]common.Hash{{erc20TransferTopic}, nil, topics}},
+	} {
+		sub, err := eth.SubscribeFilterLogs(ctx, query, logs)
+		if err != nil {
+			unsubscribeAll(subs)
+			return nil, fmt.Errorf("failed to subscribe to Transfer logs: %w", err)
+		}
+		subs = append(subs, sub)
+	}
+	return subs, nil
+}
+
+func unsubscribeAll(subs []ethereum.Subscription) {
+	for _, sub := range subs {
+		sub.Unsubscribe()
+	}
+}
+
+// subscriptionErr returns the error channel of subs[i], or nil when there is none
+func subscriptionErr(subs []ethereum.Subscription, i int) <-chan error {
+	if i >= len(subs) {
+		return nil
+	}
+	return subs[i].Err()
+}
+
+func subscriptionEnded(name string, err error) error {
+	if err == nil {
+		err = errors.New("closed")
+	}
+	return fmt.Errorf("%s subscription ended: %w", name, err)
+}
+
+func allAddresses(tracked map[common.Address][]models.Address) []common.Address {
+	addresses := make([]common.Address, 0, len(tracked))
+	for address := range tracked {
+		addresses = append(addresses, address)
+	}
+	return addresses
+}
+
+func sameAddresses(a, b map[common.Address][]models.Address) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for address := range a {
+		if b[address] == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// blockTouches tracks which addresses blocks touched: pending ones await a
+// refresh, refreshed ones await a re-check once their block is final
+type blockTouches struct {
+	finality uint64
+	head     uint64
+	touched  map[common.Address]uint64 // latest touching block
+	rechecks map[common.Address]uint64
+}
+
+func newBlockTouches(finality uint64) *blockTouches {
+	return &blockTouches{
+		finality: finality,
+		touched:  make(map[common.Address]uint64),
+		rechecks: make(map[common.Address]uint64),
+	}
+}
+
+func (t *blockTouches) touch(address common.Address, block uint64) {
+	t.touched[address] = max(t.touched[address], block)
+}
+
+func (t *blockTouches) pending() bool {
+	return len(t.touched) > 0
+}
+
+// take returns the addresses to refresh at a head: those touched since the
+// last take, and those whose re-check block is now final
+func (t *blockTouches) take(head uint64) []common.Address {
+	var due []common.Address
+	for address, block := range t.rechecks {
+		if _, final := confirmationsAt(block, head, t.finality); final {
+			delete(t.rechecks, address)
+			if _, touched := t.touched[address]; !touched {
+				due = append(due, address)
+			}
+		}
+	}
+	for address, block := range t.touched {
+		due = append(due, address)
+		// A block that is final once it's mined can't be reorged out
+		if t.finality > 1 {
+			t.rechecks[address] = max(t.rechecks[address], block)
+		}
+		delete(t.touched, address)
+	}
+	return due
+}
@@ -0,0 +1,198 @@
+package services
+
+import (
+	"context"
+	"math/big"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/glebarez/sqlite"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"web3-portfolio-dashboard/backend/internal/config"
+	"web3-portfolio-dashboard/backend/internal/models"
+)
+
+func TestBlockTouches(t *testing.T) {
+	alice, bob := common.HexToAddress("0xa1"), common.HexToAddress("0xb0")
+	touches := newBlockTouches(4)
+
+	touches.touch(alice, 100)
+	touches.touch(bob, 101)
+	touches.touch(alice, 99)
+	require.True(t, touches.pending())
+	require.ElementsMatch(t, []common.Address{alice, bob}, touches.take(101))
+	require.False(t, touches.pending())
+
+	// Each address is read again once its latest touching block is final
+	require.Empty(t, touches.take(102))
+	require.Equal(t, []common.Address{alice}, touches.take(103))
+	touches.touch(bob, 104)
+	require.Equal(t, []common.Address{bob}, touches.take(104))
+	require.Empty(t, touches.take(106))
+	require.Equal(t, []common.Address{bob}, touches.take(107))
+	require.Empty(t, touches.take(200))
+
+	// Blocks that are final at once are not re-checked
+	touches = newBlockTouches(1)
+	touches.touch(alice, 100)
+	require.Equal(t, []common.Address{alice}, touches.take(100))
+	require.Empty(t, touches.take(100))
+}
+
+// fakeNode serves the eth_subscribe and eth_getBlockByNumber calls of a WebSocket node
+type fakeNode struct {
+	blocks     map[uint64][]map[string]interface{}
+	subscribed chan map[string]interface{} // the filter of each logs subscription
+	heads      chan *types.Header
+	logs       chan types.Log
+}
+
+func (n *fakeNode) NewHeads(ctx context.Context) (*rpc.Subscription, error) {
+	return n.forward(ctx, func(notifier *rpc.Notifier, sub *rpc.Subscription) {
+		for head := range n.heads {
+			notifier.Notify(sub.ID, head)
+		}
+	})
+}
+
+func (n *fakeNode) Logs(ctx context.Context, filter map[string]interface{}) (*rpc.Subscription, error) {
+	n.subscribed <- filter
+	return n.forward(ctx, func(notifier *rpc.Notifier, sub *rpc.Subscription) {
+		for entry := range n.logs {
+			notifier.Notify(sub.ID, entry)
+		}
+	})
+}
+
+func (n *fakeNode) forward(ctx context.Context, send func(*rpc.Notifier, *rpc.Subscription)) (*rpc.Subscription, error) {
+	notifier, _ := rpc.NotifierFromContext(ctx)
+	sub := notifier.CreateSubscription()
+	go send(notifier, sub)
+	return sub, nil
+}
+
+func (n *fakeNode) GetBlockByNumber(number hexutil.Uint64, full bool) (map[string]interface{}, error) {
+	return map[string]interface{}{"number": number, "transactions": n.blocks[uint64(number)]}, nil
+}
+
+func TestBlockWatcher(t *testing.T) {
+	alice := common.HexToAddress("0x00000000000000000000000000000000000a11ce")
+	stranger := common.HexToAddress("0x000000000000000000000000000000000000beef")
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "watcher.db")), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.Exec(`CREATE TABLE addresses (id TEXT PRIMARY KEY, portfolio_id TEXT, address TEXT, network TEXT,
+		label TEXT, is_active NUMERIC, last_refreshed_at DATETIME, created_at DATETIME, updated_at DATETIME)`).Error)
+	for _, address := range []models.Address{
+		{ID: uuid.New(), PortfolioID: uuid.New(), Address: strings.ToLower(alice.Hex()), Network: "testnet", IsActive: true},
+		{ID: uuid.New(), PortfolioID: uuid.New(), Address: alice.Hex(), Network: "testnet", IsActive: true},
+		{ID: uuid.New(), PortfolioID: uuid.New(), Address: stranger.Hex(), Network: "testnet", Label: "removed", IsActive: true},
+		{ID: uuid.New(), PortfolioID: uuid.New(), Address: stranger.Hex(), Network: "othernet", IsActive: true},
+	} {
+		require.NoError(t, db.Create(&address).Error)
+	}
+	require.NoError(t, db.Model(&models.Address{}).Where("label = ?", "removed").Update("is_active", false).Error)
+
+	node := &fakeNode{
+		blocks: map[uint64][]map[string]interface{}{
+			100: {{"from": stranger, "to": alice}},
+			101: {{"from": stranger, "to": nil}},
+		},
+		subscribed: make(chan map[string]interface{}, 2),
+		heads:      make(chan *types.Header),
+		logs:       make(chan types.Log),
+	}
+	server := rpc.NewServer()
+	require.NoError(t, server.RegisterName("eth", node))
+	httpServer := httptest.NewServer(server.WebsocketHandler([]string{"*"}))
+	t.Cleanup(func() {
+		close(node.heads)
+		close(node.logs)
+		httpServer.Close()
+		server.Stop()
+	})
+
+	cfg := &config.Config{
+		Networks: config.NewNetworkRegistry([]config.NetworkConfig{
+			{Name: "testnet", WSURL: "ws" + strings.TrimPrefix(httpServer.URL, "http"), Finality: 2},
+			{Name: "othernet", RPCURLs: []string{"http://localhost"}},
+		}),
+	}
+	refreshed := make(chan []string, 8)
+	watcher := NewBlockWatcher(db, cfg, func(ctx context.Context, addresses []models.Address) (int, error) {
+		var ids []string
+		for _, address := range addresses {
+			ids = append(ids, address.Address)
+		}
+		sort.Strings(ids)
+		refreshed <- ids
+		return len(addresses), nil
+	}, nil)
+	watcher.flushDelay = 10 * time.Millisecond
+	require.Len(t, watcher.networks, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		watcher.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	// Transfer logs are followed from and to the tracked address
+	aliceTopic := common.BytesToHash(alice.Bytes()).Hex()
+	var filters []map[string]interface{}
+	for i := 0; i < 2; i++ {
+		select {
+		case filter := <-node.subscribed:
+			filters = append(filters, filter)
+		case <-time.After(5 * time.Second):
+			t.Fatal("logs subscription not made")
+		}
+	}
+	topics := []interface{}{filters[0]["topics"], filters[1]["topics"]}
+	require.Contains(t, topics, []interface{}{[]interface{}{erc20TransferTopic.Hex()}, []interface{}{aliceTopic}})
+	require.Contains(t, topics, []interface{}{[]interface{}{erc20TransferTopic.Hex()}, nil, []interface{}{aliceTopic}})
+
+	expect := func(want ...string) {
+		t.Helper()
+		select {
+		case ids := <-refreshed:
+			require.Equal(t, want, ids)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no refresh, want %v", want)
+		}
+	}
+	both := []string{alice.Hex(), strings.ToLower(alice.Hex())}
+	sort.Strings(both)
+
+	// A native transfer in a new block refreshes the recipient in every portfolio
+	node.heads <- &types.Header{Number: big.NewInt(100), Difficulty: new(big.Int)}
+	expect(both...)
+
+	// So does a Transfer log, and a later block that confirms it reads the address again
+	node.logs <- types.Log{
+		Address:     stranger,
+		Topics:      []common.Hash{erc20TransferTopic, common.BytesToHash(stranger.Bytes()), common.BytesToHash(alice.Bytes())},
+		BlockNumber: 101,
+	}
+	expect(both...)
+	node.heads <- &types.Header{Number: big.NewInt(101), Difficulty: new(big.Int)}
+	node.heads <- &types.Header{Number: big.NewInt(103), Difficulty: new(big.Int)}
+	expect(both...)
+	require.Empty(t, refreshed)
+}
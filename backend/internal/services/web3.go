@@ -5,20 +5,34 @@ import (
 	"fmt"
 	"log"
 	"math/big"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ethereum/go-ethereum"
-	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
 
 	"web3-portfolio-dashboard/backend/internal/config"
+	"web3-portfolio-dashboard/backend/internal/models"
 )
 
 type Web3Service struct {
-	clients map[string]*ethclient.Client
-	config  *config.Config
+	pools    map[string]*rpcPool
+	networks *config.NetworkRegistry
+	config   *config.Config
+	stop     context.CancelFunc
+	catalog  *TokenCatalogService
+	prices   *PriceService
+
+	// Resolved ERC-20 metadata keyed by "network:address"
+	tokenMeta   map[string]models.Token
+	tokenMetaMu sync.RWMutex
 }
 
 type BalanceInfo struct {
@@ -46,110 +60,166 @@ type TransactionInfo struct {
 	Value        string    `json:"value"`
 	TokenAddress string    `json:"token_address"`
 	BlockNumber  uint64    `json:"block_number"`
+	BlockHash    string    `json:"block_hash"`
 	Timestamp    time.Time `json:"timestamp"`
+	MethodID     string    `json:"method_id"` // first 4 bytes of the call data, empty for plain transfers
+	GasUsed      uint64    `json:"gas_used"`
+	GasPrice     string    `json:"gas_price"` // effective price paid per gas, in wei
+	Success      bool      `json:"success"`
 }
 
-// Add a map of known token decimals
-var tokenDecimals = map[string]uint8{
-	// Ethereum
-	"0xdAC17F958D2ee523a2206206994597C13D831ec7": 6,  // USDT
-	"0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48": 6,  // USDC
-	"0x6B175474E89094C44Da98b954EedeAC495271d0F": 18, // DAI
-	"0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2": 18, // WETH
-	// Polygon
-	"0xc2132D05D31c914a87C6611C10748AEb04B58e8F": 6,  // USDT
-	"0x2791Bca1f2de4661ED88A30C99A7a9449Aa84174": 6,  // USDC
-	"0x8f3Cf7ad23Cd3CaDbD9735AFf958023239c6A063": 18, // DAI
-	"0x0d500B1d8E8eF31E21C99d1Db9A6444d3ADf1270": 18, // WMATIC
-	// BSC
-	"0x55d398326f99059fF775485246999027B3197955": 18, // USDT
-	"0x8ac76a51cc950d9822d68b83fe1ad97b32cd580d": 18, // USDC
-	"0x1AF3F329e8BE154074D8769D1FFa4eE058B1DBc3": 18, // DAI
-	"0x2170Ed0880ac9A755fd29B2688956BD959F933F8": 18, // WETH
-	// Arbitrum (add more as needed)
+// NetworkInfo describes a registered network and whether it is connected
+type NetworkInfo struct {
+	Name         string `json:"name"`
+	DisplayName  string `json:"display_name"`
+	ChainID      uint64 `json:"chain_id"`
+	NativeSymbol string `json:"native_symbol"`
+	NativeName   string `json:"native_name"`
+	Decimals     uint8  `json:"decimals"`
+	ExplorerURL  string `json:"explorer_url"`
+	TokenCount   int    `json:"token_count"`
+	Connected    bool   `json:"connected"`
+}
+
+// NetworkStatus is the health of a network's RPC pool
+type NetworkStatus struct {
+	Connected bool             `json:"connected"`
+	Endpoints []EndpointStatus `json:"endpoints"`
 }
 
 func NewWeb3Service(cfg *config.Config) *Web3Service {
-	clients := make(map[string]*ethclient.Client)
-
-	// Initialize Ethereum client
-	if cfg.EthereumRPCURL != "" {
-		log.Printf("Connecting to Ethereum RPC: %s", cfg.EthereumRPCURL)
-		if client, err := ethclient.Dial(cfg.EthereumRPCURL); err == nil {
-			// Test the connection
-			if _, err := client.BlockNumber(context.Background()); err == nil {
-				clients["ethereum"] = client
-				log.Printf("✅ Ethereum client connected successfully")
-			} else {
-				log.Printf("❌ Ethereum client connection test failed: %v", err)
-			}
-		} else {
-			log.Printf("❌ Failed to connect to Ethereum RPC: %v", err)
-		}
+	pools := make(map[string]*rpcPool)
+
+	networks := cfg.Networks
+	if networks == nil {
+		networks = config.NewNetworkRegistry(nil)
 	}
 
-	// Initialize Polygon client
-	if cfg.PolygonRPCURL != "" {
-		log.Printf("Connecting to Polygon RPC: %s", cfg.PolygonRPCURL)
-		if client, err := ethclient.Dial(cfg.PolygonRPCURL); err == nil {
-			// Test the connection
-			if _, err := client.BlockNumber(context.Background()); err == nil {
-				clients["polygon"] = client
-				log.Printf("✅ Polygon client connected successfully")
-			} else {
-				log.Printf("❌ Polygon client connection test failed: %v", err)
-			}
-		} else {
-			log.Printf("❌ Failed to connect to Polygon RPC: %v", err)
-		}
+	opts := RPCPoolOptions{
+		Timeout:          cfg.RPCTimeout,
+		ProbeInterval:    cfg.RPCProbeInterval,
+		FailureThreshold: cfg.RPCBreakerThreshold,
+		Cooldown:         cfg.RPCBreakerCooldown,
 	}
 
-	// Initialize BSC client
-	if cfg.BSCRPCURL != "" {
-		log.Printf("Connecting to BSC RPC: %s", cfg.BSCRPCURL)
-		if client, err := ethclient.Dial(cfg.BSCRPCURL); err == nil {
-			// Test the connection
-			if _, err := client.BlockNumber(context.Background()); err == nil {
-				clients["bsc"] = client
-				log.Printf("✅ BSC client connected successfully")
-			} else {
-				log.Printf("❌ BSC client connection test failed: %v", err)
-			}
-		} else {
-			log.Printf("❌ Failed to connect to BSC RPC: %v", err)
+	for _, network := range networks.All() {
+		if len(network.RPCURLs) == 0 {
+			continue
 		}
+		pools[network.Name] = newRPCPool(network.Name, network.RPCURLs, opts)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Web3Service{
+		pools:     pools,
+		networks:  networks,
+		config:    cfg,
+		stop:      cancel,
+		tokenMeta: make(map[string]models.Token),
 	}
 
-	// Initialize Arbitrum client
-	if cfg.ArbitrumRPCURL != "" {
-		log.Printf("Connecting to Arbitrum RPC: %s", cfg.ArbitrumRPCURL)
-		if client, err := ethclient.Dial(cfg.ArbitrumRPCURL); err == nil {
-			// Test the connection
-			if _, err := client.BlockNumber(context.Background()); err == nil {
-				clients["arbitrum"] = client
-				log.Printf("✅ Arbitrum client connected successfully")
+	// Probe every pool at once up front so status is known at boot without
+	// waiting out each unreachable endpoint in turn, then keep probing in the background
+	var wg sync.WaitGroup
+	var connected atomic.Int32
+	for name, pool := range pools {
+		wg.Add(1)
+		go func(name string, pool *rpcPool) {
+			defer wg.Done()
+			if pool.Probe(ctx) > 0 {
+				connected.Add(1)
+				log.Printf("✅ %s RPC pool connected", name)
 			} else {
-				log.Printf("❌ Arbitrum client connection test failed: %v", err)
+				log.Printf("❌ %s RPC pool has no healthy endpoint, will keep retrying", name)
 			}
-		} else {
-			log.Printf("❌ Failed to connect to Arbitrum RPC: %v", err)
-		}
+			go pool.Run(ctx)
+		}(name, pool)
 	}
+	wg.Wait()
+
+	log.Printf("Web3Service initialized with %d connected networks", connected.Load())
+	return s
+}
 
-	log.Printf("Web3Service initialized with %d connected networks", len(clients))
-	return &Web3Service{
-		clients: clients,
-		config:  cfg,
+// Close stops background health probes and closes RPC connections
+func (s *Web3Service) Close() {
+	s.stop()
+	for _, pool := range s.pools {
+		pool.Close()
 	}
 }
 
-// GetBalance gets the native token balance for an address
-func (s *Web3Service) GetBalance(address, network string) (*big.Int, error) {
-	client, exists := s.clients[network]
+// SetTokenCatalog makes token reads use and fill the persistent token catalog
+func (s *Web3Service) SetTokenCatalog(catalog *TokenCatalogService) {
+	s.catalog = catalog
+}
+
+// SetPriceService sets where token prices come from
+func (s *Web3Service) SetPriceService(prices *PriceService) {
+	s.prices = prices
+}
+
+// withClient runs fn against a healthy client of the network, failing over between endpoints
+func (s *Web3Service) withClient(ctx context.Context, network string, fn func(ctx context.Context, client *ethclient.Client) error) error {
+	pool, exists := s.pools[network]
 	if !exists {
-		return nil, fmt.Errorf("network %s not supported or not connected", network)
+		return fmt.Errorf("network %s not supported or not connected", network)
+	}
+	return pool.Do(ctx, fn)
+}
+
+// Networks returns every registered network with its connection state
+func (s *Web3Service) Networks() []NetworkInfo {
+	var networks []NetworkInfo
+	for _, network := range s.networks.All() {
+		pool, exists := s.pools[network.Name]
+		connected := exists && pool.Healthy()
+		networks = append(networks, NetworkInfo{
+			Name:         network.Name,
+			DisplayName:  network.DisplayName,
+			ChainID:      network.ChainID,
+			NativeSymbol: network.NativeSymbol,
+			NativeName:   network.NativeName,
+			Decimals:     network.Decimals,
+			ExplorerURL:  network.ExplorerURL,
+			TokenCount:   len(network.Tokens),
+			Connected:    connected,
+		})
+	}
+	return networks
+}
+
+// Network returns the registry entry for a network
+func (s *Web3Service) Network(name string) (*config.NetworkConfig, bool) {
+	return s.networks.Get(name)
+}
+
+// NativeTokenSymbol returns the native token symbol of a network
+func (s *Web3Service) NativeTokenSymbol(network string) string {
+	if n, ok := s.networks.Get(network); ok && n.NativeSymbol != "" {
+		return n.NativeSymbol
+	}
+	return "NATIVE"
+}
+
+// NativeTokenName returns the native token name of a network
+func (s *Web3Service) NativeTokenName(network string) string {
+	if n, ok := s.networks.Get(network); ok && n.NativeName != "" {
+		return n.NativeName
+	}
+	return "Native Token"
+}
+
+// NativeTokenDecimals returns the native token decimals of a network
+func (s *Web3Service) NativeTokenDecimals(network string) uint8 {
+	if n, ok := s.networks.Get(network); ok {
+		return n.Decimals
 	}
+	return 18
+}
 
+// GetBalance gets the native token balance for an address
+func (s *Web3Service) GetBalance(ctx context.Context, address, network string) (*big.Int, error) {
 	// Validate address
 	if !common.IsHexAddress(address) {
 		return nil, fmt.Errorf("invalid address format: %s", address)
@@ -158,7 +228,12 @@ func (s *Web3Service) GetBalance(address, network string) (*big.Int, error) {
 	addr := common.HexToAddress(address)
 	log.Printf("Getting balance for address %s on network %s", address, network)
 
-	balance, err := client.BalanceAt(context.Background(), addr, nil)
+	var balance *big.Int
+	err := s.withClient(ctx, network, func(ctx context.Context, client *ethclient.Client) error {
+		var err error
+		balance, err = client.BalanceAt(ctx, addr, nil)
+		return err
+	})
 	if err != nil {
 		log.Printf("Error getting balance for %s on %s: %v", address, network, err)
 		return nil, fmt.Errorf("failed to get balance: %w", err)
@@ -169,21 +244,12 @@ func (s *Web3Service) GetBalance(address, network string) (*big.Int, error) {
 }
 
 // GetTokenBalance gets the ERC-20 token balance for an address
-func (s *Web3Service) GetTokenBalance(address, tokenAddress, network string) (*big.Int, error) {
-	client, exists := s.clients[network]
-	if !exists {
-		return nil, fmt.Errorf("network %s not supported", network)
-	}
-
+func (s *Web3Service) GetTokenBalance(ctx context.Context, address, tokenAddress, network string) (*big.Int, error) {
 	if !common.IsHexAddress(address) || !common.IsHexAddress(tokenAddress) {
 		return nil, fmt.Errorf("invalid address format")
 	}
 
 	// Use ABI encoding for balanceOf(address)
-	erc20ABI, err := abi.JSON(strings.NewReader(`[{"constant":true,"inputs":[{"name":"_owner","type":"address"}],"name":"balanceOf","outputs":[{"name":"balance","type":"uint256"}],"type":"function"}]`))
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse ABI: %w", err)
-	}
 	data, err := erc20ABI.Pack("balanceOf", common.HexToAddress(address))
 	if err != nil {
 		return nil, fmt.Errorf("failed to pack ABI: %w", err)
@@ -194,7 +260,12 @@ func (s *Web3Service) GetTokenBalance(address, tokenAddress, network string) (*b
 		To:   &to,
 		Data: data,
 	}
-	result, err := client.CallContract(context.Background(), msg, nil)
+	var result []byte
+	err = s.withClient(ctx, network, func(ctx context.Context, client *ethclient.Client) error {
+		var err error
+		result, err = client.CallContract(ctx, msg, nil)
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to call contract: %w", err)
 	}
@@ -207,148 +278,435 @@ func (s *Web3Service) GetTokenBalance(address, tokenAddress, network string) (*b
 	return balance, nil
 }
 
+// HeldAmount is a balance in whole token units
+type HeldAmount struct {
+	Symbol       string
+	TokenAddress string // empty for the native token
+	Units        *big.Rat
+}
+
+// GetHeldAmount reads an address's balance of the native token, or of an ERC-20
+// token given by contract address or by the symbol of a tracked token, and
+// normalizes it by the token's decimals
+func (s *Web3Service) GetHeldAmount(ctx context.Context, address, network, token string) (*HeldAmount, error) {
+	if token == "" || strings.EqualFold(token, s.NativeTokenSymbol(network)) {
+		balance, err := s.GetBalance(ctx, address, network)
+		if err != nil {
+			return nil, err
+		}
+		return &HeldAmount{
+			Symbol: s.NativeTokenSymbol(network),
+			Units:  unitsOf(balance, s.NativeTokenDecimals(network)),
+		}, nil
+	}
+
+	tokenAddr, meta, err := s.resolveToken(ctx, network, address, token)
+	if err != nil {
+		return nil, err
+	}
+	balance, err := s.GetTokenBalance(ctx, address, tokenAddr.Hex(), network)
+	if err != nil {
+		return nil, err
+	}
+	return &HeldAmount{Symbol: meta.Symbol, TokenAddress: tokenAddr.Hex(), Units: unitsOf(balance, meta.Decimals)}, nil
+}
+
+// resolveToken finds an ERC-20 token by contract address, or by symbol among the
+// tokens tracked for a holder
+func (s *Web3Service) resolveToken(ctx context.Context, network, holder, token string) (common.Address, models.Token, error) {
+	if common.IsHexAddress(token) {
+		tokenAddr := common.HexToAddress(token)
+		meta, ok := s.TokenMetadata(ctx, network, []common.Address{tokenAddr})[tokenAddr]
+		if !ok {
+			return common.Address{}, models.Token{}, fmt.Errorf("%s is not an ERC-20 token on %s", token, network)
+		}
+		return tokenAddr, meta, nil
+	}
+
+	networkConfig, ok := s.networks.Get(network)
+	if !ok {
+		return common.Address{}, models.Token{}, fmt.Errorf("network %s not supported", network)
+	}
+	var tokenAddrs []common.Address
+	for _, tracked := range s.trackedTokens(networkConfig, []common.Address{common.HexToAddress(holder)}) {
+		tokenAddrs = append(tokenAddrs, common.HexToAddress(tracked.Address))
+	}
+	metadata := s.TokenMetadata(ctx, network, tokenAddrs)
+	for _, tokenAddr := range tokenAddrs {
+		if meta, ok := metadata[tokenAddr]; ok && strings.EqualFold(meta.Symbol, token) {
+			return tokenAddr, meta, nil
+		}
+	}
+	return common.Address{}, models.Token{}, fmt.Errorf("token %s is not tracked on %s", token, network)
+}
+
+// unitsOf divides a base-unit amount by 10^decimals, exactly
+func unitsOf(amount *big.Int, decimals uint8) *big.Rat {
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)
+	return new(big.Rat).SetFrac(amount, scale)
+}
+
 // GetPortfolioBalances gets balances for multiple addresses
-func (s *Web3Service) GetPortfolioBalances(addresses []string, network string) ([]BalanceInfo, error) {
+func (s *Web3Service) GetPortfolioBalances(ctx context.Context, addresses []string, network string) ([]BalanceInfo, error) {
 	var balances []BalanceInfo
 
+	// Get token balances for all addresses in one batch
+	tokenBalances, _ := s.GetTokenBalancesForAddresses(ctx, addresses, network)
+
 	for _, address := range addresses {
-		balance, err := s.GetBalance(address, network)
+		balance, err := s.GetBalance(ctx, address, network)
 		if err != nil {
 			continue // Skip failed addresses
 		}
 
-		balanceInfo := BalanceInfo{
-			Address: address,
-			Network: network,
-			Balance: balance.String(),
+		balances = append(balances, BalanceInfo{
+			Address:       address,
+			Network:       network,
+			Balance:       balance.String(),
+			TokenBalances: tokenBalances[address],
+		})
+	}
+
+	return balances, nil
+}
+
+// GetTokenBalances gets all token balances for an address
+func (s *Web3Service) GetTokenBalances(ctx context.Context, address, network string) ([]TokenBalance, error) {
+	balances, err := s.GetTokenBalancesForAddresses(ctx, []string{address}, network)
+	if err != nil {
+		return nil, err
+	}
+	return balances[address], nil
+}
+
+// GetTokenBalancesForAddresses gets the token balances of several addresses on
+// one network. balanceOf, and decimals/symbol/name for tokens not seen before,
+// are batched through Multicall3 or a JSON-RPC batch.
+func (s *Web3Service) GetTokenBalancesForAddresses(ctx context.Context, addresses []string, network string) (map[string][]TokenBalance, error) {
+	networkConfig, exists := s.networks.Get(network)
+	if !exists {
+		return nil, fmt.Errorf("network %s not supported for token balances", network)
+	}
+	network = networkConfig.Name
+
+	holders := make([]common.Address, 0, len(addresses))
+	for _, address := range addresses {
+		if !common.IsHexAddress(address) {
+			return nil, fmt.Errorf("invalid address format: %s", address)
 		}
+		holders = append(holders, common.HexToAddress(address))
+	}
 
-		// Get token balances if supported
-		if network == "ethereum" {
-			tokenBalances, err := s.GetTokenBalances(address, network)
-			if err == nil {
-				balanceInfo.TokenBalances = tokenBalances
+	tokens := s.trackedTokens(networkConfig, holders)
+	if len(tokens) == 0 {
+		return map[string][]TokenBalance{}, nil
+	}
+
+	tokenAddrs := make([]common.Address, 0, len(tokens))
+	for _, token := range tokens {
+		tokenAddrs = append(tokenAddrs, common.HexToAddress(token.Address))
+	}
+	metadataFor := s.loadTokenMetadata(network, tokenAddrs)
+
+	result, err := readERC20Batch(ctx, s.batchCaller(network), holders, tokenAddrs, metadataFor)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token balances: %w", err)
+	}
+
+	discovered := onChainTokens(network, result.Metadata)
+	for _, token := range discovered {
+		s.cacheTokenMetadata(network, common.HexToAddress(token.Address), token)
+	}
+	if s.catalog != nil && len(discovered) > 0 {
+		if err := s.catalog.saveDiscovered(discovered); err != nil {
+			log.Printf("Failed to save discovered tokens on %s: %v", network, err)
+		}
+	}
+
+	listed := make(map[common.Address]bool, len(networkConfig.Tokens))
+	for _, token := range networkConfig.Tokens {
+		listed[common.HexToAddress(token.Address)] = true
+	}
+
+	balances := make(map[string][]TokenBalance, len(addresses))
+	for i, address := range addresses {
+		for j, token := range tokens {
+			tokenAddr := tokenAddrs[j]
+			balance, ok := result.Balances[holders[i]][tokenAddr]
+			if !ok || balance.Sign() == 0 {
+				continue // Skip failed or zero balances
+			}
+			meta, known := s.cachedTokenMetadata(network, tokenAddr)
+			if !known && !listed[tokenAddr] {
+				continue // A transferred token's decimals are unknown until its metadata is read
 			}
+
+			// Admin overrides win, then on-chain or catalog metadata, then the registry
+			tokenBalance := TokenBalance{
+				TokenAddress: token.Address,
+				Symbol:       token.Symbol,
+				Name:         token.Name,
+				Amount:       balance.String(),
+				Decimals:     token.Decimals,
+			}
+			if known {
+				tokenBalance.Decimals = meta.Decimals
+				if meta.IsOverride || tokenBalance.Symbol == "" {
+					tokenBalance.Symbol = meta.Symbol
+				}
+				if meta.IsOverride || tokenBalance.Name == "" {
+					tokenBalance.Name = meta.Name
+				}
+			}
+
+			balances[address] = append(balances[address], tokenBalance)
 		}
+	}
 
-		balances = append(balances, balanceInfo)
+	// Price every held token in one go
+	var symbols []string
+	for _, tokenBalances := range balances {
+		for _, tokenBalance := range tokenBalances {
+			symbols = append(symbols, tokenBalance.Symbol)
+		}
+	}
+	prices := s.tokenPrices(ctx, symbols)
+	for _, tokenBalances := range balances {
+		for i := range tokenBalances {
+			tokenBalance := &tokenBalances[i]
+			price, ok := prices[strings.ToUpper(tokenBalance.Symbol)]
+			if !ok {
+				continue
+			}
+			amount, _ := new(big.Int).SetString(tokenBalance.Amount, 10)
+			tokenBalance.Price = formatPrice(price.Price)
+			tokenBalance.Value = tokenValue(amount, tokenBalance.Decimals, tokenBalance.Price)
+		}
 	}
 
 	return balances, nil
 }
 
-// GetTokenBalances gets all token balances for an address
-func (s *Web3Service) GetTokenBalances(address, network string) ([]TokenBalance, error) {
-	// This is a simplified version - in production, you'd want to:
-	// 1. Use a token list API
-	// 2. Cache results
-	// 3. Handle rate limiting
-	// 4. Use batch calls for efficiency
-
-	// Common tokens for each network
-	tokens := map[string][]struct {
-		Address string
-		Symbol  string
-		Name    string
-	}{
-		"ethereum": {
-			{"0xdAC17F958D2ee523a2206206994597C13D831ec7", "USDT", "Tether USD"},
-			{"0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48", "USDC", "USD Coin"},
-			{"0x6B175474E89094C44Da98b954EedeAC495271d0F", "DAI", "Dai Stablecoin"},
-			{"0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2", "WETH", "Wrapped Ether"},
-		},
-		"polygon": {
-			{"0xc2132D05D31c914a87C6611C10748AEb04B58e8F", "USDT", "Tether USD"},
-			{"0x2791Bca1f2de4661ED88A30C99A7a9449Aa84174", "USDC", "USD Coin"},
-			{"0x8f3Cf7ad23Cd3CaDbD9735AFf958023239c6A063", "DAI", "Dai Stablecoin"},
-			{"0x0d500B1d8E8eF31E21C99d1Db9A6444d3ADf1270", "WMATIC", "Wrapped MATIC"},
-		},
-		"bsc": {
-			{"0x55d398326f99059fF775485246999027B3197955", "USDT", "Tether USD"},
-			{"0x8ac76a51cc950d9822d68b83fe1ad97b32cd580d", "USDC", "USD Coin"},
-			{"0x1AF3F329e8BE154074D8769D1FFa4eE058B1DBc3", "DAI", "Dai Stablecoin"},
-			{"0x2170Ed0880ac9A755fd29B2688956BD959F933F8", "WETH", "Wrapped Ether"},
-		},
-		"arbitrum": {
-			// Add Arbitrum tokens as needed
-		},
-	}
-
-	networkTokens, exists := tokens[network]
-	if !exists {
-		return nil, fmt.Errorf("network %s not supported for token balances", network)
+// tokenPrices gets prices for a set of symbols, keyed by upper-case symbol
+func (s *Web3Service) tokenPrices(ctx context.Context, symbols []string) map[string]TokenPrice {
+	if s.prices == nil || len(symbols) == 0 {
+		return map[string]TokenPrice{}
 	}
+	prices, err := s.prices.GetPrices(ctx, symbols)
+	if err != nil {
+		log.Printf("Failed to get token prices: %v", err)
+		return map[string]TokenPrice{}
+	}
+	return prices
+}
 
-	var tokenBalances []TokenBalance
+// ReadTokenMetadata reads decimals, symbol and name of tokens on-chain.
+// Tokens that don't answer decimals() are left out.
+func (s *Web3Service) ReadTokenMetadata(ctx context.Context, network string, tokens []common.Address) ([]models.Token, error) {
+	result, err := readERC20Batch(ctx, s.batchCaller(network), nil, nil, tokens)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token metadata: %w", err)
+	}
+	return onChainTokens(network, result.Metadata), nil
+}
+
+// TokenMetadata returns metadata for tokens from the cache, the catalog or on-chain.
+// Tokens read on-chain are added to the catalog; tokens that aren't ERC-20 are left out.
+func (s *Web3Service) TokenMetadata(ctx context.Context, network string, tokens []common.Address) map[common.Address]models.Token {
+	if missing := s.loadTokenMetadata(network, tokens); len(missing) > 0 {
+		discovered, err := s.ReadTokenMetadata(ctx, network, missing)
+		if err != nil {
+			log.Printf("Failed to read token metadata on %s: %v", network, err)
+		}
+		for _, token := range discovered {
+			s.cacheTokenMetadata(network, common.HexToAddress(token.Address), token)
+		}
+		if s.catalog != nil && len(discovered) > 0 {
+			if err := s.catalog.saveDiscovered(discovered); err != nil {
+				log.Printf("Failed to save discovered tokens on %s: %v", network, err)
+			}
+		}
+	}
 
-	for _, token := range networkTokens {
-		balance, err := s.GetTokenBalance(address, token.Address, network)
-		if err != nil || balance.Cmp(big.NewInt(0)) == 0 {
-			continue // Skip failed or zero balances
+	metadata := make(map[common.Address]models.Token, len(tokens))
+	for _, token := range tokens {
+		if meta, ok := s.cachedTokenMetadata(network, token); ok {
+			metadata[token] = meta
 		}
+	}
+	return metadata
+}
 
-		decimals := uint8(18)
-		if d, ok := tokenDecimals[strings.ToLower(token.Address)]; ok {
-			decimals = d
+// trackedTokens returns the registry tokens of a network plus the tokens the
+// holders sent or received in indexed transfers. The rest of the catalog is
+// only used for metadata.
+func (s *Web3Service) trackedTokens(networkConfig *config.NetworkConfig, holders []common.Address) []config.TokenConfig {
+	tokens := make([]config.TokenConfig, 0, len(networkConfig.Tokens))
+	seen := make(map[common.Address]bool)
+	for _, token := range networkConfig.Tokens {
+		tokenAddr := common.HexToAddress(token.Address)
+		if !seen[tokenAddr] {
+			seen[tokenAddr] = true
+			tokens = append(tokens, token)
 		}
-		tokenBalance := TokenBalance{
-			TokenAddress: token.Address,
-			Symbol:       token.Symbol,
-			Name:         token.Name,
-			Amount:       balance.String(),
-			Decimals:     decimals,
+	}
+
+	if s.catalog == nil {
+		return tokens
+	}
+	transferred, err := s.catalog.transferredTokens(networkConfig.Name, holders)
+	if err != nil {
+		log.Printf("Failed to load transferred tokens for %s: %v", networkConfig.Name, err)
+		return tokens
+	}
+	for _, tokenAddr := range transferred {
+		if !seen[tokenAddr] {
+			seen[tokenAddr] = true
+			tokens = append(tokens, config.TokenConfig{Address: tokenAddr.Hex()})
 		}
+	}
+	return tokens
+}
 
-		// Get price from cache or API
-		price, err := s.GetTokenPrice(token.Symbol)
-		if err == nil {
-			tokenBalance.Price = price
-			amountFloat := new(big.Float).SetInt(balance)
-			amountDiv := new(big.Float).Quo(amountFloat, new(big.Float).SetFloat64(float64(1)).SetPrec(uint(decimals*3)).SetInt(big.NewInt(0).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)))
-			priceFloat, _ := new(big.Float).SetString(price)
-			valueFloat := new(big.Float).Mul(amountDiv, priceFloat)
-			tokenBalance.Value = valueFloat.Text('f', 8)
+// loadTokenMetadata fills the in-memory cache from the catalog and returns the
+// tokens whose metadata still has to be read on-chain
+func (s *Web3Service) loadTokenMetadata(network string, tokens []common.Address) []common.Address {
+	var missing []common.Address
+	for _, token := range tokens {
+		if _, cached := s.cachedTokenMetadata(network, token); !cached {
+			missing = append(missing, token)
 		}
+	}
+	if s.catalog == nil || len(missing) == 0 {
+		return missing
+	}
 
-		tokenBalances = append(tokenBalances, tokenBalance)
+	known, err := s.catalog.lookup(network, missing)
+	if err != nil {
+		log.Printf("Failed to look up token catalog for %s: %v", network, err)
+		return missing
 	}
 
-	return tokenBalances, nil
+	var unknown []common.Address
+	for _, token := range missing {
+		if entry, ok := known[token]; ok {
+			s.cacheTokenMetadata(network, token, entry)
+			continue
+		}
+		unknown = append(unknown, token)
+	}
+	return unknown
 }
 
-// GetTokenPrice gets the current price of a token
-func (s *Web3Service) GetTokenPrice(symbol string) (string, error) {
-	// In production, you'd want to:
-	// 1. Use CoinGecko API
-	// 2. Cache results
-	// 3. Handle rate limiting
-	// 4. Use WebSocket for real-time updates
+// onChainTokens converts on-chain metadata into catalog entries
+func onChainTokens(network string, metadata map[common.Address]erc20Metadata) []models.Token {
+	var tokens []models.Token
+	for tokenAddr, meta := range metadata {
+		if !meta.HasDecimals {
+			continue
+		}
+		tokens = append(tokens, models.Token{
+			Network:  network,
+			Address:  tokenAddr.Hex(),
+			Symbol:   meta.Symbol,
+			Name:     meta.Name,
+			Decimals: meta.Decimals,
+			Source:   TokenSourceOnChain,
+		})
+	}
+	return tokens
+}
 
-	// Mock prices for demo
-	prices := map[string]string{
-		"USDT":   "1.00",
-		"USDC":   "1.00",
-		"DAI":    "1.00",
-		"WETH":   "2000.00",
-		"WMATIC": "0.80",
+// batchCaller returns a batchCaller for a network that prefers Multicall3 and
+// falls back to JSON-RPC batches when the network has no Multicall3 or it fails
+func (s *Web3Service) batchCaller(network string) batchCaller {
+	var multicall *common.Address
+	if n, ok := s.networks.Get(network); ok && common.IsHexAddress(n.Multicall3) {
+		addr := common.HexToAddress(n.Multicall3)
+		multicall = &addr
 	}
 
-	price, exists := prices[strings.ToUpper(symbol)]
-	if !exists {
+	return func(ctx context.Context, calls []contractCall) ([]callResult, error) {
+		var results []callResult
+		err := s.withClient(ctx, network, func(ctx context.Context, client *ethclient.Client) error {
+			if multicall != nil {
+				r, err := aggregate3(ctx, client, *multicall, calls)
+				if err == nil {
+					results = r
+					return nil
+				}
+				log.Printf("Multicall3 failed on %s, falling back to batched eth_call: %v", network, err)
+			}
+
+			r, err := batchEthCall(ctx, client.Client(), calls)
+			if err != nil {
+				return err
+			}
+			results = r
+			return nil
+		})
+		return results, err
+	}
+}
+
+func (s *Web3Service) cachedTokenMetadata(network string, token common.Address) (models.Token, bool) {
+	s.tokenMetaMu.RLock()
+	defer s.tokenMetaMu.RUnlock()
+	meta, ok := s.tokenMeta[network+":"+token.Hex()]
+	return meta, ok
+}
+
+func (s *Web3Service) cacheTokenMetadata(network string, token common.Address, meta models.Token) {
+	s.tokenMetaMu.Lock()
+	defer s.tokenMetaMu.Unlock()
+	s.tokenMeta[network+":"+token.Hex()] = meta
+}
+
+// forgetToken drops cached metadata so the next read goes back to the catalog
+func (s *Web3Service) forgetToken(network string, token common.Address) {
+	s.tokenMetaMu.Lock()
+	defer s.tokenMetaMu.Unlock()
+	delete(s.tokenMeta, network+":"+token.Hex())
+}
+
+// tokenValue returns amount (in base units) times price, as a decimal string
+func tokenValue(amount *big.Int, decimals uint8, price string) string {
+	priceFloat, ok := new(big.Float).SetString(price)
+	if !ok {
+		return ""
+	}
+	scale := new(big.Float).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil))
+	units := new(big.Float).Quo(new(big.Float).SetInt(amount), scale)
+	return new(big.Float).Mul(units, priceFloat).Text('f', 8)
+}
+
+// GetTokenPrice gets the current USD price of a token
+func (s *Web3Service) GetTokenPrice(ctx context.Context, symbol string) (string, error) {
+	if s.prices == nil {
 		return "0.00", fmt.Errorf("price not available for %s", symbol)
 	}
 
-	return price, nil
+	price, err := s.prices.GetPrice(ctx, symbol)
+	if err != nil {
+		return "0.00", err
+	}
+
+	return formatPrice(price.Price), nil
+}
+
+// formatPrice formats a USD price without losing precision on small values
+func formatPrice(price float64) string {
+	return strconv.FormatFloat(price, 'f', -1, 64)
 }
 
 // GetGasPrice gets the current gas price for a network
 func (s *Web3Service) GetGasPrice(network string) (*big.Int, error) {
-	client, exists := s.clients[network]
-	if !exists {
-		return nil, fmt.Errorf("network %s not supported", network)
-	}
-
-	gasPrice, err := client.SuggestGasPrice(context.Background())
+	var gasPrice *big.Int
+	err := s.withClient(context.Background(), network, func(ctx context.Context, client *ethclient.Client) error {
+		var err error
+		gasPrice, err = client.SuggestGasPrice(ctx)
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get gas price: %w", err)
 	}
@@ -356,13 +714,208 @@ func (s *Web3Service) GetGasPrice(network string) (*big.Int, error) {
 	return gasPrice, nil
 }
 
-// GetNetworkStatus returns the status of all networks
-func (s *Web3Service) GetNetworkStatus() map[string]bool {
-	status := make(map[string]bool)
+// FeeHistory gets the base fees and priority fee percentiles of the latest blocks of a network
+func (s *Web3Service) FeeHistory(ctx context.Context, network string, blocks uint64, percentiles []float64) (*ethereum.FeeHistory, error) {
+	var history *ethereum.FeeHistory
+	err := s.withClient(ctx, network, func(ctx context.Context, client *ethclient.Client) error {
+		var err error
+		history, err = client.FeeHistory(ctx, blocks, nil, percentiles)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get fee history: %w", err)
+	}
+	return history, nil
+}
+
+// BlockNumber gets the latest block number of a network
+func (s *Web3Service) BlockNumber(ctx context.Context, network string) (uint64, error) {
+	var head uint64
+	err := s.withClient(ctx, network, func(ctx context.Context, client *ethclient.Client) error {
+		var err error
+		head, err = client.BlockNumber(ctx)
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to get block number: %w", err)
+	}
+	return head, nil
+}
+
+// FilterLogs runs an eth_getLogs query against a network
+func (s *Web3Service) FilterLogs(ctx context.Context, network string, query ethereum.FilterQuery) ([]types.Log, error) {
+	var logs []types.Log
+	err := s.withClient(ctx, network, func(ctx context.Context, client *ethclient.Client) error {
+		var err error
+		logs, err = client.FilterLogs(ctx, query)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to filter logs: %w", err)
+	}
+	return logs, nil
+}
+
+// BlockHeader is the part of a block header the indexer reads
+type BlockHeader struct {
+	Number     uint64
+	Hash       common.Hash
+	ParentHash common.Hash
+	Time       time.Time
+}
+
+// BlockHeaders gets the headers of blocks, batching the reads. A block the
+// node doesn't have yet is an error.
+func (s *Web3Service) BlockHeaders(ctx context.Context, network string, blocks []uint64) (map[uint64]BlockHeader, error) {
+	type rpcHeader struct {
+		Hash       common.Hash    `json:"hash"`
+		ParentHash common.Hash    `json:"parentHash"`
+		Timestamp  hexutil.Uint64 `json:"timestamp"`
+	}
+	result := make(map[uint64]BlockHeader, len(blocks))
+
+	for start := 0; start < len(blocks); start += rpcBatchChunkSize {
+		end := start + rpcBatchChunkSize
+		if end > len(blocks) {
+			end = len(blocks)
+		}
+
+		headers := make([]*rpcHeader, end-start)
+		elems := make([]rpc.BatchElem, 0, end-start)
+		for i, block := range blocks[start:end] {
+			elems = append(elems, rpc.BatchElem{
+				Method: "eth_getBlockByNumber",
+				Args:   []interface{}{hexutil.EncodeUint64(block), false},
+				Result: &headers[i],
+			})
+		}
+
+		err := s.withClient(ctx, network, func(ctx context.Context, client *ethclient.Client) error {
+			return client.Client().BatchCallContext(ctx, elems)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get block headers: %w", err)
+		}
+
+		for i, elem := range elems {
+			block := blocks[start+i]
+			if elem.Error != nil {
+				return nil, fmt.Errorf("failed to get block %d: %w", block, elem.Error)
+			}
+			if headers[i] == nil {
+				return nil, fmt.Errorf("block %d not found", block)
+			}
+			result[block] = BlockHeader{
+				Number:     block,
+				Hash:       headers[i].Hash,
+				ParentHash: headers[i].ParentHash,
+				Time:       time.Unix(int64(headers[i].Timestamp), 0).UTC(),
+			}
+		}
+	}
+
+	return result, nil
+}
 
-	for network, client := range s.clients {
-		_, err := client.BlockNumber(context.Background())
-		status[network] = err == nil
+// GetTransactions reads transactions and their receipts, batching the reads.
+// Timestamps are left empty; hashes the node doesn't know are left out.
+func (s *Web3Service) GetTransactions(ctx context.Context, network string, hashes []common.Hash) (map[common.Hash]TransactionInfo, error) {
+	type rpcTransaction struct {
+		From     common.Address  `json:"from"`
+		To       *common.Address `json:"to"`
+		Value    *hexutil.Big    `json:"value"`
+		Input    hexutil.Bytes   `json:"input"`
+		GasPrice *hexutil.Big    `json:"gasPrice"`
+	}
+	type rpcReceipt struct {
+		BlockNumber       hexutil.Uint64 `json:"blockNumber"`
+		BlockHash         common.Hash    `json:"blockHash"`
+		GasUsed           hexutil.Uint64 `json:"gasUsed"`
+		EffectiveGasPrice *hexutil.Big   `json:"effectiveGasPrice"`
+		Status            hexutil.Uint64 `json:"status"`
+	}
+
+	infos := make(map[common.Hash]TransactionInfo, len(hashes))
+	perBatch := rpcBatchChunkSize / 2
+	for start := 0; start < len(hashes); start += perBatch {
+		end := start + perBatch
+		if end > len(hashes) {
+			end = len(hashes)
+		}
+
+		txs := make([]*rpcTransaction, end-start)
+		receipts := make([]*rpcReceipt, end-start)
+		elems := make([]rpc.BatchElem, 0, 2*(end-start))
+		for i, hash := range hashes[start:end] {
+			elems = append(elems,
+				rpc.BatchElem{Method: "eth_getTransactionByHash", Args: []interface{}{hash}, Result: &txs[i]},
+				rpc.BatchElem{Method: "eth_getTransactionReceipt", Args: []interface{}{hash}, Result: &receipts[i]},
+			)
+		}
+
+		err := s.withClient(ctx, network, func(ctx context.Context, client *ethclient.Client) error {
+			return client.Client().BatchCallContext(ctx, elems)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get transactions: %w", err)
+		}
+
+		for i, hash := range hashes[start:end] {
+			if err := elems[2*i].Error; err != nil {
+				return nil, fmt.Errorf("failed to get transaction %s: %w", hash.Hex(), err)
+			}
+			if err := elems[2*i+1].Error; err != nil {
+				return nil, fmt.Errorf("failed to get receipt of %s: %w", hash.Hex(), err)
+			}
+			tx, receipt := txs[i], receipts[i]
+			if tx == nil || receipt == nil {
+				continue
+			}
+
+			info := TransactionInfo{
+				TxHash:      hash.Hex(),
+				Network:     network,
+				From:        tx.From.Hex(),
+				Value:       "0",
+				BlockNumber: uint64(receipt.BlockNumber),
+				GasUsed:     uint64(receipt.GasUsed),
+				GasPrice:    "0",
+				Success:     uint64(receipt.Status) == types.ReceiptStatusSuccessful,
+			}
+			if tx.To != nil {
+				info.To = tx.To.Hex()
+			}
+			if receipt.BlockHash != (common.Hash{}) {
+				info.BlockHash = receipt.BlockHash.Hex()
+			}
+			if tx.Value != nil {
+				info.Value = tx.Value.ToInt().String()
+			}
+			if len(tx.Input) >= 4 {
+				info.MethodID = hexutil.Encode(tx.Input[:4])
+			}
+			// Receipts from before London have no effective price; the transaction's price applies
+			if receipt.EffectiveGasPrice != nil {
+				info.GasPrice = receipt.EffectiveGasPrice.ToInt().String()
+			} else if tx.GasPrice != nil {
+				info.GasPrice = tx.GasPrice.ToInt().String()
+			}
+			infos[hash] = info
+		}
+	}
+
+	return infos, nil
+}
+
+// GetNetworkStatus returns the RPC pool health of all networks, as seen by the last probes
+func (s *Web3Service) GetNetworkStatus() map[string]NetworkStatus {
+	status := make(map[string]NetworkStatus)
+
+	for network, pool := range s.pools {
+		status[network] = NetworkStatus{
+			Connected: pool.Healthy(),
+			Endpoints: pool.Status(),
+		}
 	}
 
 	return status
@@ -1,14 +1,20 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 	"web3-portfolio-dashboard/backend/internal/api"
 	"web3-portfolio-dashboard/backend/internal/config"
 	"web3-portfolio-dashboard/backend/internal/database"
 	"web3-portfolio-dashboard/backend/internal/services"
 
+	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 )
 
@@ -16,6 +22,15 @@ func main() {
 	// Parse command line flags
 	testDB := flag.Bool("test-db", false, "Test database connection and exit")
 	migrate := flag.Bool("migrate", false, "Run database migrations and exit")
+	importTokenList := flag.String("import-token-list", "", "Import a Uniswap-format token list JSON file into the token catalog and exit")
+	backfillPrices := flag.Int("backfill-prices", 0, "Backfill this many days of price history and exit")
+	backfillSymbols := flag.String("backfill-symbols", "", "Comma-separated symbols to backfill (default: all tracked symbols)")
+	exportPortfolio := flag.String("export-portfolio", "", "Write a tax export of this portfolio ID as CSV and exit")
+	exportReport := flag.String("export-report", "gains", "Export report: gains or ledger")
+	exportFormat := flag.String("export-format", "generic", "Export format: generic, koinly or cointracker")
+	exportYear := flag.String("export-year", "", "Tax year to export (default: current year)")
+	exportMethod := flag.String("export-method", "fifo", "Cost-basis method for the gains report: fifo, lifo or average")
+	exportOut := flag.String("export-out", "", "File to write the export to (default: stdout)")
 	flag.Parse()
 
 	// Load config from env
@@ -59,13 +74,110 @@ func main() {
 
 	// Initialize services
 	web3Service := services.NewWeb3Service(cfg)
-	portfolioService := services.NewPortfolioService(db, web3Service)
+	defer web3Service.Close()
+	priceService := services.NewPriceService(cfg, services.DefaultPriceProviders(cfg, web3Service))
+	web3Service.SetPriceService(priceService)
+	priceHistory := services.NewPriceHistoryService(db, cfg, priceService, web3Service)
+	transactionIndexer := services.NewTransactionIndexer(db, cfg, web3Service, priceHistory)
+	balanceRefresher := services.NewBalanceRefresher(cfg, web3Service)
+	portfolioService := services.NewPortfolioService(db, web3Service, priceHistory, transactionIndexer, balanceRefresher)
 	authService := services.NewAuthService(db, cfg.JWTSecret)
-	alertService := services.NewAlertService(db)
+	gasOracle := services.NewGasOracle(cfg, web3Service)
+	notificationService := services.NewNotificationService(db, cfg, services.DefaultNotifiers(cfg))
+	alertService := services.NewAlertService(db, web3Service, priceHistory, gasOracle, notificationService)
+	tokenCatalog := services.NewTokenCatalogService(db, web3Service)
+	web3Service.SetTokenCatalog(tokenCatalog)
+	events := services.NewEventHub(cfg.EventsBufferSize)
+	priceHistory.SetEventHub(events)
+	portfolioService.SetEventHub(events)
+	alertService.SetEventHub(events)
+
+	// Import a token list and exit
+	if *importTokenList != "" {
+		count, err := tokenCatalog.ImportTokenList(*importTokenList)
+		if err != nil {
+			log.Fatalf("Failed to import token list: %v", err)
+		}
+		log.Printf("✅ Imported %d tokens from %s", count, *importTokenList)
+		os.Exit(0)
+	}
+
+	// Backfill price history and exit
+	if *backfillPrices > 0 {
+		var symbols []string
+		for _, symbol := range strings.Split(*backfillSymbols, ",") {
+			if symbol = strings.TrimSpace(symbol); symbol != "" {
+				symbols = append(symbols, symbol)
+			}
+		}
+		to := time.Now()
+		count, err := priceHistory.Backfill(context.Background(), symbols, to.AddDate(0, 0, -*backfillPrices), to)
+		if err != nil {
+			log.Fatalf("Failed to backfill prices: %v", err)
+		}
+		log.Printf("✅ Backfilled %d price snapshots", count)
+		os.Exit(0)
+	}
+
+	// Write a tax export and exit
+	if *exportPortfolio != "" {
+		portfolioID, err := uuid.Parse(*exportPortfolio)
+		if err != nil {
+			log.Fatalf("Invalid portfolio ID: %v", err)
+		}
+		opts, err := services.ParseExportOptions(*exportReport, *exportFormat, *exportYear, *exportMethod)
+		if err != nil {
+			log.Fatalf("Invalid export options: %v", err)
+		}
+		out := os.Stdout
+		if *exportOut != "" {
+			if out, err = os.Create(*exportOut); err != nil {
+				log.Fatalf("Failed to create export file: %v", err)
+			}
+		}
+		if err := portfolioService.WriteExport(portfolioID, opts, out); err != nil {
+			log.Fatalf("Failed to export portfolio: %v", err)
+		}
+		if err := out.Close(); err != nil {
+			log.Fatalf("Failed to write export file: %v", err)
+		}
+		log.Printf("✅ Exported %s report for %d", opts.Report, opts.Year)
+		os.Exit(0)
+	}
+
+	// Stop on SIGINT/SIGTERM: in-flight requests and jobs get to finish
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Start background jobs
+	scheduler := services.NewScheduler(db, cfg)
+	scheduler.Register(services.JobPriceCollect, cfg.PriceCollectInterval, priceHistory.Collect)
+	scheduler.Register(services.JobPortfolioSnapshot, cfg.PortfolioSnapshotInterval, portfolioService.RecordAllSnapshots)
+	scheduler.Register(services.JobBalanceRefresh, cfg.BalanceRefreshInterval, portfolioService.RefreshAllBalances)
+	scheduler.Register(services.JobTransactionSync, cfg.TransactionSyncInterval, portfolioService.SyncAllTransactions)
+	scheduler.Register(services.JobAlertCheck, cfg.AlertCheckInterval, alertService.CheckAlerts)
+	schedulerDone := make(chan struct{})
+	go func() {
+		scheduler.Run(ctx)
+		close(schedulerDone)
+	}()
+
+	// Refresh addresses as soon as a block touches them, on networks with a ws_url
+	watcher := services.NewBlockWatcher(db, cfg, portfolioService.RefreshAddresses, scheduler.IsLeader)
+	watcherDone := make(chan struct{})
+	go func() {
+		watcher.Run(ctx)
+		close(watcherDone)
+	}()
 
 	// Create and start the server
-	server := api.NewServer(cfg, logger, db, portfolioService, authService, alertService, web3Service)
-	if err := server.Start(":" + cfg.Port); err != nil {
+	server := api.NewServer(cfg, logger, db, portfolioService, authService, alertService, notificationService, web3Service, tokenCatalog, priceService, priceHistory, gasOracle, scheduler, events)
+	if err := server.Start(ctx, ":"+cfg.Port); err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
+	<-schedulerDone
+	<-watcherDone
+	// Alert checks have stopped, so in-flight deliveries end with their retries cut short
+	notificationService.Wait()
+	log.Println("✅ Server stopped")
 }
@@ -55,12 +55,35 @@ export interface Transaction {
   created_at: string
 }
 
+export interface AlertCondition {
+  and?: AlertCondition[]
+  or?: AlertCondition[]
+  type?: string
+  token?: string
+  address?: string
+  network?: string
+  portfolio_id?: string
+  operator?: string
+  value?: number | string
+  window?: string
+  fee?: 'base_fee' | 'slow' | 'standard' | 'fast'
+  direction?: 'in' | 'out'
+  min_amount?: number | string
+  counterparties?: string[]
+  exclude_counterparties?: string[]
+  methods?: string[]
+}
+
+export interface AlertConditions extends AlertCondition {
+  version: number
+}
+
 export interface Alert {
   id: string
   user_id: string
   type: string
   name: string
-  conditions: string
+  conditions: AlertConditions
   is_active: boolean
   created_at: string
   updated_at: string